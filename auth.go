@@ -0,0 +1,202 @@
+package vcs
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Auth holds the credentials a repo uses to authenticate with its remote. Set
+// it on a repo when the ambient SSH agent or credential helpers cannot be
+// relied on, such as in headless CI environments. When set, commands never
+// prompt for credentials and fail instead.
+//
+// Git and P4 receive the credentials through their environment. Git needs git
+// 2.31 or later to receive them. P4 uses the Username and the Password or
+// Token, as a ticket, and ignores SSHKeyPath. Svn and Hg receive them as
+// command line flags, which other users on the system may be able to see. Git
+// and Hg only send the Username, Password, or Token to the host of the remote,
+// and not to other hosts such as those of submodules, subrepos, or redirects.
+// Bzr and Fossil do not support Auth and their operations against the remote
+// return an error when it is set.
+type Auth struct {
+	// The path to the private key used for SSH remotes
+	SSHKeyPath string
+
+	// The username and password used for HTTP remotes
+	Username, Password string
+
+	// Token is a token used for HTTP remotes. Git sends it as a bearer token,
+	// or as the password when a Username is set. Svn and Hg, which do not
+	// support bearer tokens, send it as the password.
+	Token string
+}
+
+// authUnsupported returns the error for a VCS unable to use an Auth.
+func authUnsupported(t Type) error {
+	return NewLocalError(fmt.Sprintf("%s does not support Auth", t), nil, "")
+}
+
+// password returns the password to use for basic authentication.
+func (a *Auth) password() string {
+	if a.Password == "" {
+		return a.Token
+	}
+	return a.Password
+}
+
+// sshCommand returns the ssh command line that uses the SSH key. BatchMode
+// keeps ssh from prompting for a passphrase or host key confirmation.
+func (a *Auth) sshCommand() string {
+	return "ssh -i " + shellQuote(a.SSHKeyPath) + " -o IdentitiesOnly=yes -o BatchMode=yes"
+}
+
+// gitHeader returns the git configuration key and value of the HTTP
+// Authorization header that passes the Auth. The key is scoped to the scheme
+// and host of the remote so the credentials are not sent to other hosts, such
+// as those of submodules. Both are empty when there are no HTTP credentials or
+// the remote is not an HTTP one.
+func (b *base) gitHeader() (string, string) {
+	a := b.Auth
+	if a == nil {
+		return "", ""
+	}
+	var header string
+	if a.Username != "" {
+		header = "Basic " + base64.StdEncoding.EncodeToString([]byte(a.Username+":"+a.password()))
+	} else if a.Token != "" {
+		header = "Bearer " + a.Token
+	}
+	prefix := b.httpPrefix()
+	if header == "" || prefix == "" {
+		return "", ""
+	}
+
+	return "http." + prefix + ".extraHeader", "Authorization: " + header
+}
+
+// httpPrefix returns the scheme and host of the remote, such as
+// https://example.com/, that HTTP credentials are scoped to. It is empty when
+// the remote is not an HTTP one.
+func (b *base) httpPrefix() string {
+	u, err := url.Parse(b.Remote())
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + "/"
+}
+
+// gitConfigEnvVersion is the first git version that reads configuration from
+// the GIT_CONFIG_COUNT, GIT_CONFIG_KEY_n, and GIT_CONFIG_VALUE_n variables.
+const gitConfigEnvVersion = "2.31"
+
+var (
+	gitConfigEnvOnce sync.Once
+	gitConfigEnvErr  error
+)
+
+// checkAuth returns an error when the Auth cannot be passed to a command, so
+// it is not run without its credentials. This is the case for the HTTP
+// credentials of Git when the installed git is too old to read configuration
+// from the environment. The version is only checked once.
+func (b *base) checkAuth(cmd string) error {
+	if cmd != "git" {
+		return nil
+	}
+	if k, _ := b.gitHeader(); k == "" {
+		return nil
+	}
+
+	gitConfigEnvOnce.Do(func() {
+		v, err := VcsVersion(Git)
+		if err == nil && CompareVersions(v, gitConfigEnvVersion) < 0 {
+			gitConfigEnvErr = NewLocalError(fmt.Sprintf("Git %s or later is required to use the Username, Password, or Token of an Auth, found %s", gitConfigEnvVersion, v), nil, "")
+		}
+	})
+	return gitConfigEnvErr
+}
+
+// authEnv returns the environment variables that pass the Auth to a command.
+func (b *base) authEnv(cmd string) []string {
+	a := b.Auth
+	if a == nil {
+		return nil
+	}
+
+	var env []string
+	switch cmd {
+	case "git":
+		env = append(env, "GIT_TERMINAL_PROMPT=0")
+		if a.SSHKeyPath != "" {
+			env = append(env, "GIT_SSH_COMMAND="+a.sshCommand())
+		}
+		if key, header := b.gitHeader(); key != "" {
+			// Configuration passed through the environment, rather than with
+			// -c, keeps the credentials out of the process list. Entries
+			// already in the environment of the repo are kept.
+			n, _ := strconv.Atoi(envValue(b.environ(), "GIT_CONFIG_COUNT"))
+			env = append(env,
+				"GIT_CONFIG_COUNT="+strconv.Itoa(n+1),
+				"GIT_CONFIG_KEY_"+strconv.Itoa(n)+"="+key,
+				"GIT_CONFIG_VALUE_"+strconv.Itoa(n)+"="+header)
+		}
+	case "svn":
+		if a.SSHKeyPath != "" {
+			env = append(env, "SVN_SSH="+a.sshCommand())
+		}
+	case "p4":
+		if a.Username != "" {
+			env = append(env, "P4USER="+a.Username)
+		}
+		if p := a.password(); p != "" {
+			env = append(env, "P4PASSWD="+p)
+		}
+	}
+
+	return env
+}
+
+// authArgs prepends the flags that pass the Auth to a command to its
+// arguments.
+func (b *base) authArgs(cmd string, args []string) []string {
+	a := b.Auth
+	if a == nil {
+		return args
+	}
+
+	var c []string
+	switch cmd {
+	case "svn":
+		c = append(c, "--non-interactive", "--no-auth-cache")
+		if a.Username != "" {
+			c = append(c, "--username", a.Username)
+		}
+		if p := a.password(); p != "" {
+			c = append(c, "--password", p)
+		}
+	case "hg":
+		c = append(c, "--config", "ui.interactive=false")
+		if a.SSHKeyPath != "" {
+			c = append(c, "--config", "ui.ssh="+a.sshCommand())
+		}
+		// The prefix scopes the credentials to the host of the remote.
+		if p := b.httpPrefix(); p != "" && (a.Username != "" || a.password() != "") {
+			c = append(c, "--config", "auth.vcs.prefix="+p,
+				"--config", "auth.vcs.username="+a.Username,
+				"--config", "auth.vcs.password="+a.password())
+		}
+	}
+
+	if len(c) == 0 {
+		return args
+	}
+	return append(c, args...)
+}
+
+// shellQuote quotes a string for use as a single word in a shell command.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -4,9 +4,9 @@ import (
 	"fmt"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -16,7 +16,7 @@ var bzrDetectURL = regexp.MustCompile("parent branch: (?P<foo>.+)\n")
 // NewBzrRepo creates a new instance of BzrRepo. The remote and local directories
 // need to be passed in.
 func NewBzrRepo(remote, local string) (*BzrRepo, error) {
-	ins := depInstalled("bzr")
+	ins := DefaultRunner != nil || depInstalled("bzr")
 	if !ins {
 		return nil, NewLocalError("bzr is not installed", nil, "")
 	}
@@ -31,6 +31,9 @@ func NewBzrRepo(remote, local string) (*BzrRepo, error) {
 	r.setRemote(remote)
 	r.setLocalPath(local)
 	r.Logger = Logger
+	r.FollowRedirects = true
+	r.FS = FS
+	r.Runner = DefaultRunner
 
 	// With the other VCS we can check if the endpoint locally is different
 	// from the one configured internally. But, with Bzr you can't. For example,
@@ -40,10 +43,7 @@ func NewBzrRepo(remote, local string) (*BzrRepo, error) {
 	// the change from https to http and the path chance.
 	// Here we set the remote to be the local one if none is passed in.
 	if err == nil && r.CheckLocal() && remote == "" {
-		c := exec.Command("bzr", "info")
-		c.Dir = local
-		c.Env = envForDir(c.Dir)
-		out, err := c.CombinedOutput()
+		out, err := r.RunFromDir("bzr", "info")
 		if err != nil {
 			return nil, NewLocalError("Unable to retrieve local repo information", err, string(out))
 		}
@@ -69,18 +69,37 @@ func (s BzrRepo) Vcs() Type {
 	return Bzr
 }
 
-// Get is used to perform an initial clone of a repository.
+// Get is used to perform an initial clone of a repository. When Depth is 1 a
+// stacked branch is created that reads history from the remote as needed.
+// Other depths are not supported.
 func (s *BzrRepo) Get() error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if s.Auth != nil {
+		return authUnsupported(s.Vcs())
+	}
+
+	args := []string{"branch"}
+	if s.Depth == 1 {
+		args = append(args, "--stacked")
+	} else if s.Depth > 1 {
+		return depthUnsupported(s.Vcs(), s.Depth)
+	}
 
 	basePath := filepath.Dir(filepath.FromSlash(s.LocalPath()))
-	if _, err := os.Stat(basePath); os.IsNotExist(err) {
-		err = os.MkdirAll(basePath, 0755)
+	if _, err := s.fs().Stat(basePath); os.IsNotExist(err) {
+		err = s.fs().MkdirAll(basePath, 0755)
 		if err != nil {
 			return NewLocalError("Unable to create directory", err, "")
 		}
 	}
 
-	out, err := s.run("bzr", "branch", s.Remote(), s.LocalPath())
+	args = append(args, s.Remote(), s.LocalPath())
+	out, err := s.run("bzr", args...)
 	if err != nil {
 		return NewRemoteError("Unable to get repository", err, string(out))
 	}
@@ -98,8 +117,8 @@ func (s *BzrRepo) Init() error {
 	if err != nil && s.isUnableToCreateDir(err) {
 
 		basePath := filepath.Dir(filepath.FromSlash(s.LocalPath()))
-		if _, err := os.Stat(basePath); os.IsNotExist(err) {
-			err = os.MkdirAll(basePath, 0755)
+		if _, err := s.fs().Stat(basePath); os.IsNotExist(err) {
+			err = s.fs().MkdirAll(basePath, 0755)
 			if err != nil {
 				return NewLocalError("Unable to initialize repository", err, "")
 			}
@@ -120,11 +139,31 @@ func (s *BzrRepo) Init() error {
 
 // Update performs a Bzr pull and update to an existing checkout.
 func (s *BzrRepo) Update() error {
-	out, err := s.RunFromDir("bzr", "pull")
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = s.Pull()
+	if err != nil {
+		return err
+	}
+	out, err := s.RunFromDir("bzr", "update")
 	if err != nil {
 		return NewRemoteError("Unable to update repository", err, string(out))
 	}
-	out, err = s.RunFromDir("bzr", "update")
+	return nil
+}
+
+// Pull performs a Bzr pull from the parent branch without the update of the
+// working tree that Update also performs.
+func (s *BzrRepo) Pull() error {
+	if s.Auth != nil {
+		return authUnsupported(s.Vcs())
+	}
+
+	out, err := s.RunFromDir("bzr", "pull")
 	if err != nil {
 		return NewRemoteError("Unable to update repository", err, string(out))
 	}
@@ -133,6 +172,12 @@ func (s *BzrRepo) Update() error {
 
 // UpdateVersion sets the version of a package currently checked out via Bzr.
 func (s *BzrRepo) UpdateVersion(version string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	out, err := s.RunFromDir("bzr", "update", "-r", version)
 	if err != nil {
 		return NewLocalError("Unable to update checked out version", err, string(out))
@@ -143,9 +188,25 @@ func (s *BzrRepo) UpdateVersion(version string) error {
 // Version retrieves the current version.
 func (s *BzrRepo) Version() (string, error) {
 
-	out, err := s.RunFromDir("bzr", "revno", "--tree")
+	out, stderr, err := s.runSeparate("bzr", "revno", "--tree")
 	if err != nil {
-		return "", NewLocalError("Unable to retrieve checked out version", err, string(out))
+		return "", NewLocalError("Unable to retrieve checked out version", err, string(stderr))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ShortVersion retrieves the current version. Bzr revision numbers are
+// already short so this is the same as Version.
+func (s *BzrRepo) ShortVersion() (string, error) {
+	return s.Version()
+}
+
+// RootDir retrieves the top level directory of the checkout.
+func (s *BzrRepo) RootDir() (string, error) {
+	out, err := s.RunFromDir("bzr", "root")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve root directory", err, string(out))
 	}
 
 	return strings.TrimSpace(string(out)), nil
@@ -181,11 +242,29 @@ func (s *BzrRepo) Current() (string, error) {
 	return curr, nil
 }
 
+// CurrentBranch retrieves the nickname of the branch, which defaults to the
+// name of its directory. In Bzr a branch is its own location so it is never
+// empty.
+func (s *BzrRepo) CurrentBranch() (string, error) {
+	out, stderr, err := s.runSeparate("bzr", "nick")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve current branch", err, string(stderr))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// DefaultBranch retrieves the branch a Bzr checkout has. As each branch is a
+// location of its own this is the same as CurrentBranch.
+func (s *BzrRepo) DefaultBranch() (string, error) {
+	return s.CurrentBranch()
+}
+
 // Date retrieves the date on the latest commit.
 func (s *BzrRepo) Date() (time.Time, error) {
-	out, err := s.RunFromDir("bzr", "version-info", "--custom", "--template={date}")
+	out, stderr, err := s.runSeparate("bzr", "version-info", "--custom", "--template={date}")
 	if err != nil {
-		return time.Time{}, NewLocalError("Unable to retrieve revision date", err, string(out))
+		return time.Time{}, NewLocalError("Unable to retrieve revision date", err, string(stderr))
 	}
 	t, err := time.Parse(longForm, string(out))
 	if err != nil {
@@ -194,9 +273,36 @@ func (s *BzrRepo) Date() (time.Time, error) {
 	return t, nil
 }
 
+// RevisionAt retrieves the revision number of the latest revision on the
+// mainline of the branch committed at or before the passed in time.
+func (s *BzrRepo) RevisionAt(t time.Time) (string, error) {
+	// A date revision spec is the first revision after the date so the one
+	// before it is wanted. When the time is after the tip there is no such
+	// revision and the tip is used instead.
+	out, stderr, err := s.runSeparate("bzr", "revno", "-r", "before:date:"+t.Local().Format("2006-01-02,15:04:05"))
+	if err != nil {
+		tip, derr := s.Date()
+		if derr != nil {
+			return "", derr
+		}
+		if tip.After(t) {
+			return "", NewLocalError("Unable to retrieve revision at "+t.String(), err, string(stderr))
+		}
+		out, stderr, err = s.runSeparate("bzr", "revno")
+		if err != nil {
+			return "", NewLocalError("Unable to retrieve revision at "+t.String(), err, string(stderr))
+		}
+	}
+	rev := strings.TrimSpace(string(out))
+	if rev == "0" {
+		return "", ErrRevisionUnavailable
+	}
+	return rev, nil
+}
+
 // CheckLocal verifies the local location is a Bzr repo.
 func (s *BzrRepo) CheckLocal() bool {
-	if _, err := os.Stat(s.LocalPath() + "/.bzr"); err == nil {
+	if _, err := s.fs().Stat(s.LocalPath() + "/.bzr"); err == nil {
 		return true
 	}
 
@@ -214,14 +320,71 @@ func (s *BzrRepo) Branches() ([]string, error) {
 
 // Tags returns a list of available tags on the repository.
 func (s *BzrRepo) Tags() ([]string, error) {
-	out, err := s.RunFromDir("bzr", "tags")
+	out, stderr, err := s.runSeparate("bzr", "tags")
 	if err != nil {
-		return []string{}, NewLocalError("Unable to retrieve tags", err, string(out))
+		return []string{}, NewLocalError("Unable to retrieve tags", err, string(stderr))
 	}
 	tags := s.referenceList(string(out), `(?m-s)^(\S+)`)
 	return tags, nil
 }
 
+// CreateTag tags a version, or the checked out version when version is empty,
+// with a name. Bzr tags do not carry a message so message is ignored. The tag
+// is only created locally, use Push to publish it.
+func (s *BzrRepo) CreateTag(name, message, version string) error {
+	if name == "" || strings.HasPrefix(name, "-") {
+		return NewLocalError(fmt.Sprintf("Invalid tag name %q", name), nil, "")
+	}
+
+	args := []string{"tag"}
+	if version != "" {
+		args = append(args, "-r", version)
+	}
+	out, err := s.RunFromDir("bzr", append(args, "--", name)...)
+	if err != nil {
+		return NewLocalError("Unable to create tag "+name, err, string(out))
+	}
+
+	return nil
+}
+
+// DeleteTag deletes a tag from the branch.
+func (s *BzrRepo) DeleteTag(name string) error {
+	if name == "" || strings.HasPrefix(name, "-") {
+		return NewLocalError(fmt.Sprintf("Invalid tag name %q", name), nil, "")
+	}
+
+	out, err := s.RunFromDir("bzr", "tag", "--delete", "--", name)
+	if err != nil {
+		return NewLocalError("Unable to delete tag "+name, err, string(out))
+	}
+
+	return nil
+}
+
+// Push pushes the branch, along with its tags, to the parent branch it was
+// retrieved from. The refspec is a revision to push up to, when it is empty
+// the tip of the branch is pushed.
+func (s *BzrRepo) Push(refspec string) error {
+	if s.Auth != nil {
+		return authUnsupported(s.Vcs())
+	}
+
+	args := []string{"push"}
+	if refspec != "" {
+		if strings.HasPrefix(refspec, "-") {
+			return NewLocalError(fmt.Sprintf("Invalid revision %q", refspec), nil, "")
+		}
+		args = append(args, "-r", refspec)
+	}
+	out, err := s.RunFromDir("bzr", append(args, ":parent")...)
+	if err != nil {
+		return NewRemoteError("Unable to push to remote", err, string(out))
+	}
+
+	return nil
+}
+
 // IsReference returns if a string is a reference. A reference can be a
 // commit id or tag.
 func (s *BzrRepo) IsReference(r string) bool {
@@ -229,10 +392,130 @@ func (s *BzrRepo) IsReference(r string) bool {
 	return err == nil
 }
 
+// RefType returns the kind of reference a name is. Bzr has a single branch per
+// location so only its nickname is a branch. A revision number, or a revision
+// id of the form revid:<id>, is a commit.
+func (s *BzrRepo) RefType(r string) RefType {
+	if r == "" || strings.HasPrefix(r, "-") {
+		return RefUnknown
+	}
+
+	tags, err := s.Tags()
+	if err != nil {
+		return RefUnknown
+	}
+	if containsString(tags, r) {
+		return RefTag
+	}
+	if b, err := s.CurrentBranch(); err == nil && b == r {
+		return RefBranch
+	}
+
+	// Revision numbers are dotted for merged revisions, such as 1.2.3.
+	if !strings.HasPrefix(r, "revid:") {
+		for _, n := range strings.Split(r, ".") {
+			if _, err = strconv.Atoi(n); err != nil {
+				return RefUnknown
+			}
+		}
+	}
+	if s.IsReference(r) {
+		return RefCommit
+	}
+	return RefUnknown
+}
+
+// Status retrieves a snapshot of the state of the checkout. The branch is the
+// nickname of the Bzr branch.
+func (s *BzrRepo) Status() (*RepoStatus, error) {
+	v, err := s.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.RunFromDir("bzr", "nick")
+	if err != nil {
+		return nil, NewLocalError("Unable to retrieve status", err, string(out))
+	}
+	branch := strings.TrimSpace(string(out))
+
+	out, err = s.RunFromDir("bzr", "status", "--short", "--versioned")
+	if err != nil {
+		return nil, NewLocalError("Unable to retrieve status", err, string(out))
+	}
+
+	return &RepoStatus{
+		Version: v,
+		Branch:  branch,
+		Dirty:   strings.TrimSpace(string(out)) != "",
+	}, nil
+}
+
+// Pin records the remote and the revision id of the checked out revision, as
+// revision numbers are only stable within a branch, along with the tag it is
+// on, or -1 for the tip, as returned by Current.
+func (s *BzrRepo) Pin() (Pin, error) {
+	p, err := pinRepo(s)
+	if err != nil {
+		return Pin{}, err
+	}
+
+	out, stderr, err := s.runSeparate("bzr", "version-info", "--custom", "--template={revision_id}")
+	if err != nil {
+		return Pin{}, NewLocalError("Unable to retrieve revision id", err, string(stderr))
+	}
+	p.Revision = "revid:" + strings.TrimSpace(string(out))
+
+	return p, nil
+}
+
+// Reset discards the changes to versioned files with bzr revert, without
+// keeping backups of them, when hard is true. Bzr does not have a staging
+// area so nothing is done otherwise.
+func (s *BzrRepo) Reset(hard bool) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !hard {
+		return nil
+	}
+
+	out, err := s.RunFromDir("bzr", "revert", "--no-backup")
+	if err != nil {
+		return NewLocalError("Unable to reset checkout", err, string(out))
+	}
+
+	return nil
+}
+
+// Clean removes the unknown files and directories with bzr clean-tree.
+func (s *BzrRepo) Clean(ignored bool) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	args := []string{"clean-tree", "--force", "--unknown"}
+	if ignored {
+		args = append(args, "--ignored")
+	}
+
+	out, err := s.RunFromDir("bzr", args...)
+	if err != nil {
+		return NewLocalError("Unable to clean checkout", err, string(out))
+	}
+
+	return nil
+}
+
 // IsDirty returns if the checkout has been modified from the checked
 // out reference.
 func (s *BzrRepo) IsDirty() bool {
-	out, err := s.RunFromDir("bzr", "diff")
+	out, err := s.RunFromDir("bzr", "status", "--versioned")
 	return err != nil || len(out) != 0
 }
 
@@ -244,39 +527,139 @@ func (s *BzrRepo) CommitInfo(id string) (*CommitInfo, error) {
 		return nil, ErrRevisionUnavailable
 	}
 
-	ci := &CommitInfo{}
-	lines := strings.Split(string(out), "\n")
+	log, err := parseBzrLog(string(out))
+	if err != nil {
+		return nil, NewLocalError("Unable to retrieve commit information", err, string(out))
+	}
+
+	// Didn't find the revision
+	if len(log) == 0 || log[0].Author == "" {
+		return nil, ErrRevisionUnavailable
+	}
+
+	return &log[0], nil
+}
+
+// CommitLog retrieves metadata about the commits selected by the options,
+// the most recent first. Only the mainline revisions are included, not the
+// ones merged into them.
+func (s *BzrRepo) CommitLog(o LogOptions) ([]CommitInfo, error) {
+	to := o.To
+	if to == "" {
+		v, err := s.Version()
+		if err != nil {
+			return []CommitInfo{}, err
+		}
+		to = v
+	}
+
+	// A range includes both ends so From, resolved to a revision number, is
+	// dropped from it.
+	var from string
+	rev := "1.." + to
+	if o.From != "" {
+		out, stderr, err := s.runSeparate("bzr", "revno", "-r", o.From)
+		if err != nil {
+			return []CommitInfo{}, NewLocalError("Unable to retrieve commit log", err, string(stderr))
+		}
+		from = strings.TrimSpace(string(out))
+		rev = o.From + ".." + to
+	}
+	args := []string{"log", "-r", rev, "--log-format=long", "-n1"}
+	if o.Limit > 0 {
+		// One more is needed in case it is From.
+		args = append(args, "-l", strconv.Itoa(o.Limit+1))
+	}
+
+	out, err := s.RunFromDir("bzr", args...)
+	if err != nil {
+		return []CommitInfo{}, NewLocalError("Unable to retrieve commit log", err, string(out))
+	}
+	log, err := parseBzrLog(string(out))
+	if err != nil {
+		return []CommitInfo{}, NewLocalError("Unable to parse commit log", err, string(out))
+	}
+
+	entries := []CommitInfo{}
+	for _, ci := range log {
+		if ci.Commit != from && (o.Limit <= 0 || len(entries) < o.Limit) {
+			entries = append(entries, ci)
+		}
+	}
+
+	return entries, nil
+}
+
+// parseBzrLog parses the output of bzr log in the long format, where
+// revisions are separated by a line of dashes.
+func parseBzrLog(out string) ([]CommitInfo, error) {
 	const format = "Mon 2006-01-02 15:04:05 -0700"
-	var track int
-	var trackOn bool
+	log := []CommitInfo{}
+	var ci *CommitInfo
+	var message []string
+	done := func() {
+		if ci != nil {
+			ci.Message = strings.TrimSpace(strings.Join(message, "\n"))
+			log = append(log, *ci)
+		}
+		ci, message = nil, nil
+	}
 
 	// Note, bzr does not appear to use i18m.
-	for i, l := range lines {
-		if strings.HasPrefix(l, "revno:") {
+	for _, l := range strings.Split(out, "\n") {
+		if l != "" && strings.Trim(l, "-") == "" {
+			done()
+			continue
+		}
+		if ci == nil {
+			ci = &CommitInfo{}
+		}
+
+		// The message is indented and runs until the next revision.
+		if message != nil {
+			message = append(message, strings.TrimPrefix(l, "  "))
+		} else if strings.HasPrefix(l, "revno:") {
 			ci.Commit = strings.TrimSpace(strings.TrimPrefix(l, "revno:"))
 		} else if strings.HasPrefix(l, "committer:") {
 			ci.Author = strings.TrimSpace(strings.TrimPrefix(l, "committer:"))
+			ci.AuthorName, ci.AuthorEmail = splitAuthor(ci.Author)
 		} else if strings.HasPrefix(l, "timestamp:") {
 			ts := strings.TrimSpace(strings.TrimPrefix(l, "timestamp:"))
-			ci.Date, err = time.Parse(format, ts)
+			t, err := time.Parse(format, ts)
 			if err != nil {
-				return nil, NewLocalError("Unable to retrieve commit information", err, string(out))
+				return nil, err
 			}
+			ci.Date = t
 		} else if strings.TrimSpace(l) == "message:" {
-			track = i
-			trackOn = true
-		} else if trackOn && i > track {
-			ci.Message = ci.Message + l
+			message = []string{}
+		}
+	}
+	done()
+
+	// Lines outside of a revision, such as warnings before the first
+	// separator, are not one.
+	entries := log[:0]
+	for _, c := range log {
+		if c.Commit != "" {
+			entries = append(entries, c)
 		}
 	}
-	ci.Message = strings.TrimSpace(ci.Message)
 
-	// Didn't find the revision
-	if ci.Author == "" {
-		return nil, ErrRevisionUnavailable
+	return entries, nil
+}
+
+// CommitMessage retrieves the message of the checked out revision.
+func (s *BzrRepo) CommitMessage() (string, error) {
+	v, err := s.Version()
+	if err != nil {
+		return "", err
+	}
+	ci, err := s.CommitInfo(v)
+	if err != nil {
+		return "", err
 	}
 
-	return ci, nil
+	return ci.Message, nil
 }
 
 // TagsFromCommit retrieves tags from a commit id.
@@ -290,8 +673,72 @@ func (s *BzrRepo) TagsFromCommit(id string) ([]string, error) {
 	return tags, nil
 }
 
+// ListFiles returns the paths, relative to the root of the branch, of the
+// versioned files in the working tree.
+func (s *BzrRepo) ListFiles() ([]string, error) {
+	out, err := s.RunFromDir("bzr", "ls", "--recursive", "--versioned", "--kind=file")
+	if err != nil {
+		return []string{}, NewLocalError("Unable to list files", err, string(out))
+	}
+
+	files := []string{}
+	for _, p := range strings.Split(string(out), "\n") {
+		if p != "" {
+			files = append(files, filepath.FromSlash(p))
+		}
+	}
+
+	return files, nil
+}
+
+// Diff returns the changes between two revisions as a unified diff.
+func (s *BzrRepo) Diff(from, to string) (string, error) {
+	// bzr diff exits with 1 when there are changes and 2 when some of them,
+	// such as to binary files, cannot be shown.
+	out, stderr, err := s.runSeparate("bzr", "diff", "-r", from+".."+to)
+	if err != nil && exitStatus(err) != 1 && exitStatus(err) != 2 {
+		return "", NewLocalError("Unable to retrieve diff", err, string(stderr))
+	}
+
+	return string(out), nil
+}
+
+// ChangedFiles returns the paths of the files that differ between two
+// revisions. A renamed file is reported as its new path.
+func (s *BzrRepo) ChangedFiles(from, to string) ([]string, error) {
+	out, err := s.RunFromDir("bzr", "status", "--short", "-r", from+".."+to)
+	if err != nil {
+		return []string{}, NewLocalError("Unable to retrieve changed files", err, string(out))
+	}
+
+	// Each line has a three character status, a space, and the path. Renames
+	// are in the form "old => new".
+	files := []string{}
+	for _, l := range strings.Split(string(out), "\n") {
+		if len(l) < 5 {
+			continue
+		}
+		p := strings.TrimSpace(l[4:])
+		if i := strings.Index(p, " => "); i != -1 {
+			p = p[i+4:]
+		}
+		files = append(files, p)
+	}
+
+	return files, nil
+}
+
 // Ping returns if remote location is accessible.
 func (s *BzrRepo) Ping() bool {
+	return s.CheckRemote() == nil
+}
+
+// CheckRemote checks the remote location is accessible. Projects on
+// Launchpad are looked up with its API and others with bzr info.
+func (s *BzrRepo) CheckRemote() error {
+	if s.Auth != nil {
+		return authUnsupported(s.Vcs())
+	}
 
 	// Running bzr info is slow. Many of the projects are on launchpad which
 	// has a public 1.0 API we can use.
@@ -304,14 +751,21 @@ func (s *BzrRepo) Ping() bool {
 			// an error is returned. Launchpad returns a 404 for a codebase that
 			// does not exist. Otherwise it returns a JSON object describing it.
 			_, er := get("https://api.launchpad.net/1.0/" + try)
-			return er == nil
+			if er != nil {
+				return NewRemoteError("Unable to reach remote", er, "")
+			}
+			return nil
 		}
 	}
 
 	// This is the same command that Go itself uses but it's not fast (or fast
 	// enough by my standards). A faster method would be useful.
-	_, err = s.run("bzr", "info", s.Remote())
-	return err == nil
+	out, err := s.run("bzr", "info", s.Remote())
+	if err != nil {
+		return NewRemoteError("Unable to reach remote", err, string(out))
+	}
+
+	return nil
 }
 
 // ExportDir exports the current revision to the passed in directory.
@@ -325,6 +779,17 @@ func (s *BzrRepo) ExportDir(dir string) error {
 	return nil
 }
 
+// ExportVersion exports the passed in version to the passed in directory.
+func (s *BzrRepo) ExportVersion(dir, version string) error {
+	out, err := s.RunFromDir("bzr", "export", "-r", version, dir)
+	s.log(out)
+	if err != nil {
+		return NewLocalError("Unable to export "+version, err, string(out))
+	}
+
+	return nil
+}
+
 // Multi-lingual manner check for the VCS error that it couldn't create directory.
 // https://bazaar.launchpad.net/~bzr-pqm/bzr/bzr.dev/files/head:/po/
 func (s *BzrRepo) isUnableToCreateDir(err error) bool {
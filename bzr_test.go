@@ -42,7 +42,7 @@ func TestBzr(t *testing.T) {
 	if repo.Remote() != "https://launchpad.net/govcstestbzrrepo" {
 		t.Error("Remote not set properly")
 	}
-	if repo.LocalPath() != tempDir+"/govcstestbzrrepo" {
+	if repo.OriginalLocalPath() != tempDir+"/govcstestbzrrepo" {
 		t.Error("Local disk location not set properly")
 	}
 
@@ -326,3 +326,41 @@ func TestBzrInit(t *testing.T) {
 		t.Errorf("Bzr Init returns wrong version: %s", v)
 	}
 }
+
+func TestParseBzrLog(t *testing.T) {
+	out := `------------------------------------------------------------
+revno: 2
+tags: 1.0.0
+committer: Matt Farina <matt@mattfarina.com>
+branch nick: repo
+timestamp: Fri 2015-06-26 13:20:50 -0400
+message:
+  Second commit
+
+  With a body
+  ------------------------------------------------------------
+------------------------------------------------------------
+revno: 1
+committer: Matt Farina <matt@mattfarina.com>
+branch nick: repo
+timestamp: Fri 2015-06-26 13:18:36 -0400
+message:
+  Initial commit
+`
+	log, err := parseBzrLog(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 2 {
+		t.Fatalf("parseBzrLog returned %d revisions, expected 2", len(log))
+	}
+	if log[0].Commit != "2" || log[0].Message != "Second commit\n\nWith a body\n------------------------------------------------------------" {
+		t.Errorf("parseBzrLog returned the wrong first revision. Got %+v", log[0])
+	}
+	if log[1].Commit != "1" || log[1].Message != "Initial commit" || log[1].AuthorEmail != "matt@mattfarina.com" {
+		t.Errorf("parseBzrLog returned the wrong second revision. Got %+v", log[1])
+	}
+	if !log[1].Date.Equal(time.Date(2015, 6, 26, 17, 18, 36, 0, time.UTC)) {
+		t.Errorf("parseBzrLog returned the wrong date %s", log[1].Date)
+	}
+}
@@ -3,6 +3,8 @@ package vcs
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 )
 
 // The vcs package provides ways to work with errors that hide the underlying
@@ -56,8 +58,113 @@ var (
 	// ErrRevisionUnavailable happens when commit revision information is
 	// unavailable.
 	ErrRevisionUnavailable = errors.New("Revision unavailable")
+
+	// ErrNoCommonAncestor happens when two revisions do not share any history.
+	ErrNoCommonAncestor = errors.New("No common ancestor")
+
+	// ErrNoteNotFound happens when a commit does not have a note attached.
+	ErrNoteNotFound = errors.New("Note not found")
+
+	// ErrPatchDoesNotApply happens when a patch does not apply cleanly to the
+	// checkout.
+	ErrPatchDoesNotApply = errors.New("Patch does not apply")
+
+	// ErrNotShallow happens when history is requested for a clone that
+	// already has its full history.
+	ErrNotShallow = errors.New("Repository is not shallow")
+
+	// ErrOutputTooLarge happens when a command produces more output than
+	// the MaxOutputBytes set on the repo.
+	ErrOutputTooLarge = errors.New("Command output too large")
+
+	// ErrHashMismatch happens when the checked out commit does not match the
+	// commit it was expected to be.
+	ErrHashMismatch = errors.New("Commit hash mismatch")
+
+	// ErrNoUpstream happens when the checked out branch does not track an
+	// upstream branch.
+	ErrNoUpstream = errors.New("No upstream configured")
+
+	// ErrNoMatchingTag happens when no tag of a repo satisfies the version
+	// constraint passed to LatestTagMatching.
+	ErrNoMatchingTag = errors.New("No tag matches the constraint")
+
+	// ErrRefNotFound is the Kind of a RemoteError or LocalError for a branch,
+	// tag, or revision that does not exist.
+	ErrRefNotFound = errors.New("Reference not found")
+
+	// ErrAuthRequired is the Kind of a RemoteError or LocalError for a remote
+	// that requires credentials or rejected the ones provided.
+	ErrAuthRequired = errors.New("Authentication required")
+
+	// ErrRemoteUnavailable is the Kind of a RemoteError or LocalError for a
+	// remote that could not be reached or does not exist.
+	ErrRemoteUnavailable = errors.New("Remote unavailable")
+
+	// ErrRedirectRefused is the Kind of a RemoteError or LocalError for a
+	// remote that answered with an HTTP redirect that was not followed, such
+	// as when FollowRedirects is disabled.
+	ErrRedirectRefused = errors.New("Redirect refused")
 )
 
+// errorKinds maps the kinds of errors to the messages, in the output of each
+// VCS, that identify them. The kinds are checked in order as some failures,
+// such as a rejected SSH key, also report the remote as unreadable. Only the
+// English messages are known.
+var errorKinds = []struct {
+	kind     error
+	messages []string
+}{
+	{ErrAuthRequired, []string{
+		// Git
+		"authentication failed", "could not read username", "could not read password",
+		"terminal prompts disabled", "http basic: access denied", "returned error: 401", "returned error: 403",
+		// Hg
+		"authorization failed", "http authorization required", "http error 401", "http error 403",
+		// Svn
+		"e170001", "e215004",
+		// Bzr
+		"unable to authenticate", "401 unauthorized",
+		// SSH for all
+		"permission denied (publickey", "host key verification failed",
+	}},
+	// Checked before ErrRemoteUnavailable as Git reports the redirect as a
+	// remote it is unable to access.
+	{ErrRedirectRefused, []string{
+		// Git
+		"returned error: 301", "returned error: 302", "returned error: 303", "returned error: 307",
+		"returned error: 308",
+		// Hg
+		"http error 301", "http error 302", "http error 303", "http error 307", "http error 308",
+		// Svn
+		"e175011", "repository moved",
+	}},
+	{ErrRemoteUnavailable, []string{
+		// Git
+		"could not resolve host", "remote end hung up unexpectedly", "does not appear to be a git repository",
+		"could not read from remote repository", "repository not found", "fatal: repository '", "returned error: 404", "unable to access",
+		// Hg
+		"abort: error:", "http error 404", "abort: repository",
+		// Svn
+		"e170013", "e670002", "e670008", "e175002", "e000111", "e000110",
+		// Bzr
+		"not a branch", "unable to connect", "connectionerror",
+		// Networking for all
+		"connection refused", "connection timed out", "name or service not known", "no route to host",
+	}},
+	{ErrRefNotFound, []string{
+		// Git
+		"did not match any file(s) known to git", "unknown revision", "couldn't find remote ref",
+		"not a valid object name", "bad revision", "invalid reference", "not found in upstream",
+		// Hg
+		"abort: unknown revision",
+		// Svn
+		"e160006", "no such revision",
+		// Bzr
+		"does not exist in branch", "no such tag", "requested revision",
+	}},
+}
+
 // RemoteError is returned when an operation fails against a remote repo
 type RemoteError struct {
 	vcsError
@@ -88,6 +195,33 @@ func NewLocalError(msg string, err error, out string) error {
 	return e
 }
 
+// MultiError is returned when an operation across several repos, such as those
+// of a RepoSet, fails for any of them. The error of each repo can be
+// classified with Kind as for any other error returned by the repo.
+type MultiError struct {
+	// Errors holds the error of each repo that failed keyed by its local
+	// location.
+	Errors map[string]error
+}
+
+// Error implements the Error interface
+func (e *MultiError) Error() string {
+	paths := make([]string, 0, len(e.Errors))
+	for p := range e.Errors {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	msgs := make([]string, len(paths))
+	for i, p := range paths {
+		msgs[i] = p + ": " + e.Errors[p].Error()
+	}
+	if len(msgs) == 1 {
+		return "1 repo failed: " + msgs[0]
+	}
+	return fmt.Sprintf("%d repos failed: %s", len(msgs), strings.Join(msgs, "; "))
+}
+
 type vcsError struct {
 	s string
 	e error  // The original error
@@ -112,3 +246,25 @@ func (e *vcsError) Original() error {
 func (e *vcsError) Out() string {
 	return e.o
 }
+
+// Kind classifies the failure based on the output of the command and the
+// original error. It returns ErrRefNotFound, ErrAuthRequired,
+// ErrRedirectRefused, or ErrRemoteUnavailable for the common failures of the
+// VCS commands and nil when the failure is not recognized.
+func (e *vcsError) Kind() error {
+	msg := e.o
+	if e.e != nil {
+		msg = msg + "\n" + e.e.Error()
+	}
+	msg = strings.ToLower(msg)
+
+	for _, k := range errorKinds {
+		for _, m := range k.messages {
+			if strings.Contains(msg, m) {
+				return k.kind
+			}
+		}
+	}
+
+	return nil
+}
@@ -34,3 +34,40 @@ func TestNewLocalError(t *testing.T) {
 		t.Error("Wrong error type returned from NewLocalError")
 	}
 }
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		out  string
+		kind error
+	}{
+		{"fatal: could not read Username for 'https://example.com': terminal prompts disabled", ErrAuthRequired},
+		{"fatal: unable to access 'https://example.com/r/': The requested URL returned error: 401", ErrAuthRequired},
+		{"git@example.com: Permission denied (publickey).\nfatal: Could not read from remote repository.", ErrAuthRequired},
+		{"abort: authorization failed", ErrAuthRequired},
+		{"svn: E170001: Authentication required for '<https://example.com:443>'", ErrAuthRequired},
+		{"fatal: unable to access 'https://example.com/r/': Could not resolve host: example.com", ErrRemoteUnavailable},
+		{"fatal: '/tmp/none' does not appear to be a git repository", ErrRemoteUnavailable},
+		{"abort: error: Name or service not known", ErrRemoteUnavailable},
+		{"svn: E170013: Unable to connect to a repository at URL 'https://example.com/r'", ErrRemoteUnavailable},
+		{"bzr: ERROR: Not a branch: \"/tmp/none/\".", ErrRemoteUnavailable},
+		{"fatal: unable to access 'http://example.com/r.git/': The requested URL returned error: 301", ErrRedirectRefused},
+		{"warning: redirecting to https://example.com/r.git/\nfatal: couldn't find remote ref nope", ErrRefNotFound},
+		{"abort: HTTP Error 302: Found", ErrRedirectRefused},
+		{"svn: E175011: Repository moved permanently to 'https://example.com/r'; please relocate", ErrRedirectRefused},
+		{"error: pathspec 'nope' did not match any file(s) known to git", ErrRefNotFound},
+		{"fatal: couldn't find remote ref nope", ErrRefNotFound},
+		{"abort: unknown revision 'nope'!", ErrRefNotFound},
+		{"svn: E160006: No such revision 99", ErrRefNotFound},
+		{"bzr: ERROR: Requested revision: 'nope' does not exist in branch", ErrRefNotFound},
+		{"error: Your local changes would be overwritten", nil},
+	}
+
+	for _, tt := range tests {
+		if k := NewRemoteError("remote error msg", errors.New("exit status 1"), tt.out).(*RemoteError).Kind(); k != tt.kind {
+			t.Errorf("Kind for %q is %v, expected %v", tt.out, k, tt.kind)
+		}
+		if k := NewLocalError("local error msg", errors.New("exit status 1"), tt.out).(*LocalError).Kind(); k != tt.kind {
+			t.Errorf("Kind for %q is %v, expected %v", tt.out, k, tt.kind)
+		}
+	}
+}
@@ -0,0 +1,826 @@
+package vcs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fossilRepoFile is the name of the repository database Get and Init create at
+// the root of the checkout. Fossil keeps a repository separate from the
+// checkouts opened from it.
+const fossilRepoFile = ".fossil"
+
+// NewFossilRepo creates a new instance of FossilRepo. The remote and local
+// directories need to be passed in.
+func NewFossilRepo(remote, local string) (*FossilRepo, error) {
+	ins := DefaultRunner != nil || depInstalled("fossil")
+	if !ins {
+		return nil, NewLocalError("fossil is not installed", nil, "")
+	}
+	ltype, err := DetectVcsFromFS(local)
+
+	// Found a VCS other than Fossil. Need to report an error.
+	if err == nil && ltype != Fossil {
+		return nil, ErrWrongVCS
+	}
+
+	r := &FossilRepo{}
+	r.setRemote(remote)
+	r.setLocalPath(local)
+	r.Logger = Logger
+	r.FollowRedirects = true
+	r.FS = FS
+	r.Runner = DefaultRunner
+
+	// Make sure the local Fossil checkout is configured the same as the
+	// remote when a remote value was passed in.
+	if err == nil && r.CheckLocal() {
+		out, err := r.RunFromDir("fossil", "remote-url")
+		if err != nil {
+			return nil, NewLocalError("Unable to retrieve local repo information", err, string(out))
+		}
+
+		// Fossil reports off when no remote is configured.
+		u := strings.TrimSpace(string(out))
+		if u == "off" {
+			u = ""
+		}
+		if u != "" && remote != "" && u != remote {
+			return nil, ErrWrongRemote
+		}
+
+		// If no remote was passed in but one is configured for the locally
+		// checked out Fossil repo use that one.
+		if remote == "" && u != "" {
+			r.setRemote(u)
+		}
+	}
+
+	return r, nil
+}
+
+// FossilRepo implements the Repo interface for the Fossil source control.
+// Fossil separates a repository from its checkouts so Get and Init create the
+// repository as a .fossil file at the root of the checkout and open the
+// checkout from it.
+type FossilRepo struct {
+	base
+}
+
+// Vcs retrieves the underlying VCS being implemented.
+func (s FossilRepo) Vcs() Type {
+	return Fossil
+}
+
+// Get is used to perform an initial clone of a repository. Fossil is unable
+// to truncate history so setting a Depth causes an error. Credentials are
+// passed in the remote URL and Auth is not supported.
+func (s *FossilRepo) Get() error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if s.Depth > 0 {
+		return depthUnsupported(s.Vcs(), s.Depth)
+	}
+	if s.Auth != nil {
+		return authUnsupported(s.Vcs())
+	}
+
+	if err = s.fs().MkdirAll(s.LocalPath(), 0755); err != nil {
+		return NewLocalError("Unable to create directory", err, "")
+	}
+	out, err := s.run("fossil", "clone", s.Remote(), filepath.Join(s.LocalPath(), fossilRepoFile))
+	if err != nil {
+		return NewRemoteError("Unable to get repository", err, string(out))
+	}
+
+	return s.open()
+}
+
+// Init initializes a Fossil repository at the local location and opens a
+// checkout of it.
+func (s *FossilRepo) Init() error {
+	if err := s.fs().MkdirAll(s.LocalPath(), 0755); err != nil {
+		return NewLocalError("Unable to initialize repository", err, "")
+	}
+	out, err := s.run("fossil", "init", filepath.Join(s.LocalPath(), fossilRepoFile))
+	if err != nil {
+		return NewLocalError("Unable to initialize repository", err, string(out))
+	}
+
+	return s.open()
+}
+
+// open opens a checkout of the repository database at the local location.
+func (s *FossilRepo) open() error {
+	out, err := s.RunFromDir("fossil", "open", fossilRepoFile)
+	if err != nil {
+		return NewLocalError("Unable to open checkout", err, string(out))
+	}
+	return nil
+}
+
+// Update pulls from the remote and updates the checkout to the tip of its
+// branch.
+func (s *FossilRepo) Update() error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if s.Auth != nil {
+		return authUnsupported(s.Vcs())
+	}
+
+	out, err := s.RunFromDir("fossil", "pull")
+	if err != nil {
+		return NewRemoteError("Unable to update repository", err, string(out))
+	}
+	out, err = s.RunFromDir("fossil", "update")
+	if err != nil {
+		return NewLocalError("Unable to update checked out version", err, string(out))
+	}
+	return nil
+}
+
+// UpdateVersion sets the version of a package currently checked out via
+// Fossil.
+func (s *FossilRepo) UpdateVersion(version string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	out, err := s.RunFromDir("fossil", "update", version)
+	if err != nil {
+		return NewLocalError("Unable to update checked out version", err, string(out))
+	}
+	return nil
+}
+
+// info runs fossil info and returns its fields by name. Without a name it
+// describes the checkout.
+func (s *FossilRepo) info(name ...string) (map[string]string, error) {
+	out, stderr, err := s.runSeparate("fossil", append([]string{"info"}, name...)...)
+	if err != nil {
+		return nil, NewLocalError("Unable to retrieve information", err, string(stderr))
+	}
+
+	fields := make(map[string]string)
+	for _, l := range strings.Split(string(out), "\n") {
+		i := strings.Index(l, ":")
+		if i <= 0 {
+			continue
+		}
+		k := strings.TrimSpace(l[:i])
+		if _, ok := fields[k]; !ok {
+			fields[k] = strings.TrimSpace(l[i+1:])
+		}
+	}
+
+	return fields, nil
+}
+
+// checkin resolves a check-in name, or the checked out check-in when the name
+// is empty, to its hash and time.
+func (s *FossilRepo) checkin(name string) (string, time.Time, error) {
+	var f map[string]string
+	var err error
+	var v string
+	if name == "" {
+		f, err = s.info()
+		v = f["checkout"]
+	} else {
+		// Versions of fossil before 2.0 name the hash uuid.
+		f, err = s.info(name)
+		v = f["hash"]
+		if v == "" {
+			v = f["uuid"]
+		}
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// The value is in the form "<hash> <date> <time> UTC".
+	p := strings.Fields(v)
+	if len(p) < 3 {
+		return "", time.Time{}, NewLocalError("Unable to parse check-in", nil, v)
+	}
+	t, err := time.Parse("2006-01-02 15:04:05", p[1]+" "+p[2])
+	if err != nil {
+		return "", time.Time{}, NewLocalError("Unable to parse check-in", err, v)
+	}
+
+	return p[0], t, nil
+}
+
+// Version retrieves the current version.
+func (s *FossilRepo) Version() (string, error) {
+	h, _, err := s.checkin("")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve checked out version", err, "")
+	}
+
+	return h, nil
+}
+
+// ShortVersion retrieves the abbreviated form of the current version. Fossil
+// abbreviates hashes to 10 characters.
+func (s *FossilRepo) ShortVersion() (string, error) {
+	v, err := s.Version()
+	if err != nil {
+		return "", err
+	}
+	if len(v) > 10 {
+		v = v[:10]
+	}
+
+	return v, nil
+}
+
+// RootDir retrieves the top level directory of the checkout.
+func (s *FossilRepo) RootDir() (string, error) {
+	f, err := s.info()
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve root directory", err, "")
+	}
+	root := f["local-root"]
+	if root == "" {
+		return "", NewLocalError("Unable to retrieve root directory", nil, "")
+	}
+
+	return filepath.Clean(filepath.FromSlash(root)), nil
+}
+
+// branch returns the branch of the checkout.
+func (s *FossilRepo) branch() (string, error) {
+	out, stderr, err := s.runSeparate("fossil", "branch", "current")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve branch", err, string(stderr))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Current returns the current version-ish. This means:
+// * Branch name if on the tip of the branch
+// * Tag if on a tag
+// * Otherwise a revision id
+func (s *FossilRepo) Current() (string, error) {
+	branch, err := s.branch()
+	if err != nil {
+		return "", err
+	}
+
+	curr, err := s.Version()
+	if err != nil {
+		return "", err
+	}
+
+	// A branch name refers to the latest check-in on the branch.
+	tip, _, err := s.checkin(branch)
+	if err != nil {
+		return "", err
+	}
+	if tip == curr {
+		return branch, nil
+	}
+
+	ts, err := s.TagsFromCommit(curr)
+	if err != nil {
+		return "", err
+	}
+	if len(ts) > 0 {
+		return ts[0], nil
+	}
+
+	return curr, nil
+}
+
+// CurrentBranch retrieves the branch of the checkout.
+func (s *FossilRepo) CurrentBranch() (string, error) {
+	return s.branch()
+}
+
+// DefaultBranch retrieves the default branch, which Fossil names trunk.
+func (s *FossilRepo) DefaultBranch() (string, error) {
+	return "trunk", nil
+}
+
+// Date retrieves the date on the latest commit.
+func (s *FossilRepo) Date() (time.Time, error) {
+	_, t, err := s.checkin("")
+	if err != nil {
+		return time.Time{}, NewLocalError("Unable to retrieve revision date", err, "")
+	}
+
+	return t, nil
+}
+
+// RevisionAt retrieves the latest check-in on trunk, the default branch,
+// committed at or before the passed in time.
+func (s *FossilRepo) RevisionAt(t time.Time) (string, error) {
+	// A branch followed by a timestamp names the latest check-in on the
+	// branch that is not more recent than it.
+	h, _, err := s.checkin("trunk:" + t.UTC().Format("2006-01-02T15:04:05"))
+	if err != nil {
+		return "", ErrRevisionUnavailable
+	}
+
+	return h, nil
+}
+
+// CheckLocal verifies the local location is a Fossil checkout.
+func (s *FossilRepo) CheckLocal() bool {
+	for _, n := range []string{".fslckout", "_FOSSIL_"} {
+		if _, err := s.fs().Stat(filepath.Join(s.LocalPath(), n)); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Branches returns a list of the open branches.
+func (s *FossilRepo) Branches() ([]string, error) {
+	out, stderr, err := s.runSeparate("fossil", "branch", "list")
+	if err != nil {
+		return []string{}, NewLocalError("Unable to retrieve branches", err, string(stderr))
+	}
+
+	// The current branch is marked with a *.
+	branches := []string{}
+	for _, l := range strings.Split(string(out), "\n") {
+		if f := strings.Fields(strings.TrimPrefix(strings.TrimSpace(l), "*")); len(f) > 0 {
+			branches = append(branches, f[0])
+		}
+	}
+
+	return branches, nil
+}
+
+// Tags returns a list of available tags. Fossil records branches as tags as
+// well and the branch names are left out.
+func (s *FossilRepo) Tags() ([]string, error) {
+	out, stderr, err := s.runSeparate("fossil", "tag", "list")
+	if err != nil {
+		return []string{}, NewLocalError("Unable to retrieve tags", err, string(stderr))
+	}
+
+	return s.tagList(string(out))
+}
+
+// tagList parses the output of fossil tag list into the tags that are not
+// branch names. Tags with values, such as the branch=trunk a check-in is
+// listed with, are internal to Fossil and skipped.
+func (s *FossilRepo) tagList(out string) ([]string, error) {
+	branches, err := s.Branches()
+	if err != nil {
+		return []string{}, err
+	}
+	isBranch := make(map[string]bool)
+	for _, b := range branches {
+		isBranch[b] = true
+	}
+
+	tags := []string{}
+	for _, l := range strings.Split(out, "\n") {
+		t := strings.TrimPrefix(strings.TrimSpace(l), "sym-")
+		if t != "" && !strings.Contains(t, "=") && !isBranch[t] {
+			tags = append(tags, t)
+		}
+	}
+
+	return tags, nil
+}
+
+// IsReference returns if a string is a reference. A reference can be a
+// check-in hash, branch, or tag.
+func (s *FossilRepo) IsReference(r string) bool {
+	_, err := s.RunFromDir("fossil", "info", r)
+	return err == nil
+}
+
+// RefType returns the kind of reference a name is. A check-in hash, or an
+// abbreviation of one, is a commit.
+func (s *FossilRepo) RefType(r string) RefType {
+	if r == "" || strings.HasPrefix(r, "-") {
+		return RefUnknown
+	}
+
+	branches, err := s.Branches()
+	if err != nil {
+		return RefUnknown
+	}
+	if containsString(branches, r) {
+		return RefBranch
+	}
+	tags, err := s.Tags()
+	if err != nil {
+		return RefUnknown
+	}
+	if containsString(tags, r) {
+		return RefTag
+	}
+
+	if isHex(r) && s.IsReference(r) {
+		return RefCommit
+	}
+	return RefUnknown
+}
+
+// Reset discards the changes to files in the checkout with fossil revert when
+// hard is true. Fossil does not have a staging area so nothing is done
+// otherwise.
+func (s *FossilRepo) Reset(hard bool) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !hard {
+		return nil
+	}
+
+	out, err := s.RunFromDir("fossil", "revert")
+	if err != nil {
+		return NewLocalError("Unable to reset checkout", err, string(out))
+	}
+
+	return nil
+}
+
+// Clean removes the files that are not part of the checkout with fossil
+// clean. Fossil reserves the name of the repository database, which is kept
+// in the checkout, so it is not removed.
+func (s *FossilRepo) Clean(ignored bool) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	args := []string{"clean", "--force"}
+	if ignored {
+		args = append(args, "--verily")
+	}
+
+	out, err := s.RunFromDir("fossil", args...)
+	if err != nil {
+		return NewLocalError("Unable to clean checkout", err, string(out))
+	}
+
+	return nil
+}
+
+// IsDirty returns if the checkout has been modified from the checked
+// out reference.
+func (s *FossilRepo) IsDirty() bool {
+	out, err := s.RunFromDir("fossil", "changes")
+	return err != nil || len(strings.TrimSpace(string(out))) != 0
+}
+
+// Status retrieves a snapshot of the state of the checkout.
+func (s *FossilRepo) Status() (*RepoStatus, error) {
+	v, err := s.Version()
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.branch()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RepoStatus{
+		Version: v,
+		Branch:  b,
+		Dirty:   s.IsDirty(),
+	}, nil
+}
+
+// Pin records the remote and the full hash of the checked out check-in, along
+// with the branch or tag it is on as returned by Current.
+func (s *FossilRepo) Pin() (Pin, error) {
+	return pinRepo(s)
+}
+
+// fossilUnescape decodes the escaping of text in the cards of a manifest.
+var fossilUnescape = strings.NewReplacer(`\\`, `\`, `\s`, " ", `\n`, "\n", `\t`, "\t", `\r`, "\r")
+
+// CommitInfo retrieves metadata about a commit. Fossil records a user name
+// rather than an email for the author.
+func (s *FossilRepo) CommitInfo(id string) (*CommitInfo, error) {
+	h, _, err := s.checkin(id)
+	if err != nil {
+		return nil, ErrRevisionUnavailable
+	}
+
+	ci, _, err := s.manifest(h)
+	return ci, err
+}
+
+// CommitLog retrieves metadata about the commits selected by the options,
+// the most recent first. The history is followed through the primary parent
+// of each check-in, leaving out the ones merged into it.
+func (s *FossilRepo) CommitLog(o LogOptions) ([]CommitInfo, error) {
+	to := o.To
+	if to == "" {
+		to = "current"
+	}
+	h, _, err := s.checkin(to)
+	if err != nil {
+		return []CommitInfo{}, ErrRevisionUnavailable
+	}
+	var from string
+	if o.From != "" {
+		if from, _, err = s.checkin(o.From); err != nil {
+			return []CommitInfo{}, ErrRevisionUnavailable
+		}
+	}
+
+	log := []CommitInfo{}
+	for h != "" && h != from && (o.Limit <= 0 || len(log) < o.Limit) {
+		ci, parent, err := s.manifest(h)
+		if err != nil {
+			return []CommitInfo{}, err
+		}
+		log = append(log, *ci)
+		h = parent
+	}
+
+	return log, nil
+}
+
+// manifest retrieves metadata about a check-in, by its hash, along with the
+// hash of its primary parent. The parent is empty for the first check-in.
+func (s *FossilRepo) manifest(h string) (*CommitInfo, string, error) {
+	// The manifest of the check-in has a card per line, starting with its
+	// type, holding the comment, date, parents, and user.
+	out, err := s.RunFromDir("fossil", "artifact", h)
+	if err != nil {
+		return nil, "", NewLocalError("Unable to retrieve commit information", err, string(out))
+	}
+
+	ci := &CommitInfo{Commit: h}
+	var parent string
+	for _, l := range strings.Split(string(out), "\n") {
+		if len(l) < 2 || l[1] != ' ' {
+			continue
+		}
+		v := l[2:]
+		switch l[0] {
+		case 'C':
+			ci.Message = fossilUnescape.Replace(v)
+		case 'D':
+			ci.Date, err = time.Parse("2006-01-02T15:04:05", v)
+			if err != nil {
+				return nil, "", NewLocalError("Unable to retrieve commit information", err, string(out))
+			}
+		case 'P':
+			if p := strings.Fields(v); len(p) > 0 {
+				parent = p[0]
+			}
+		case 'U':
+			ci.Author = fossilUnescape.Replace(v)
+			ci.AuthorName = ci.Author
+		}
+	}
+
+	return ci, parent, nil
+}
+
+// CommitMessage retrieves the full message of the checked out check-in.
+func (s *FossilRepo) CommitMessage() (string, error) {
+	ci, err := s.CommitInfo("current")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve commit message", err, "")
+	}
+
+	return ci.Message, nil
+}
+
+// TagsFromCommit retrieves tags from a commit id.
+func (s *FossilRepo) TagsFromCommit(id string) ([]string, error) {
+	out, err := s.RunFromDir("fossil", "tag", "list", id)
+	if err != nil {
+		return []string{}, NewLocalError("Unable to retrieve tags", err, string(out))
+	}
+
+	return s.tagList(string(out))
+}
+
+// ListFiles returns the paths, relative to the root of the repository, of the
+// files tracked in the checkout.
+func (s *FossilRepo) ListFiles() ([]string, error) {
+	out, err := s.RunFromDir("fossil", "ls")
+	if err != nil {
+		return []string{}, NewLocalError("Unable to list files", err, string(out))
+	}
+
+	files := []string{}
+	for _, p := range strings.Split(string(out), "\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			files = append(files, filepath.FromSlash(p))
+		}
+	}
+
+	return files, nil
+}
+
+// Diff returns the changes between two check-ins as a unified diff. The
+// built in diff is used rather than any configured diff command.
+func (s *FossilRepo) Diff(from, to string) (string, error) {
+	out, stderr, err := s.runSeparate("fossil", "diff", "-i", "--from", from, "--to", to)
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve diff", err, string(stderr))
+	}
+
+	return string(out), nil
+}
+
+// ChangedFiles returns the paths of the files that differ between two
+// check-ins.
+func (s *FossilRepo) ChangedFiles(from, to string) ([]string, error) {
+	out, err := s.RunFromDir("fossil", "diff", "--brief", "--from", from, "--to", to)
+	if err != nil {
+		return []string{}, NewLocalError("Unable to retrieve changed files", err, string(out))
+	}
+
+	// Each line is a word for the kind of change, such as CHANGED or ADDED,
+	// followed by the path.
+	files := []string{}
+	for _, l := range strings.Split(string(out), "\n") {
+		f := strings.Fields(l)
+		if len(f) < 2 {
+			continue
+		}
+		p := strings.TrimSpace(strings.TrimSpace(l)[len(f[0]):])
+		files = append(files, filepath.FromSlash(p))
+	}
+
+	return files, nil
+}
+
+// Ping returns if remote location is accessible.
+func (s *FossilRepo) Ping() bool {
+	return s.CheckRemote() == nil
+}
+
+// CheckRemote checks the remote location is accessible. Fossil has no command
+// to query a remote without cloning it so a local remote is checked to exist
+// and an HTTP or HTTPS remote to respond successfully. Other remotes, such as
+// ones over SSH, cannot be checked and an error is returned.
+func (s *FossilRepo) CheckRemote() error {
+	if filepath.IsAbs(s.Remote()) {
+		if _, err := s.fs().Stat(s.Remote()); err != nil {
+			return NewRemoteError("Unable to reach remote", err, "")
+		}
+		return nil
+	}
+
+	u, err := url.Parse(s.Remote())
+	if err != nil {
+		return NewLocalError("Unable to parse remote", err, "")
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		p := s.Remote()
+		if u.Scheme == "file" {
+			p = u.Path
+		}
+		if _, err := s.fs().Stat(p); err != nil {
+			return NewRemoteError("Unable to reach remote", err, "")
+		}
+	case "http", "https":
+		resp, err := http.Get(s.Remote())
+		if err != nil {
+			return NewRemoteError("Unable to reach remote", err, "")
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return NewRemoteError("Unable to reach remote", nil, resp.Status)
+		}
+	default:
+		return NewLocalError("Unable to check a remote with the "+u.Scheme+" scheme", nil, "")
+	}
+
+	return nil
+}
+
+// ExportDir exports the current revision to the passed in directory.
+func (s *FossilRepo) ExportDir(dir string) error {
+	return s.ExportVersion(dir, "current")
+}
+
+// ExportVersion exports the passed in version to the passed in directory.
+func (s *FossilRepo) ExportVersion(dir, version string) error {
+	f, err := ioutil.TempFile("", "go-vcs-fossil")
+	if err != nil {
+		return NewLocalError("Unable to create temporary file", err, "")
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	// The files in the tarball are in a single directory with the name given.
+	out, err := s.RunFromDir("fossil", "tarball", version, f.Name(), "--name", "export")
+	s.log(out)
+	if err != nil {
+		return NewLocalError("Unable to export "+version, err, string(out))
+	}
+
+	if err = extractTarball(f.Name(), "export", dir); err != nil {
+		return NewLocalError("Unable to export "+version, err, "")
+	}
+
+	return nil
+}
+
+// extractTarball extracts the files under a prefix in a gzip compressed tar
+// file into a directory. Entries outside of the prefix are skipped.
+func extractTarball(file, prefix, dir string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(z)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(filepath.Clean(h.Name))
+		if !strings.HasPrefix(name, prefix+"/") {
+			continue
+		}
+		p := filepath.Join(dir, filepath.FromSlash(name[len(prefix)+1:]))
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(p, 0755)
+		case tar.TypeSymlink:
+			// Links are kept within the directory so later entries cannot be
+			// written through them to elsewhere.
+			t := filepath.Join(filepath.Dir(p), filepath.FromSlash(h.Linkname))
+			if filepath.IsAbs(h.Linkname) || !strings.HasPrefix(t, filepath.Clean(dir)+string(os.PathSeparator)) {
+				continue
+			}
+			if err = os.MkdirAll(filepath.Dir(p), 0755); err == nil {
+				err = os.Symlink(h.Linkname, p)
+			}
+		case tar.TypeReg:
+			err = writeTarFile(tr, p, os.FileMode(h.Mode).Perm())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeTarFile writes the contents of the current entry of a tar file to a
+// path.
+func writeTarFile(r io.Reader, p string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,312 @@
+package vcs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Canary test to ensure FossilRepo implements the Repo interface.
+var _ Repo = &FossilRepo{}
+
+func TestFossilDetect(t *testing.T) {
+	mfs := &memFileSystem{paths: make(map[string]bool)}
+	def := FS
+	FS = mfs
+	defer func() {
+		FS = def
+	}()
+
+	for _, n := range []string{".fslckout", "_FOSSIL_"} {
+		repo := &FossilRepo{}
+		repo.setLocalPath(filepath.Join("mem", n, "repo"))
+		repo.FS = mfs
+
+		// The repo operates on the normalized, absolute, form of the path.
+		local := repo.LocalPath()
+		if err := mfs.MkdirAll(filepath.Join(local, n), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if ltype, err := DetectVcsFromFS(local); err != nil || ltype != Fossil {
+			t.Errorf("DetectVcsFromFS did not detect a Fossil checkout with %s. Got %s, err %v", n, ltype, err)
+		}
+		if !repo.CheckLocal() {
+			t.Errorf("Fossil CheckLocal did not detect a checkout with %s", n)
+		}
+	}
+}
+
+// To verify fossil is working a local repository is created and cloned so
+// the tests do not need network access.
+
+func TestFossil(t *testing.T) {
+	if !Available(Fossil) {
+		t.Skip("fossil is not installed")
+	}
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-fossil-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	remote, err := NewFossilRepo("", filepath.Join(tempDir, "remote"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Init(); err != nil {
+		t.Fatalf("Unable to initialize Fossil repo. Err was %s", err)
+	}
+	writeLocalFile(t, remote, "README.md", "Test repository\n")
+	for _, args := range [][]string{
+		{"add", "README.md"},
+		{"commit", "--user-override", "tester", "-m", "Initial commit"},
+		{"tag", "add", "v1.0.0", "current"},
+	} {
+		out, err := remote.RunFromDir("fossil", args...)
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+	first, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, remote, "README.md", "Second commit\n")
+	out, err := remote.RunFromDir("fossil", "commit", "--user-override", "tester", "-m", "Second commit")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	second, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := NewFossilRepo(filepath.Join(remote.LocalPath(), fossilRepoFile), filepath.Join(tempDir, "clone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.Vcs() != Fossil {
+		t.Error("Fossil is detecting the wrong type")
+	}
+	if !repo.Ping() {
+		t.Error("Fossil Ping failed for a local remote")
+	}
+
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to clone Fossil repo. Err was %s", err)
+	}
+	if !repo.CheckLocal() {
+		t.Error("Problem checking out repo or Fossil CheckLocal is not working")
+	}
+	if ltype, err := DetectVcsFromFS(repo.LocalPath()); err != nil || ltype != Fossil {
+		t.Errorf("DetectVcsFromFS did not detect the Fossil checkout. Got %s, err %v", ltype, err)
+	}
+
+	// Opening the existing checkout picks up its remote.
+	existing, err := NewFossilRepo("", repo.LocalPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existing.Remote() == "" {
+		t.Error("Fossil did not detect the remote of an existing checkout")
+	}
+
+	v, err := repo.Version()
+	if err != nil || v != second {
+		t.Errorf("Fossil Version returned %s, expected %s. Err was %v", v, second, err)
+	}
+	sv, err := repo.ShortVersion()
+	if err != nil || sv != second[:10] {
+		t.Errorf("Fossil ShortVersion returned %s. Err was %v", sv, err)
+	}
+	c, err := repo.Current()
+	if err != nil || c != "trunk" {
+		t.Errorf("Fossil Current returned %s, expected trunk. Err was %v", c, err)
+	}
+	if b, err := repo.CurrentBranch(); err != nil || b != "trunk" {
+		t.Errorf("Fossil CurrentBranch returned %s, expected trunk. Err was %v", b, err)
+	}
+	root, err := repo.RootDir()
+	if err != nil || root != repo.LocalPath() {
+		t.Errorf("Fossil RootDir returned %s, expected %s. Err was %v", root, repo.LocalPath(), err)
+	}
+
+	branches, err := repo.Branches()
+	if err != nil || len(branches) != 1 || branches[0] != "trunk" {
+		t.Errorf("Fossil Branches returned %v. Err was %v", branches, err)
+	}
+	tags, err := repo.Tags()
+	if err != nil || len(tags) != 1 || tags[0] != "v1.0.0" {
+		t.Errorf("Fossil Tags returned %v. Err was %v", tags, err)
+	}
+	if !repo.IsReference("v1.0.0") || !repo.IsReference("trunk") || repo.IsReference("doesnotexist") {
+		t.Error("Fossil IsReference misreported a reference")
+	}
+	for r, expected := range map[string]RefType{"trunk": RefBranch, "v1.0.0": RefTag, first: RefCommit, "doesnotexist": RefUnknown} {
+		if rt := repo.RefType(r); rt != expected {
+			t.Errorf("Fossil RefType of %q returned %q, expected %q", r, rt, expected)
+		}
+	}
+
+	ci, err := repo.CommitInfo(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ci.Commit != first || ci.Author != "tester" || ci.Message != "Initial commit" || ci.Date.IsZero() {
+		t.Errorf("Fossil CommitInfo returned the wrong information. Got %+v", ci)
+	}
+	if _, err = repo.CommitInfo("doesnotexist"); err != ErrRevisionUnavailable {
+		t.Errorf("Fossil CommitInfo for a missing revision returned %v", err)
+	}
+	msg, err := repo.CommitMessage()
+	if err != nil || msg != "Second commit" {
+		t.Errorf("Fossil CommitMessage returned %q. Err was %v", msg, err)
+	}
+
+	changed, err := repo.ChangedFiles(first, second)
+	if err != nil || len(changed) != 1 || changed[0] != "README.md" {
+		t.Errorf("Fossil ChangedFiles returned %v. Err was %v", changed, err)
+	}
+	diff, err := repo.Diff(first, second)
+	if err != nil || !strings.Contains(diff, "+Second commit") {
+		t.Errorf("Fossil Diff returned %q. Err was %v", diff, err)
+	}
+
+	at, err := repo.RevisionAt(time.Now().Add(time.Hour))
+	if err != nil || at != second {
+		t.Errorf("Fossil RevisionAt returned %s, expected %s. Err was %v", at, second, err)
+	}
+
+	if err = repo.UpdateVersion("v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ = repo.Version(); v != first {
+		t.Errorf("Fossil UpdateVersion checked out %s, expected %s", v, first)
+	}
+	c, err = repo.Current()
+	if err != nil || c != "v1.0.0" {
+		t.Errorf("Fossil Current returned %s, expected v1.0.0. Err was %v", c, err)
+	}
+	tags, err = TagsFromCurrent(repo)
+	if err != nil || len(tags) != 1 || tags[0] != "v1.0.0" {
+		t.Errorf("Fossil TagsFromCommit returned %v. Err was %v", tags, err)
+	}
+
+	files, err := repo.ListFiles()
+	if err != nil || len(files) != 1 || files[0] != "README.md" {
+		t.Errorf("Fossil ListFiles returned %v. Err was %v", files, err)
+	}
+
+	if repo.IsDirty() {
+		t.Error("Fossil IsDirty reported a clean checkout as dirty")
+	}
+	writeLocalFile(t, repo, "README.md", "Changed\n")
+	if !repo.IsDirty() {
+		t.Error("Fossil IsDirty did not report a modified file")
+	}
+	st, err := repo.Status()
+	if err != nil || !st.Dirty || st.Branch != "trunk" || st.Version != first {
+		t.Errorf("Fossil Status returned %+v. Err was %v", st, err)
+	}
+	writeLocalFile(t, repo, "extra.txt", "Extra\n")
+	if err = repo.Reset(true); err != nil || repo.IsDirty() {
+		t.Errorf("Fossil Reset did not discard the changes. Err was %v", err)
+	}
+	if err = repo.Clean(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(repo.LocalPath(), "extra.txt")); !os.IsNotExist(err) {
+		t.Error("Fossil Clean did not remove an extra file")
+	}
+	if _, err = os.Stat(filepath.Join(repo.LocalPath(), fossilRepoFile)); err != nil {
+		t.Error("Fossil Clean removed the repository")
+	}
+
+	export := filepath.Join(tempDir, "export")
+	if err = repo.ExportVersion(export, second); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(export, "README.md"))
+	if err != nil || string(b) != "Second commit\n" {
+		t.Errorf("Fossil ExportVersion exported %q. Err was %v", b, err)
+	}
+	if _, err = os.Stat(filepath.Join(export, fossilRepoFile)); !os.IsNotExist(err) {
+		t.Error("Fossil ExportVersion exported the repository")
+	}
+}
+
+func TestExtractTarball(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "go-vcs-tarball-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	file := filepath.Join(tempDir, "export.tar.gz")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := gzip.NewWriter(f)
+	tw := tar.NewWriter(z)
+	entries := []struct {
+		h    tar.Header
+		body string
+	}{
+		{tar.Header{Name: "export/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{tar.Header{Name: "export/README.md", Typeflag: tar.TypeReg, Mode: 0644}, "readme\n"},
+		{tar.Header{Name: "export/sub/run.sh", Typeflag: tar.TypeReg, Mode: 0755}, "#!/bin/sh\n"},
+		{tar.Header{Name: "other/file", Typeflag: tar.TypeReg, Mode: 0644}, "outside\n"},
+		{tar.Header{Name: "export/escape", Typeflag: tar.TypeSymlink, Linkname: "../../outside"}, ""},
+	}
+	for _, e := range entries {
+		e.h.Size = int64(len(e.body))
+		if err = tw.WriteHeader(&e.h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []interface {
+		Close() error
+	}{tw, z, f} {
+		if err = c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := filepath.Join(tempDir, "out")
+	if err = extractTarball(file, "export", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "README.md"))
+	if err != nil || string(b) != "readme\n" {
+		t.Errorf("extractTarball extracted %q. Err was %v", b, err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "sub", "run.sh")); err != nil {
+		t.Error(err)
+	}
+	for _, p := range []string{"file", "other", "escape"} {
+		if _, err = os.Lstat(filepath.Join(dir, p)); !os.IsNotExist(err) {
+			t.Errorf("extractTarball extracted %s", p)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package vcs
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// FileSystem describes the file system operations the package performs
+// directly, outside of the VCS commands it executes. It allows the checks
+// and setup done before running a command, such as CheckLocal or creating
+// parent directories in Get, to be performed against something other than
+// the local disk. A FileSystem that also implements FileReadWriter is used to
+// read and write the small metadata files the package handles itself.
+//
+// Some files are always accessed on the local disk as they are shared with
+// the VCS commands or other processes rather than only read by the package:
+// the lock file of the Lock option, which relies on an operating system file
+// lock, the configuration files the VCS commands read, such as the P4CONFIG
+// file of a Perforce workspace or the hgrc of a restored Hg snapshot, the
+// temporary files and directories handed to the VCS commands, such as the
+// tarball a Fossil export is extracted from or the index of a Git export, the
+// files exports write to their destination, and the snapshot directories,
+// which hold the bundles the VCS commands create and read.
+type FileSystem interface {
+	// Stat returns the os.FileInfo describing the named file.
+	Stat(name string) (os.FileInfo, error)
+
+	// MkdirAll creates a directory along with any necessary parents.
+	MkdirAll(path string, perm os.FileMode) error
+
+	// RemoveAll removes a path and any children it contains.
+	RemoveAll(path string) error
+}
+
+// FileReadWriter can be implemented by a FileSystem to have the metadata files
+// only the package reads and writes go through it: the .git file of a
+// submodule or linked worktree, the description of a Git repository, and the
+// pin files of ReadPinFile and WritePinFile. It is also used to check a
+// directory is empty before Get clones into it. When the FileSystem does not
+// implement it the local disk is used for these.
+type FileReadWriter interface {
+	// ReadFile returns the contents of the named file.
+	ReadFile(name string) ([]byte, error)
+
+	// WriteFile writes data to the named file, creating it with perm if
+	// needed and truncating it otherwise.
+	WriteFile(name string, data []byte, perm os.FileMode) error
+
+	// ReadDir returns the entries of the named directory.
+	ReadDir(name string) ([]os.FileInfo, error)
+}
+
+// FS is the FileSystem used by package level functions and handed to new
+// repo instances. It defaults to the local disk via the os package. To use
+// a different file system replace this before creating a repo or set the FS
+// on an individual repo.
+var FS FileSystem = osFileSystem{}
+
+// osFileSystem implements FileSystem using the os package.
+type osFileSystem struct{}
+
+func (osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFileSystem) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (osFileSystem) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(name)
+}
+
+func (osFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(name, data, perm)
+}
+
+func (osFileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+// readFile reads a file through the FileSystem when it is a FileReadWriter
+// and from the local disk otherwise.
+func readFile(f FileSystem, name string) ([]byte, error) {
+	if rw, ok := f.(FileReadWriter); ok {
+		return rw.ReadFile(name)
+	}
+	return ioutil.ReadFile(name)
+}
+
+// writeFile writes a file through the FileSystem when it is a FileReadWriter
+// and to the local disk otherwise.
+func writeFile(f FileSystem, name string, data []byte, perm os.FileMode) error {
+	if rw, ok := f.(FileReadWriter); ok {
+		return rw.WriteFile(name, data, perm)
+	}
+	return ioutil.WriteFile(name, data, perm)
+}
+
+// isEmptyDir returns if a directory does not exist or contains no entries.
+func isEmptyDir(f FileSystem, dir string) bool {
+	if rw, ok := f.(FileReadWriter); ok {
+		entries, err := rw.ReadDir(dir)
+		return err != nil || len(entries) == 0
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return true
+	}
+	defer d.Close()
+
+	_, err = d.Readdirnames(1)
+	return err != nil
+}
@@ -0,0 +1,191 @@
+package vcs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// memFileSystem is a FileSystem that tracks paths in memory. Directories are
+// tracked in paths and the files written to it in files.
+type memFileSystem struct {
+	paths map[string]bool
+	files map[string][]byte
+}
+
+func (m *memFileSystem) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+	if _, ok := m.files[name]; ok {
+		return memFileInfo{name: filepath.Base(name)}, nil
+	}
+	if !m.paths[name] {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return memFileInfo{name: filepath.Base(name), dir: true}, nil
+}
+
+func (m *memFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	for p := filepath.Clean(path); p != "." && p != string(os.PathSeparator); p = filepath.Dir(p) {
+		m.paths[p] = true
+	}
+	return nil
+}
+
+func (m *memFileSystem) RemoveAll(path string) error {
+	path = filepath.Clean(path)
+	for p := range m.paths {
+		if p == path || filepath.Dir(p) == path {
+			delete(m.paths, p)
+		}
+	}
+	for p := range m.files {
+		if p == path || filepath.Dir(p) == path {
+			delete(m.files, p)
+		}
+	}
+	return nil
+}
+
+func (m *memFileSystem) ReadFile(name string) ([]byte, error) {
+	b, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return b, nil
+}
+
+func (m *memFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	name = filepath.Clean(name)
+	if !m.paths[filepath.Dir(name)] {
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if m.files == nil {
+		m.files = make(map[string][]byte)
+	}
+	m.files[name] = append([]byte{}, data...)
+	return nil
+}
+
+func (m *memFileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	name = filepath.Clean(name)
+	if !m.paths[name] {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	var entries []os.FileInfo
+	for p := range m.paths {
+		if filepath.Dir(p) == name && p != name {
+			entries = append(entries, memFileInfo{name: filepath.Base(p), dir: true})
+		}
+	}
+	for p := range m.files {
+		if filepath.Dir(p) == name {
+			entries = append(entries, memFileInfo{name: filepath.Base(p)})
+		}
+	}
+	return entries, nil
+}
+
+type memFileInfo struct {
+	name string
+	dir  bool
+}
+
+func (i memFileInfo) Name() string { return i.name }
+func (i memFileInfo) Size() int64  { return 0 }
+func (i memFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+func (i memFileInfo) ModTime() time.Time { return time.Time{} }
+func (i memFileInfo) IsDir() bool        { return i.dir }
+func (i memFileInfo) Sys() interface{}   { return nil }
+
+func TestFileSystem(t *testing.T) {
+	mfs := &memFileSystem{paths: make(map[string]bool)}
+
+	def := FS
+	FS = mfs
+	defer func() {
+		FS = def
+	}()
+
+	local := filepath.Join("mem", "repo")
+	if _, err := DetectVcsFromFS(local); err != ErrCannotDetectVCS {
+		t.Errorf("DetectVcsFromFS found a VCS on an empty file system. Err was %v", err)
+	}
+
+	repo, err := NewGitRepo("", local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.FS != mfs {
+		t.Error("NewGitRepo did not use the package FileSystem")
+	}
+	if repo.CheckLocal() {
+		t.Error("Git CheckLocal found a repo on an empty file system")
+	}
+
+	// The repo operates on the normalized, absolute, form of the path.
+	local = repo.LocalPath()
+
+	if err = mfs.MkdirAll(filepath.Join(local, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if !repo.CheckLocal() {
+		t.Error("Git CheckLocal did not use the repo FileSystem")
+	}
+	if ltype, err := DetectVcsFromFS(local); err != nil || ltype != Git {
+		t.Errorf("DetectVcsFromFS did not use the package FileSystem. Got %s, err %v", ltype, err)
+	}
+}
+
+func TestFileReadWriter(t *testing.T) {
+	mfs := &memFileSystem{paths: make(map[string]bool)}
+	def := FS
+	FS = mfs
+	defer func() {
+		FS = def
+	}()
+
+	repo, err := NewGitRepo("", filepath.Join("mem", "worktree"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := repo.LocalPath()
+	if !isEmptyDir(mfs, local) {
+		t.Error("isEmptyDir found entries in a missing directory")
+	}
+
+	// A linked worktree has a .git file pointing at its git directory.
+	gitdir := filepath.Join(filepath.Dir(local), "main", ".git", "worktrees", "worktree")
+	if err = mfs.MkdirAll(gitdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = mfs.MkdirAll(local, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = mfs.WriteFile(filepath.Join(local, ".git"), []byte("gitdir: "+gitdir+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if isEmptyDir(mfs, local) {
+		t.Error("isEmptyDir did not use the FileReadWriter")
+	}
+	if dir, ok := repo.gitDir(); !ok || dir != gitdir {
+		t.Errorf("Git gitDir did not read the .git file through the FileReadWriter. Got %s", dir)
+	}
+
+	lock := filepath.Join(local, "vcs.lock")
+	pins := map[string]Pin{"worktree": {Type: Git, Remote: "https://example.com/repo.git", Revision: "abc"}}
+	if err = WritePinFile(lock, pins); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mfs.files[lock]; !ok {
+		t.Error("WritePinFile did not write through the package FS")
+	}
+	if read, err := ReadPinFile(lock); err != nil || read["worktree"] != pins["worktree"] {
+		t.Errorf("ReadPinFile returned %v. Err was %v", read, err)
+	}
+}
@@ -2,20 +2,24 @@ package vcs
 
 import (
 	"bytes"
-	"encoding/xml"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
 
 // NewGitRepo creates a new instance of GitRepo. The remote and local directories
-// need to be passed in.
-func NewGitRepo(remote, local string) (*GitRepo, error) {
-	ins := depInstalled("git")
+// need to be passed in. Options, such as RemoteName, can optionally be passed
+// in to configure the repo before the local checkout is inspected.
+func NewGitRepo(remote, local string, opts ...GitOption) (*GitRepo, error) {
+	ins := DefaultRunner != nil || depInstalled("git")
 	if !ins {
 		return nil, NewLocalError("git is not installed", nil, "")
 	}
@@ -31,14 +35,18 @@ func NewGitRepo(remote, local string) (*GitRepo, error) {
 	r.setLocalPath(local)
 	r.RemoteLocation = "origin"
 	r.Logger = Logger
+	r.FollowRedirects = true
+	r.FS = FS
+	r.Runner = DefaultRunner
+	r.optionEnv = r.lfsEnv
+	for _, o := range opts {
+		o(r)
+	}
 
 	// Make sure the local Git repo is configured the same as the remote when
 	// A remote value was passed in.
 	if err == nil && r.CheckLocal() {
-		c := exec.Command("git", "config", "--get", "remote.origin.url")
-		c.Dir = local
-		c.Env = envForDir(c.Dir)
-		out, err := c.CombinedOutput()
+		out, err := r.RunFromDir("git", "config", "--get", "remote."+r.RemoteLocation+".url")
 		if err != nil {
 			return nil, NewLocalError("Unable to retrieve local repo information", err, string(out))
 		}
@@ -58,10 +66,154 @@ func NewGitRepo(remote, local string) (*GitRepo, error) {
 	return r, nil
 }
 
+// GitOption configures a GitRepo when it is created by NewGitRepo.
+type GitOption func(*GitRepo)
+
+// RemoteName sets the name of the remote, the RemoteLocation, a GitRepo works
+// with in place of origin. Get names the remote it clones from accordingly and
+// an existing checkout is validated against the remote of that name.
+func RemoteName(name string) GitOption {
+	return func(r *GitRepo) {
+		r.RemoteLocation = name
+	}
+}
+
+// TrustLocal sets TrustLocalPath so NewGitRepo can inspect an existing
+// checkout owned by a different user.
+func TrustLocal() GitOption {
+	return func(r *GitRepo) {
+		r.TrustLocalPath = true
+	}
+}
+
 // GitRepo implements the Repo interface for the Git source control.
 type GitRepo struct {
 	base
 	RemoteLocation string
+
+	// AllowNonEmpty allows Get to clone into a directory that already exists
+	// and contains files. Instead of a git clone the repository is initialized
+	// in place, the remote is added and fetched, and the default branch is
+	// checked out. Existing files are left in place. If one conflicts with a
+	// file in the repository Get fails rather than overwriting it.
+	AllowNonEmpty bool
+
+	// SquashHistory makes Get produce a checkout whose history is a single
+	// root commit. The remote is cloned with a depth of 1 and the checked out
+	// commit is replaced by a new parentless commit with the same tree,
+	// message, author, and committer. Tags pointing at the original commit
+	// are recreated as lightweight tags on the new one. All other tags and the
+	// remote tracking branches are removed and the original objects pruned.
+	// The result is a valid repository, so tools like git describe work, but
+	// its commit id differs from the remote and it cannot be updated from or
+	// pushed to the remote.
+	SquashHistory bool
+
+	// ShallowSince, when not zero, makes Get clone only the history committed
+	// after the given time via git clone --shallow-since. It takes precedence
+	// over Depth, which is ignored when both are set, and is not used with
+	// SquashHistory, which always clones a single commit.
+	ShallowSince time.Time
+
+	// Ref is the branch or tag Get checks out in place of the default branch
+	// of the remote, via git clone --branch. Combined with Depth this retrieves
+	// just the recent history needed for a single tag. It cannot be combined
+	// with TagsOnly or with AllowNonEmpty for a directory that is not empty.
+	Ref string
+
+	// TagsOnly makes Get mirror only the tags of the remote. No branches are
+	// fetched and nothing is checked out until UpdateVersion is called with a
+	// tag. Update fetches any new tags. This cannot be combined with
+	// SquashHistory and, as the repository is initialized in place,
+	// AllowNonEmpty is implied.
+	TagsOnly bool
+
+	// Filter, when set, makes Get a partial clone that leaves out the objects
+	// the filter selects, such as blob:none for the contents of every file,
+	// until a command needs them. Combined with SparsePaths only the files in
+	// the working tree are retrieved. The remote must support partial
+	// clones, which a local path needs a file:// URL for.
+	Filter string
+
+	// SparsePaths, when set, makes Get check out only these directories,
+	// relative to the root of the repository, along with the files at the top
+	// level. See SparseCheckout, which Get uses, for the details.
+	SparsePaths []string
+
+	// Mirror makes Get create a bare mirror of the remote, via git clone
+	// --mirror, with every ref of the remote and no working tree. Update then
+	// runs git remote update --prune so refs that are removed from the remote
+	// are removed from the mirror as well. Operations that need a working
+	// tree, such as UpdateVersion, fail. It cannot be combined with TagsOnly,
+	// SquashHistory, Ref, or AllowNonEmpty.
+	Mirror bool
+
+	// PullRequestStyle selects the refs pull requests are published under by
+	// the hosting service of the remote. It defaults to GitHubPullRequests.
+	PullRequestStyle PullRequestStyle
+
+	// ResumableChunk is the number of commits GetResumable retrieves with
+	// each fetch. When 0 a chunk of 1000 commits is used.
+	ResumableChunk int
+
+	// SigningKey is the key, passed to gpg, that commits and tags created by
+	// the package are signed with. Currently these are the commit and tags
+	// created by SquashHistory. Signed tags are annotated rather than
+	// lightweight. When empty nothing is signed.
+	SigningKey string
+
+	// RecurseSubmodules makes UpdateVersion switch the submodules to the
+	// commits recorded by the version as part of the checkout itself, via git
+	// checkout --recurse-submodules, rather than only in the submodule update
+	// that follows it. A checkout that fails in a submodule then fails as a
+	// whole instead of leaving the submodules behind.
+	RecurseSubmodules bool
+
+	// SkipSubmodules leaves the submodules of the repository uninitialized.
+	// By default Get clones them recursively and every operation that changes
+	// the checkout, such as Update and UpdateVersion, updates them to the
+	// commits it records and cleans up the ones that went away. When set none
+	// of this happens and RecurseSubmodules is ignored.
+	SkipSubmodules bool
+
+	// LineEndings sets the core.autocrlf configuration, one of true, false,
+	// or input, used for every git command run against the repo, including
+	// the clone in Get. This provides the same line endings no matter the
+	// user or system configuration. When empty the configuration is not
+	// overridden.
+	LineEndings string
+
+	// DisableAutoGC sets gc.auto to 0 for every git command run against the
+	// repo so git does not start a garbage collection in the middle of an
+	// operation. Repacking then only happens when git gc is run explicitly.
+	DisableAutoGC bool
+
+	// TrustLocalPath marks the local path as a safe.directory for every git
+	// command run against the repo. Git refuses to work in a repository
+	// owned by a different user, reporting dubious ownership, which is
+	// common in containers and shared caches. It is off by default to
+	// preserve that protection. Use the TrustLocal option for the check
+	// NewGitRepo performs on an existing checkout.
+	TrustLocalPath bool
+
+	// SkipLFS leaves the content of the files tracked by Git LFS out of the
+	// checkout for callers that only need the metadata of a repository.
+	// GIT_LFS_SKIP_SMUDGE is set for every git command so those files are
+	// checked out as the pointer files stored in the repository, and Get and
+	// UpdateVersion do not retrieve the content with git lfs pull.
+	SkipLFS bool
+
+	// IgnoreMailmap reports authors, such as in CommitInfo and Contributors,
+	// as they are recorded in the commits. By default the .mailmap in the
+	// repository is applied to combine the identities an author has used
+	// under a canonical name and email. Ignoring it in Contributors requires
+	// git 2.29 or newer.
+	IgnoreMailmap bool
+
+	// The socket and timeout, in seconds, of the credential cache enabled by
+	// EnableCredentialCache.
+	credentialSocket  string
+	credentialTimeout int
 }
 
 // Vcs retrieves the underlying VCS being implemented.
@@ -69,9 +221,75 @@ func (s GitRepo) Vcs() Type {
 	return Git
 }
 
-// Get is used to perform an initial clone of a repository.
+// Get is used to perform an initial clone of a repository. When the checkout
+// uses Git LFS, as reported by UsesLFS, LFS is installed into the repository
+// configuration with git lfs install --local and the content of the files it
+// tracks is retrieved with git lfs pull, unless SkipLFS is set. If git-lfs is
+// not installed the pointer files are left in place and a message is logged.
 func (s *GitRepo) Get() error {
-	out, err := s.run("git", "clone", "--recursive", s.Remote(), s.LocalPath())
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.get()
+}
+
+// get performs the clone for Get without taking the lock.
+func (s *GitRepo) get() error {
+	if s.Mirror {
+		return s.getMirror()
+	}
+
+	if s.TagsOnly {
+		if s.SquashHistory {
+			return NewLocalError("TagsOnly cannot be combined with SquashHistory", nil, "")
+		}
+		if s.Ref != "" {
+			return NewLocalError("TagsOnly cannot be combined with Ref", nil, "")
+		}
+		return s.getTagsOnly()
+	}
+
+	if s.AllowNonEmpty && !isEmptyDir(s.fs(), s.LocalPath()) {
+		if s.Ref != "" {
+			return NewLocalError("Ref cannot be used to get into a directory that is not empty", nil, "")
+		}
+		if err := s.getNonEmpty(); err != nil {
+			return err
+		}
+		return s.pullLFS()
+	}
+
+	opts := []string{"-o", s.RemoteLocation}
+	if s.Ref != "" {
+		opts = append(opts, "--branch", s.Ref)
+	}
+	if s.Filter != "" {
+		opts = append(opts, "--filter="+s.Filter)
+	}
+	if len(s.SparsePaths) > 0 {
+		// The working tree is checked out once the sparse checkout is set up
+		// so the other files are never written.
+		opts = append(opts, "--no-checkout")
+	}
+	if s.SquashHistory {
+		opts = append(opts, "--depth", "1")
+	} else if !s.ShallowSince.IsZero() {
+		opts = append(opts, "--shallow-since="+s.ShallowSince.Format(time.RFC3339))
+	} else if s.Depth > 0 {
+		// A shallow clone otherwise only has the branch that is checked out.
+		// Keeping the tips of the other branches lets UpdateVersion switch to
+		// them.
+		opts = append(opts, "--depth", strconv.Itoa(s.Depth), "--no-single-branch")
+	}
+
+	args := []string{"clone"}
+	if !s.SkipSubmodules {
+		args = append(args, "--recursive")
+	}
+	out, err := s.run("git", append(append(args, opts...), s.Remote(), s.LocalPath())...)
 
 	// There are some windows cases where Git cannot create the parent directory,
 	// if it does not already exist, to the location it's trying to create the
@@ -79,289 +297,1738 @@ func (s *GitRepo) Get() error {
 	if err != nil && s.isUnableToCreateDir(err) {
 
 		basePath := filepath.Dir(filepath.FromSlash(s.LocalPath()))
-		if _, err := os.Stat(basePath); os.IsNotExist(err) {
-			err = os.MkdirAll(basePath, 0755)
+		if _, err := s.fs().Stat(basePath); os.IsNotExist(err) {
+			err = s.fs().MkdirAll(basePath, 0755)
 			if err != nil {
 				return NewLocalError("Unable to create directory", err, "")
 			}
 
-			out, err = s.run("git", "clone", s.Remote(), s.LocalPath())
+			args = append([]string{"clone"}, opts...)
+			out, err = s.run("git", append(args, s.Remote(), s.LocalPath())...)
 			if err != nil {
 				return NewRemoteError("Unable to get repository", err, string(out))
 			}
-			return err
 		}
 
 	} else if err != nil {
 		return NewRemoteError("Unable to get repository", err, string(out))
 	}
 
+	if len(s.SparsePaths) > 0 {
+		if err = s.SparseCheckout(s.SparsePaths); err != nil {
+			return err
+		}
+		out, err = s.RunFromDir("git", "checkout", "-q")
+		if err != nil {
+			return NewLocalError("Unable to check out sparse paths", err, string(out))
+		}
+		if err = s.updateSubmodules(); err != nil {
+			return err
+		}
+	}
+
+	if s.SquashHistory {
+		if err = s.squashHistory(); err != nil {
+			return err
+		}
+	}
+
+	return s.pullLFS()
+}
+
+// getMirror performs the clone for Get when Mirror is set.
+func (s *GitRepo) getMirror() error {
+	if s.TagsOnly || s.SquashHistory || s.Ref != "" || s.AllowNonEmpty {
+		return NewLocalError("Mirror cannot be combined with TagsOnly, SquashHistory, Ref, or AllowNonEmpty", nil, "")
+	}
+
+	basePath := filepath.Dir(filepath.FromSlash(s.LocalPath()))
+	if _, err := s.fs().Stat(basePath); os.IsNotExist(err) {
+		err = s.fs().MkdirAll(basePath, 0755)
+		if err != nil {
+			return NewLocalError("Unable to create directory", err, "")
+		}
+	}
+
+	out, err := s.run("git", "clone", "--mirror", "-o", s.RemoteLocation, s.Remote(), s.LocalPath())
+	if err != nil {
+		return NewRemoteError("Unable to get repository", err, string(out))
+	}
+
 	return nil
 }
 
-// Init initializes a git repository at local location.
-func (s *GitRepo) Init() error {
-	out, err := s.run("git", "init", s.LocalPath())
+// GetResumable performs an initial clone of a repository in chunks so a
+// network failure only loses the chunk being retrieved. The clone starts with
+// the most recent ResumableChunk commits of every branch and the history is
+// then deepened a chunk at a time until it is complete. When a fetch fails
+// the error is returned and calling GetResumable again, with the partial
+// clone in place, resumes from the history already retrieved. The tradeoff is
+// more round trips to the remote, and more work for it, than a single clone.
+// Shallow operations are ignored by git for local paths so a local remote
+// needs to use a file:// URL.
+func (s *GitRepo) GetResumable() error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	// There are some windows cases where Git cannot create the parent directory,
-	// if it does not already exist, to the location it's trying to create the
-	// repo. Catch that error and try to handle it.
-	if err != nil && s.isUnableToCreateDir(err) {
+	chunk := "1000"
+	if s.ResumableChunk > 0 {
+		chunk = strconv.Itoa(s.ResumableChunk)
+	}
 
+	if !s.CheckLocal() {
 		basePath := filepath.Dir(filepath.FromSlash(s.LocalPath()))
-		if _, err := os.Stat(basePath); os.IsNotExist(err) {
-			err = os.MkdirAll(basePath, 0755)
-			if err != nil {
-				return NewLocalError("Unable to initialize repository", err, "")
-			}
-
-			out, err = s.run("git", "init", s.LocalPath())
+		if _, err = s.fs().Stat(basePath); os.IsNotExist(err) {
+			err = s.fs().MkdirAll(basePath, 0755)
 			if err != nil {
-				return NewLocalError("Unable to initialize repository", err, string(out))
+				return NewLocalError("Unable to create directory", err, "")
 			}
-			return nil
 		}
 
-	} else if err != nil {
-		return NewLocalError("Unable to initialize repository", err, string(out))
+		out, err := s.run("git", "clone", "--depth", chunk, "--no-single-branch", "-o", s.RemoteLocation, s.Remote(), s.LocalPath())
+		if err != nil {
+			return NewRemoteError("Unable to get repository", err, string(out))
+		}
 	}
 
-	return nil
-}
+	for s.IsShallow() {
+		out, err := s.RunFromDir("git", "fetch", "--deepen="+chunk, s.RemoteLocation)
+		if err != nil {
+			return NewRemoteError("Unable to get repository", err, string(out))
+		}
+	}
 
-// Update performs an Git fetch and pull to an existing checkout.
-func (s *GitRepo) Update() error {
-	// Perform a fetch to make sure everything is up to date.
 	out, err := s.RunFromDir("git", "fetch", "--tags", s.RemoteLocation)
 	if err != nil {
-		return NewRemoteError("Unable to update repository", err, string(out))
+		return NewRemoteError("Unable to get repository", err, string(out))
 	}
 
-	// When in a detached head state, such as when an individual commit is checked
-	// out do not attempt a pull. It will cause an error.
-	detached, err := isDetachedHead(s.LocalPath())
+	return s.updateSubmodules()
+}
+
+// GetCommit performs an initial retrieval of a repository with the commit
+// hash checked out in a detached head state. Rather than cloning everything the
+// repository is initialized and only the commit, and its history, is fetched.
+// This requires the remote to allow fetching commits by hash, which a full
+// hash is needed for. When the commit cannot be fetched directly, such as when
+// the remote refuses it or the hash is abbreviated, every branch and tag is
+// fetched, as a clone would, and the commit is checked out from those.
+func (s *GitRepo) GetCommit(hash string) error {
+	unlock, err := s.lock()
 	if err != nil {
-		return NewLocalError("Unable to update repository", err, "")
+		return err
 	}
+	defer unlock()
 
-	if detached {
-		return nil
+	if hash == "" || strings.Trim(strings.ToLower(hash), "0123456789abcdef") != "" {
+		return NewLocalError(fmt.Sprintf("Invalid commit hash %q", hash), nil, "")
 	}
 
-	out, err = s.RunFromDir("git", "pull")
+	if err = s.Init(); err != nil {
+		return err
+	}
+	out, err := s.RunFromDir("git", "remote", "add", s.RemoteLocation, s.Remote())
 	if err != nil {
-		return NewRemoteError("Unable to update repository", err, string(out))
+		return NewLocalError("Unable to add remote", err, string(out))
 	}
 
-	return s.defendAgainstSubmodules()
-}
+	out, err = s.RunFromDir("git", "fetch", s.RemoteLocation, hash)
+	if err != nil {
+		s.log("Unable to fetch commit " + hash + " directly, fetching all refs")
+		out, err = s.RunFromDir("git", "fetch", "--tags", s.RemoteLocation)
+		if err != nil {
+			return NewRemoteError("Unable to get repository", err, string(out))
+		}
+	}
 
-// UpdateVersion sets the version of a package currently checked out via Git.
-func (s *GitRepo) UpdateVersion(version string) error {
-	out, err := s.RunFromDir("git", "checkout", version)
+	out, err = s.RunFromDir("git", "checkout", "--detach", hash+"^{commit}")
 	if err != nil {
-		return NewLocalError("Unable to update checked out version", err, string(out))
+		if _, cerr := s.RunFromDir("git", "cat-file", "-e", hash+"^{commit}"); cerr != nil {
+			return ErrRevisionUnavailable
+		}
+		return NewLocalError("Unable to check out commit", err, string(out))
 	}
 
 	return s.defendAgainstSubmodules()
 }
 
-// defendAgainstSubmodules tries to keep repo state sane in the event of
-// submodules. Or nested submodules. What a great idea, submodules.
-func (s *GitRepo) defendAgainstSubmodules() error {
-	// First, update them to whatever they should be, if there should happen to be any.
-	out, err := s.RunFromDir("git", "submodule", "update", "--init", "--recursive")
-	if err != nil {
-		return NewLocalError("Unexpected error while defensively updating submodules", err, string(out))
+// GetPinned performs an initial clone of a repository, checks out ref, and
+// verifies the checked out commit is expectedHash, a full commit hash. When
+// the commit differs ErrHashMismatch is returned and the checkout is removed
+// so content other than what was pinned is never left behind. The checkout is
+// also removed if any step after the clone fails. The local path must not
+// exist or be an empty directory, which is left in place. With Lock set the
+// lock is held for the whole operation.
+func (s *GitRepo) GetPinned(ref, expectedHash string) error {
+	expectedHash = strings.ToLower(expectedHash)
+	if (len(expectedHash) != 40 && len(expectedHash) != 64) || strings.Trim(expectedHash, "0123456789abcdef") != "" {
+		return NewLocalError(fmt.Sprintf("Invalid commit hash %q", expectedHash), nil, "")
 	}
-	// Now, do a special extra-aggressive clean in case changing versions caused
-	// one or more submodules to go away.
-	out, err = s.RunFromDir("git", "clean", "-x", "-d", "-f", "-f")
+
+	unlock, err := s.lock()
 	if err != nil {
-		return NewLocalError("Unexpected error while defensively cleaning up after possible derelict submodule directories", err, string(out))
+		return err
 	}
-	// Then, repeat just in case there are any nested submodules that went away.
-	out, err = s.RunFromDir("git", "submodule", "foreach", "--recursive", "git", "clean", "-x", "-d", "-f", "-f")
-	if err != nil {
-		return NewLocalError("Unexpected error while defensively cleaning up after possible derelict nested submodule directories", err, string(out))
+	defer unlock()
+
+	// Only clone into an empty location so removing the checkout never
+	// removes anything that was there before.
+	if !isEmptyDir(s.fs(), s.LocalPath()) {
+		return NewLocalError("Local path "+s.LocalPath()+" is not empty", nil, "")
 	}
+	_, serr := s.fs().Stat(s.LocalPath())
+	existed := serr == nil
 
-	return nil
-}
+	if err = s.get(); err != nil {
+		return err
+	}
 
-// Version retrieves the current version.
-func (s *GitRepo) Version() (string, error) {
-	out, err := s.RunFromDir("git", "rev-parse", "HEAD")
+	err = s.updateVersion(ref)
+	if err == nil {
+		var v string
+		v, err = s.Version()
+		if err == nil && v != expectedHash {
+			s.log("Checked out commit " + v + " does not match pinned commit " + expectedHash)
+			err = ErrHashMismatch
+		}
+	}
 	if err != nil {
-		return "", NewLocalError("Unable to retrieve checked out version", err, string(out))
+		rerr := s.fs().RemoveAll(s.LocalPath())
+		if rerr == nil && existed {
+			rerr = s.fs().MkdirAll(s.LocalPath(), 0755)
+		}
+		if rerr != nil {
+			return NewLocalError("Unable to remove checkout", rerr, "")
+		}
+		return err
 	}
 
-	return strings.TrimSpace(string(out)), nil
+	return nil
 }
 
-// Current returns the current version-ish. This means:
-// * Branch name if on the tip of the branch
-// * Tag if on a tag
-// * Otherwise a revision id
-func (s *GitRepo) Current() (string, error) {
-	out, err := s.RunFromDir("git", "symbolic-ref", "HEAD")
-	if err == nil {
-		o := bytes.TrimSpace(bytes.TrimPrefix(out, []byte("refs/heads/")))
-		return string(o), nil
+// WithTempCheckout checks out ref from the remote into a temporary directory,
+// calls fn with the path of the checkout, and removes the directory when fn
+// returns, including when it panics. The ref may be a branch, a tag, or a full
+// commit hash, which requires the remote to allow fetching commits by hash.
+// Only the commit itself is fetched, with a depth of 1, to keep this fast. The
+// options set on the repo are used for the checkout while the local checkout
+// of the repo, if there is one, is left untouched. The error returned by fn is
+// passed through.
+func (s *GitRepo) WithTempCheckout(ref string, fn func(path string) error) error {
+	if s.Remote() == "" {
+		return NewLocalError("A remote is required for a temporary checkout", nil, "")
+	}
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return NewLocalError(fmt.Sprintf("Invalid ref %q", ref), nil, "")
 	}
 
-	v, err := s.Version()
+	tempDir, err := ioutil.TempDir("", "go-vcs-temp-checkout")
 	if err != nil {
-		return "", err
+		return NewLocalError("Unable to create temporary directory", err, "")
 	}
+	defer os.RemoveAll(tempDir)
 
-	ts, err := s.TagsFromCommit(v)
+	t := *s
+	t.setLocalPath(filepath.Join(tempDir, "checkout"))
+	if err = t.Init(); err != nil {
+		return err
+	}
+	out, err := t.RunFromDir("git", "remote", "add", t.RemoteLocation, t.Remote())
 	if err != nil {
-		return "", err
+		return NewLocalError("Unable to add remote", err, string(out))
 	}
-
-	if len(ts) > 0 {
-		return ts[0], nil
+	out, err = t.RunFromDir("git", "fetch", "--depth", "1", t.RemoteLocation, ref)
+	if err != nil {
+		return NewRemoteError("Unable to fetch "+ref, err, string(out))
+	}
+	out, err = t.RunFromDir("git", "checkout", "-q", "--detach", "FETCH_HEAD")
+	if err != nil {
+		return NewLocalError("Unable to check out "+ref, err, string(out))
+	}
+	if err = t.defendAgainstSubmodules(); err != nil {
+		return err
 	}
 
-	return v, nil
+	return fn(t.LocalPath())
 }
 
-// Date retrieves the date on the latest commit.
-func (s *GitRepo) Date() (time.Time, error) {
-	out, err := s.RunFromDir("git", "log", "-1", "--date=iso", "--pretty=format:%cd")
-	if err != nil {
-		return time.Time{}, NewLocalError("Unable to retrieve revision date", err, string(out))
-	}
-	t, err := time.Parse(longForm, string(out))
+// RemoteSize returns an estimate, in bytes, of the size of the repository on
+// the remote without cloning it. Git does not report the size of a remote so
+// the history is probed with a temporary bare clone that leaves out file
+// contents via --filter=blob:none and the size of the objects retrieved is
+// returned. The estimate is therefore a lower bound that excludes the files,
+// which usually make up most of a repository. When the remote does not
+// support partial clones the filter is ignored and the probe retrieves, and
+// measures, the whole repository which is exact but as slow as a clone.
+func (s *GitRepo) RemoteSize() (int64, error) {
+	tempDir, err := ioutil.TempDir("", "go-vcs-remote-size")
 	if err != nil {
-		return time.Time{}, NewLocalError("Unable to retrieve revision date", err, string(out))
+		return 0, NewLocalError("Unable to create temporary directory", err, "")
 	}
-	return t, nil
-}
+	defer os.RemoveAll(tempDir)
 
-// Branches returns a list of available branches on the RemoteLocation
-func (s *GitRepo) Branches() ([]string, error) {
-	out, err := s.RunFromDir("git", "show-ref")
+	out, err := s.run("git", "clone", "-q", "--bare", "--filter=blob:none", s.Remote(), tempDir)
 	if err != nil {
-		return []string{}, NewLocalError("Unable to retrieve branches", err, string(out))
+		return 0, NewRemoteError("Unable to probe remote size", err, string(out))
 	}
-	branches := s.referenceList(string(out), `(?m-s)(?:`+s.RemoteLocation+`)/(\S+)$`)
-	return branches, nil
-}
 
-// Tags returns a list of available tags on the RemoteLocation
-func (s *GitRepo) Tags() ([]string, error) {
-	out, err := s.RunFromDir("git", "show-ref")
+	c := exec.Command("git", "count-objects", "-v")
+	c.Dir = tempDir
+	c.Env = s.envForDir(c.Dir)
+	var o bytes.Buffer
+	err = s.capture(c, &o, &o)
+	out = o.Bytes()
 	if err != nil {
-		return []string{}, NewLocalError("Unable to retrieve tags", err, string(out))
+		return 0, NewLocalError("Unable to probe remote size", err, string(out))
 	}
-	tags := s.referenceList(string(out), `(?m-s)(?:tags)/(\S+)$`)
-	return tags, nil
-}
 
-// CheckLocal verifies the local location is a Git repo.
-func (s *GitRepo) CheckLocal() bool {
-	if _, err := os.Stat(s.LocalPath() + "/.git"); err == nil {
-		return true
+	// The loose and packed sizes are reported in KiB.
+	var size int64
+	for _, l := range strings.Split(string(out), "\n") {
+		f := strings.SplitN(l, ": ", 2)
+		if len(f) == 2 && (f[0] == "size" || f[0] == "size-pack") {
+			n, err := strconv.ParseInt(strings.TrimSpace(f[1]), 10, 64)
+			if err != nil {
+				return 0, NewLocalError("Unable to probe remote size", err, string(out))
+			}
+			size += n * 1024
+		}
 	}
 
-	return false
+	return size, nil
 }
 
-// IsReference returns if a string is a reference. A reference can be a
-// commit id, branch, or tag.
-func (s *GitRepo) IsReference(r string) bool {
-	_, err := s.RunFromDir("git", "rev-parse", "--verify", r)
-	if err == nil {
-		return true
+// IsShallow returns if the checkout is a shallow clone that is missing part
+// of its history.
+func (s *GitRepo) IsShallow() bool {
+	gitDir, ok := s.gitDir()
+	if !ok {
+		return false
 	}
 
-	// Some refs will fail rev-parse. For example, a remote branch that has
-	// not been checked out yet. This next step should pickup the other
-	// possible references.
-	_, err = s.RunFromDir("git", "show-ref", r)
+	// Git removes the shallow file once the full history is present.
+	_, err := s.fs().Stat(filepath.Join(gitDir, "shallow"))
 	return err == nil
 }
 
-// IsDirty returns if the checkout has been modified from the checked
-// out reference.
-func (s *GitRepo) IsDirty() bool {
-	out, err := s.RunFromDir("git", "diff")
-	return err != nil || len(out) != 0
-}
-
-// CommitInfo retrieves metadata about a commit.
-func (s *GitRepo) CommitInfo(id string) (*CommitInfo, error) {
-	fm := `--pretty=format:"<logentry><commit>%H</commit><author>%an &lt;%ae&gt;</author><date>%aD</date><message>%s</message></logentry>"`
-	out, err := s.RunFromDir("git", "log", id, fm, "-1")
-	if err != nil {
-		return nil, ErrRevisionUnavailable
+// Deepen fetches n more commits of history from the RemoteLocation for each
+// branch of a shallow clone, such as when git describe needs to reach a tag.
+// ErrNotShallow is returned when the clone already has its full history.
+func (s *GitRepo) Deepen(n int) error {
+	if n <= 0 {
+		return NewLocalError(fmt.Sprintf("Invalid depth %d to deepen by", n), nil, "")
+	}
+	if !s.IsShallow() {
+		return ErrNotShallow
 	}
 
-	cis := struct {
-		Commit  string `xml:"commit"`
-		Author  string `xml:"author"`
-		Date    string `xml:"date"`
-		Message string `xml:"message"`
-	}{}
-	err = xml.Unmarshal(out, &cis)
+	out, err := s.RunFromDir("git", "fetch", "--deepen="+strconv.Itoa(n), s.RemoteLocation)
 	if err != nil {
-		return nil, NewLocalError("Unable to retrieve commit information", err, string(out))
+		return NewRemoteError("Unable to deepen repository", err, string(out))
 	}
 
-	t, err := time.Parse("Mon, _2 Jan 2006 15:04:05 -0700", cis.Date)
-	if err != nil {
-		return nil, NewLocalError("Unable to retrieve commit information", err, string(out))
+	return nil
+}
+
+// Unshallow fetches the remaining history from the RemoteLocation to turn a
+// shallow clone into a full one. ErrNotShallow is returned when the clone
+// already has its full history.
+func (s *GitRepo) Unshallow() error {
+	if !s.IsShallow() {
+		return ErrNotShallow
 	}
 
-	ci := &CommitInfo{
-		Commit:  cis.Commit,
-		Author:  cis.Author,
-		Date:    t,
-		Message: cis.Message,
+	out, err := s.RunFromDir("git", "fetch", "--unshallow", s.RemoteLocation)
+	if err != nil {
+		return NewRemoteError("Unable to unshallow repository", err, string(out))
 	}
 
-	return ci, nil
+	return nil
 }
 
-// TagsFromCommit retrieves tags from a commit id.
-func (s *GitRepo) TagsFromCommit(id string) ([]string, error) {
-	// This is imperfect and a better method would be great.
+// squashHistory replaces the history of a fresh clone with a single root
+// commit that has the tree, message, and identities of the checked out commit.
+func (s *GitRepo) squashHistory() error {
+	orig, err := s.Version()
+	if err != nil {
+		return err
+	}
+	tags, err := s.TagsFromCommit(orig)
+	if err != nil {
+		return err
+	}
+	out, err := s.RunFromDir("git", "symbolic-ref", "HEAD")
+	if err != nil {
+		return NewLocalError("Unable to squash history", err, string(out))
+	}
+	branch := strings.TrimSpace(string(out))
 
-	var re []string
+	out, err = s.RunFromDir("git", "log", "-1", "--date=raw", "--format=%an%x00%ae%x00%ad%x00%cn%x00%ce%x00%cd%x00%B")
+	if err != nil {
+		return NewLocalError("Unable to squash history", err, string(out))
+	}
+	f := strings.SplitN(string(out), "\x00", 7)
+	if len(f) != 7 {
+		return NewLocalError("Unable to squash history", nil, string(out))
+	}
+
+	// Reuse the original identities and dates so the new commit does not
+	// depend on the local user configuration.
+	ident := []string{
+		"GIT_AUTHOR_NAME=" + f[0],
+		"GIT_AUTHOR_EMAIL=" + f[1],
+		"GIT_AUTHOR_DATE=" + f[2],
+		"GIT_COMMITTER_NAME=" + f[3],
+		"GIT_COMMITTER_EMAIL=" + f[4],
+		"GIT_COMMITTER_DATE=" + f[5],
+	}
+	args := []string{"commit-tree", orig + "^{tree}", "-m", strings.TrimSpace(f[6])}
+	if s.SigningKey != "" {
+		args = append(args, "-S"+s.SigningKey)
+	}
+	c := s.CmdFromDir("git", args...)
+	c.Env = mergeEnvLists(ident, c.Env)
+	out, err = s.runInput(c)
+	if err != nil {
+		return s.signingError("Unable to squash history", err, out)
+	}
+	commit := strings.TrimSpace(string(out))
 
-	out, err := s.RunFromDir("git", "show-ref", "-d")
+	out, err = s.RunFromDir("git", "update-ref", branch, commit)
 	if err != nil {
-		return []string{}, NewLocalError("Unable to retrieve tags", err, string(out))
+		return NewLocalError("Unable to squash history", err, string(out))
 	}
 
-	lines := strings.Split(string(out), "\n")
-	var list []string
-	for _, i := range lines {
-		if strings.HasPrefix(strings.TrimSpace(i), id) {
-			list = append(list, i)
-		}
+	// Drop every other ref so nothing keeps the original history reachable.
+	out, err = s.RunFromDir("git", "for-each-ref", "--format=delete %(refname)", "refs/remotes", "refs/tags")
+	if err != nil {
+		return NewLocalError("Unable to squash history", err, string(out))
+	}
+	c = s.CmdFromDir("git", "update-ref", "--no-deref", "--stdin")
+	c.Stdin = bytes.NewReader(out)
+	out, err = s.runInput(c)
+	if err != nil {
+		return NewLocalError("Unable to squash history", err, string(out))
 	}
-	tags := s.referenceList(strings.Join(list, "\n"), `(?m-s)(?:tags)/(\S+)$`)
 	for _, t := range tags {
-		// Dereferenced tags have ^{} appended to them.
-		re = append(re, strings.TrimSuffix(t, "^{}"))
+		args = []string{"tag"}
+		if s.SigningKey != "" {
+			args = append(args, "-u", s.SigningKey, "-m", t)
+		}
+		c = s.CmdFromDir("git", append(args, t, commit)...)
+		c.Env = mergeEnvLists(ident, c.Env)
+		out, err = s.runInput(c)
+		if err != nil {
+			return s.signingError("Unable to squash history", err, out)
+		}
 	}
 
-	return re, nil
-}
-
-// Ping returns if remote location is accessible.
-func (s *GitRepo) Ping() bool {
-	c := exec.Command("git", "ls-remote", s.Remote())
+	err = s.fs().RemoveAll(filepath.Join(s.LocalPath(), ".git", "shallow"))
+	if err != nil {
+		return NewLocalError("Unable to squash history", err, "")
+	}
+	out, err = s.RunFromDir("git", "reflog", "expire", "--expire=now", "--all")
+	if err != nil {
+		return NewLocalError("Unable to squash history", err, string(out))
+	}
+	out, err = s.RunFromDir("git", "gc", "-q", "--prune=now")
+	if err != nil {
+		return NewLocalError("Unable to squash history", err, string(out))
+	}
 
-	// If prompted for a username and password, which GitHub does for all things
-	// not public, it's considered not available. To make it available the
-	// remote needs to be different.
-	c.Env = mergeEnvLists([]string{"GIT_TERMINAL_PROMPT=0"}, os.Environ())
-	_, err := c.CombinedOutput()
-	return err == nil
+	return nil
+}
+
+// signingError returns the error for a failed git command that creates a
+// commit or tag. When signing is enabled and the output shows signing failed,
+// such as when no gpg agent is available, the error is attributed to signing.
+func (s *GitRepo) signingError(msg string, err error, out []byte) error {
+	if s.SigningKey != "" {
+		o := strings.ToLower(string(out))
+		if strings.Contains(o, "gpg") || strings.Contains(o, "failed to sign") || strings.Contains(o, "signing") {
+			return NewLocalError("Unable to sign with key "+s.SigningKey, err, string(out))
+		}
+	}
+	return NewLocalError(msg, err, string(out))
+}
+
+// getNonEmpty performs the steps of a clone in place for a directory that
+// already contains files.
+func (s *GitRepo) getNonEmpty() error {
+	out, err := s.RunFromDir("git", "init")
+	if err != nil {
+		return NewLocalError("Unable to initialize repository", err, string(out))
+	}
+
+	out, err = s.RunFromDir("git", "remote", "add", s.RemoteLocation, s.Remote())
+	if err != nil {
+		return NewLocalError("Unable to add remote", err, string(out))
+	}
+
+	out, err = s.RunFromDir("git", append(s.fetchDepth("fetch", "--tags"), s.RemoteLocation)...)
+	if err != nil {
+		return NewRemoteError("Unable to get repository", err, string(out))
+	}
+
+	// Ask the remote which branch HEAD points to so the same branch a clone
+	// would have checked out is used.
+	out, err = s.RunFromDir("git", "remote", "set-head", s.RemoteLocation, "--auto")
+	if err != nil {
+		return NewRemoteError("Unable to detect default branch", err, string(out))
+	}
+	out, err = s.RunFromDir("git", "symbolic-ref", "--short", "refs/remotes/"+s.RemoteLocation+"/HEAD")
+	if err != nil {
+		return NewLocalError("Unable to detect default branch", err, string(out))
+	}
+	remoteBranch := strings.TrimSpace(string(out))
+	branch := strings.TrimPrefix(remoteBranch, s.RemoteLocation+"/")
+
+	out, err = s.RunFromDir("git", "checkout", "-B", branch, "--track", remoteBranch)
+	if err != nil {
+		return NewLocalError("Unable to check out default branch", err, string(out))
+	}
+
+	// The aggressive clean in defendAgainstSubmodules would remove the files
+	// that were already present so only the submodules are updated.
+	return s.updateSubmodules()
+}
+
+// getTagsOnly initializes a repository and fetches only the tags of the
+// remote into it. The fetch configuration of the remote is limited to tags so
+// later fetches do not retrieve branches either.
+func (s *GitRepo) getTagsOnly() error {
+	if err := s.Init(); err != nil {
+		return err
+	}
+
+	out, err := s.RunFromDir("git", "remote", "add", s.RemoteLocation, s.Remote())
+	if err != nil {
+		return NewLocalError("Unable to add remote", err, string(out))
+	}
+	out, err = s.RunFromDir("git", "config", "remote."+s.RemoteLocation+".fetch", "+refs/tags/*:refs/tags/*")
+	if err != nil {
+		return NewLocalError("Unable to configure remote", err, string(out))
+	}
+
+	out, err = s.RunFromDir("git", append(s.fetchDepth("fetch"), s.RemoteLocation)...)
+	if err != nil {
+		return NewRemoteError("Unable to get repository", err, string(out))
+	}
+
+	return nil
+}
+
+// fetchDepth appends the option limiting the history fetched by Get, when
+// ShallowSince or Depth is set, to the arguments of a git fetch.
+func (s *GitRepo) fetchDepth(args ...string) []string {
+	if !s.ShallowSince.IsZero() {
+		return append(args, "--shallow-since="+s.ShallowSince.Format(time.RFC3339))
+	}
+	if s.Depth > 0 {
+		return append(args, "--depth", strconv.Itoa(s.Depth))
+	}
+
+	return args
+}
+
+// Init initializes a git repository at local location.
+func (s *GitRepo) Init() error {
+	out, err := s.run("git", "init", s.LocalPath())
+
+	// There are some windows cases where Git cannot create the parent directory,
+	// if it does not already exist, to the location it's trying to create the
+	// repo. Catch that error and try to handle it.
+	if err != nil && s.isUnableToCreateDir(err) {
+
+		basePath := filepath.Dir(filepath.FromSlash(s.LocalPath()))
+		if _, err := s.fs().Stat(basePath); os.IsNotExist(err) {
+			err = s.fs().MkdirAll(basePath, 0755)
+			if err != nil {
+				return NewLocalError("Unable to initialize repository", err, "")
+			}
+
+			out, err = s.run("git", "init", s.LocalPath())
+			if err != nil {
+				return NewLocalError("Unable to initialize repository", err, string(out))
+			}
+			return nil
+		}
+
+	} else if err != nil {
+		return NewLocalError("Unable to initialize repository", err, string(out))
+	}
+
+	return nil
+}
+
+// Update performs an Git fetch and pull to an existing checkout.
+func (s *GitRepo) Update() error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// A mirror has no branch to pull into and follows the remote refs,
+	// including their removal.
+	if s.Mirror {
+		out, err := s.RunFromDir("git", "remote", "update", "--prune", s.RemoteLocation)
+		if err != nil {
+			return NewRemoteError("Unable to update repository", err, string(out))
+		}
+		return nil
+	}
+
+	// Perform a fetch to make sure everything is up to date.
+	out, err := s.RunFromDir("git", "fetch", "--tags", s.RemoteLocation)
+	if err != nil {
+		return NewRemoteError("Unable to update repository", err, string(out))
+	}
+
+	// There is no branch to pull into when only tags are mirrored.
+	if s.TagsOnly {
+		return nil
+	}
+
+	return s.Pull()
+}
+
+// Pull performs a Git pull of the upstream of the checked out branch without
+// first fetching tags and refs from the RemoteLocation the way Update does.
+// When in a detached head state there is no branch to pull into and nothing
+// is done.
+func (s *GitRepo) Pull() error {
+	// When in a detached head state, such as when an individual commit is checked
+	// out do not attempt a pull. It will cause an error.
+	detached, err := s.IsDetached()
+	if err != nil {
+		return NewLocalError("Unable to update repository", err, "")
+	}
+
+	if detached {
+		return nil
+	}
+
+	out, err := s.RunFromDir("git", "pull")
+	if err != nil {
+		return NewRemoteError("Unable to update repository", err, string(out))
+	}
+
+	return s.defendAgainstSubmodules()
+}
+
+// SetRemoteLocation selects, by name, the remote the repo works with in place
+// of its RemoteLocation. For an existing checkout the remote must be
+// configured and the Remote of the repo becomes its URL, the same as when the
+// RemoteName option is passed to NewGitRepo. Otherwise only the name is set
+// and Get names the remote it clones from accordingly.
+func (s *GitRepo) SetRemoteLocation(name string) error {
+	if name == "" || strings.HasPrefix(name, "-") {
+		return NewLocalError(fmt.Sprintf("Invalid remote name %q", name), nil, "")
+	}
+
+	if s.CheckLocal() {
+		out, err := s.RunFromDir("git", "config", "--get", "remote."+name+".url")
+		if err != nil {
+			return NewLocalError("Remote "+name+" is not configured", err, string(out))
+		}
+		s.setRemote(strings.TrimSpace(string(out)))
+	}
+	s.RemoteLocation = name
+
+	return nil
+}
+
+// AddRemote adds a remote with a name and URL to the checkout. It does not
+// change the RemoteLocation the repo works with, use SetRemoteLocation for
+// that.
+func (s *GitRepo) AddRemote(name, url string) error {
+	if name == "" || strings.HasPrefix(name, "-") {
+		return NewLocalError(fmt.Sprintf("Invalid remote name %q", name), nil, "")
+	}
+
+	out, err := s.RunFromDir("git", "remote", "add", "--", name, url)
+	if err != nil {
+		return NewLocalError("Unable to add remote "+name, err, string(out))
+	}
+
+	return nil
+}
+
+// ListRemotes returns the remotes configured for the checkout mapped to
+// their URLs.
+func (s *GitRepo) ListRemotes() (map[string]string, error) {
+	// git config exits with 1 when nothing matches, which is a checkout
+	// without any remotes.
+	out, stderr, err := s.runSeparate("git", "config", "--get-regexp", `^remote\..*\.url$`)
+	if err != nil && exitStatus(err) != 1 {
+		return map[string]string{}, NewLocalError("Unable to list remotes", err, string(stderr))
+	}
+
+	// Each line is the key, remote.<name>.url, a space, and the URL. Names
+	// may contain dots so the name is what is between the prefix and suffix.
+	remotes := make(map[string]string)
+	for _, l := range strings.Split(string(out), "\n") {
+		f := strings.SplitN(strings.TrimSpace(l), " ", 2)
+		if len(f) != 2 {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(f[0], "remote."), ".url")
+		remotes[name] = f[1]
+	}
+
+	return remotes, nil
+}
+
+// FetchRefspec fetches a refspec, such as
+// +refs/pull/*/head:refs/remotes/origin/pr/*, from the RemoteLocation. This
+// allows fetching refs that are not fetched by default, like the refs for pull
+// and merge requests. The refspec is validated before git is run.
+func (s *GitRepo) FetchRefspec(refspec string) error {
+	if err := validateRefspec(refspec); err != nil {
+		return err
+	}
+
+	out, err := s.RunFromDir("git", "fetch", s.RemoteLocation, refspec)
+	if err != nil {
+		return NewRemoteError("Unable to fetch refspec", err, string(out))
+	}
+
+	return nil
+}
+
+// PullRequestStyle describes how a hosting service publishes the refs of pull
+// requests.
+type PullRequestStyle int
+
+const (
+	// GitHubPullRequests are published as refs/pull/<n>/head.
+	GitHubPullRequests PullRequestStyle = iota
+
+	// GitLabMergeRequests are published as refs/merge-requests/<n>/head.
+	GitLabMergeRequests
+)
+
+// pullRequestRef returns the ref on the remote and the local remote tracking
+// ref used for a pull request, or for every pull request when n is "*".
+func (s *GitRepo) pullRequestRef(n string) (string, string) {
+	if s.PullRequestStyle == GitLabMergeRequests {
+		return "refs/merge-requests/" + n + "/head", "refs/remotes/" + s.RemoteLocation + "/mr/" + n
+	}
+	return "refs/pull/" + n + "/head", "refs/remotes/" + s.RemoteLocation + "/pr/" + n
+}
+
+// PullRequestRefs returns the refs of the pull requests, or merge requests,
+// published on the RemoteLocation in the PullRequestStyle of the repo, such as
+// refs/pull/1/head.
+func (s *GitRepo) PullRequestRefs() ([]string, error) {
+	remoteRef, _ := s.pullRequestRef("*")
+	out, err := s.RunFromDir("git", "ls-remote", s.RemoteLocation, remoteRef)
+	if err != nil {
+		return []string{}, NewRemoteError("Unable to retrieve pull requests", err, string(out))
+	}
+
+	refs := []string{}
+	for _, l := range strings.Split(string(out), "\n") {
+		f := strings.Fields(l)
+		if len(f) == 2 {
+			refs = append(refs, f[1])
+		}
+	}
+
+	return refs, nil
+}
+
+// CheckoutPullRequest fetches pull request, or merge request, n from the
+// RemoteLocation and checks it out in a detached head state. The fetched
+// commit is kept in the remote tracking ref pr/<n>, or mr/<n> for GitLab, of
+// the RemoteLocation.
+func (s *GitRepo) CheckoutPullRequest(n int) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	remoteRef, localRef := s.pullRequestRef(strconv.Itoa(n))
+	out, err := s.RunFromDir("git", "fetch", s.RemoteLocation, "+"+remoteRef+":"+localRef)
+	if err != nil {
+		return NewRemoteError(fmt.Sprintf("Unable to fetch pull request %d", n), err, string(out))
+	}
+
+	out, err = s.RunFromDir("git", "checkout", "--detach", localRef)
+	if err != nil {
+		return NewLocalError(fmt.Sprintf("Unable to check out pull request %d", n), err, string(out))
+	}
+
+	return s.defendAgainstSubmodules()
+}
+
+// validateRefspec checks a refspec is in the form [+]<src>[:<dst>] where the
+// source and destination are valid ref names containing at most one glob
+// each, and where a glob in one side is matched by a glob in the other.
+func validateRefspec(refspec string) error {
+	invalid := func(reason string) error {
+		return NewLocalError(fmt.Sprintf("Invalid refspec %q: %s", refspec, reason), nil, "")
+	}
+
+	spec := strings.TrimPrefix(refspec, "+")
+	if spec == "" {
+		return invalid("it is empty")
+	}
+	if strings.HasPrefix(spec, "-") {
+		return invalid("it cannot start with -")
+	}
+
+	parts := strings.Split(spec, ":")
+	if len(parts) > 2 {
+		return invalid("it can contain at most one :")
+	}
+	var globs []int
+	for i, p := range parts {
+		// The destination is optional but the source is required unless the
+		// spec is being used to delete a ref, which fetch does not support.
+		if p == "" {
+			if i == 0 {
+				return invalid("the source cannot be empty")
+			}
+			continue
+		}
+		if strings.ContainsAny(p, " ~^?[\\") || strings.Contains(p, "..") || strings.Contains(p, "@{") ||
+			strings.HasSuffix(p, "/") || strings.HasSuffix(p, ".lock") || strings.HasSuffix(p, ".") {
+			return invalid("it contains a disallowed character or sequence")
+		}
+		for _, r := range p {
+			if r < 0x20 || r == 0x7f {
+				return invalid("it contains a control character")
+			}
+		}
+		globs = append(globs, strings.Count(p, "*"))
+	}
+	for _, g := range globs {
+		if g > 1 {
+			return invalid("each side can contain at most one *")
+		}
+	}
+	if len(globs) == 2 && globs[0] != globs[1] {
+		return invalid("a * in the source must be matched by a * in the destination")
+	}
+
+	return nil
+}
+
+// UpdateVersion sets the version of a package currently checked out via Git.
+// As with Get the content of the files tracked by Git LFS is retrieved.
+func (s *GitRepo) UpdateVersion(version string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.updateVersion(version)
+}
+
+// updateVersion checks out a version for UpdateVersion without taking the
+// lock.
+func (s *GitRepo) updateVersion(version string) error {
+	args := []string{"checkout"}
+	if s.RecurseSubmodules && !s.SkipSubmodules {
+		args = append(args, "--recurse-submodules")
+	}
+	out, err := s.RunFromDir("git", append(args, version)...)
+	if err != nil && s.IsShallow() {
+		// The version may be in the history a shallow clone left out.
+		s.log("Unable to check out " + version + " in a shallow clone, fetching the full history")
+		if err = s.Unshallow(); err != nil {
+			return err
+		}
+		out, err = s.RunFromDir("git", append(args, version)...)
+	}
+	if err != nil {
+		return NewLocalError("Unable to update checked out version", err, string(out))
+	}
+
+	if err = s.defendAgainstSubmodules(); err != nil {
+		return err
+	}
+	return s.pullLFS()
+}
+
+// UsesLFS returns if the checked out version of the repository uses Git LFS,
+// which is when a .gitattributes file in it assigns files to the lfs filter.
+func (s *GitRepo) UsesLFS() bool {
+	_, err := s.RunFromDir("git", "grep", "-q", "-F", "filter=lfs", "--", ":(glob)**/.gitattributes")
+	return err == nil
+}
+
+// lfsEnv returns the variables that make git leave the content of files
+// tracked by Git LFS out of the checkout when SkipLFS is set.
+func (s *GitRepo) lfsEnv(cmd string) []string {
+	if cmd != "git" || !s.SkipLFS {
+		return nil
+	}
+	return []string{"GIT_LFS_SKIP_SMUDGE=1"}
+}
+
+// pullLFS retrieves the content of the files tracked by Git LFS when the
+// checkout uses it. LFS is installed into the configuration of the repository,
+// rather than relying on a global git lfs install, so later checkouts
+// retrieve the content as well.
+func (s *GitRepo) pullLFS() error {
+	if s.SkipLFS || !s.UsesLFS() {
+		return nil
+	}
+	if _, err := s.RunFromDir("git", "lfs", "version"); err != nil {
+		s.log("git-lfs is not installed, leaving the Git LFS pointer files in " + s.LocalPath())
+		return nil
+	}
+
+	out, err := s.RunFromDir("git", "lfs", "install", "--local")
+	if err != nil {
+		return NewLocalError("Unable to install Git LFS", err, string(out))
+	}
+	out, err = s.RunFromDir("git", "lfs", "pull", s.RemoteLocation)
+	if err != nil {
+		return NewRemoteError("Unable to retrieve Git LFS content", err, string(out))
+	}
+
+	return nil
+}
+
+// updateSubmodules initializes and updates the submodules, unless they are
+// skipped, without the clean up in defendAgainstSubmodules.
+func (s *GitRepo) updateSubmodules() error {
+	if s.SkipSubmodules {
+		return nil
+	}
+
+	out, err := s.RunFromDir("git", "submodule", "update", "--init", "--recursive")
+	if err != nil {
+		return NewLocalError("Unable to update submodules", err, string(out))
+	}
+
+	return nil
+}
+
+// defendAgainstSubmodules tries to keep repo state sane in the event of
+// submodules. Or nested submodules. What a great idea, submodules.
+func (s *GitRepo) defendAgainstSubmodules() error {
+	if s.SkipSubmodules {
+		return nil
+	}
+
+	// First, update them to whatever they should be, if there should happen to be any.
+	out, err := s.RunFromDir("git", "submodule", "update", "--init", "--recursive")
+	if err != nil {
+		return NewLocalError("Unexpected error while defensively updating submodules", err, string(out))
+	}
+	// Now, do a special extra-aggressive clean in case changing versions caused
+	// one or more submodules to go away.
+	out, err = s.RunFromDir("git", "clean", "-x", "-d", "-f", "-f")
+	if err != nil {
+		return NewLocalError("Unexpected error while defensively cleaning up after possible derelict submodule directories", err, string(out))
+	}
+	// Then, repeat just in case there are any nested submodules that went away.
+	out, err = s.RunFromDir("git", "submodule", "foreach", "--recursive", "git", "clean", "-x", "-d", "-f", "-f")
+	if err != nil {
+		return NewLocalError("Unexpected error while defensively cleaning up after possible derelict nested submodule directories", err, string(out))
+	}
+
+	return nil
+}
+
+// Version retrieves the current version.
+func (s *GitRepo) Version() (string, error) {
+	out, stderr, err := s.runSeparate("git", "rev-parse", "HEAD")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve checked out version", err, string(stderr))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ShortVersion retrieves the abbreviated form of the current version. The
+// length is the one git chooses, which is longer than the default when that
+// is needed to keep the abbreviation unique.
+func (s *GitRepo) ShortVersion() (string, error) {
+	out, stderr, err := s.runSeparate("git", "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve checked out version", err, string(stderr))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// DescribeOptions configures the output of DescribeWith.
+type DescribeOptions struct {
+	// Tags includes lightweight tags rather than only annotated tags.
+	Tags bool
+
+	// Dirty appends -dirty when the working tree has modifications.
+	Dirty bool
+
+	// Always falls back to the abbreviated commit id when there are no tags
+	// to describe the commit with rather than returning an error.
+	Always bool
+}
+
+// Describe returns a human readable version of the checked out commit in the
+// form produced by git describe --tags --always --dirty. For example,
+// v1.2.3-5-gabc1234-dirty. When there are no tags the abbreviated commit id
+// is returned.
+func (s *GitRepo) Describe() (string, error) {
+	return s.DescribeWith(DescribeOptions{Tags: true, Dirty: true, Always: true})
+}
+
+// DescribeWith returns a human readable version of the checked out commit
+// from git describe using the passed in options.
+func (s *GitRepo) DescribeWith(o DescribeOptions) (string, error) {
+	args := []string{"describe"}
+	if o.Tags {
+		args = append(args, "--tags")
+	}
+	if o.Always {
+		args = append(args, "--always")
+	}
+	if o.Dirty {
+		args = append(args, "--dirty")
+	}
+
+	out, err := s.RunFromDir("git", args...)
+	if err != nil {
+		return "", NewLocalError("Unable to describe checked out version", err, string(out))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// RootDir retrieves the top level directory of the checkout.
+func (s *GitRepo) RootDir() (string, error) {
+	out, err := s.RunFromDir("git", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve root directory", err, string(out))
+	}
+
+	return filepath.FromSlash(strings.TrimSpace(string(out))), nil
+}
+
+// Current returns the current version-ish. This means:
+// * Branch name if on the tip of the branch
+// * Tag if on a tag
+// * Otherwise a revision id
+func (s *GitRepo) Current() (string, error) {
+	out, err := s.RunFromDir("git", "symbolic-ref", "HEAD")
+	if err == nil {
+		o := bytes.TrimSpace(bytes.TrimPrefix(out, []byte("refs/heads/")))
+		return string(o), nil
+	}
+
+	v, err := s.Version()
+	if err != nil {
+		return "", err
+	}
+
+	ts, err := s.TagsFromCommit(v)
+	if err != nil {
+		return "", err
+	}
+
+	if len(ts) > 0 {
+		return ts[0], nil
+	}
+
+	return v, nil
+}
+
+// CurrentBranch retrieves the branch that is checked out. It is empty when in
+// a detached head state, such as after UpdateVersion checks out a tag or
+// commit. DefaultBranch retrieves the branch to return to.
+func (s *GitRepo) CurrentBranch() (string, error) {
+	out, err := s.RunFromDir("git", "symbolic-ref", "-q", "HEAD")
+	if err != nil {
+		// A status of 1 means HEAD is not a symbolic ref and so is detached.
+		if exitStatus(err) == 1 {
+			return "", nil
+		}
+		return "", NewLocalError("Unable to retrieve current branch", err, string(out))
+	}
+
+	return strings.TrimPrefix(strings.TrimSpace(string(out)), "refs/heads/"), nil
+}
+
+// DefaultBranch retrieves the default branch of the RemoteLocation from the
+// HEAD recorded for it when it was cloned. When there is none, such as when
+// the remote was added after the fact, the remote is asked for its HEAD. A
+// mirror has the HEAD of the remote as its own.
+func (s *GitRepo) DefaultBranch() (string, error) {
+	if s.Mirror {
+		return s.CurrentBranch()
+	}
+
+	prefix := "refs/remotes/" + s.RemoteLocation + "/"
+	out, _, err := s.runSeparate("git", "symbolic-ref", "-q", prefix+"HEAD")
+	if err == nil {
+		return strings.TrimPrefix(strings.TrimSpace(string(out)), prefix), nil
+	}
+
+	// The symbolic ref of HEAD is reported on a line in the form
+	// "ref: refs/heads/master<tab>HEAD".
+	out, stderr, err := s.runSeparate("git", "ls-remote", "--symref", s.RemoteLocation, "HEAD")
+	if err != nil {
+		return "", NewRemoteError("Unable to retrieve default branch", err, string(stderr))
+	}
+	for _, l := range strings.Split(string(out), "\n") {
+		f := strings.Fields(l)
+		if len(f) == 3 && f[0] == "ref:" && f[2] == "HEAD" {
+			return strings.TrimPrefix(f[1], "refs/heads/"), nil
+		}
+	}
+
+	return "", NewRemoteError("Unable to retrieve default branch", nil, string(out))
+}
+
+// IsDetached returns if the checkout is in a detached head state, where HEAD
+// points directly at a commit rather than at a branch.
+func (s *GitRepo) IsDetached() (bool, error) {
+	out, err := s.RunFromDir("git", "symbolic-ref", "-q", "HEAD")
+	if err != nil {
+		// A status of 1 means HEAD is not a symbolic ref and so is detached.
+		if exitStatus(err) == 1 {
+			return true, nil
+		}
+		return false, NewLocalError("Unable to detect detached head", err, string(out))
+	}
+
+	return false, nil
+}
+
+// Upstream returns the short name of the upstream the checked out branch
+// tracks, such as origin/master. When the branch does not have an upstream or
+// HEAD is detached ErrNoUpstream is returned.
+func (s *GitRepo) Upstream() (string, error) {
+	out, err := s.RunFromDir("git", "symbolic-ref", "-q", "HEAD")
+	if err != nil {
+		if exitStatus(err) == 1 {
+			return "", ErrNoUpstream
+		}
+		return "", NewLocalError("Unable to retrieve upstream", err, string(out))
+	}
+
+	out, stderr, err := s.runSeparate("git", "for-each-ref", "--format=%(upstream:short)", strings.TrimSpace(string(out)))
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve upstream", err, string(stderr))
+	}
+	u := strings.TrimSpace(string(out))
+	if u == "" {
+		return "", ErrNoUpstream
+	}
+
+	return u, nil
+}
+
+// Date retrieves the date on the latest commit.
+func (s *GitRepo) Date() (time.Time, error) {
+	out, stderr, err := s.runSeparate("git", "log", "-1", "--date=iso", "--pretty=format:%cd")
+	if err != nil {
+		return time.Time{}, NewLocalError("Unable to retrieve revision date", err, string(stderr))
+	}
+	t, err := time.Parse(longForm, string(out))
+	if err != nil {
+		return time.Time{}, NewLocalError("Unable to retrieve revision date", err, string(out))
+	}
+	return t, nil
+}
+
+// RevisionAt retrieves the latest commit on the default branch of the
+// RemoteLocation, or on HEAD when it is not known, with a commit date at or
+// before the passed in time.
+func (s *GitRepo) RevisionAt(t time.Time) (string, error) {
+	ref := "refs/remotes/" + s.RemoteLocation + "/HEAD"
+	if _, err := s.RunFromDir("git", "rev-parse", "-q", "--verify", ref); err != nil {
+		ref = "HEAD"
+	}
+	out, stderr, err := s.runSeparate("git", "rev-list", "-1", "--before="+t.Format(time.RFC3339), ref)
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve revision at "+t.String(), err, string(stderr))
+	}
+	rev := strings.TrimSpace(string(out))
+	if rev == "" {
+		return "", ErrRevisionUnavailable
+	}
+	return rev, nil
+}
+
+// Branches returns a list of available branches on the RemoteLocation
+func (s *GitRepo) Branches() ([]string, error) {
+	out, stderr, err := s.runSeparate("git", "show-ref")
+	if err != nil {
+		return []string{}, NewLocalError("Unable to retrieve branches", err, string(stderr))
+	}
+
+	// A mirror has the branches of the remote as its own.
+	if s.Mirror {
+		return s.referenceList(string(out), `(?m-s)refs/heads/(\S+)$`), nil
+	}
+	// Only the remote tracking branches of the RemoteLocation are wanted, not
+	// those of other remotes or refs that happen to contain its name.
+	branches := s.referenceList(string(out), `(?m-s)refs/remotes/`+regexp.QuoteMeta(s.RemoteLocation)+`/(\S+)$`)
+	return branches, nil
+}
+
+// Tags returns a list of available tags on the RemoteLocation
+func (s *GitRepo) Tags() ([]string, error) {
+	out, stderr, err := s.runSeparate("git", "show-ref")
+	if err != nil {
+		return []string{}, NewLocalError("Unable to retrieve tags", err, string(stderr))
+	}
+	tags := s.referenceList(string(out), `(?m-s)(?:tags)/(\S+)$`)
+	return tags, nil
+}
+
+// NewTagsSince fetches the tags from the RemoteLocation and returns the tags
+// that are not in knownTags, such as the tags from a previous call to Tags.
+// Tags that were moved on the remote are updated locally but, as their names
+// are known, are not reported.
+func (s *GitRepo) NewTagsSince(knownTags []string) ([]string, error) {
+	// Without --force newer versions of git refuse to update a tag that was
+	// moved on the remote and fail the whole fetch.
+	out, err := s.RunFromDir("git", "fetch", "--tags", "--force", s.RemoteLocation)
+	if err != nil {
+		return []string{}, NewRemoteError("Unable to fetch tags", err, string(out))
+	}
+
+	tags, err := s.Tags()
+	if err != nil {
+		return []string{}, err
+	}
+
+	known := make(map[string]bool, len(knownTags))
+	for _, t := range knownTags {
+		known[t] = true
+	}
+	newTags := []string{}
+	for _, t := range tags {
+		if !known[t] {
+			newTags = append(newTags, t)
+		}
+	}
+
+	return newTags, nil
+}
+
+// CreateTag tags a version, or the checked out version when version is empty,
+// with a name. When message is set an annotated tag carrying the message is
+// created, otherwise a lightweight tag. The tag is only created locally, use
+// Push to publish it.
+func (s *GitRepo) CreateTag(name, message, version string) error {
+	if err := validateTagName(name); err != nil {
+		return err
+	}
+	if version == "" {
+		version = "HEAD"
+	}
+
+	args := []string{"tag"}
+	if message != "" {
+		args = append(args, "-a", "-m", message)
+	}
+	out, err := s.RunFromDir("git", append(args, "--", name, version)...)
+	if err != nil {
+		return NewLocalError("Unable to create tag "+name, err, string(out))
+	}
+
+	return nil
+}
+
+// DeleteTag deletes a tag from the checkout. To delete the tag from the
+// RemoteLocation as well push the refspec :refs/tags/<name>.
+func (s *GitRepo) DeleteTag(name string) error {
+	if err := validateTagName(name); err != nil {
+		return err
+	}
+
+	out, err := s.RunFromDir("git", "tag", "-d", "--", name)
+	if err != nil {
+		return NewLocalError("Unable to delete tag "+name, err, string(out))
+	}
+
+	return nil
+}
+
+// Push pushes a refspec, such as a branch, refs/tags/1.0.0, or
+// refs/heads/*:refs/heads/*, to the RemoteLocation. A refspec of the form
+// :<dst> deletes dst on the remote. When refspec is empty what git push
+// pushes by default, as configured by push.default, is pushed. The refspec is
+// validated before git is run.
+func (s *GitRepo) Push(refspec string) error {
+	args := []string{"push", s.RemoteLocation}
+	if refspec != "" {
+		spec := refspec
+		if strings.HasPrefix(spec, ":") {
+			spec = spec[1:]
+		}
+		if err := validateRefspec(spec); err != nil {
+			return err
+		}
+		args = append(args, refspec)
+	}
+
+	out, err := s.RunFromDir("git", args...)
+	if err != nil {
+		return NewRemoteError("Unable to push to remote", err, string(out))
+	}
+
+	return nil
+}
+
+// validateTagName checks a tag name is a valid ref name that cannot be taken
+// for a flag or a refspec.
+func validateTagName(name string) error {
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, ":*") || validateRefspec(name) != nil {
+		return NewLocalError(fmt.Sprintf("Invalid tag name %q", name), nil, "")
+	}
+	return nil
+}
+
+// AllRefs returns every local branch, remote branch, and tag, along with any
+// other refs, mapped to the commit id each points at using a single command.
+// Refs are keyed by their full name, such as refs/heads/master or
+// refs/tags/1.0.0, and annotated tags are mapped to the commit they tag.
+// Symbolic refs, such as the HEAD of a remote, are skipped as they are only
+// an alias for another ref in the map.
+func (s *GitRepo) AllRefs() (map[string]string, error) {
+	out, stderr, err := s.runSeparate("git", "for-each-ref", "--format=%(objectname) %(*objectname) %(refname) %(symref)")
+	if err != nil {
+		return map[string]string{}, NewLocalError("Unable to retrieve refs", err, string(stderr))
+	}
+
+	refs := make(map[string]string)
+	for _, l := range strings.Split(string(out), "\n") {
+		// The peeled object and symref fields are empty when they do not apply
+		// which leaves extra spaces in the line.
+		f := strings.Split(l, " ")
+		if len(f) != 4 || f[3] != "" {
+			continue
+		}
+
+		id := f[0]
+		if f[1] != "" {
+			id = f[1]
+		}
+		refs[f[2]] = id
+	}
+
+	return refs, nil
+}
+
+// Description returns the description of the repository stored in the
+// description file of the Git directory. This is typically used by bare
+// repositories served by tools like gitweb. When the file does not exist or
+// still contains the placeholder Git creates it with an empty string is
+// returned.
+func (s *GitRepo) Description() (string, error) {
+	// The Git directory is the repo itself for bare repos and .git, or wherever
+	// a .git file points, otherwise.
+	out, err := s.RunFromDir("git", "rev-parse", "--git-dir")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve description", err, string(out))
+	}
+	dir := filepath.FromSlash(strings.TrimSpace(string(out)))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(s.LocalPath(), dir)
+	}
+
+	b, err := readFile(s.fs(), filepath.Join(dir, "description"))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", NewLocalError("Unable to retrieve description", err, "")
+	}
+
+	d := strings.TrimSpace(string(b))
+	if strings.HasPrefix(d, "Unnamed repository;") {
+		return "", nil
+	}
+	return d, nil
+}
+
+// CheckLocal verifies the local location is a Git repo, either a checkout or
+// a bare repository such as a mirror.
+func (s *GitRepo) CheckLocal() bool {
+	_, ok := s.gitDir()
+	return ok
+}
+
+// gitDir returns the location of the git directory for the checkout. This is
+// the .git directory of a top-level repository, the local path itself for a
+// bare repository or, for submodules and linked worktrees, the directory
+// named by the gitdir pointer in a .git file. The second return value is
+// false when none of these forms points to an existing directory.
+func (s *GitRepo) gitDir() (string, bool) {
+	p := filepath.Join(s.LocalPath(), ".git")
+	fi, err := s.fs().Stat(p)
+	if err != nil {
+		if isBareGitDir(s.fs(), s.LocalPath()) {
+			return s.LocalPath(), true
+		}
+		return "", false
+	}
+	if fi.IsDir() {
+		return p, true
+	}
+
+	b, err := readFile(s.fs(), p)
+	if err != nil {
+		return "", false
+	}
+	line := strings.TrimSpace(strings.SplitN(string(b), "\n", 2)[0])
+	if !strings.HasPrefix(line, "gitdir:") {
+		return "", false
+	}
+	dir := filepath.FromSlash(strings.TrimSpace(strings.TrimPrefix(line, "gitdir:")))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(s.LocalPath(), dir)
+	}
+	if fi, err = s.fs().Stat(dir); err != nil || !fi.IsDir() {
+		return "", false
+	}
+
+	return dir, true
+}
+
+// isBareGitDir returns if a directory has the layout of a bare Git
+// repository, a HEAD file along with objects and refs directories.
+func isBareGitDir(fs FileSystem, dir string) bool {
+	if fi, err := fs.Stat(filepath.Join(dir, "HEAD")); err != nil || fi.IsDir() {
+		return false
+	}
+	for _, d := range []string{"objects", "refs"} {
+		if fi, err := fs.Stat(filepath.Join(dir, d)); err != nil || !fi.IsDir() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Status retrieves a snapshot of the state of the checkout from a single git
+// status command.
+func (s *GitRepo) Status() (*RepoStatus, error) {
+	out, stderr, err := s.runSeparate("git", "status", "--porcelain=v2", "--branch", "--untracked-files=no")
+	if err != nil {
+		return nil, NewLocalError("Unable to retrieve status", err, string(stderr))
+	}
+
+	st := &RepoStatus{}
+	for _, l := range strings.Split(string(out), "\n") {
+		if l == "" {
+			continue
+		}
+		if !strings.HasPrefix(l, "# ") {
+			// Every other line is a changed file.
+			st.Dirty = true
+			continue
+		}
+
+		f := strings.Fields(l)
+		if len(f) < 3 {
+			continue
+		}
+		switch f[1] {
+		case "branch.oid":
+			if f[2] != "(initial)" {
+				st.Version = f[2]
+			}
+		case "branch.head":
+			if f[2] != "(detached)" {
+				st.Branch = f[2]
+			}
+		case "branch.upstream":
+			st.HasUpstream = true
+		case "branch.ab":
+			if len(f) == 4 {
+				st.Ahead, _ = strconv.Atoi(strings.TrimPrefix(f[2], "+"))
+				st.Behind, _ = strconv.Atoi(strings.TrimPrefix(f[3], "-"))
+			}
+		}
+	}
+
+	return st, nil
+}
+
+// Pin records the remote and the full hash of the checked out commit, along
+// with the branch or tag it is on as returned by Current.
+func (s *GitRepo) Pin() (Pin, error) {
+	return pinRepo(s)
+}
+
+// IsReference returns if a string is a reference. A reference can be a
+// commit id, branch, or tag.
+func (s *GitRepo) IsReference(r string) bool {
+	_, err := s.RunFromDir("git", "rev-parse", "--verify", r)
+	if err == nil {
+		return true
+	}
+
+	// Some refs will fail rev-parse. For example, a remote branch that has
+	// not been checked out yet. This next step should pickup the other
+	// possible references.
+	_, err = s.RunFromDir("git", "show-ref", r)
+	return err == nil
+}
+
+// RefType returns the kind of reference a name is. Local branches and the
+// branches of the RemoteLocation are branches. A name that is both a branch
+// and a tag is reported as a branch, as that is what UpdateVersion checks out.
+// A commit id, or an abbreviation of one, is a commit. Other revision
+// expressions, such as HEAD~1, are RefUnknown.
+func (s *GitRepo) RefType(r string) RefType {
+	// HEAD is symbolic, it is not the remote HEAD it would otherwise match.
+	if r == "" || r == "HEAD" || strings.HasPrefix(r, "-") {
+		return RefUnknown
+	}
+
+	for _, ref := range []struct {
+		name string
+		t    RefType
+	}{
+		{"refs/heads/" + r, RefBranch},
+		{"refs/remotes/" + s.RemoteLocation + "/" + r, RefBranch},
+		{"refs/tags/" + r, RefTag},
+	} {
+		if _, err := s.RunFromDir("git", "show-ref", "--verify", "-q", ref.name); err == nil {
+			return ref.t
+		}
+	}
+
+	out, err := s.RunFromDir("git", "rev-parse", "--verify", "-q", r+"^{commit}")
+	if err != nil {
+		return RefUnknown
+	}
+	if isHex(r) && strings.HasPrefix(strings.TrimSpace(string(out)), strings.ToLower(r)) {
+		return RefCommit
+	}
+
+	// Full or partial ref names, such as refs/tags/1.0.0 or origin/master.
+	// Symbolic refs such as HEAD are not resolved to the branch they point at.
+	if !strings.Contains(r, "/") {
+		return RefUnknown
+	}
+	out, stderr, err := s.runSeparate("git", "rev-parse", "--symbolic-full-name", r)
+	if err != nil || len(stderr) > 0 {
+		return RefUnknown
+	}
+	full := strings.TrimSpace(string(out))
+	switch {
+	case strings.HasPrefix(full, "refs/heads/"), strings.HasPrefix(full, "refs/remotes/"):
+		return RefBranch
+	case strings.HasPrefix(full, "refs/tags/"):
+		return RefTag
+	}
+	return RefUnknown
+}
+
+// Reset discards the changes to tracked files, and the submodules, with git
+// reset --hard when hard is true. Otherwise the index is reset so staged
+// changes are kept in the working tree but no longer staged.
+func (s *GitRepo) Reset(hard bool) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !hard {
+		out, err := s.RunFromDir("git", "reset", "-q")
+		if err != nil {
+			return NewLocalError("Unable to reset checkout", err, string(out))
+		}
+		return nil
+	}
+
+	out, err := s.RunFromDir("git", "reset", "-q", "--hard")
+	if err != nil {
+		return NewLocalError("Unable to reset checkout", err, string(out))
+	}
+	if s.SkipSubmodules {
+		return nil
+	}
+	out, err = s.RunFromDir("git", "submodule", "foreach", "--recursive", "git", "reset", "-q", "--hard")
+	if err != nil {
+		return NewLocalError("Unable to reset submodules", err, string(out))
+	}
+
+	return s.updateSubmodules()
+}
+
+// Clean removes the untracked files and directories, including those of the
+// submodules, with git clean. Nested repositories that are not submodules are
+// removed as well.
+func (s *GitRepo) Clean(ignored bool) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// A second -f is needed for git to remove nested repositories.
+	args := []string{"clean", "-q", "-d", "-f", "-f"}
+	if ignored {
+		args = append(args, "-x")
+	}
+
+	out, err := s.RunFromDir("git", args...)
+	if err != nil {
+		return NewLocalError("Unable to clean checkout", err, string(out))
+	}
+	if s.SkipSubmodules {
+		return nil
+	}
+	out, err = s.RunFromDir("git", append([]string{"submodule", "foreach", "--recursive", "git"}, args...)...)
+	if err != nil {
+		return NewLocalError("Unable to clean submodules", err, string(out))
+	}
+
+	return nil
+}
+
+// IsDirty returns if the checkout has been modified from the checked
+// out reference.
+func (s *GitRepo) IsDirty() bool {
+	out, err := s.RunFromDir("git", "status", "--porcelain", "--untracked-files=no")
+	return err != nil || len(out) != 0
+}
+
+// CommitInfo retrieves metadata about a commit, including its full message.
+func (s *GitRepo) CommitInfo(id string) (*CommitInfo, error) {
+	log, err := s.CommitLog(LogOptions{To: id, Limit: 1})
+	if err != nil || len(log) == 0 {
+		return nil, ErrRevisionUnavailable
+	}
+
+	return &log[0], nil
+}
+
+// CommitLog retrieves metadata about the commits selected by the options,
+// the most recent first. As with CommitInfo the full commit messages are
+// returned.
+func (s *GitRepo) CommitLog(o LogOptions) ([]CommitInfo, error) {
+	author := "%aN <%aE>"
+	if s.IgnoreMailmap {
+		author = "%an <%ae>"
+	}
+
+	// With -z each field and each commit ends in a NUL, which unlike markup
+	// cannot appear in the message.
+	args := []string{"log", "-z", "--format=%H%x00" + author + "%x00%aD%x00%B"}
+	if o.Limit > 0 {
+		args = append(args, "-n", strconv.Itoa(o.Limit))
+	}
+	rev := o.To
+	if rev == "" {
+		rev = "HEAD"
+	}
+	if o.From != "" {
+		rev = o.From + ".." + rev
+	}
+	args = append(args, rev, "--")
+
+	out, err := s.RunFromDir("git", args...)
+	if err != nil {
+		return []CommitInfo{}, NewLocalError("Unable to retrieve commit log", err, string(out))
+	}
+
+	log := []CommitInfo{}
+	if len(out) == 0 {
+		return log, nil
+	}
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	if len(fields)%4 != 0 {
+		return []CommitInfo{}, NewLocalError("Unable to parse commit log", nil, string(out))
+	}
+	for i := 0; i < len(fields); i += 4 {
+		t, err := time.Parse("Mon, _2 Jan 2006 15:04:05 -0700", fields[i+2])
+		if err != nil {
+			return []CommitInfo{}, NewLocalError("Unable to parse commit log", err, string(out))
+		}
+		ci := CommitInfo{
+			Commit:  fields[i],
+			Author:  fields[i+1],
+			Date:    t,
+			Message: strings.TrimRight(fields[i+3], "\n"),
+		}
+		ci.AuthorName, ci.AuthorEmail = splitAuthor(ci.Author)
+		log = append(log, ci)
+	}
+
+	return log, nil
+}
+
+// CommitMessage retrieves the full message of the checked out commit.
+func (s *GitRepo) CommitMessage() (string, error) {
+	out, err := s.RunFromDir("git", "log", "-1", "--format=%B")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve commit message", err, string(out))
+	}
+
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// TagsFromCommit retrieves tags from a commit id.
+func (s *GitRepo) TagsFromCommit(id string) ([]string, error) {
+	// This is imperfect and a better method would be great.
+
+	var re []string
+
+	out, stderr, err := s.runSeparate("git", "show-ref", "-d")
+	if err != nil {
+		return []string{}, NewLocalError("Unable to retrieve tags", err, string(stderr))
+	}
+
+	lines := strings.Split(string(out), "\n")
+	var list []string
+	for _, i := range lines {
+		if strings.HasPrefix(strings.TrimSpace(i), id) {
+			list = append(list, i)
+		}
+	}
+	tags := s.referenceList(strings.Join(list, "\n"), `(?m-s)(?:tags)/(\S+)$`)
+	for _, t := range tags {
+		// Dereferenced tags have ^{} appended to them.
+		re = append(re, strings.TrimSuffix(t, "^{}"))
+	}
+
+	return re, nil
+}
+
+// Ping returns if remote location is accessible.
+func (s *GitRepo) Ping() bool {
+	return s.CheckRemote() == nil
+}
+
+// CheckRemote checks the remote location is accessible by listing its HEAD
+// with git ls-remote.
+func (s *GitRepo) CheckRemote() error {
+	args := []string{"ls-remote", s.Remote(), "HEAD"}
+	newCmd := func() *exec.Cmd {
+		c := exec.Command("git", s.configArgs("git", args)...)
+
+		// If prompted for a username and password, which GitHub does for all
+		// things not public, it's considered not available. To make it
+		// available the remote needs to be different.
+		c.Env = mergeEnvLists(append([]string{"GIT_TERMINAL_PROMPT=0"}, s.authEnv("git")...), s.environ())
+		return c
+	}
+	var out bytes.Buffer
+	if err := s.captureRetry("git", args, newCmd, &out, &out); err != nil {
+		return NewRemoteError("Unable to reach remote", err, out.String())
+	}
+
+	return nil
 }
 
 // EscapePathSeparator escapes the path separator by replacing it with several.
@@ -386,58 +2053,931 @@ func EscapePathSeparator(path string) string {
 	}
 }
 
-// ExportDir exports the current revision to the passed in directory.
-func (s *GitRepo) ExportDir(dir string) error {
+// ExportDir exports the current revision to the passed in directory.
+func (s *GitRepo) ExportDir(dir string) error {
+
+	var path string
+
+	// Without the trailing / there can be problems.
+	if !strings.HasSuffix(dir, string(os.PathSeparator)) {
+		dir = dir + string(os.PathSeparator)
+	}
+
+	// checkout-index on some systems, such as some Windows cases, does not
+	// create the parent directory to export into if it does not exist. Explicitly
+	// creating it.
+	err := s.fs().MkdirAll(dir, 0755)
+	if err != nil {
+		return NewLocalError("Unable to create directory", err, "")
+	}
+
+	path = EscapePathSeparator(dir)
+	out, err := s.RunFromDir("git", "checkout-index", "-f", "-a", "--prefix="+path)
+	s.log(out)
+	if err != nil {
+		return NewLocalError("Unable to export source", err, string(out))
+	}
+
+	// and now, the horror of submodules
+	path = EscapePathSeparator(dir + "$path" + string(os.PathSeparator))
+	out, err = s.RunFromDir("git", "submodule", "foreach", "--recursive", "git checkout-index -f -a --prefix="+path)
+	s.log(out)
+	if err != nil {
+		return NewLocalError("Error while exporting submodule sources", err, string(out))
+	}
+
+	return nil
+}
+
+// ExportVersion exports the files of a version to the passed in directory
+// without changing the checkout. The tree of the version is read into a
+// temporary index and checked out from it, so submodules, which ExportDir
+// includes for the current revision, are not exported.
+func (s *GitRepo) ExportVersion(dir, version string) error {
+	if _, err := s.RunFromDir("git", "rev-parse", "--verify", "--quiet", version+"^{tree}"); err != nil {
+		return ErrRevisionUnavailable
+	}
+
+	err := s.fs().MkdirAll(dir, 0755)
+	if err != nil {
+		return NewLocalError("Unable to create directory", err, "")
+	}
+
+	// Git creates the index itself and rejects an existing empty file, so
+	// only a unique name is taken from the temporary file.
+	f, err := ioutil.TempFile("", "go-vcs-export-index")
+	if err != nil {
+		return NewLocalError("Unable to create temporary index", err, "")
+	}
+	index := f.Name()
+	f.Close()
+	os.Remove(index)
+	defer os.Remove(index)
+
+	if !strings.HasSuffix(dir, string(os.PathSeparator)) {
+		dir = dir + string(os.PathSeparator)
+	}
+	for _, args := range [][]string{
+		{"read-tree", version + "^{tree}"},
+		{"checkout-index", "-f", "-a", "--prefix=" + EscapePathSeparator(dir)},
+	} {
+		c := s.CmdFromDir("git", args...)
+		c.Env = append(c.Env, "GIT_INDEX_FILE="+index)
+		out, err := s.runInput(c)
+		s.log(out)
+		if err != nil {
+			return NewLocalError("Unable to export "+version, err, string(out))
+		}
+	}
+
+	return nil
+}
+
+// MergeBase returns the best common ancestor of two revisions. When the
+// revisions do not share any history ErrNoCommonAncestor is returned.
+func (s *GitRepo) MergeBase(a, b string) (string, error) {
+	out, err := s.RunFromDir("git", "merge-base", a, b)
+	if err != nil {
+		// git merge-base exits with a status of 1, and no output, when there
+		// is no common ancestor. Invalid revisions exit with a different status.
+		if exitStatus(err) == 1 && len(bytes.TrimSpace(out)) == 0 {
+			return "", ErrNoCommonAncestor
+		}
+		return "", NewLocalError("Unable to retrieve merge base", err, string(out))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Parents returns the commit ids of the parents of a commit. A merge commit
+// has two or more parents and a root commit has none. ErrRevisionUnavailable
+// is returned when the revision does not exist.
+func (s *GitRepo) Parents(id string) ([]string, error) {
+	out, err := s.RunFromDir("git", "rev-list", "--parents", "-n", "1", id, "--")
+	if err != nil {
+		return []string{}, ErrRevisionUnavailable
+	}
+
+	// The line starts with the commit itself followed by its parents.
+	f := strings.Fields(string(out))
+	if len(f) == 0 {
+		return []string{}, ErrRevisionUnavailable
+	}
+
+	return f[1:], nil
+}
+
+// IsAncestor returns if ancestor is an ancestor of descendant. A commit is
+// considered an ancestor of itself. An error is returned when either
+// revision is invalid.
+func (s *GitRepo) IsAncestor(ancestor, descendant string) (bool, error) {
+	out, err := s.RunFromDir("git", "merge-base", "--is-ancestor", ancestor, descendant)
+	if err != nil {
+		// A status of 1 means not an ancestor. Invalid revisions exit with 128.
+		if exitStatus(err) == 1 {
+			return false, nil
+		}
+		return false, NewLocalError("Unable to determine ancestry", err, string(out))
+	}
+
+	return true, nil
+}
+
+// AbortAll aborts any merge, rebase, cherry-pick, or revert that was left in
+// progress in the checkout, such as after one failed with a conflict. Once
+// aborted the working tree is verified to be clean. When nothing is in
+// progress only the verification is performed.
+func (s *GitRepo) AbortAll() error {
+	gitDir, ok := s.gitDir()
+	if !ok {
+		return NewLocalError("Unable to locate git directory", nil, "")
+	}
+	inProgress := func(name string) bool {
+		_, err := s.fs().Stat(filepath.Join(gitDir, name))
+		return err == nil
+	}
+
+	var ops []string
+	if inProgress("rebase-merge") || inProgress("rebase-apply") {
+		ops = append(ops, "rebase")
+	}
+	if inProgress("MERGE_HEAD") {
+		ops = append(ops, "merge")
+	}
+	if inProgress("CHERRY_PICK_HEAD") {
+		ops = append(ops, "cherry-pick")
+	}
+	if inProgress("REVERT_HEAD") {
+		ops = append(ops, "revert")
+	}
+
+	for _, op := range ops {
+		out, err := s.RunFromDir("git", op, "--abort")
+		if err != nil {
+			return NewLocalError("Unable to abort in progress "+op, err, string(out))
+		}
+	}
+
+	if s.IsDirty() {
+		return NewLocalError("Working tree is not clean after aborting in progress operations", nil, "")
+	}
+
+	return nil
+}
+
+// StashEntry describes a single entry in the stash of a Git checkout.
+type StashEntry struct {
+	// The position of the entry in the stash where 0 is the most recent
+	Index int
+
+	// The branch that was checked out when the entry was created
+	Branch string
+
+	// The message describing the entry
+	Message string
+}
+
+// Stashes returns the entries in the stash, most recent first. When the stash
+// is empty an empty list is returned.
+func (s *GitRepo) Stashes() ([]StashEntry, error) {
+	out, err := s.RunFromDir("git", "stash", "list", "--format=%gd%x00%gs")
+	if err != nil {
+		return []StashEntry{}, NewLocalError("Unable to retrieve stashes", err, string(out))
+	}
+
+	entries := []StashEntry{}
+	for _, l := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(l, "\x00", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		// The selector is in the form stash@{<index>}.
+		i, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(parts[0], "stash@{"), "}"))
+		if err != nil {
+			return []StashEntry{}, NewLocalError("Unable to retrieve stashes", err, string(out))
+		}
+		e := StashEntry{Index: i, Message: parts[1]}
+
+		// The subject is in the form "WIP on <branch>: <commit> <subject>" for
+		// entries without a message or "On <branch>: <message>" otherwise.
+		for _, p := range []string{"WIP on ", "On "} {
+			if !strings.HasPrefix(parts[1], p) {
+				continue
+			}
+			subj := strings.TrimPrefix(parts[1], p)
+			if sep := strings.Index(subj, ": "); sep != -1 {
+				e.Branch = subj[:sep]
+				e.Message = subj[sep+2:]
+			}
+			break
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// Contributor describes a person who authored commits in a repository.
+type Contributor struct {
+	// The name and email of the author after applying any .mailmap
+	Name, Email string
 
-	var path string
+	// The number of commits authored
+	Commits int
+}
 
-	// Without the trailing / there can be problems.
-	if !strings.HasSuffix(dir, string(os.PathSeparator)) {
-		dir = dir + string(os.PathSeparator)
+// Contributors returns the authors of the commits reachable from HEAD, most
+// commits first with ties ordered by name. Identities are combined using the
+// .mailmap in the repository, if there is one, unless IgnoreMailmap is set. A
+// repository without commits returns an empty list.
+func (s *GitRepo) Contributors() ([]Contributor, error) {
+	if _, err := s.RunFromDir("git", "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		return []Contributor{}, nil
 	}
 
-	// checkout-index on some systems, such as some Windows cases, does not
-	// create the parent directory to export into if it does not exist. Explicitly
-	// creating it.
-	err := os.MkdirAll(dir, 0755)
+	args := []string{"shortlog", "-sne"}
+	if s.IgnoreMailmap {
+		// Grouping by the raw author fields is the only way to keep shortlog
+		// from applying the .mailmap.
+		args = append(args, "--group=format:%an <%ae>")
+	}
+	out, stderr, err := s.runSeparate("git", append(args, "HEAD", "--")...)
 	if err != nil {
-		return NewLocalError("Unable to create directory", err, "")
+		return []Contributor{}, NewLocalError("Unable to retrieve contributors", err, string(stderr))
 	}
 
-	path = EscapePathSeparator(dir)
-	out, err := s.RunFromDir("git", "checkout-index", "-f", "-a", "--prefix="+path)
-	s.log(out)
+	// Each line is in the form "<commits>\t<name> <<email>>".
+	contributors := []Contributor{}
+	for _, l := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(l), "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return []Contributor{}, NewLocalError("Unable to retrieve contributors", err, string(out))
+		}
+		c := Contributor{Name: parts[1], Commits: n}
+		if i := strings.LastIndex(parts[1], " <"); i != -1 && strings.HasSuffix(parts[1], ">") {
+			c.Name = parts[1][:i]
+			c.Email = parts[1][i+2 : len(parts[1])-1]
+		}
+		contributors = append(contributors, c)
+	}
+
+	return contributors, nil
+}
+
+// ReadNote retrieves the Git note attached to a commit under the notes ref,
+// such as commits or refs/notes/commits. When the commit does not have a note
+// ErrNoteNotFound is returned.
+func (s *GitRepo) ReadNote(ref, commit string) ([]byte, error) {
+	out, err := s.RunFromDir("git", "notes", "--ref="+ref, "show", commit)
 	if err != nil {
-		return NewLocalError("Unable to export source", err, string(out))
+		if exitStatus(err) == 1 && bytes.Contains(out, []byte("no note found")) {
+			return nil, ErrNoteNotFound
+		}
+		return nil, NewLocalError("Unable to read note", err, string(out))
 	}
 
-	// and now, the horror of submodules
-	path = EscapePathSeparator(dir + "$path" + string(os.PathSeparator))
-	out, err = s.RunFromDir("git", "submodule", "foreach", "--recursive", "git checkout-index -f -a --prefix="+path)
-	s.log(out)
+	return out, nil
+}
+
+// WriteNote attaches a Git note to a commit under the notes ref. An existing
+// note on the commit under the same ref is replaced.
+func (s *GitRepo) WriteNote(ref, commit, message string) error {
+	out, err := s.RunFromDir("git", "notes", "--ref="+ref, "add", "-f", "-m", message, commit)
 	if err != nil {
-		return NewLocalError("Error while exporting submodule sources", err, string(out))
+		return NewLocalError("Unable to write note", err, string(out))
+	}
+
+	return nil
+}
+
+// RevList returns the commit ids selected by a git rev-list specification,
+// such as a revision, a range like HEAD~10..HEAD, or --all. The commits are
+// returned newest first in the order git rev-list provides them.
+func (s *GitRepo) RevList(spec string) ([]string, error) {
+	out, stderr, err := s.runSeparate("git", "rev-list", spec, "--")
+	if err != nil {
+		return []string{}, NewLocalError("Unable to retrieve revisions", err, string(stderr))
+	}
+
+	return strings.Fields(string(out)), nil
+}
+
+// CommitFileCounts returns the number of files each commit selected by a git
+// rev-list specification, such as HEAD~10..HEAD, changed, keyed by commit id.
+// All commits are read in a single pass of git log. Merge commits are
+// reported with a count of 0 because they do not change files themselves.
+// Use ChangedFiles with the first parent of a merge to see what it brought in.
+func (s *GitRepo) CommitFileCounts(spec string) (map[string]int, error) {
+	out, stderr, err := s.runSeparate("git", "log", "--format=%x00%H", "--name-only", spec, "--")
+	if err != nil {
+		return map[string]int{}, NewLocalError("Unable to retrieve commit file counts", err, string(stderr))
+	}
+
+	counts := map[string]int{}
+	for _, c := range strings.Split(string(out), "\x00") {
+		lines := strings.Split(c, "\n")
+		if lines[0] == "" {
+			continue
+		}
+		n := 0
+		for _, l := range lines[1:] {
+			if l != "" {
+				n++
+			}
+		}
+		counts[lines[0]] = n
+	}
+
+	return counts, nil
+}
+
+// HasUnpushedCommits returns if any local branch, or a detached HEAD, has
+// commits that are not on a remote. Rather than comparing each branch with its
+// upstream, commits are checked against every remote-tracking branch so a
+// branch without an upstream only counts when its commits are nowhere on a
+// remote. The remote-tracking branches are as of the last fetch. A checkout
+// without any remotes reports every commit as unpushed.
+func (s *GitRepo) HasUnpushedCommits() (bool, error) {
+	args := []string{"rev-list", "-n", "1", "--branches"}
+	if _, err := s.RunFromDir("git", "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+		args = append(args, "HEAD")
+	}
+	out, stderr, err := s.runSeparate("git", append(args, "--not", "--remotes", "--")...)
+	if err != nil {
+		return false, NewLocalError("Unable to check for unpushed commits", err, string(stderr))
+	}
+
+	return strings.TrimSpace(string(out)) != "", nil
+}
+
+// RestorePaths checks out the given paths, relative to the root of the
+// repository, as they are at ref without moving HEAD or switching branches.
+// The restored content is also staged. If any of the paths do not exist at
+// ref nothing is restored and the error lists the missing paths.
+func (s *GitRepo) RestorePaths(ref string, paths ...string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	out, err := s.RunFromDir("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return ErrRevisionUnavailable
+	}
+
+	var missing []string
+	for _, p := range paths {
+		_, err = s.RunFromDir("git", "cat-file", "-e", ref+":"+filepath.ToSlash(p))
+		if err != nil {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		return NewLocalError("Paths not found at "+ref+": "+strings.Join(missing, ", "), nil, "")
+	}
+
+	args := append([]string{"checkout", ref, "--"}, paths...)
+	out, err = s.RunFromDir("git", args...)
+	if err != nil {
+		return NewLocalError("Unable to restore paths", err, string(out))
+	}
+
+	return nil
+}
+
+// ExcludePaths limits the working tree to everything except the given paths,
+// relative to the root of the repository, using a non-cone sparse checkout.
+// The excluded files and directories are removed from the working tree while
+// remaining in the history. Call it after Get to skip large directories that
+// are not needed. Each call replaces the previous exclusions and passing no
+// paths disables the sparse checkout, restoring the full working tree.
+func (s *GitRepo) ExcludePaths(paths []string) error {
+	if len(paths) == 0 {
+		out, err := s.RunFromDir("git", "sparse-checkout", "disable")
+		if err != nil {
+			return NewLocalError("Unable to restore excluded paths", err, string(out))
+		}
+		return nil
+	}
+
+	// Everything at the top level is included, and with it everything below,
+	// and the paths are then excluded. Characters that are special in
+	// patterns are escaped so paths are matched literally.
+	patterns := []string{"/*"}
+	for _, p := range paths {
+		p = strings.Trim(filepath.ToSlash(p), "/")
+		if p == "" || p == "." {
+			return NewLocalError("Unable to exclude the root of the repository", nil, "")
+		}
+		var e bytes.Buffer
+		for _, r := range p {
+			if strings.ContainsRune(`\*?[!#`, r) {
+				e.WriteRune('\\')
+			}
+			e.WriteRune(r)
+		}
+		patterns = append(patterns, "!/"+e.String())
+	}
+
+	out, err := s.RunFromDir("git", "sparse-checkout", "init", "--no-cone")
+	if err != nil {
+		return NewLocalError("Unable to exclude paths", err, string(out))
+	}
+	c := s.CmdFromDir("git", "sparse-checkout", "set", "--stdin")
+	c.Stdin = strings.NewReader(strings.Join(patterns, "\n") + "\n")
+	if out, err = s.runInput(c); err != nil {
+		return NewLocalError("Unable to exclude paths", err, string(out))
+	}
+
+	return nil
+}
+
+// SparseCheckout limits the working tree to the given directories, relative
+// to the root of the repository, along with the files at the top level using
+// a cone mode sparse checkout. Everything else is removed from the working
+// tree while remaining in the history, and stays out of it when Update or
+// UpdateVersion check out a different commit. Each call replaces the previous
+// directories, or the paths of ExcludePaths, and passing no directories
+// disables the sparse checkout, restoring the full working tree.
+func (s *GitRepo) SparseCheckout(dirs []string) error {
+	if len(dirs) == 0 {
+		out, err := s.RunFromDir("git", "sparse-checkout", "disable")
+		if err != nil {
+			return NewLocalError("Unable to restore sparse paths", err, string(out))
+		}
+		return nil
+	}
+
+	var paths []string
+	for _, d := range dirs {
+		d = strings.Trim(filepath.ToSlash(d), "/")
+		if d == "" || d == "." {
+			return NewLocalError("Unable to limit the checkout to the root of the repository", nil, "")
+		}
+		paths = append(paths, d)
+	}
+
+	out, err := s.RunFromDir("git", "sparse-checkout", "init", "--cone")
+	if err != nil {
+		return NewLocalError("Unable to set sparse paths", err, string(out))
+	}
+	c := s.CmdFromDir("git", "sparse-checkout", "set", "--stdin")
+	c.Stdin = strings.NewReader(strings.Join(paths, "\n") + "\n")
+	if out, err = s.runInput(c); err != nil {
+		return NewLocalError("Unable to set sparse paths", err, string(out))
+	}
+
+	return nil
+}
+
+// ListFiles returns the paths, relative to the root of the repository, of the
+// files tracked at the checked out commit.
+func (s *GitRepo) ListFiles() ([]string, error) {
+	out, stderr, err := s.runSeparate("git", "ls-tree", "-r", "-z", "--name-only", "HEAD")
+	if err != nil {
+		return []string{}, NewLocalError("Unable to list files", err, string(stderr))
+	}
+
+	files := []string{}
+	for _, p := range strings.Split(string(out), "\x00") {
+		if p != "" {
+			files = append(files, filepath.FromSlash(p))
+		}
+	}
+
+	return files, nil
+}
+
+// Diff returns the changes between two revisions as a unified diff. Renames
+// are detected and external diff tools are not used.
+func (s *GitRepo) Diff(from, to string) (string, error) {
+	out, stderr, err := s.runSeparate("git", "diff", "--no-ext-diff", "-M", from, to, "--")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve diff", err, string(stderr))
+	}
+
+	return string(out), nil
+}
+
+// ChangedFiles returns the paths of the files that differ between two
+// revisions. A renamed file is reported as its new path. Use FileChanges to
+// also retrieve the kind of change and the original path of a rename.
+func (s *GitRepo) ChangedFiles(from, to string) ([]string, error) {
+	out, stderr, err := s.runSeparate("git", "diff", "--name-only", "-z", "-M", from, to, "--")
+	if err != nil {
+		return []string{}, NewLocalError("Unable to retrieve changed files", err, string(stderr))
+	}
+
+	// The paths are NUL terminated so those with special characters are not
+	// quoted.
+	files := []string{}
+	for _, p := range strings.Split(string(out), "\x00") {
+		if p != "" {
+			files = append(files, p)
+		}
+	}
+
+	return files, nil
+}
+
+// FileChange describes a file that differs between two revisions.
+type FileChange struct {
+	// The kind of change. A for add, M for modify, D for delete, R for rename,
+	// C for copy, and T for a change in the type of file.
+	Status string
+
+	// The path of the file
+	Path string
+
+	// The path the file was renamed or copied from
+	OldPath string
+}
+
+// FileChanges returns the files that differ between two revisions along with
+// the kind of change to each.
+func (s *GitRepo) FileChanges(from, to string) ([]FileChange, error) {
+	out, stderr, err := s.runSeparate("git", "diff", "--name-status", "-z", "-M", from, to, "--")
+	if err != nil {
+		return []FileChange{}, NewLocalError("Unable to retrieve changed files", err, string(stderr))
+	}
+
+	// Each status and path is NUL terminated. Renames and copies are reported
+	// as R<score> or C<score> followed by both the old and new path.
+	changes := []FileChange{}
+	f := strings.Split(string(out), "\x00")
+	for i := 0; i+1 < len(f); i += 2 {
+		if f[i] == "" {
+			break
+		}
+		c := FileChange{Status: f[i][:1], Path: f[i+1]}
+		if (c.Status == "R" || c.Status == "C") && i+2 < len(f) {
+			i++
+			c.OldPath, c.Path = f[i], f[i+1]
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, nil
+}
+
+// WorkingTreeStatus describes the changes in the working tree and index of a
+// Git checkout. The paths are relative to the root of the repository.
+type WorkingTreeStatus struct {
+	// The changes staged in the index compared to HEAD
+	Staged []FileChange
+
+	// The changes in the working tree that are not staged
+	Modified []FileChange
+
+	// The files that are not tracked, excluding ignored files
+	Untracked []string
+
+	// The files with unresolved merge conflicts
+	Conflicted []string
+}
+
+// StatusDetail returns the staged, modified, untracked, and conflicted files
+// in the checkout as reported by git status. A renamed or copied file is
+// reported with the path it came from. Untracked directories are listed as
+// the individual files in them.
+func (s *GitRepo) StatusDetail() (*WorkingTreeStatus, error) {
+	out, stderr, err := s.runSeparate("git", "status", "--porcelain=v2", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, NewLocalError("Unable to retrieve status", err, string(stderr))
+	}
+
+	st := &WorkingTreeStatus{
+		Staged:     []FileChange{},
+		Modified:   []FileChange{},
+		Untracked:  []string{},
+		Conflicted: []string{},
+	}
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i < len(entries); i++ {
+		e := entries[i]
+		if len(e) < 2 {
+			continue
+		}
+
+		// Changed entries are in the form "1 <XY> ... <path>" with 8 fields
+		// before the path, renames and copies in the form "2 <XY> ... <score>
+		// <path>" followed by the original path as the next entry, and
+		// conflicts in the form "u <XY> ... <path>" with 10 fields before the
+		// path. X is the change in the index and Y in the working tree.
+		var f []string
+		switch e[0] {
+		case '?':
+			st.Untracked = append(st.Untracked, e[2:])
+			continue
+		case '1':
+			f = strings.SplitN(e, " ", 9)
+		case '2':
+			f = strings.SplitN(e, " ", 10)
+		case 'u':
+			if f = strings.SplitN(e, " ", 11); len(f) == 11 {
+				st.Conflicted = append(st.Conflicted, f[10])
+			}
+			continue
+		default:
+			continue
+		}
+		if len(f) < 9 || len(f[1]) != 2 {
+			continue
+		}
+
+		c := FileChange{Path: f[len(f)-1]}
+		if e[0] == '2' && i+1 < len(entries) {
+			i++
+			c.OldPath = entries[i]
+		}
+		if x := f[1][:1]; x != "." {
+			sc := c
+			sc.Status = x
+			if x != "R" && x != "C" {
+				sc.OldPath = ""
+			}
+			st.Staged = append(st.Staged, sc)
+		}
+		if y := f[1][1:]; y != "." {
+			wc := c
+			wc.Status = y
+			if y != "R" && y != "C" {
+				wc.OldPath = ""
+			}
+			st.Modified = append(st.Modified, wc)
+		}
+	}
+
+	return st, nil
+}
+
+// Submodule describes a submodule declared in a repository's .gitmodules file.
+type Submodule struct {
+	// The name of the submodule
+	Name string
+
+	// The path, relative to the repository root, the submodule is placed at
+	Path string
+
+	// The remote location of the submodule
+	URL string
+}
+
+// Submodules returns the submodules declared in the .gitmodules file at the
+// root of the checkout. The submodules are not required to be initialized or
+// checked out. When there are no submodules an empty list is returned.
+func (s *GitRepo) Submodules() ([]Submodule, error) {
+	subs := []Submodule{}
+	if _, err := s.fs().Stat(filepath.Join(s.LocalPath(), ".gitmodules")); os.IsNotExist(err) {
+		return subs, nil
+	}
+
+	out, err := s.RunFromDir("git", "config", "-f", ".gitmodules", "--get-regexp", `^submodule\.`)
+	if err != nil {
+		// git config exits with a status of 1 when nothing matched. In that case
+		// there is no output and there are no submodules.
+		if len(bytes.TrimSpace(out)) == 0 {
+			return subs, nil
+		}
+		return []Submodule{}, NewLocalError("Unable to retrieve submodules", err, string(out))
+	}
+
+	// Each line is in the form submodule.<name>.<key> <value> where the name
+	// may contain dots of its own.
+	idx := make(map[string]int)
+	for _, l := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(l), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		k := strings.TrimPrefix(parts[0], "submodule.")
+		dot := strings.LastIndex(k, ".")
+		if dot == -1 {
+			continue
+		}
+		name, key := k[:dot], k[dot+1:]
+
+		i, ok := idx[name]
+		if !ok {
+			i = len(subs)
+			idx[name] = i
+			subs = append(subs, Submodule{Name: name})
+		}
+
+		switch key {
+		case "path":
+			subs[i].Path = parts[1]
+		case "url":
+			subs[i].URL = parts[1]
+		}
+	}
+
+	return subs, nil
+}
+
+// EnableCredentialCache keeps credentials used for the remote in memory, via
+// the git credential cache, so they are only provided once for the operations
+// performed by the repo. The cache is specific to the repo and is not shared
+// with other repos or the credential helpers the user has configured. Cached
+// credentials expire after the timeout. ClearCredentialCache needs to be
+// called when the repo is no longer used. The git credential cache is not
+// available on Windows.
+func (s *GitRepo) EnableCredentialCache(timeout time.Duration) error {
+	if s.credentialSocket != "" {
+		s.credentialTimeout = int(timeout.Seconds())
+		return nil
+	}
+
+	// The directory is only accessible by the current user which protects the
+	// socket the cache listens on.
+	dir, err := ioutil.TempDir("", "go-vcs-credentials")
+	if err != nil {
+		return NewLocalError("Unable to enable credential cache", err, "")
+	}
+	s.credentialSocket = filepath.Join(dir, "socket")
+	s.credentialTimeout = int(timeout.Seconds())
+
+	return nil
+}
+
+// ClearCredentialCache stops the credential cache enabled by
+// EnableCredentialCache, discarding any credentials it holds. Nothing is done
+// when the cache is not enabled.
+func (s *GitRepo) ClearCredentialCache() error {
+	if s.credentialSocket == "" {
+		return nil
+	}
+
+	out, err := s.base.run("git", "credential-cache", "--socket="+s.credentialSocket, "exit")
+	if err != nil {
+		return NewLocalError("Unable to clear credential cache", err, string(out))
+	}
+	err = s.fs().RemoveAll(filepath.Dir(s.credentialSocket))
+	if err != nil {
+		return NewLocalError("Unable to clear credential cache", err, "")
+	}
+	s.credentialSocket = ""
+
+	return nil
+}
+
+// RunFromDir executes a command from repo's directory. When the command is git
+// the configuration set by the options on the repo is passed along with it.
+func (s *GitRepo) RunFromDir(cmd string, args ...string) ([]byte, error) {
+	return s.base.RunFromDir(cmd, s.configArgs(cmd, args)...)
+}
+
+// CmdFromDir creates a new command that will be executed from repo's
+// directory. When the command is git the configuration set by the options on
+// the repo is passed along with it.
+func (s *GitRepo) CmdFromDir(cmd string, args ...string) *exec.Cmd {
+	return s.base.CmdFromDir(cmd, s.configArgs(cmd, args)...)
+}
+
+func (s *GitRepo) run(cmd string, args ...string) ([]byte, error) {
+	return s.base.run(cmd, s.configArgs(cmd, args)...)
+}
+
+func (s *GitRepo) runSeparate(cmd string, args ...string) ([]byte, []byte, error) {
+	return s.base.runSeparate(cmd, s.configArgs(cmd, args)...)
+}
+
+// configArgs prepends the -c configuration flags for the options set on the
+// repo to the arguments of a git command. Other commands are left untouched.
+func (s *GitRepo) configArgs(cmd string, args []string) []string {
+	if cmd != "git" {
+		return args
+	}
+
+	var c []string
+	if !s.FollowRedirects {
+		c = append(c, "-c", "http.followRedirects=false")
+	}
+	if s.LineEndings != "" {
+		c = append(c, "-c", "core.autocrlf="+s.LineEndings)
+	}
+	if s.DisableAutoGC {
+		c = append(c, "-c", "gc.auto=0")
+	}
+	if s.TrustLocalPath && s.LocalPath() != "" {
+		c = append(c, "-c", "safe.directory="+filepath.ToSlash(s.LocalPath()))
+	}
+	if s.credentialSocket != "" {
+		// The empty helper resets the list so only the repo specific cache is
+		// consulted and credentials do not end up in other helpers.
+		c = append(c, "-c", "credential.helper=",
+			"-c", fmt.Sprintf("credential.helper=cache --timeout=%d --socket='%s'", s.credentialTimeout, filepath.ToSlash(s.credentialSocket)))
+	}
+
+	if len(c) == 0 {
+		return args
+	}
+	return append(c, args...)
+}
+
+// ExportSubdir writes an archive of a subdirectory of the tree at a ref to w.
+// The format is any supported by git archive, such as tar or zip, and defaults
+// to tar when empty. The paths in the archive remain relative to the root of
+// the repository. An error is returned when the subdirectory does not exist at
+// the ref.
+func (s *GitRepo) ExportSubdir(w io.Writer, format, ref, subdir string) error {
+	args := []string{"archive"}
+	if format != "" {
+		args = append(args, "--format="+format)
+	}
+	args = append(args, ref, "--", filepath.ToSlash(subdir))
+
+	var stderr bytes.Buffer
+	c := s.CmdFromDir("git", args...)
+	c.Stdout = w
+	c.Stderr = &stderr
+	err := s.runCommand(c)
+	if err != nil {
+		return NewLocalError("Unable to export "+subdir+" at "+ref, err, stderr.String())
+	}
+
+	return nil
+}
+
+// ExportFastImport writes the full history of the repository, every branch
+// and tag, to w as a git fast-import stream. The stream is written as git
+// fast-export produces it rather than being held in memory. Signed tags are
+// exported with their signatures, which no longer verify if the history is
+// rewritten.
+func (s *GitRepo) ExportFastImport(w io.Writer) error {
+	var stderr bytes.Buffer
+	c := s.CmdFromDir("git", "fast-export", "--all", "--signed-tags=verbatim")
+	c.Stdout = w
+	c.Stderr = &stderr
+	err := s.runCommand(c)
+	if err != nil {
+		return NewLocalError("Unable to export history", err, stderr.String())
+	}
+
+	return nil
+}
+
+// ImportFastImport reads a fast-import stream from r, such as one written by
+// ExportFastImport, into the repository, which must already exist. The
+// branches and tags in the stream are created or updated but the working tree
+// is not checked out. An existing branch is only updated when the imported
+// history descends from it.
+func (s *GitRepo) ImportFastImport(r io.Reader) error {
+	c := s.CmdFromDir("git", "fast-import", "--quiet")
+	c.Stdin = r
+	out, err := s.runInput(c)
+	if err != nil {
+		return NewLocalError("Unable to import history", err, string(out))
 	}
 
 	return nil
 }
 
-// isDetachedHead will detect if git repo is in "detached head" state.
-func isDetachedHead(dir string) (bool, error) {
-	p := filepath.Join(dir, ".git", "HEAD")
-	contents, err := ioutil.ReadFile(p)
+// Apply applies the patch read from patch, in the format produced by git diff,
+// to the working tree. When the patch does not apply cleanly nothing is
+// changed and ErrPatchDoesNotApply is returned.
+func (s *GitRepo) Apply(patch io.Reader) error {
+	out, err := s.apply(patch)
 	if err != nil {
-		return false, err
+		// git apply exits with a status of 1 when the patch does not apply and
+		// 128 when it cannot be read, such as when it is corrupt.
+		if exitStatus(err) == 1 {
+			s.log(out)
+			return ErrPatchDoesNotApply
+		}
+		return NewLocalError("Unable to apply patch", err, string(out))
 	}
 
-	contents = bytes.TrimSpace(contents)
-	if bytes.HasPrefix(contents, []byte("ref: ")) {
-		return false, nil
+	return nil
+}
+
+// ApplyCheck returns if the patch read from patch applies cleanly to the
+// working tree without applying it. An error is only returned when the check
+// itself fails, such as for a corrupt patch.
+func (s *GitRepo) ApplyCheck(patch io.Reader) (bool, error) {
+	out, err := s.apply(patch, "--check")
+	if err != nil {
+		if exitStatus(err) == 1 {
+			return false, nil
+		}
+		return false, NewLocalError("Unable to check patch", err, string(out))
 	}
 
 	return true, nil
 }
 
+// apply runs git apply with the patch on stdin.
+func (s *GitRepo) apply(patch io.Reader, args ...string) ([]byte, error) {
+	c := s.CmdFromDir("git", append([]string{"apply"}, args...)...)
+	c.Stdin = patch
+	return s.runInput(c)
+}
+
 // isUnableToCreateDir checks for an error in Init() to see if an error
 // where the parent directory of the VCS local path doesn't exist. This is
 // done in a multi-lingual manner.
@@ -1,12 +1,24 @@
 package vcs
 
 import (
+	"archive/tar"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 	//"log"
 	"os"
+	"os/exec"
 	"testing"
 )
 
@@ -41,7 +53,7 @@ func TestGit(t *testing.T) {
 	if repo.Remote() != "https://github.com/Masterminds/VCSTestRepo" {
 		t.Error("Remote not set properly")
 	}
-	if repo.LocalPath() != tempDir+"/VCSTestRepo" {
+	if repo.OriginalLocalPath() != tempDir+"/VCSTestRepo" {
 		t.Error("Local disk location not set properly")
 	}
 
@@ -288,6 +300,38 @@ func TestGitCheckLocal(t *testing.T) {
 		t.Error("Git CheckLocal does not identify non-Git location")
 	}
 
+	// A .git file, as used by submodules and linked worktrees, that points to
+	// a git directory elsewhere identifies a checkout.
+	gitDir := filepath.Join(tempDir, "modules", "sub")
+	if err = os.MkdirAll(gitDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	subDir := filepath.Join(tempDir, "sub")
+	if err = os.MkdirAll(subDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := NewGitRepo("", subDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitFile := filepath.Join(subDir, ".git")
+	if err = ioutil.WriteFile(gitFile, []byte("gitdir: ../modules/sub\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !sub.CheckLocal() {
+		t.Error("Git CheckLocal does not identify a .git file checkout")
+	}
+	if d, ok := sub.gitDir(); !ok || d != gitDir {
+		t.Errorf("Git directory resolved to %q instead of %q", d, gitDir)
+	}
+
+	if err = ioutil.WriteFile(gitFile, []byte("gitdir: ../modules/missing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if sub.CheckLocal() {
+		t.Error("Git CheckLocal accepted a .git file pointing to a missing directory")
+	}
+
 	// Test NewRepo when there's no local. This should simply provide a working
 	// instance without error based on looking at the remote localtion.
 	_, nrerr := NewRepo("https://github.com/Masterminds/VCSTestRepo", tempDir+"/VCSTestRepo")
@@ -508,7 +552,7 @@ func TestGitSubmoduleHandling2(t *testing.T) {
 	if repo.Remote() != "https://github.com/cloudfoundry/sonde-go" {
 		t.Error("Remote not set properly")
 	}
-	if repo.LocalPath() != tempDir+"/VCSTestRepo2" {
+	if repo.OriginalLocalPath() != tempDir+"/VCSTestRepo2" {
 		t.Error("Local disk location not set properly")
 	}
 
@@ -596,3 +640,4106 @@ func TestGitSubmoduleHandling2(t *testing.T) {
 		t.Error("Error checking Git metadata. It exists.")
 	}
 }
+
+// newLocalGitRepo initializes a Git repository in a temporary directory and
+// adds a single commit to it so tests can run without network access. The
+// returned function removes the temporary directory.
+func newLocalGitRepo(t *testing.T) (*GitRepo, func()) {
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-local-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		err := os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	repo, err := NewGitRepo("", filepath.Join(tempDir, "repo"))
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	err = repo.Init()
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	// Use a fixed identity so commits do not depend on the user configuration.
+	for _, c := range [][]string{{"user.name", "Test User"}, {"user.email", "test@example.com"}} {
+		out, err := repo.RunFromDir("git", "config", c[0], c[1])
+		if err != nil {
+			cleanup()
+			t.Fatalf("Unable to configure identity. Err was %s: %s", err, out)
+		}
+	}
+
+	writeLocalFile(t, repo, "README.md", "Test repository\n")
+	commitLocalGitRepo(t, repo, "Initial commit")
+
+	return repo, cleanup
+}
+
+// writeLocalFile writes a file, relative to the root of the checkout, into a
+// local repository.
+func writeLocalFile(t *testing.T, repo Repo, name, contents string) {
+	p := filepath.Join(repo.LocalPath(), name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// commitLocalGitRepo commits all changes in a local repository.
+func commitLocalGitRepo(t *testing.T, repo *GitRepo, msg string) {
+	out, err := repo.RunFromDir("git", "add", "-A")
+	if err != nil {
+		t.Fatalf("Unable to add files. Err was %s: %s", err, out)
+	}
+	out, err = repo.RunFromDir("git", "commit", "-q", "-m", msg)
+	if err != nil {
+		t.Fatalf("Unable to commit. Err was %s: %s", err, out)
+	}
+}
+
+func TestGitSubmodules(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	subs, err := repo.Submodules()
+	if err != nil {
+		t.Error(err)
+	}
+	if subs == nil || len(subs) != 0 {
+		t.Errorf("Git Submodules should return an empty list without submodules. Got %v", subs)
+	}
+
+	writeLocalFile(t, repo, ".gitmodules", `[submodule "foo"]
+	path = vendor/foo
+	url = https://github.com/Masterminds/foo
+[submodule "bar.baz"]
+	path = bar
+	url = ../bar.git
+`)
+
+	subs, err = repo.Submodules()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(subs) != 2 {
+		t.Fatalf("Git Submodules returned the wrong number of submodules. Got %v", subs)
+	}
+	if subs[0].Name != "foo" || subs[0].Path != "vendor/foo" || subs[0].URL != "https://github.com/Masterminds/foo" {
+		t.Errorf("Git Submodules parsed the wrong submodule. Got %v", subs[0])
+	}
+	if subs[1].Name != "bar.baz" || subs[1].Path != "bar" || subs[1].URL != "../bar.git" {
+		t.Errorf("Git Submodules parsed the wrong submodule. Got %v", subs[1])
+	}
+}
+
+func TestGitFollowRedirects(t *testing.T) {
+	repo, err := NewGitRepo("https://github.com/Masterminds/VCSTestRepo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !repo.FollowRedirects {
+		t.Error("Git should follow redirects by default")
+	}
+	c := repo.CmdFromDir("git", "fetch")
+	if strings.Join(c.Args, " ") != "git fetch" {
+		t.Errorf("Git passed unexpected arguments when following redirects. Got %v", c.Args)
+	}
+
+	repo.FollowRedirects = false
+	c = repo.CmdFromDir("git", "fetch")
+	if strings.Join(c.Args, " ") != "git -c http.followRedirects=false fetch" {
+		t.Errorf("Git did not disable following redirects. Got %v", c.Args)
+	}
+
+	// A redirect that is not followed can be told apart from other failures.
+	srv := httptest.NewServer(http.RedirectHandler("http://127.0.0.1:1/r.git", http.StatusMovedPermanently))
+	defer srv.Close()
+	repo, err = NewGitRepo(srv.URL+"/r.git", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.FollowRedirects = false
+	err = repo.CheckRemote()
+	if e, ok := err.(*RemoteError); !ok || e.Kind() != ErrRedirectRefused {
+		t.Errorf("Git CheckRemote of a redirect that was not followed returned %v", err)
+	}
+}
+
+func TestGitMergeBase(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	base, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := repo.RunFromDir("git", "checkout", "-q", "-b", "feature")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	writeLocalFile(t, repo, "feature.txt", "feature\n")
+	commitLocalGitRepo(t, repo, "Add feature")
+	feature, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mb, err := repo.MergeBase(base, feature)
+	if err != nil {
+		t.Error(err)
+	}
+	if mb != base {
+		t.Errorf("Git MergeBase returned the wrong commit. Got %s, expected %s", mb, base)
+	}
+
+	out, err = repo.RunFromDir("git", "checkout", "-q", "--orphan", "unrelated")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	writeLocalFile(t, repo, "unrelated.txt", "unrelated\n")
+	commitLocalGitRepo(t, repo, "Unrelated history")
+
+	_, err = repo.MergeBase(feature, "unrelated")
+	if err != ErrNoCommonAncestor {
+		t.Errorf("Git MergeBase did not return ErrNoCommonAncestor for unrelated histories. Got %v", err)
+	}
+
+	_, err = repo.MergeBase(feature, "doesnotexist")
+	if _, ok := err.(*LocalError); !ok {
+		t.Errorf("Git MergeBase did not return a LocalError for an invalid revision. Got %v", err)
+	}
+}
+
+func TestGitAbortAll(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	// Nothing in progress on a clean checkout.
+	if err := repo.AbortAll(); err != nil {
+		t.Error(err)
+	}
+
+	base, err := repo.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := repo.RunFromDir("git", "checkout", "-q", "-b", "conflict")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	writeLocalFile(t, repo, "README.md", "Conflicting change\n")
+	commitLocalGitRepo(t, repo, "Change on branch")
+	out, err = repo.RunFromDir("git", "checkout", "-q", base)
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	writeLocalFile(t, repo, "README.md", "Another change\n")
+	commitLocalGitRepo(t, repo, "Change on base")
+
+	_, err = repo.RunFromDir("git", "merge", "conflict")
+	if err == nil {
+		t.Fatal("Expected conflicting merge to fail")
+	}
+	if _, err = os.Stat(filepath.Join(repo.LocalPath(), ".git", "MERGE_HEAD")); err != nil {
+		t.Fatal("Expected merge to be in progress")
+	}
+
+	if err = repo.AbortAll(); err != nil {
+		t.Error(err)
+	}
+	if _, err = os.Stat(filepath.Join(repo.LocalPath(), ".git", "MERGE_HEAD")); err == nil {
+		t.Error("Git AbortAll did not abort the in progress merge")
+	}
+	if repo.IsDirty() {
+		t.Error("Git AbortAll left a dirty working tree")
+	}
+}
+
+func TestGitRootDir(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	writeLocalFile(t, repo, filepath.Join("sub", "dir", "file.txt"), "file\n")
+	root, err := filepath.EvalSymlinks(repo.LocalPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := NewGitRepo("", filepath.Join(repo.LocalPath(), "sub", "dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*GitRepo{repo, sub} {
+		dir, err := r.RootDir()
+		if err != nil {
+			t.Error(err)
+		}
+		if dir != root {
+			t.Errorf("Git RootDir returned the wrong directory for %s. Got %s, expected %s", r.LocalPath(), dir, root)
+		}
+	}
+}
+
+func TestGitGetNonEmpty(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-nonempty-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	repo, err := NewGitRepo(remote.LocalPath(), tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, repo, "unrelated.txt", "unrelated\n")
+
+	err = repo.Get()
+	if err == nil {
+		t.Error("Git Get cloned into a non-empty directory without AllowNonEmpty")
+	}
+
+	repo.AllowNonEmpty = true
+	err = repo.Get()
+	if err != nil {
+		t.Fatalf("Unable to get Git repo into non-empty directory. Err was %s", err)
+	}
+
+	if !repo.CheckLocal() {
+		t.Error("Git Get into non-empty directory did not create a repo")
+	}
+	if _, err = os.Stat(filepath.Join(tempDir, "README.md")); err != nil {
+		t.Error("Git Get into non-empty directory did not check out files")
+	}
+	if _, err = os.Stat(filepath.Join(tempDir, "unrelated.txt")); err != nil {
+		t.Error("Git Get into non-empty directory removed an existing file")
+	}
+
+	v, err := repo.Version()
+	if err != nil {
+		t.Error(err)
+	}
+	rv, err := remote.Version()
+	if err != nil {
+		t.Error(err)
+	}
+	if v != rv {
+		t.Errorf("Git Get into non-empty directory checked out %s instead of %s", v, rv)
+	}
+
+	c, err := remote.Current()
+	if err != nil {
+		t.Error(err)
+	}
+	if cur, err := repo.Current(); err != nil || cur != c {
+		t.Errorf("Git Get into non-empty directory is on %s instead of %s (err %v)", cur, c, err)
+	}
+}
+
+func TestGitStashes(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	stashes, err := repo.Stashes()
+	if err != nil {
+		t.Error(err)
+	}
+	if stashes == nil || len(stashes) != 0 {
+		t.Errorf("Git Stashes should return an empty list without stashes. Got %v", stashes)
+	}
+
+	branch, err := repo.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, repo, "README.md", "First change\n")
+	out, err := repo.RunFromDir("git", "stash", "-q")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	writeLocalFile(t, repo, "README.md", "Second change\n")
+	out, err = repo.RunFromDir("git", "stash", "push", "-q", "-m", "Keep: this")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	stashes, err = repo.Stashes()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(stashes) != 2 {
+		t.Fatalf("Git Stashes returned the wrong number of entries. Got %v", stashes)
+	}
+	if stashes[0].Index != 0 || stashes[0].Branch != branch || stashes[0].Message != "Keep: this" {
+		t.Errorf("Git Stashes parsed the wrong entry. Got %v", stashes[0])
+	}
+	if stashes[1].Index != 1 || stashes[1].Branch != branch || !strings.HasSuffix(stashes[1].Message, "Initial commit") {
+		t.Errorf("Git Stashes parsed the wrong entry. Got %v", stashes[1])
+	}
+}
+
+func TestGitRemoteName(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	out, err := remote.RunFromDir("git", "branch", "feature")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-remote-name-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	local := filepath.Join(tempDir, "repo")
+
+	repo, err := NewGitRepo(remote.LocalPath(), local, RemoteName("upstream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.RemoteLocation != "upstream" {
+		t.Errorf("Git RemoteName option not applied. Got %s", repo.RemoteLocation)
+	}
+	err = repo.Get()
+	if err != nil {
+		t.Fatalf("Unable to clone Git repo. Err was %s", err)
+	}
+
+	out, err = repo.RunFromDir("git", "config", "--get", "remote.upstream.url")
+	if err != nil || strings.TrimSpace(string(out)) != remote.LocalPath() {
+		t.Errorf("Git Get did not name the remote upstream. Got %s (err %v)", out, err)
+	}
+
+	branches, err := repo.Branches()
+	if err != nil {
+		t.Error(err)
+	}
+	var found bool
+	for _, b := range branches {
+		if b == "feature" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Git Branches did not find branches on the upstream remote. Got %v", branches)
+	}
+
+	// An existing checkout is validated against the named remote.
+	if _, err = NewGitRepo(remote.LocalPath(), local, RemoteName("upstream")); err != nil {
+		t.Errorf("NewGitRepo failed to validate the upstream remote. Err was %s", err)
+	}
+	if _, err = NewGitRepo(remote.LocalPath(), local); err == nil {
+		t.Error("NewGitRepo validated a checkout without an origin remote")
+	}
+}
+
+func TestGitPull(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-pull-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to clone Git repo. Err was %s", err)
+	}
+
+	writeLocalFile(t, remote, "new.txt", "new\n")
+	commitLocalGitRepo(t, remote, "Add new file")
+	want, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = repo.Pull(); err != nil {
+		t.Error(err)
+	}
+	v, err := repo.Version()
+	if err != nil {
+		t.Error(err)
+	}
+	if v != want {
+		t.Errorf("Git Pull did not update to the upstream. Got %s, expected %s", v, want)
+	}
+}
+
+func TestGitIsDetached(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	detached, err := repo.IsDetached()
+	if err != nil {
+		t.Error(err)
+	}
+	if detached {
+		t.Error("Git IsDetached reported detached while on a branch")
+	}
+
+	v, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.UpdateVersion(v); err != nil {
+		t.Fatal(err)
+	}
+
+	detached, err = repo.IsDetached()
+	if err != nil {
+		t.Error(err)
+	}
+	if !detached {
+		t.Error("Git IsDetached did not report detached while on a commit")
+	}
+
+	// Pulling while detached is skipped rather than failing.
+	if err = repo.Pull(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGitNotes(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	_, err := repo.ReadNote("builds", "HEAD")
+	if err != ErrNoteNotFound {
+		t.Errorf("Git ReadNote did not return ErrNoteNotFound for a commit without a note. Got %v", err)
+	}
+
+	if err = repo.WriteNote("builds", "HEAD", "passed"); err != nil {
+		t.Fatal(err)
+	}
+	note, err := repo.ReadNote("builds", "HEAD")
+	if err != nil {
+		t.Error(err)
+	}
+	if strings.TrimSpace(string(note)) != "passed" {
+		t.Errorf("Git ReadNote returned the wrong note. Got %q", note)
+	}
+
+	// Writing again replaces the note and other refs are unaffected.
+	if err = repo.WriteNote("builds", "HEAD", "failed"); err != nil {
+		t.Error(err)
+	}
+	note, err = repo.ReadNote("builds", "HEAD")
+	if err != nil || strings.TrimSpace(string(note)) != "failed" {
+		t.Errorf("Git WriteNote did not replace the note. Got %q (err %v)", note, err)
+	}
+	if _, err = repo.ReadNote("commits", "HEAD"); err != ErrNoteNotFound {
+		t.Errorf("Git ReadNote found a note under the wrong ref. Got %v", err)
+	}
+
+	if _, err = repo.ReadNote("builds", "doesnotexist"); err == nil || err == ErrNoteNotFound {
+		t.Errorf("Git ReadNote did not return an error for an invalid commit. Got %v", err)
+	}
+}
+
+func TestGitSquashHistory(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	writeLocalFile(t, remote, "second.txt", "second\n")
+	commitLocalGitRepo(t, remote, "Second commit")
+	out, err := remote.RunFromDir("git", "tag", "v1.0.0")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-squash-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// Local clones ignore depth unless the remote is a file URL.
+	repo, err := NewGitRepo("file://"+filepath.ToSlash(remote.LocalPath()), filepath.Join(tempDir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.SquashHistory = true
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to clone Git repo. Err was %s", err)
+	}
+
+	out, err = repo.RunFromDir("git", "rev-list", "--count", "--all")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if strings.TrimSpace(string(out)) != "1" {
+		t.Errorf("Git SquashHistory left more than one commit. Got %s", out)
+	}
+
+	if _, err = os.Stat(filepath.Join(repo.LocalPath(), "second.txt")); err != nil {
+		t.Error("Git SquashHistory did not keep the working tree")
+	}
+	ci, err := repo.CommitInfo("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ci.Message != "Second commit" || ci.Author != "Test User <test@example.com>" {
+		t.Errorf("Git SquashHistory did not keep the commit metadata. Got %v", ci)
+	}
+
+	out, err = repo.RunFromDir("git", "describe", "--tags")
+	if err != nil || strings.TrimSpace(string(out)) != "v1.0.0" {
+		t.Errorf("Git SquashHistory did not keep tags on the commit. Got %s (err %v)", out, err)
+	}
+	out, err = repo.RunFromDir("git", "fsck", "--no-dangling")
+	if err != nil {
+		t.Errorf("Git SquashHistory produced an invalid repository. Err was %s: %s", err, out)
+	}
+}
+
+func TestGitLineEndings(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-line-endings-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for _, tc := range []struct{ endings, contents string }{
+		{"true", "Test repository\r\n"},
+		{"false", "Test repository\n"},
+	} {
+		repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, tc.endings))
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo.LineEndings = tc.endings
+		if err = repo.Get(); err != nil {
+			t.Fatalf("Unable to clone Git repo. Err was %s", err)
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(repo.LocalPath(), "README.md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tc.contents {
+			t.Errorf("Git LineEndings %s checked out %q", tc.endings, b)
+		}
+		if repo.IsDirty() {
+			t.Errorf("Git LineEndings %s reports a dirty checkout", tc.endings)
+		}
+	}
+}
+
+func TestGitAllRefs(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	out, err := remote.RunFromDir("git", "tag", "-a", "-m", "Release", "1.0.0")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	out, err = remote.RunFromDir("git", "tag", "light")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-refs-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to clone Git repo. Err was %s", err)
+	}
+	branch, err := repo.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := repo.AllRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"refs/heads/" + branch,
+		"refs/remotes/origin/" + branch,
+		"refs/tags/1.0.0",
+		"refs/tags/light",
+	}
+	if len(refs) != len(expected) {
+		t.Errorf("Git AllRefs returned the wrong refs. Got %v", refs)
+	}
+	for _, r := range expected {
+		if refs[r] != v {
+			t.Errorf("Git AllRefs returned %q for %s, expected %s", refs[r], r, v)
+		}
+	}
+}
+
+func TestGitDescription(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	d, err := repo.Description()
+	if err != nil {
+		t.Error(err)
+	}
+	if d != "" {
+		t.Errorf("Git Description returned the placeholder description. Got %q", d)
+	}
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-description-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	bare := filepath.Join(tempDir, "bare.git")
+	out, err := repo.RunFromDir("git", "clone", "-q", "--bare", repo.LocalPath(), bare)
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	err = ioutil.WriteFile(filepath.Join(bare, "description"), []byte("A test repository\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	brepo, err := NewGitRepo("", bare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err = brepo.Description()
+	if err != nil {
+		t.Error(err)
+	}
+	if d != "A test repository" {
+		t.Errorf("Git Description returned the wrong description for a bare repo. Got %q", d)
+	}
+}
+
+func TestGitRevList(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	first, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, repo, "second.txt", "second\n")
+	commitLocalGitRepo(t, repo, "Second commit")
+	second, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revs, err := repo.RevList("HEAD")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(revs) != 2 || revs[0] != second || revs[1] != first {
+		t.Errorf("Git RevList returned the wrong commits. Got %v", revs)
+	}
+
+	revs, err = repo.RevList(first + "..HEAD")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(revs) != 1 || revs[0] != second {
+		t.Errorf("Git RevList returned the wrong commits for a range. Got %v", revs)
+	}
+
+	revs, err = repo.RevList("HEAD..HEAD")
+	if err != nil {
+		t.Error(err)
+	}
+	if revs == nil || len(revs) != 0 {
+		t.Errorf("Git RevList should return an empty list for an empty range. Got %v", revs)
+	}
+
+	if _, err = repo.RevList("doesnotexist"); err == nil {
+		t.Error("Git RevList did not return an error for an invalid revision")
+	}
+}
+
+func TestGitExportSubdir(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	writeLocalFile(t, repo, filepath.Join("modules", "a", "a.go"), "package a\n")
+	writeLocalFile(t, repo, filepath.Join("modules", "b", "b.go"), "package b\n")
+	commitLocalGitRepo(t, repo, "Add modules")
+
+	var buf bytes.Buffer
+	err := repo.ExportSubdir(&buf, "tar", "HEAD", filepath.Join("modules", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var files []string
+	tr := tar.NewReader(&buf)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			files = append(files, h.Name)
+		}
+	}
+	if len(files) != 1 || files[0] != "modules/a/a.go" {
+		t.Errorf("Git ExportSubdir archived the wrong files. Got %v", files)
+	}
+
+	buf.Reset()
+	err = repo.ExportSubdir(&buf, "", "HEAD~1", filepath.Join("modules", "a"))
+	if _, ok := err.(*LocalError); !ok {
+		t.Errorf("Git ExportSubdir did not return a LocalError for a missing subdirectory. Got %v", err)
+	}
+}
+
+func TestGitChangedFiles(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	writeLocalFile(t, repo, "keep.txt", "keep\n")
+	writeLocalFile(t, repo, "remove.txt", "remove\n")
+	writeLocalFile(t, repo, "old.txt", "a file that will be renamed\n")
+	commitLocalGitRepo(t, repo, "Add files")
+	from, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := repo.RunFromDir("git", "mv", "old.txt", "new.txt")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if err = os.Remove(filepath.Join(repo.LocalPath(), "remove.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, repo, "README.md", "Changed\n")
+	writeLocalFile(t, repo, "added.txt", "added\n")
+	// Git quotes paths with special characters unless they are NUL
+	// terminated.
+	writeLocalFile(t, repo, "caf\u00e9.txt", "non-ASCII\n")
+	writeLocalFile(t, repo, `a"b.txt`, "quote\n")
+	commitLocalGitRepo(t, repo, "Change files")
+
+	files, err := repo.ChangedFiles(from, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(files, ",") != "README.md,a\"b.txt,added.txt,caf\u00e9.txt,new.txt,remove.txt" {
+		t.Errorf("Git ChangedFiles returned the wrong files. Got %v", files)
+	}
+
+	changes, err := repo.FileChanges(from, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FileChange{
+		{Status: "M", Path: "README.md"},
+		{Status: "A", Path: `a"b.txt`},
+		{Status: "A", Path: "added.txt"},
+		{Status: "A", Path: "caf\u00e9.txt"},
+		{Status: "R", Path: "new.txt", OldPath: "old.txt"},
+		{Status: "D", Path: "remove.txt"},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Git FileChanges returned the wrong changes. Got %v", changes)
+	}
+	for i, c := range expected {
+		if changes[i] != c {
+			t.Errorf("Git FileChanges returned %v, expected %v", changes[i], c)
+		}
+	}
+
+	files, err = repo.ChangedFiles("HEAD", "HEAD")
+	if err != nil || files == nil || len(files) != 0 {
+		t.Errorf("Git ChangedFiles should return an empty list without changes. Got %v (err %v)", files, err)
+	}
+}
+
+func TestGitCredentialCache(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	credential := func(action, input string) (string, error) {
+		c := repo.CmdFromDir("git", "credential", action)
+		c.Env = mergeEnvLists([]string{"GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS="}, c.Env)
+		c.Stdin = strings.NewReader(input)
+		out, err := c.CombinedOutput()
+		return string(out), err
+	}
+
+	if err := repo.EnableCredentialCache(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := repo.ClearCredentialCache(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	_, err := credential("approve", "protocol=https\nhost=example.com\nusername=user\npassword=secret\n\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := credential("fill", "protocol=https\nhost=example.com\n\n")
+	if err != nil || !strings.Contains(out, "password=secret") {
+		t.Errorf("Git credential cache did not return the cached credentials. Got %s (err %v)", out, err)
+	}
+
+	dir := filepath.Dir(repo.credentialSocket)
+	if err = repo.ClearCredentialCache(); err != nil {
+		t.Error(err)
+	}
+	if _, err = os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("Git ClearCredentialCache did not remove the cache directory")
+	}
+	if args := strings.Join(repo.CmdFromDir("git", "fetch").Args, " "); args != "git fetch" {
+		t.Errorf("Git still uses the credential cache after clearing it. Got %s", args)
+	}
+}
+
+func TestGitFetchRefspec(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	// Simulate the refs a hosting service creates for pull requests.
+	out, err := remote.RunFromDir("git", "update-ref", "refs/pull/1/head", "HEAD")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-refspec-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to clone Git repo. Err was %s", err)
+	}
+	if repo.IsReference("refs/remotes/origin/pr/1") {
+		t.Fatal("Pull request ref was fetched by clone")
+	}
+
+	if err = repo.FetchRefspec("+refs/pull/*/head:refs/remotes/origin/pr/*"); err != nil {
+		t.Fatal(err)
+	}
+	if !repo.IsReference("refs/remotes/origin/pr/1") {
+		t.Error("Git FetchRefspec did not fetch the pull request ref")
+	}
+
+	for _, rs := range []string{
+		"",
+		"+",
+		"--upload-pack=touch /tmp/x",
+		"refs/heads/a:refs/heads/b:refs/heads/c",
+		"refs/pull/*/head:refs/remotes/origin/pr",
+		"refs/*/*:refs/remotes/*/*",
+		"refs/heads/a b",
+		"refs/heads/a..b",
+		":refs/heads/a",
+	} {
+		err = repo.FetchRefspec(rs)
+		if _, ok := err.(*LocalError); !ok {
+			t.Errorf("Git FetchRefspec did not reject invalid refspec %q. Got %v", rs, err)
+		}
+	}
+}
+
+func TestGitShortVersion(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	v, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sv, err := repo.ShortVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sv) < 4 || len(sv) >= len(v) || !strings.HasPrefix(v, sv) {
+		t.Errorf("Git ShortVersion returned %s for %s", sv, v)
+	}
+}
+
+func TestGitDescribe(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	sv, err := repo.ShortVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := repo.Describe()
+	if err != nil {
+		t.Error(err)
+	}
+	if d != sv {
+		t.Errorf("Git Describe without tags returned %s, expected %s", d, sv)
+	}
+	if _, err = repo.DescribeWith(DescribeOptions{Tags: true}); err == nil {
+		t.Error("Git DescribeWith without Always did not return an error without tags")
+	}
+
+	out, err := repo.RunFromDir("git", "tag", "v1.0.0")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if d, err = repo.Describe(); err != nil || d != "v1.0.0" {
+		t.Errorf("Git Describe on a tag returned %s (err %v)", d, err)
+	}
+	if _, err = repo.DescribeWith(DescribeOptions{}); err == nil {
+		t.Error("Git DescribeWith without Tags used a lightweight tag")
+	}
+
+	writeLocalFile(t, repo, "README.md", "Changed\n")
+	if d, err = repo.Describe(); err != nil || d != "v1.0.0-dirty" {
+		t.Errorf("Git Describe on a dirty tree returned %s (err %v)", d, err)
+	}
+
+	commitLocalGitRepo(t, repo, "Second commit")
+	sv, err = repo.ShortVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, err = repo.Describe(); err != nil || d != "v1.0.0-1-g"+sv {
+		t.Errorf("Git Describe after a tag returned %s (err %v)", d, err)
+	}
+}
+
+func TestGitLock(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	// A second repo value for the same location, as another process would have.
+	repo.Lock = true
+	other := *repo
+
+	unlock, err := repo.lock()
+	if err != nil {
+		t.Fatalf("Unable to acquire lock. Err was %s", err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		otherUnlock, err := other.lock()
+		if err == nil {
+			otherUnlock()
+		}
+		acquired <- err
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Second repo acquired the lock while it was held")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	unlock()
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("Unable to acquire lock after release. Err was %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Second repo did not acquire the lock after it was released")
+	}
+
+	if _, err := os.Stat(repo.LocalPath() + ".lock"); err != nil {
+		t.Errorf("Lock file not found next to the checkout. Err was %s", err)
+	}
+
+	// Operations still succeed with locking enabled.
+	writeLocalFile(t, repo, "locked.txt", "locked\n")
+	commitLocalGitRepo(t, repo, "Locked commit")
+	v, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdateVersion(v); err != nil {
+		t.Errorf("Unable to update version with lock enabled. Err was %s", err)
+	}
+
+	// Reset and Clean wait for the lock as well.
+	if unlock, err = repo.lock(); err != nil {
+		t.Fatal(err)
+	}
+	cleaned := make(chan error)
+	go func() {
+		if err := other.Reset(true); err != nil {
+			cleaned <- err
+			return
+		}
+		cleaned <- other.Clean(false)
+	}()
+	select {
+	case <-cleaned:
+		t.Error("Git Reset and Clean ran while the lock was held")
+	case <-time.After(200 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case err := <-cleaned:
+		if err != nil {
+			t.Errorf("Unable to reset and clean with lock enabled. Err was %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Git Reset and Clean did not run after the lock was released")
+	}
+}
+
+func TestGitRestorePaths(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	writeLocalFile(t, repo, "a.txt", "one\n")
+	writeLocalFile(t, repo, "b.txt", "one\n")
+	commitLocalGitRepo(t, repo, "First")
+	first, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeLocalFile(t, repo, "a.txt", "two\n")
+	writeLocalFile(t, repo, "b.txt", "two\n")
+	commitLocalGitRepo(t, repo, "Second")
+	second, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.RestorePaths(first, "a.txt")
+	if err != nil {
+		t.Fatalf("Unable to restore paths. Err was %s", err)
+	}
+	read := func(name string) string {
+		b, err := ioutil.ReadFile(filepath.Join(repo.LocalPath(), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if c := read("a.txt"); c != "one\n" {
+		t.Errorf("Restored file has unexpected contents %q", c)
+	}
+	if c := read("b.txt"); c != "two\n" {
+		t.Errorf("Unrestored file has unexpected contents %q", c)
+	}
+	if v, err := repo.Version(); err != nil || v != second {
+		t.Errorf("RestorePaths moved HEAD to %s (err %v)", v, err)
+	}
+
+	err = repo.RestorePaths(first, "b.txt", "missing.txt", "gone/c.txt")
+	if err == nil {
+		t.Fatal("Restoring missing paths did not error")
+	}
+	if !strings.Contains(err.Error(), "missing.txt, gone/c.txt") {
+		t.Errorf("Error does not list the missing paths: %s", err)
+	}
+	if c := read("b.txt"); c != "two\n" {
+		t.Error("Paths were restored even though some were missing")
+	}
+
+	if err := repo.RestorePaths("no-such-ref", "a.txt"); err != ErrRevisionUnavailable {
+		t.Errorf("Unexpected error for unknown ref: %v", err)
+	}
+}
+
+func TestGitNewTagsSince(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	out, err := remote.RunFromDir("git", "tag", "1.0.0")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-tags-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to clone Git repo. Err was %s", err)
+	}
+	known, err := repo.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := repo.NewTagsSince(known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 0 {
+		t.Errorf("Unexpected new tags %v", tags)
+	}
+
+	writeLocalFile(t, remote, "release.txt", "1.1.0\n")
+	commitLocalGitRepo(t, remote, "Release")
+	for _, tag := range []string{"1.1.0", "1.2.0"} {
+		out, err = remote.RunFromDir("git", "tag", tag)
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+	// Moving a known tag does not report it or fail the fetch.
+	out, err = remote.RunFromDir("git", "tag", "-f", "1.0.0")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	tags, err = repo.NewTagsSince(known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || tags[0] != "1.1.0" || tags[1] != "1.2.0" {
+		t.Errorf("Git NewTagsSince returned %v", tags)
+	}
+	if !repo.IsReference("1.2.0") {
+		t.Error("Git NewTagsSince did not fetch the new tags")
+	}
+
+	tags, err = repo.NewTagsSince(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 3 {
+		t.Errorf("Git NewTagsSince with no known tags returned %v", tags)
+	}
+}
+
+func TestGitCommitMessage(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	writeLocalFile(t, repo, "change.txt", "change\n")
+	commitLocalGitRepo(t, repo, "Subject line\n\nBody of the message.\nSecond line.")
+
+	msg, err := repo.CommitMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "Subject line\n\nBody of the message.\nSecond line." {
+		t.Errorf("Git CommitMessage returned %q", msg)
+	}
+}
+
+func TestGitDisableAutoGC(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	out, err := repo.RunFromDir("git", "config", "gc.auto", "1")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	repo.DisableAutoGC = true
+	out, err = repo.RunFromDir("git", "config", "gc.auto")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if strings.TrimSpace(string(out)) != "0" {
+		t.Errorf("Git DisableAutoGC did not override gc.auto. Got %q", out)
+	}
+
+	repo.DisableAutoGC = false
+	out, err = repo.RunFromDir("git", "config", "gc.auto")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if strings.TrimSpace(string(out)) != "1" {
+		t.Errorf("Git gc.auto was overridden by default. Got %q", out)
+	}
+}
+
+func TestGitLocalPathNormalized(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	out, err := repo.RunFromDir("git", "remote", "add", "origin", "https://example.com/repo.git")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	link := filepath.Join(filepath.Dir(repo.LocalPath()), "link")
+	if err = os.Symlink(repo.LocalPath(), link); err != nil {
+		t.Skipf("Unable to create symlink: %s", err)
+	}
+
+	linked, err := NewGitRepo("", link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked.OriginalLocalPath() != link {
+		t.Errorf("Original local path not preserved. Got %s", linked.OriginalLocalPath())
+	}
+	root, err := linked.RootDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked.LocalPath() != root {
+		t.Errorf("Local path %s was not resolved to the root directory %s", linked.LocalPath(), root)
+	}
+
+	// Paths that do not exist yet are resolved as far as they exist.
+	missing := filepath.Join(link, "not", "yet")
+	if p := normalizePath(missing); p != filepath.Join(root, "not", "yet") {
+		t.Errorf("Unexpected normalized path %s for %s", p, missing)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := normalizePath("relative"); p != filepath.Join(normalizePath(wd), "relative") {
+		t.Errorf("Relative path was not made absolute. Got %s", p)
+	}
+}
+
+func TestGitTagsOnly(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	out, err := remote.RunFromDir("git", "tag", "1.0.0")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	out, err = remote.RunFromDir("git", "branch", "other")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-tags-only-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.TagsOnly = true
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to get Git repo tags. Err was %s", err)
+	}
+
+	branches, err := repo.Branches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 0 {
+		t.Errorf("Git TagsOnly fetched branches %v", branches)
+	}
+	out, err = repo.RunFromDir("git", "for-each-ref", "refs/heads", "refs/remotes")
+	if err != nil || len(out) != 0 {
+		t.Errorf("Git TagsOnly created branch refs: %s", out)
+	}
+	tags, err := repo.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0] != "1.0.0" {
+		t.Errorf("Git TagsOnly fetched tags %v", tags)
+	}
+
+	out, err = remote.RunFromDir("git", "tag", "1.1.0")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if err = repo.Update(); err != nil {
+		t.Fatalf("Unable to update Git repo tags. Err was %s", err)
+	}
+	if !repo.IsReference("1.1.0") {
+		t.Error("Git Update did not fetch the new tag")
+	}
+	branches, err = repo.Branches()
+	if err != nil || len(branches) != 0 {
+		t.Errorf("Git Update fetched branches %v", branches)
+	}
+
+	if err = repo.UpdateVersion("1.0.0"); err != nil {
+		t.Fatalf("Unable to check out tag. Err was %s", err)
+	}
+	if _, err = os.Stat(filepath.Join(repo.LocalPath(), "README.md")); err != nil {
+		t.Errorf("Tag was not checked out. Err was %s", err)
+	}
+}
+
+func TestGitIsAncestor(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	base, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, repo, "next.txt", "next\n")
+	commitLocalGitRepo(t, repo, "Next")
+	next, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		ancestor, descendant string
+		expected             bool
+	}{
+		{base, next, true},
+		{next, base, false},
+		{next, next, true},
+	} {
+		is, err := repo.IsAncestor(tc.ancestor, tc.descendant)
+		if err != nil {
+			t.Errorf("Git IsAncestor(%s, %s) errored: %s", tc.ancestor, tc.descendant, err)
+		}
+		if is != tc.expected {
+			t.Errorf("Git IsAncestor(%s, %s) returned %t", tc.ancestor, tc.descendant, is)
+		}
+	}
+
+	if _, err = repo.IsAncestor(base, "doesnotexist"); err == nil {
+		t.Error("Git IsAncestor did not error for an invalid revision")
+	}
+}
+
+func TestGitApply(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	writeLocalFile(t, repo, "file.txt", "one\n")
+	commitLocalGitRepo(t, repo, "Add file")
+	writeLocalFile(t, repo, "file.txt", "two\n")
+	patch, err := repo.RunFromDir("git", "diff")
+	if err != nil {
+		t.Fatalf("%s: %s", err, patch)
+	}
+	out, err := repo.RunFromDir("git", "checkout", "--", "file.txt")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	ok, err := repo.ApplyCheck(bytes.NewReader(patch))
+	if err != nil || !ok {
+		t.Errorf("Git ApplyCheck rejected a valid patch. Got %t, err %v", ok, err)
+	}
+	if repo.IsDirty() {
+		t.Error("Git ApplyCheck modified the checkout")
+	}
+
+	if err = repo.Apply(bytes.NewReader(patch)); err != nil {
+		t.Fatalf("Unable to apply patch. Err was %s", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(repo.LocalPath(), "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "two\n" {
+		t.Errorf("Git Apply produced %q", b)
+	}
+
+	// The patch no longer applies as it has already been applied.
+	ok, err = repo.ApplyCheck(bytes.NewReader(patch))
+	if err != nil || ok {
+		t.Errorf("Git ApplyCheck accepted a patch that does not apply. Got %t, err %v", ok, err)
+	}
+	if err = repo.Apply(bytes.NewReader(patch)); err != ErrPatchDoesNotApply {
+		t.Errorf("Git Apply did not return ErrPatchDoesNotApply. Got %v", err)
+	}
+
+	corrupt := "diff --git a/file.txt b/file.txt\n--- a/file.txt\n+++ b/file.txt\n@@ -1 +1 @@\n"
+	if _, err = repo.ApplyCheck(strings.NewReader(corrupt)); err == nil {
+		t.Error("Git ApplyCheck did not error for a corrupt patch")
+	}
+}
+
+func TestGitShallowSince(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	// Commit history with dates well in the past followed by a recent commit.
+	for i, date := range []string{"2010-01-01T00:00:00Z", "2011-01-01T00:00:00Z"} {
+		writeLocalFile(t, remote, "old.txt", fmt.Sprintf("%d\n", i))
+		out, err := remote.RunFromDir("git", "add", "-A")
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+		c := remote.CmdFromDir("git", "commit", "-q", "-m", "Old commit")
+		c.Env = mergeEnvLists([]string{"GIT_AUTHOR_DATE=" + date, "GIT_COMMITTER_DATE=" + date}, c.Env)
+		if out, err = c.CombinedOutput(); err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+	writeLocalFile(t, remote, "new.txt", "new\n")
+	commitLocalGitRepo(t, remote, "Recent commit")
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-shallow-since-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// Local clones ignore shallow options unless the file protocol is used.
+	repo, err := NewGitRepo("file://"+filepath.ToSlash(remote.LocalPath()), filepath.Join(tempDir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.ShallowSince = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo.Depth = 1000
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to clone Git repo. Err was %s", err)
+	}
+
+	revs, err := repo.RevList("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 1 {
+		t.Errorf("Git ShallowSince cloned %d commits instead of 1", len(revs))
+	}
+	if _, err = os.Stat(filepath.Join(repo.LocalPath(), ".git", "shallow")); err != nil {
+		t.Errorf("Git ShallowSince clone is not shallow. Err was %s", err)
+	}
+}
+
+func TestGitStatus(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-status-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to clone Git repo. Err was %s", err)
+	}
+	for _, c := range [][]string{{"user.name", "Test User"}, {"user.email", "test@example.com"}} {
+		out, err := repo.RunFromDir("git", "config", c[0], c[1])
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+	branch, err := repo.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := repo.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := RepoStatus{Version: v, Branch: branch, HasUpstream: true}
+	if *st != expected {
+		t.Errorf("Git Status returned %+v, expected %+v", *st, expected)
+	}
+
+	writeLocalFile(t, repo, "local.txt", "local\n")
+	commitLocalGitRepo(t, repo, "Local commit")
+	writeLocalFile(t, remote, "remote.txt", "remote\n")
+	commitLocalGitRepo(t, remote, "Remote commit")
+	out, err := repo.RunFromDir("git", "fetch")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	// Untracked files do not make the checkout dirty.
+	writeLocalFile(t, repo, "untracked.txt", "untracked\n")
+
+	st, err = repo.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Ahead != 1 || st.Behind != 1 || st.Dirty {
+		t.Errorf("Git Status returned %+v after diverging from the upstream", *st)
+	}
+
+	writeLocalFile(t, repo, "local.txt", "changed\n")
+	st, err = repo.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Dirty {
+		t.Error("Git Status did not report modified files")
+	}
+
+	out, err = repo.RunFromDir("git", "checkout", "-q", "-f", v)
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	st, err = repo.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = RepoStatus{Version: v}
+	if *st != expected {
+		t.Errorf("Git Status returned %+v when detached, expected %+v", *st, expected)
+	}
+}
+
+func TestGitGetResumable(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	for i := 0; i < 5; i++ {
+		writeLocalFile(t, remote, "counter.txt", fmt.Sprintf("%d\n", i))
+		commitLocalGitRepo(t, remote, fmt.Sprintf("Commit %d", i))
+	}
+	out, err := remote.RunFromDir("git", "tag", "1.0.0", "HEAD~3")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	full, err := remote.RevList("--all")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-resumable-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	repo, err := NewGitRepo("file://"+filepath.ToSlash(remote.LocalPath()), filepath.Join(tempDir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.ResumableChunk = 2
+	if err = repo.GetResumable(); err != nil {
+		t.Fatalf("Unable to clone Git repo. Err was %s", err)
+	}
+
+	revs, err := repo.RevList("--all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != len(full) {
+		t.Errorf("Git GetResumable retrieved %d of %d commits", len(revs), len(full))
+	}
+	if _, err = os.Stat(filepath.Join(repo.LocalPath(), ".git", "shallow")); err == nil {
+		t.Error("Git GetResumable left a shallow clone")
+	}
+	if !repo.IsReference("1.0.0") {
+		t.Error("Git GetResumable did not fetch tags")
+	}
+
+	// A partial clone is completed rather than cloned again.
+	other, err := NewGitRepo("file://"+filepath.ToSlash(remote.LocalPath()), filepath.Join(tempDir, "partial"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = other.run("git", "clone", "-q", "--depth", "1", other.Remote(), other.LocalPath())
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if err = other.GetResumable(); err != nil {
+		t.Fatalf("Unable to resume Git clone. Err was %s", err)
+	}
+	revs, err = other.RevList("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != len(full) {
+		t.Errorf("Git GetResumable resumed to %d of %d commits", len(revs), len(full))
+	}
+}
+
+func TestGitPullRequests(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-pull-request-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to clone Git repo. Err was %s", err)
+	}
+
+	// Simulate the refs hosting services create for pull and merge requests.
+	out, err := remote.RunFromDir("git", "checkout", "-q", "-b", "feature")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	writeLocalFile(t, remote, "feature.txt", "feature\n")
+	commitLocalGitRepo(t, remote, "Add feature")
+	feature, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"refs/pull/1/head", "refs/pull/2/head", "refs/merge-requests/3/head"} {
+		out, err = remote.RunFromDir("git", "update-ref", ref, "HEAD")
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+
+	refs, err := repo.PullRequestRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0] != "refs/pull/1/head" || refs[1] != "refs/pull/2/head" {
+		t.Errorf("Git PullRequestRefs returned %v", refs)
+	}
+
+	if err = repo.CheckoutPullRequest(2); err != nil {
+		t.Fatalf("Unable to check out pull request. Err was %s", err)
+	}
+	if v, _ := repo.Version(); v != feature {
+		t.Errorf("Git CheckoutPullRequest checked out %s instead of %s", v, feature)
+	}
+	if detached, _ := repo.IsDetached(); !detached {
+		t.Error("Git CheckoutPullRequest did not detach HEAD")
+	}
+	if !repo.IsReference("refs/remotes/origin/pr/2") {
+		t.Error("Git CheckoutPullRequest did not keep the pull request ref")
+	}
+	if err = repo.CheckoutPullRequest(4); err == nil {
+		t.Error("Git CheckoutPullRequest did not error for a missing pull request")
+	}
+
+	repo.PullRequestStyle = GitLabMergeRequests
+	refs, err = repo.PullRequestRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0] != "refs/merge-requests/3/head" {
+		t.Errorf("Git PullRequestRefs returned %v for GitLab", refs)
+	}
+	if err = repo.CheckoutPullRequest(3); err != nil {
+		t.Fatalf("Unable to check out merge request. Err was %s", err)
+	}
+	if !repo.IsReference("refs/remotes/origin/mr/3") {
+		t.Error("Git CheckoutPullRequest did not keep the merge request ref")
+	}
+}
+
+func TestGitMetricsFunc(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	type metric struct {
+		op  string
+		d   time.Duration
+		err error
+	}
+	var metrics []metric
+	repo.MetricsFunc = func(op string, d time.Duration, err error) {
+		metrics = append(metrics, metric{op, d, err})
+	}
+	repo.DisableAutoGC = true
+
+	if _, err := repo.Version(); err != nil {
+		t.Fatal(err)
+	}
+	repo.IsReference("doesnotexist")
+
+	// IsReference falls back to show-ref when rev-parse fails.
+	if len(metrics) != 3 {
+		t.Fatalf("Git MetricsFunc was called %d times, expected 3", len(metrics))
+	}
+	if metrics[0].op != "git rev-parse" || metrics[0].err != nil || metrics[0].d <= 0 {
+		t.Errorf("Unexpected metric %+v", metrics[0])
+	}
+	if metrics[1].op != "git rev-parse" || metrics[1].err == nil {
+		t.Errorf("Unexpected metric for a failed command %+v", metrics[1])
+	}
+	if metrics[2].op != "git show-ref" || metrics[2].err == nil {
+		t.Errorf("Unexpected metric for a failed command %+v", metrics[2])
+	}
+
+	// Commands that are not run through RunFromDir are reported as well.
+	metrics = nil
+	repo.remote = repo.LocalPath()
+	if err := repo.CheckRemote(); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.ExportFastImport(ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ApplyCheck(strings.NewReader("")); err == nil {
+		t.Error("Git ApplyCheck of an empty patch succeeded")
+	}
+	var ops []string
+	for _, m := range metrics {
+		ops = append(ops, m.op)
+	}
+	if strings.Join(ops, ",") != "git ls-remote,git fast-export,git apply" {
+		t.Errorf("Git MetricsFunc did not report every command. Got %v", ops)
+	}
+}
+
+func TestGitSigningKey(t *testing.T) {
+	if !depInstalled("gpg") {
+		t.Skip("gpg is not installed")
+	}
+
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	out, err := remote.RunFromDir("git", "tag", "v1.0.0")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-signing-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// Use a keyring of our own so the keys of the user are not involved.
+	gnupgHome := filepath.Join(tempDir, "gnupg")
+	if err = os.Mkdir(gnupgHome, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("GNUPGHOME", os.Getenv("GNUPGHOME"))
+	os.Setenv("GNUPGHOME", gnupgHome)
+	defer exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+
+	get := func(name, key string) (*GitRepo, error) {
+		repo, err := NewGitRepo("file://"+filepath.ToSlash(remote.LocalPath()), filepath.Join(tempDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo.SquashHistory = true
+		repo.SigningKey = key
+		return repo, repo.Get()
+	}
+
+	_, err = get("missing", "missing@example.com")
+	if err == nil || !strings.HasPrefix(err.Error(), "Unable to sign with key missing@example.com") {
+		t.Errorf("Git signing failure was not attributed to signing. Got %v", err)
+	}
+
+	out, err = exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "Test User <test@example.com>", "default", "sign", "never").CombinedOutput()
+	if err != nil {
+		t.Skipf("Unable to generate a signing key: %s", out)
+	}
+
+	repo, err := get("signed", "test@example.com")
+	if err != nil {
+		t.Fatalf("Unable to clone Git repo with signing. Err was %s", err)
+	}
+	out, err = repo.RunFromDir("git", "verify-commit", "HEAD")
+	if err != nil {
+		t.Errorf("Git SigningKey did not sign the commit: %s", out)
+	}
+	out, err = repo.RunFromDir("git", "verify-tag", "v1.0.0")
+	if err != nil {
+		t.Errorf("Git SigningKey did not sign the tag: %s", out)
+	}
+}
+
+func TestGitListFiles(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	writeLocalFile(t, repo, "main.go", "package main\n")
+	writeLocalFile(t, repo, filepath.Join("pkg", "lib.go"), "package pkg\n")
+	writeLocalFile(t, repo, filepath.Join("docs", "Guide.MD"), "# Guide\n")
+	writeLocalFile(t, repo, ".gitignore", "*.log\n")
+	writeLocalFile(t, repo, "Makefile", "all:\n")
+	commitLocalGitRepo(t, repo, "Add files")
+	// Ignored and untracked files are not listed.
+	writeLocalFile(t, repo, "debug.log", "log\n")
+	writeLocalFile(t, repo, "untracked.go", "package main\n")
+
+	files, err := repo.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{".gitignore", "Makefile", "README.md", filepath.Join("docs", "Guide.MD"), "main.go", filepath.Join("pkg", "lib.go")}
+	if strings.Join(files, "|") != strings.Join(expected, "|") {
+		t.Errorf("Git ListFiles returned %v", files)
+	}
+
+	stats, err := FileStats(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 3 || stats[".go"] != 2 || stats[".md"] != 2 || stats[""] != 2 {
+		t.Errorf("FileStats returned %v", stats)
+	}
+}
+
+func TestGitGetCommit(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	pinned, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, remote, "later.txt", "later\n")
+	commitLocalGitRepo(t, remote, "Later commit")
+	later, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-get-commit-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	check := func(name, hash string, direct bool) {
+		repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = repo.GetCommit(hash); err != nil {
+			t.Fatalf("Unable to get commit %s. Err was %s", hash, err)
+		}
+		if v, _ := repo.Version(); v != pinned {
+			t.Errorf("Git GetCommit checked out %s instead of %s", v, pinned)
+		}
+		if detached, _ := repo.IsDetached(); !detached {
+			t.Error("Git GetCommit did not detach HEAD")
+		}
+		if _, err = os.Stat(filepath.Join(repo.LocalPath(), "later.txt")); err == nil {
+			t.Error("Git GetCommit checked out files from a later commit")
+		}
+		_, err = repo.RunFromDir("git", "cat-file", "-e", later)
+		if direct && err == nil {
+			t.Error("Git GetCommit fetched more than the commit")
+		} else if !direct && err != nil {
+			t.Error("Git GetCommit did not fall back to fetching all refs")
+		}
+	}
+
+	// A commit that is not the tip of a branch by its full hash and, falling
+	// back to fetching all refs, by an abbreviated hash.
+	check("full", pinned, true)
+	check("short", pinned[:10], false)
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.GetCommit("0123456789abcdef0123456789abcdef01234567"); err != ErrRevisionUnavailable {
+		t.Errorf("Git GetCommit did not return ErrRevisionUnavailable for a missing commit. Got %v", err)
+	}
+	if err = repo.GetCommit("HEAD --upload-pack=x"); err == nil {
+		t.Error("Git GetCommit accepted an invalid hash")
+	}
+}
+
+func TestGitRemoteSize(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	out, err := remote.RunFromDir("git", "config", "uploadpack.allowFilter", "true")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	// Random content does not compress so it would dominate the size.
+	data := make([]byte, 1<<19)
+	rand.New(rand.NewSource(1)).Read(data)
+	writeLocalFile(t, remote, "big.txt", fmt.Sprintf("%x", data))
+	commitLocalGitRepo(t, remote, "Add big file")
+
+	repo, err := NewGitRepo("file://"+filepath.ToSlash(remote.LocalPath()), filepath.Join(remote.LocalPath(), "..", "unused"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := repo.RemoteSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size <= 0 {
+		t.Errorf("Git RemoteSize returned %d", size)
+	}
+	// File contents are left out of the estimate.
+	if size > 1<<18 {
+		t.Errorf("Git RemoteSize included file contents. Got %d", size)
+	}
+
+	repo, err = NewGitRepo("file://"+filepath.ToSlash(filepath.Join(remote.LocalPath(), "..", "missing")), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.RemoteSize(); err == nil {
+		t.Error("Git RemoteSize did not error for a missing remote")
+	}
+}
+
+func TestGitDeepen(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	for i := 0; i < 4; i++ {
+		writeLocalFile(t, remote, "counter.txt", fmt.Sprintf("%d\n", i))
+		commitLocalGitRepo(t, remote, fmt.Sprintf("Commit %d", i))
+	}
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-deepen-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	repo, err := NewGitRepo("file://"+filepath.ToSlash(remote.LocalPath()), filepath.Join(tempDir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := repo.run("git", "clone", "-q", "--depth", "1", repo.Remote(), repo.LocalPath())
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	count := func() int {
+		revs, err := repo.RevList("HEAD")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(revs)
+	}
+	if !repo.IsShallow() || count() != 1 {
+		t.Fatal("Git clone is not shallow")
+	}
+
+	if err = repo.Deepen(2); err != nil {
+		t.Fatalf("Unable to deepen clone. Err was %s", err)
+	}
+	if c := count(); c != 3 {
+		t.Errorf("Git Deepen retrieved %d commits instead of 3", c)
+	}
+	if err = repo.Deepen(0); err == nil {
+		t.Error("Git Deepen accepted a depth of 0")
+	}
+
+	if err = repo.Unshallow(); err != nil {
+		t.Fatalf("Unable to unshallow clone. Err was %s", err)
+	}
+	if repo.IsShallow() || count() != 5 {
+		t.Error("Git Unshallow did not retrieve the full history")
+	}
+
+	if err = repo.Unshallow(); err != ErrNotShallow {
+		t.Errorf("Git Unshallow did not return ErrNotShallow. Got %v", err)
+	}
+	if err = repo.Deepen(1); err != ErrNotShallow {
+		t.Errorf("Git Deepen did not return ErrNotShallow. Got %v", err)
+	}
+}
+
+func TestGitParents(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	root, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parents, err := repo.Parents(root)
+	if err != nil || len(parents) != 0 {
+		t.Errorf("Git Parents of the root commit returned %v, err %v", parents, err)
+	}
+
+	out, err := repo.RunFromDir("git", "checkout", "-q", "-b", "feature")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	writeLocalFile(t, repo, "feature.txt", "feature\n")
+	commitLocalGitRepo(t, repo, "Add feature")
+	feature, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parents, err = repo.Parents("HEAD")
+	if err != nil || len(parents) != 1 || parents[0] != root {
+		t.Errorf("Git Parents returned %v, err %v", parents, err)
+	}
+
+	out, err = repo.RunFromDir("git", "checkout", "-q", root)
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	writeLocalFile(t, repo, "other.txt", "other\n")
+	commitLocalGitRepo(t, repo, "Add other")
+	other, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = repo.RunFromDir("git", "merge", "-q", "--no-edit", feature)
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	parents, err = repo.Parents("HEAD")
+	if err != nil || len(parents) != 2 || parents[0] != other || parents[1] != feature {
+		t.Errorf("Git Parents of a merge returned %v, err %v", parents, err)
+	}
+
+	if _, err = repo.Parents("doesnotexist"); err != ErrRevisionUnavailable {
+		t.Errorf("Git Parents did not return ErrRevisionUnavailable. Got %v", err)
+	}
+}
+
+func TestGitRecurseSubmodules(t *testing.T) {
+	// Git only allows submodules from the local file system when asked to.
+	defer os.Setenv("GIT_CONFIG_PARAMETERS", os.Getenv("GIT_CONFIG_PARAMETERS"))
+	os.Setenv("GIT_CONFIG_PARAMETERS", "'protocol.file.allow=always'")
+
+	sub, cleanupSub := newLocalGitRepo(t)
+	defer cleanupSub()
+	first, err := sub.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, sub, "second.txt", "second\n")
+	commitLocalGitRepo(t, sub, "Second")
+
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+	out, err := remote.RunFromDir("git", "submodule", "add", "-q", sub.LocalPath(), "sub")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	commitLocalGitRepo(t, remote, "Add submodule at the second commit")
+	newer, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := remote.CmdFromDir("git", "checkout", "-q", first)
+	c.Dir = filepath.Join(remote.LocalPath(), "sub")
+	if out, err = c.CombinedOutput(); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	commitLocalGitRepo(t, remote, "Pin submodule to the first commit")
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-recurse-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.RecurseSubmodules = true
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to clone Git repo. Err was %s", err)
+	}
+
+	branch, err := repo.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(version string) {
+		if err := repo.UpdateVersion(version); err != nil {
+			t.Fatalf("Unable to update Git repo version. Err was %s", err)
+		}
+		out, err := repo.RunFromDir("git", "rev-parse", version+":sub")
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+		recorded := strings.TrimSpace(string(out))
+		c := repo.CmdFromDir("git", "rev-parse", "HEAD")
+		c.Dir = filepath.Join(repo.LocalPath(), "sub")
+		out, err = c.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+		if actual := strings.TrimSpace(string(out)); actual != recorded {
+			t.Errorf("Submodule is at %s after checking out %s, expected %s", actual, version, recorded)
+		}
+	}
+	check(newer)
+	check(branch)
+}
+
+func TestGitSeparateOutput(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	expected, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tracing writes to standard error which is not mixed into parsed output.
+	defer os.Setenv("GIT_TRACE", os.Getenv("GIT_TRACE"))
+	os.Setenv("GIT_TRACE", "1")
+
+	out, _, err := repo.runSeparate("git", "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	combined, err := repo.RunFromDir("git", "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(combined) <= len(out) {
+		t.Skip("git did not write trace output")
+	}
+
+	v, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != expected {
+		t.Errorf("Git Version included standard error output. Got %q", v)
+	}
+	revs, err := repo.RevList("HEAD")
+	if err != nil || len(revs) != 1 || revs[0] != expected {
+		t.Errorf("Git RevList included standard error output. Got %v", revs)
+	}
+}
+
+func TestGitMaxOutputBytes(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	full, err := repo.RunFromDir("git", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.MaxOutputBytes = int64(len(full))
+	out, err := repo.RunFromDir("git", "log")
+	if err != nil {
+		t.Errorf("Git output at the limit returned error %s", err)
+	}
+	if !bytes.Equal(out, full) {
+		t.Errorf("Git output at the limit was altered. Got %q", out)
+	}
+
+	repo.MaxOutputBytes = 10
+	out, err = repo.RunFromDir("git", "log")
+	if err != ErrOutputTooLarge {
+		t.Errorf("Git output over the limit returned %v instead of ErrOutputTooLarge", err)
+	}
+	if len(out) != 10 {
+		t.Errorf("Git output over the limit was not truncated to the limit. Got %q", out)
+	}
+
+	_, err = repo.CommitMessage()
+	if e, ok := err.(*LocalError); !ok || e.Original() != ErrOutputTooLarge {
+		t.Errorf("Git CommitMessage did not fail with ErrOutputTooLarge. Got %v", err)
+	}
+	if _, _, err = repo.runSeparate("git", "log"); err != ErrOutputTooLarge {
+		t.Errorf("Git separate output over the limit returned %v instead of ErrOutputTooLarge", err)
+	}
+
+	// A command producing endless output is killed once over the limit.
+	if !depInstalled("yes") {
+		return
+	}
+	repo.MaxOutputBytes = 1024
+	if _, err = repo.RunFromDir("yes"); err != ErrOutputTooLarge {
+		t.Errorf("Endless output returned %v instead of ErrOutputTooLarge", err)
+	}
+}
+
+func TestGitGetPinned(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	out, err := remote.RunFromDir("git", "tag", "v1.0.0")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	pinned, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, remote, "later.txt", "Later\n")
+	commitLocalGitRepo(t, remote, "Later commit")
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-get-pinned-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, "match"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.GetPinned("v1.0.0", strings.ToUpper(pinned)); err != nil {
+		t.Fatalf("Git GetPinned failed for a matching commit. Err was %s", err)
+	}
+	if v, _ := repo.Version(); v != pinned {
+		t.Errorf("Git GetPinned checked out %s instead of %s", v, pinned)
+	}
+
+	// The tag moved since it was pinned.
+	repo, err = NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, "mismatch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = remote.RunFromDir("git", "tag", "-f", "v1.0.0")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if err = repo.GetPinned("v1.0.0", pinned); err != ErrHashMismatch {
+		t.Errorf("Git GetPinned did not return ErrHashMismatch. Got %v", err)
+	}
+	if _, err = os.Stat(repo.LocalPath()); !os.IsNotExist(err) {
+		t.Error("Git GetPinned did not remove a mismatched checkout")
+	}
+
+	// An existing empty directory is kept while its contents are removed.
+	if err = os.Mkdir(repo.LocalPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.GetPinned("missing-ref", pinned); err == nil {
+		t.Error("Git GetPinned did not fail for a missing ref")
+	}
+	if !isEmptyDir(repo.fs(), repo.LocalPath()) {
+		t.Error("Git GetPinned did not remove the checkout after a failure")
+	}
+	if _, err = os.Stat(repo.LocalPath()); err != nil {
+		t.Error("Git GetPinned removed an existing empty directory")
+	}
+
+	writeLocalFile(t, repo, "keep.txt", "Keep\n")
+	if err = repo.GetPinned("v1.0.0", pinned); err == nil {
+		t.Error("Git GetPinned cloned into a non-empty directory")
+	}
+	if _, err = os.Stat(filepath.Join(repo.LocalPath(), "keep.txt")); err != nil {
+		t.Error("Git GetPinned removed existing files")
+	}
+
+	if err = repo.GetPinned("v1.0.0", pinned[:10]); err == nil {
+		t.Error("Git GetPinned accepted an abbreviated hash")
+	}
+}
+
+func TestGitCommitFileCounts(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	initial, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeLocalFile(t, repo, "a.txt", "A\n")
+	writeLocalFile(t, repo, "dir/b.txt", "B\n")
+	commitLocalGitRepo(t, repo, "Add two files")
+	two, _ := repo.Version()
+
+	out, err := repo.RunFromDir("git", "checkout", "-q", "-b", "feature")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	writeLocalFile(t, repo, "a.txt", "A changed\n")
+	commitLocalGitRepo(t, repo, "Change a file")
+	feature, _ := repo.Version()
+
+	for _, args := range [][]string{{"checkout", "-q", "-"}, {"merge", "-q", "--no-ff", "-m", "Merge feature", "feature"}} {
+		if out, err := repo.RunFromDir("git", args...); err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+	merge, _ := repo.Version()
+
+	counts, err := repo.CommitFileCounts("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{initial: 1, two: 2, feature: 1, merge: 0}
+	if len(counts) != len(expected) {
+		t.Errorf("Git CommitFileCounts returned %d commits, expected %d. Got %v", len(counts), len(expected), counts)
+	}
+	for c, n := range expected {
+		if got, ok := counts[c]; !ok || got != n {
+			t.Errorf("Git CommitFileCounts for %s returned %d, expected %d", c, got, n)
+		}
+	}
+
+	counts, err = repo.CommitFileCounts(initial + "..HEAD~1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 1 || counts[two] != 2 {
+		t.Errorf("Git CommitFileCounts did not honor a range. Got %v", counts)
+	}
+
+	if _, err = repo.CommitFileCounts("doesnotexist"); err == nil {
+		t.Error("Git CommitFileCounts did not error for a missing revision")
+	}
+}
+
+func TestGitFastImport(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	out, err := repo.RunFromDir("git", "tag", "v1.0.0")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	writeLocalFile(t, repo, "dir/later.txt", "Later\n")
+	commitLocalGitRepo(t, repo, "Later commit")
+	branch, err := repo.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest, err := NewGitRepo("", filepath.Join(filepath.Dir(repo.LocalPath()), "imported"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dest.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stream the export straight into the import.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(repo.ExportFastImport(pw))
+	}()
+	if err = dest.ImportFastImport(pr); err != nil {
+		t.Fatalf("Unable to import history. Err was %s", err)
+	}
+
+	for _, ref := range []string{branch, "v1.0.0"} {
+		expected, _ := repo.RunFromDir("git", "rev-parse", ref)
+		got, err := dest.RunFromDir("git", "rev-parse", ref)
+		if err != nil || string(got) != string(expected) {
+			t.Errorf("Git fast-import did not recreate %s. Got %s, expected %s", ref, got, expected)
+		}
+	}
+
+	if err = dest.ImportFastImport(strings.NewReader("not a stream\n")); err == nil {
+		t.Error("Git ImportFastImport did not error for an invalid stream")
+	}
+}
+
+func TestGitTrustLocalPath(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	out, err := repo.RunFromDir("git", "remote", "add", "origin", "https://example.com/repo.git")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	// Hand the checkout to another user so git reports dubious ownership.
+	// Changing the owner requires running as root.
+	out, err = exec.Command("chown", "-R", "12345", repo.LocalPath()).CombinedOutput()
+	if err != nil {
+		t.Skipf("Unable to change the owner of the checkout: %s: %s", err, out)
+	}
+	defer exec.Command("chown", "-R", strconv.Itoa(os.Getuid()), repo.LocalPath()).Run()
+
+	if _, err = repo.Version(); err == nil {
+		t.Skip("git did not refuse a checkout owned by a different user")
+	}
+
+	repo.TrustLocalPath = true
+	if _, err = repo.Version(); err != nil {
+		t.Errorf("Git TrustLocalPath did not trust the checkout. Err was %s", err)
+	}
+
+	if _, err = NewGitRepo("https://example.com/repo.git", repo.LocalPath()); err == nil {
+		t.Error("NewGitRepo inspected a checkout owned by a different user")
+	}
+	other, err := NewGitRepo("https://example.com/repo.git", repo.LocalPath(), TrustLocal())
+	if err != nil {
+		t.Fatalf("NewGitRepo with TrustLocal failed. Err was %s", err)
+	}
+	if !other.TrustLocalPath {
+		t.Error("TrustLocal did not set TrustLocalPath")
+	}
+}
+
+func TestGitContributors(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	commit := func(file, author string) {
+		writeLocalFile(t, repo, file, author+"\n")
+		out, err := repo.RunFromDir("git", "add", "-A")
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+		out, err = repo.RunFromDir("git", "commit", "-q", "-m", "Add "+file, "--author", author)
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+	commit("a.txt", "Other Author <other@example.com>")
+	commit("b.txt", "Other Author <other@example.com>")
+	// The mailmap combines an old identity with the current one.
+	writeLocalFile(t, repo, ".mailmap", "Other Author <other@example.com> <old@example.com>\n")
+	commit("c.txt", "Old Name <old@example.com>")
+
+	contributors, err := repo.Contributors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Contributor{
+		{Name: "Other Author", Email: "other@example.com", Commits: 3},
+		{Name: "Test User", Email: "test@example.com", Commits: 1},
+	}
+	if !reflect.DeepEqual(contributors, expected) {
+		t.Errorf("Git Contributors returned %v, expected %v", contributors, expected)
+	}
+
+	empty, err := NewGitRepo("", filepath.Join(filepath.Dir(repo.LocalPath()), "empty"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = empty.Init(); err != nil {
+		t.Fatal(err)
+	}
+	contributors, err = empty.Contributors()
+	if err != nil || contributors == nil || len(contributors) != 0 {
+		t.Errorf("Git Contributors on an empty repo returned %v, %v", contributors, err)
+	}
+}
+
+func TestGitIgnoreMailmap(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	writeLocalFile(t, repo, ".mailmap", "Canonical Name <canonical@example.com> <test@example.com>\n")
+	commitLocalGitRepo(t, repo, "Add mailmap")
+
+	ci, err := repo.CommitInfo("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ci.Author != "Canonical Name <canonical@example.com>" {
+		t.Errorf("Git CommitInfo did not apply the mailmap. Got %q", ci.Author)
+	}
+	if ci.AuthorName != "Canonical Name" || ci.AuthorEmail != "canonical@example.com" {
+		t.Errorf("Git CommitInfo did not split the author. Got %q and %q", ci.AuthorName, ci.AuthorEmail)
+	}
+	contributors, err := repo.Contributors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contributors) != 1 || contributors[0].Email != "canonical@example.com" || contributors[0].Commits != 2 {
+		t.Errorf("Git Contributors did not apply the mailmap. Got %v", contributors)
+	}
+
+	repo.IgnoreMailmap = true
+	ci, err = repo.CommitInfo("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ci.Author != "Test User <test@example.com>" {
+		t.Errorf("Git CommitInfo applied the mailmap when ignored. Got %q", ci.Author)
+	}
+	contributors, err = repo.Contributors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Contributor{{Name: "Test User", Email: "test@example.com", Commits: 2}}
+	if !reflect.DeepEqual(contributors, expected) {
+		t.Errorf("Git Contributors applied the mailmap when ignored. Got %v", contributors)
+	}
+}
+
+func TestGitHasUnpushedCommits(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	// Without remotes every commit is unpushed.
+	if unpushed, err := remote.HasUnpushedCommits(); err != nil || !unpushed {
+		t.Errorf("Git HasUnpushedCommits without a remote returned %t, %v", unpushed, err)
+	}
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(filepath.Dir(remote.LocalPath()), "clone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Get(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range [][]string{{"user.name", "Test User"}, {"user.email", "test@example.com"}} {
+		if out, err := repo.RunFromDir("git", "config", c[0], c[1]); err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+	run := func(args ...string) {
+		if out, err := repo.RunFromDir("git", args...); err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+	check := func(expected bool, msg string) {
+		unpushed, err := repo.HasUnpushedCommits()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if unpushed != expected {
+			t.Errorf("Git HasUnpushedCommits returned %t %s", unpushed, msg)
+		}
+	}
+
+	check(false, "for a fresh clone")
+
+	// A branch without an upstream whose commits are on the remote.
+	run("checkout", "-q", "-b", "local")
+	check(false, "for a branch without an upstream")
+
+	writeLocalFile(t, repo, "local.txt", "Local\n")
+	commitLocalGitRepo(t, repo, "Local commit")
+	check(true, "for a local commit")
+
+	run("push", "-q", "origin", "local")
+	check(false, "after pushing")
+
+	run("checkout", "-q", "--detach")
+	writeLocalFile(t, repo, "detached.txt", "Detached\n")
+	commitLocalGitRepo(t, repo, "Detached commit")
+	check(true, "for a commit on a detached HEAD")
+}
+
+func TestGitExcludePaths(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	writeLocalFile(t, repo, "vendor/big/file.txt", "Big\n")
+	writeLocalFile(t, repo, "src/keep/file.txt", "Keep\n")
+	writeLocalFile(t, repo, "src/skip/file.txt", "Skip\n")
+	writeLocalFile(t, repo, "src/skip.txt", "Sibling\n")
+	writeLocalFile(t, repo, "docs/[draft].md", "Draft\n")
+	commitLocalGitRepo(t, repo, "Add directories")
+
+	exists := func(p string) bool {
+		_, err := os.Stat(filepath.Join(repo.LocalPath(), filepath.FromSlash(p)))
+		return err == nil
+	}
+
+	if err := repo.ExcludePaths([]string{"vendor", "src/skip/", "docs/[draft].md"}); err != nil {
+		t.Fatalf("Unable to exclude paths. Err was %s", err)
+	}
+	for _, p := range []string{"vendor", "src/skip", "docs/[draft].md"} {
+		if exists(p) {
+			t.Errorf("Git ExcludePaths left %s in the working tree", p)
+		}
+	}
+	for _, p := range []string{"README.md", "src/keep/file.txt", "src/skip.txt"} {
+		if !exists(p) {
+			t.Errorf("Git ExcludePaths removed %s from the working tree", p)
+		}
+	}
+	if repo.IsDirty() {
+		t.Error("Git ExcludePaths left the working tree dirty")
+	}
+
+	if err := repo.ExcludePaths(nil); err != nil {
+		t.Fatalf("Unable to restore excluded paths. Err was %s", err)
+	}
+	for _, p := range []string{"vendor/big/file.txt", "src/skip/file.txt", "docs/[draft].md"} {
+		if !exists(p) {
+			t.Errorf("Git ExcludePaths did not restore %s", p)
+		}
+	}
+
+	if err := repo.ExcludePaths([]string{"/"}); err == nil {
+		t.Error("Git ExcludePaths accepted the root of the repository")
+	}
+}
+
+func TestGitUpstream(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	if _, err := remote.Upstream(); err != ErrNoUpstream {
+		t.Errorf("Git Upstream without an upstream returned %v instead of ErrNoUpstream", err)
+	}
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(filepath.Dir(remote.LocalPath()), "clone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Get(); err != nil {
+		t.Fatal(err)
+	}
+	branch, err := repo.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := repo.Upstream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "origin/"+branch {
+		t.Errorf("Git Upstream returned %q, expected %q", u, "origin/"+branch)
+	}
+
+	out, err := repo.RunFromDir("git", "checkout", "-q", "--detach")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if _, err = repo.Upstream(); err != ErrNoUpstream {
+		t.Errorf("Git Upstream on a detached HEAD returned %v instead of ErrNoUpstream", err)
+	}
+}
+
+func TestGitStatusDetail(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	for _, f := range []string{"staged.txt", "modified.txt", "renamed.txt", "deleted.txt", "conflict.txt"} {
+		writeLocalFile(t, repo, f, "Original contents of "+f+"\n")
+	}
+	writeLocalFile(t, repo, ".gitignore", "*.log\n")
+	commitLocalGitRepo(t, repo, "Add files")
+
+	run := func(args ...string) {
+		if out, err := repo.RunFromDir("git", args...); err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+
+	// Produce a conflict in conflict.txt.
+	run("checkout", "-q", "-b", "other")
+	writeLocalFile(t, repo, "conflict.txt", "Other\n")
+	commitLocalGitRepo(t, repo, "Change on other")
+	run("checkout", "-q", "-")
+	writeLocalFile(t, repo, "conflict.txt", "Local\n")
+	commitLocalGitRepo(t, repo, "Change locally")
+	if _, err := repo.RunFromDir("git", "merge", "-q", "other"); err == nil {
+		t.Fatal("Merge unexpectedly succeeded")
+	}
+
+	writeLocalFile(t, repo, "staged.txt", "Staged\n")
+	run("add", "staged.txt")
+	writeLocalFile(t, repo, "modified.txt", "Modified\n")
+	run("mv", "renamed.txt", "new name.txt")
+	writeLocalFile(t, repo, "new name.txt", "Original contents of renamed.txt\nand a change\n")
+	if err := os.Remove(filepath.Join(repo.LocalPath(), "deleted.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, repo, "added.txt", "Added\n")
+	run("add", "added.txt")
+	writeLocalFile(t, repo, "untracked dir/file.txt", "Untracked\n")
+	writeLocalFile(t, repo, "ignored.log", "Ignored\n")
+
+	st, err := repo.StatusDetail()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &WorkingTreeStatus{
+		Staged: []FileChange{
+			{Status: "A", Path: "added.txt"},
+			{Status: "R", Path: "new name.txt", OldPath: "renamed.txt"},
+			{Status: "M", Path: "staged.txt"},
+		},
+		Modified: []FileChange{
+			{Status: "D", Path: "deleted.txt"},
+			{Status: "M", Path: "modified.txt"},
+			{Status: "M", Path: "new name.txt"},
+		},
+		Untracked:  []string{"untracked dir/file.txt"},
+		Conflicted: []string{"conflict.txt"},
+	}
+	if !reflect.DeepEqual(st, expected) {
+		t.Errorf("Git StatusDetail returned %+v, expected %+v", st, expected)
+	}
+}
+
+func TestGitWithTempCheckout(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	out, err := remote.RunFromDir("git", "tag", "v1.0.0")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	tagged, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, remote, "later.txt", "Later\n")
+	commitLocalGitRepo(t, remote, "Later commit")
+	later, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := NewGitRepo("file://"+filepath.ToSlash(remote.LocalPath()), filepath.Join(filepath.Dir(remote.LocalPath()), "unused"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(ref, expected string, later bool) {
+		var checkout string
+		err := repo.WithTempCheckout(ref, func(path string) error {
+			checkout = path
+			r := *repo
+			r.setLocalPath(path)
+			if v, _ := r.Version(); v != expected {
+				t.Errorf("Git WithTempCheckout of %s checked out %s instead of %s", ref, v, expected)
+			}
+			if !r.IsShallow() {
+				t.Errorf("Git WithTempCheckout of %s did not make a shallow clone", ref)
+			}
+			if _, err := os.Stat(filepath.Join(path, "later.txt")); (err == nil) != later {
+				t.Errorf("Git WithTempCheckout of %s has the wrong files", ref)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Git WithTempCheckout of %s failed. Err was %s", ref, err)
+		}
+		if _, err = os.Stat(checkout); !os.IsNotExist(err) {
+			t.Errorf("Git WithTempCheckout did not remove %s", checkout)
+		}
+	}
+	check("v1.0.0", tagged, false)
+	check(later, later, true)
+
+	if _, err = os.Stat(repo.LocalPath()); !os.IsNotExist(err) {
+		t.Error("Git WithTempCheckout created the local checkout of the repo")
+	}
+
+	// Errors are passed through and panics still clean up.
+	var checkout string
+	expected := errors.New("callback failed")
+	err = repo.WithTempCheckout("v1.0.0", func(path string) error {
+		checkout = path
+		return expected
+	})
+	if err != expected {
+		t.Errorf("Git WithTempCheckout returned %v instead of the callback error", err)
+	}
+	if _, err = os.Stat(checkout); !os.IsNotExist(err) {
+		t.Error("Git WithTempCheckout did not remove the checkout after an error")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Git WithTempCheckout swallowed a panic")
+			}
+		}()
+		repo.WithTempCheckout("v1.0.0", func(path string) error {
+			checkout = path
+			panic("callback panicked")
+		})
+	}()
+	if _, err = os.Stat(checkout); !os.IsNotExist(err) {
+		t.Error("Git WithTempCheckout did not remove the checkout after a panic")
+	}
+
+	if err = repo.WithTempCheckout("doesnotexist", func(string) error { return nil }); err == nil {
+		t.Error("Git WithTempCheckout did not error for a missing ref")
+	}
+}
+
+func TestGitConcurrentUse(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(filepath.Dir(remote.LocalPath()), "clone"+strconv.Itoa(i)))
+			if err == nil {
+				err = repo.Get()
+			}
+			if err == nil {
+				_, err = repo.Version()
+			}
+			if err == nil && !repo.CheckLocal() {
+				err = fmt.Errorf("Clone %d is not a checkout", i)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Concurrent use of Git repos failed. Err was %s", err)
+		}
+	}
+
+	if now, _ := os.Getwd(); now != wd {
+		t.Errorf("The working directory changed from %s to %s", wd, now)
+	}
+}
+
+func TestGitDepth(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	first, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, remote, "second.txt", "Second\n")
+	commitLocalGitRepo(t, remote, "Second commit")
+	for _, args := range [][]string{{"branch", "other"}, {"tag", "v2.0.0"}} {
+		if out, err := remote.RunFromDir("git", args...); err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+	second, _ := remote.Version()
+	writeLocalFile(t, remote, "third.txt", "Third\n")
+	commitLocalGitRepo(t, remote, "Third commit")
+
+	// Local clones ignore shallow options unless the file protocol is used.
+	u := "file://" + filepath.ToSlash(remote.LocalPath())
+	base := filepath.Dir(remote.LocalPath())
+
+	repo, err := NewGitRepo(u, filepath.Join(base, "shallow"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Depth = 1
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to make a shallow clone. Err was %s", err)
+	}
+	if revs, _ := repo.RevList("HEAD"); len(revs) != 1 {
+		t.Errorf("Git Depth of 1 cloned %d commits", len(revs))
+	}
+	if !repo.IsShallow() {
+		t.Error("Git Depth did not make a shallow clone")
+	}
+	if branches, _ := repo.Branches(); !containsString(branches, "other") {
+		t.Errorf("Git Depth clone is missing other branches. Got %v", branches)
+	}
+
+	// Checking out history the clone does not have fetches it.
+	if err = repo.UpdateVersion(first); err != nil {
+		t.Fatalf("Git UpdateVersion did not fall back to the full history. Err was %s", err)
+	}
+	if v, _ := repo.Version(); v != first {
+		t.Errorf("Git UpdateVersion checked out %s instead of %s", v, first)
+	}
+	if repo.IsShallow() {
+		t.Error("Git UpdateVersion did not unshallow the clone")
+	}
+
+	repo, err = NewGitRepo(u, filepath.Join(base, "tag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Depth = 1
+	repo.Ref = "v2.0.0"
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to make a shallow clone of a tag. Err was %s", err)
+	}
+	if v, _ := repo.Version(); v != second {
+		t.Errorf("Git Ref checked out %s instead of %s", v, second)
+	}
+	if revs, _ := repo.RevList("HEAD"); len(revs) != 1 {
+		t.Errorf("Git Ref with a Depth of 1 cloned %d commits", len(revs))
+	}
+
+	repo, err = NewGitRepo(u, filepath.Join(base, "tags-only"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.TagsOnly = true
+	repo.Ref = "v2.0.0"
+	if err = repo.Get(); err == nil {
+		t.Error("Git Get accepted Ref combined with TagsOnly")
+	}
+}
+
+func TestGitExportVersion(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	first, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, repo, "dir/later.txt", "Later\n")
+	commitLocalGitRepo(t, repo, "Later commit")
+	writeLocalFile(t, repo, "README.md", "Uncommitted change\n")
+
+	dir := filepath.Join(filepath.Dir(repo.LocalPath()), "export", "first")
+	if err = repo.ExportVersion(dir, first); err != nil {
+		t.Fatalf("Unable to export version. Err was %s", err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "README.md")); err != nil || string(b) != "Test repository\n" {
+		t.Errorf("Git ExportVersion exported the wrong README.md. Got %q, %v", b, err)
+	}
+	for _, p := range []string{"dir", ".git"} {
+		if _, err = os.Stat(filepath.Join(dir, p)); err == nil {
+			t.Errorf("Git ExportVersion exported %s", p)
+		}
+	}
+
+	dir = filepath.Join(filepath.Dir(repo.LocalPath()), "export", "head")
+	if err = repo.ExportVersion(dir, "HEAD"); err != nil {
+		t.Fatalf("Unable to export version. Err was %s", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "dir", "later.txt")); err != nil {
+		t.Error("Git ExportVersion did not export a nested file")
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "README.md")); string(b) != "Test repository\n" {
+		t.Errorf("Git ExportVersion exported changes in the working tree. Got %q", b)
+	}
+
+	// The checkout and its index are untouched.
+	if b, _ := ioutil.ReadFile(filepath.Join(repo.LocalPath(), "README.md")); string(b) != "Uncommitted change\n" {
+		t.Error("Git ExportVersion changed the working tree")
+	}
+	if out, _ := repo.RunFromDir("git", "diff", "--cached", "--name-only"); len(out) != 0 {
+		t.Errorf("Git ExportVersion changed the index. Got %s", out)
+	}
+
+	if err = repo.ExportVersion(dir, "doesnotexist"); err != ErrRevisionUnavailable {
+		t.Errorf("Git ExportVersion returned %v instead of ErrRevisionUnavailable", err)
+	}
+}
+
+func TestGitAuth(t *testing.T) {
+	headers := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Get("Authorization")
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	tempDir, err := ioutil.TempDir("", "go-vcs-git-auth-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tempDir)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	check := func(name string, auth *Auth, expected string) {
+		repo, err := NewGitRepo(server.URL+"/repo.git", filepath.Join(tempDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo.Auth = auth
+		// The server always rejects the credentials and the clone fails
+		// rather than prompting for others.
+		if err = repo.Get(); err == nil {
+			t.Fatal("Git clone unexpectedly succeeded")
+		}
+		found := false
+		for len(headers) > 0 {
+			if h := <-headers; h == expected {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Git Auth did not send the header %q", expected)
+		}
+	}
+	check("token", &Auth{Token: "secret"}, "Bearer secret")
+	check("basic", &Auth{Username: "user", Password: "pass"}, "Basic dXNlcjpwYXNz")
+	check("user-token", &Auth{Username: "user", Token: "pass"}, "Basic dXNlcjpwYXNz")
+}
+
+func TestGitIsDirty(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	if repo.IsDirty() {
+		t.Error("Git IsDirty reported a clean checkout as dirty")
+	}
+
+	writeLocalFile(t, repo, "untracked.txt", "Untracked\n")
+	if repo.IsDirty() {
+		t.Error("Git IsDirty reported an untracked file as dirty")
+	}
+
+	writeLocalFile(t, repo, "README.md", "Changed\n")
+	if !repo.IsDirty() {
+		t.Error("Git IsDirty did not report a modified file")
+	}
+
+	// A change that is staged no longer shows up in git diff.
+	out, err := repo.RunFromDir("git", "add", "README.md")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if !repo.IsDirty() {
+		t.Error("Git IsDirty did not report a staged change")
+	}
+
+	if !repo.IsReference("HEAD") || repo.IsReference("doesnotexist") {
+		t.Error("Git IsReference misreported a reference")
+	}
+}
+
+func TestGitCheckRemote(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(filepath.Dir(remote.LocalPath()), "clone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.CheckRemote(); err != nil {
+		t.Errorf("Git CheckRemote failed for an existing remote. Err was %s", err)
+	}
+	if _, err = os.Stat(repo.LocalPath()); !os.IsNotExist(err) {
+		t.Error("Git CheckRemote retrieved the repository")
+	}
+
+	repo, err = NewGitRepo(filepath.Join(remote.LocalPath(), "missing"), filepath.Join(filepath.Dir(remote.LocalPath()), "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := repo.CheckRemote().(*RemoteError); !ok {
+		t.Error("Git CheckRemote did not return a RemoteError for a missing remote")
+	}
+	if repo.Ping() {
+		t.Error("Git Ping succeeded for a missing remote")
+	}
+
+	// Rejected credentials fail rather than prompting for others.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	repo, err = NewGitRepo(server.URL+"/repo.git", filepath.Join(filepath.Dir(remote.LocalPath()), "private"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Auth = &Auth{Token: "wrong"}
+	if err = repo.CheckRemote(); err == nil {
+		t.Error("Git CheckRemote succeeded with rejected credentials")
+	}
+}
+
+func TestGitErrorKind(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	kind := func(err error) error {
+		switch e := err.(type) {
+		case *RemoteError:
+			return e.Kind()
+		case *LocalError:
+			return e.Kind()
+		}
+		return nil
+	}
+
+	if k := kind(remote.UpdateVersion("doesnotexist")); k != ErrRefNotFound {
+		t.Errorf("Git UpdateVersion to a missing ref had the error kind %v", k)
+	}
+
+	repo, err := NewGitRepo(filepath.Join(remote.LocalPath(), "missing"), filepath.Join(filepath.Dir(remote.LocalPath()), "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := kind(repo.Get()); k != ErrRemoteUnavailable {
+		t.Errorf("Git Get of a missing remote had the error kind %v", k)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	repo, err = NewGitRepo(server.URL+"/repo.git", filepath.Join(filepath.Dir(remote.LocalPath()), "private"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Auth = &Auth{Token: "wrong"}
+	if k := kind(repo.Get()); k != ErrAuthRequired {
+		t.Errorf("Git Get with rejected credentials had the error kind %v", k)
+	}
+}
+
+func TestGitSkipSubmodules(t *testing.T) {
+	sub, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+	remote, cleanupRemote := newLocalGitRepo(t)
+	defer cleanupRemote()
+
+	// Git refuses local submodules unless the file protocol is allowed.
+	os.Setenv("GIT_CONFIG_COUNT", "1")
+	os.Setenv("GIT_CONFIG_KEY_0", "protocol.file.allow")
+	os.Setenv("GIT_CONFIG_VALUE_0", "always")
+	defer func() {
+		os.Unsetenv("GIT_CONFIG_COUNT")
+		os.Unsetenv("GIT_CONFIG_KEY_0")
+		os.Unsetenv("GIT_CONFIG_VALUE_0")
+	}()
+
+	out, err := remote.RunFromDir("git", "submodule", "add", sub.LocalPath(), "sub")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	commitLocalGitRepo(t, remote, "Add submodule")
+
+	readme := func(repo *GitRepo) bool {
+		_, err := os.Stat(filepath.Join(repo.LocalPath(), "sub", "README.md"))
+		return err == nil
+	}
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(filepath.Dir(remote.LocalPath()), "clone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if !readme(repo) {
+		t.Error("Git Get did not retrieve the submodule")
+	}
+
+	skip, err := NewGitRepo(remote.LocalPath(), filepath.Join(filepath.Dir(remote.LocalPath()), "skip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	skip.SkipSubmodules = true
+	skip.RecurseSubmodules = true
+	if err = skip.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if readme(skip) {
+		t.Error("Git Get retrieved a skipped submodule")
+	}
+	branch, err := skip.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = skip.UpdateVersion("HEAD~1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = skip.UpdateVersion(branch); err != nil {
+		t.Fatal(err)
+	}
+	if err = skip.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if readme(skip) {
+		t.Error("Git UpdateVersion retrieved a skipped submodule")
+	}
+}
+
+func TestGitRevisionAt(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	commit := func(date string) string {
+		os.Setenv("GIT_COMMITTER_DATE", date)
+		defer os.Unsetenv("GIT_COMMITTER_DATE")
+		writeLocalFile(t, repo, "date.txt", date)
+		commitLocalGitRepo(t, repo, "Commit at "+date)
+		v, err := repo.Version()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	first := commit("2030-01-01T00:00:00Z")
+	second := commit("2030-06-01T00:00:00Z")
+
+	tests := []struct {
+		at       time.Time
+		expected string
+	}{
+		{time.Date(2030, 3, 1, 0, 0, 0, 0, time.UTC), first},
+		{time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC), second},
+		{time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC), second},
+	}
+	for _, tt := range tests {
+		rev, err := repo.RevisionAt(tt.at)
+		if err != nil {
+			t.Error(err)
+		}
+		if rev != tt.expected {
+			t.Errorf("Git RevisionAt %s returned %s, expected %s", tt.at, rev, tt.expected)
+		}
+	}
+
+	if _, err := repo.RevisionAt(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)); err != ErrRevisionUnavailable {
+		t.Errorf("Git RevisionAt before the first commit returned %v", err)
+	}
+}
+
+func TestGitProgress(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	repo, err := NewGitRepo("file://"+filepath.ToSlash(remote.LocalPath()), filepath.Join(filepath.Dir(remote.LocalPath()), "clone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var progress bytes.Buffer
+	repo.Progress = &progress
+
+	c := repo.CmdFromDir("git", "fetch", "origin")
+	if strings.Join(c.Args, " ") != "git fetch --progress origin" {
+		t.Errorf("Git did not ask fetch for progress. Got %v", c.Args)
+	}
+	c = repo.CmdFromDir("git", "status")
+	if strings.Join(c.Args, " ") != "git status" {
+		t.Errorf("Git asked a command that does not retrieve for progress. Got %v", c.Args)
+	}
+
+	if err = repo.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(progress.String(), "Receiving objects") {
+		t.Errorf("Git Get did not report its progress. Got %q", progress.String())
+	}
+
+	// The progress of commands whose output is parsed is not passed on.
+	progress.Reset()
+	if _, err = repo.Version(); err != nil {
+		t.Fatal(err)
+	}
+	if progress.Len() != 0 {
+		t.Errorf("Git Version wrote its output as progress. Got %q", progress.String())
+	}
+}
+
+func TestGitCommitLog(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	first, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, repo, "README.md", "Second\n")
+	commitLocalGitRepo(t, repo, "Second commit\n\nWith a <body> & more")
+	second, _ := repo.Version()
+	writeLocalFile(t, repo, "README.md", "Third\n")
+	commitLocalGitRepo(t, repo, "Third commit")
+	third, _ := repo.Version()
+
+	log, err := repo.CommitLog(LogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 3 || log[0].Commit != third || log[1].Commit != second || log[2].Commit != first {
+		t.Fatalf("Git CommitLog returned the wrong commits. Got %+v", log)
+	}
+	if log[1].Message != "Second commit\n\nWith a <body> & more" {
+		t.Errorf("Git CommitLog returned the wrong message %q", log[1].Message)
+	}
+	if ci, err := repo.CommitInfo(second); err != nil || ci.Message != log[1].Message {
+		t.Errorf("Git CommitInfo did not return the full message. Got %+v (err %v)", ci, err)
+	}
+	if log[0].AuthorName == "" || log[0].AuthorEmail == "" || log[0].Date.IsZero() {
+		t.Errorf("Git CommitLog returned incomplete metadata. Got %+v", log[0])
+	}
+
+	log, err = repo.CommitLog(LogOptions{From: first, To: second})
+	if err != nil || len(log) != 1 || log[0].Commit != second {
+		t.Errorf("Git CommitLog between two commits returned %+v. Err was %v", log, err)
+	}
+	log, err = repo.CommitLog(LogOptions{Limit: 2})
+	if err != nil || len(log) != 2 || log[1].Commit != second {
+		t.Errorf("Git CommitLog with a limit returned %+v. Err was %v", log, err)
+	}
+	log, err = repo.CommitLog(LogOptions{From: third})
+	if err != nil || len(log) != 0 {
+		t.Errorf("Git CommitLog of an empty range returned %+v. Err was %v", log, err)
+	}
+	if _, err = repo.CommitLog(LogOptions{To: "doesnotexist"}); err == nil {
+		t.Error("Git CommitLog did not fail for a missing revision")
+	}
+}
+
+func TestGitDiff(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	from, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, repo, "README.md", "Changed\n")
+	commitLocalGitRepo(t, repo, "Change the readme")
+
+	diff, err := repo.Diff(from, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "+++ b/README.md") || !strings.Contains(diff, "+Changed") {
+		t.Errorf("Git Diff returned the wrong diff. Got %q", diff)
+	}
+
+	diff, err = repo.Diff("HEAD", "HEAD")
+	if err != nil || diff != "" {
+		t.Errorf("Git Diff should be empty without changes. Got %q (err %v)", diff, err)
+	}
+	if _, err = repo.Diff("doesnotexist", "HEAD"); err == nil {
+		t.Error("Git Diff did not fail for a missing revision")
+	}
+}
+
+func TestGitMirror(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	out, err := remote.RunFromDir("git", "branch", "old")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	mirrorPath := filepath.Join(filepath.Dir(remote.LocalPath()), "mirror.git")
+	repo, err := NewGitRepo(remote.LocalPath(), mirrorPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Mirror = true
+	if repo.CheckLocal() {
+		t.Error("Git CheckLocal detected a mirror before it was created")
+	}
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to create Git mirror. Err was %s", err)
+	}
+	if _, err = os.Stat(filepath.Join(mirrorPath, "README.md")); !os.IsNotExist(err) {
+		t.Error("Git mirror has a working tree")
+	}
+	if !repo.CheckLocal() {
+		t.Error("Git CheckLocal did not detect the mirror")
+	}
+	if ltype, err := DetectVcsFromFS(mirrorPath); err != nil || ltype != Git {
+		t.Errorf("DetectVcsFromFS did not detect the Git mirror. Got %s, err %v", ltype, err)
+	}
+
+	// Opening the existing mirror picks up its remote.
+	existing, err := NewGitRepo("", mirrorPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existing.Remote() != remote.LocalPath() {
+		t.Errorf("Git did not detect the remote of a mirror. Got %s", existing.Remote())
+	}
+
+	// Update brings in new refs and prunes removed ones.
+	for _, args := range [][]string{{"branch", "-D", "old"}, {"branch", "new"}, {"tag", "v1.0.0"}} {
+		if out, err = remote.RunFromDir("git", args...); err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+	if err = repo.Update(); err != nil {
+		t.Fatalf("Unable to update Git mirror. Err was %s", err)
+	}
+	branches, err := repo.Branches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 2 || (branches[0] != "new" && branches[1] != "new") {
+		t.Errorf("Git mirror has the wrong branches. Got %v", branches)
+	}
+	if !repo.IsReference("v1.0.0") {
+		t.Error("Git mirror Update did not fetch a new tag")
+	}
+
+	repo.Ref = "new"
+	if err = repo.Get(); err == nil {
+		t.Error("Git Mirror was combined with Ref")
+	}
+}
+
+func TestGitRemotes(t *testing.T) {
+	origin, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+	fork, forkCleanup := newLocalGitRepo(t)
+	defer forkCleanup()
+
+	for _, r := range []struct {
+		repo   *GitRepo
+		branch string
+	}{{origin, "feature"}, {fork, "forked"}} {
+		out, err := r.repo.RunFromDir("git", "branch", r.branch)
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+
+	local := filepath.Join(filepath.Dir(origin.LocalPath()), "clone")
+	repo, err := NewGitRepo(origin.LocalPath(), local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to clone Git repo. Err was %s", err)
+	}
+
+	if err = repo.AddRemote("upstream", fork.LocalPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.AddRemote("-bad", fork.LocalPath()); err == nil {
+		t.Error("Git AddRemote accepted an invalid name")
+	}
+	remotes, err := repo.ListRemotes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remotes) != 2 || remotes["origin"] != origin.LocalPath() || remotes["upstream"] != fork.LocalPath() {
+		t.Errorf("Git ListRemotes returned the wrong remotes. Got %v", remotes)
+	}
+
+	if err = repo.SetRemoteLocation("missing"); err == nil {
+		t.Error("Git SetRemoteLocation selected a remote that is not configured")
+	}
+	if repo.RemoteLocation != "origin" || repo.Remote() != origin.LocalPath() {
+		t.Errorf("Git SetRemoteLocation changed the remote on failure. Got %s at %s", repo.RemoteLocation, repo.Remote())
+	}
+	if err = repo.SetRemoteLocation("upstream"); err != nil {
+		t.Fatal(err)
+	}
+	if repo.RemoteLocation != "upstream" || repo.Remote() != fork.LocalPath() {
+		t.Errorf("Git SetRemoteLocation did not select upstream. Got %s at %s", repo.RemoteLocation, repo.Remote())
+	}
+
+	if err = repo.Update(); err != nil {
+		t.Fatal(err)
+	}
+	branches, err := repo.Branches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var forked bool
+	for _, b := range branches {
+		if b == "feature" {
+			t.Errorf("Git Branches returned a branch of another remote. Got %v", branches)
+		}
+		if b == "forked" {
+			forked = true
+		}
+	}
+	if !forked {
+		t.Errorf("Git Branches did not return the branches of upstream. Got %v", branches)
+	}
+
+	// The remote of a repo without a checkout is only named.
+	fresh, err := NewGitRepo(fork.LocalPath(), filepath.Join(filepath.Dir(origin.LocalPath()), "fresh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fresh.SetRemoteLocation("upstream"); err != nil || fresh.RemoteLocation != "upstream" {
+		t.Errorf("Git SetRemoteLocation failed without a checkout. Err was %v", err)
+	}
+	remotes, err = fresh.ListRemotes()
+	if err == nil {
+		t.Errorf("Git ListRemotes without a checkout returned %v", remotes)
+	}
+}
+
+func TestGitSparseCheckout(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	writeLocalFile(t, remote, "keep/file.txt", "Keep\n")
+	writeLocalFile(t, remote, "skip/file.txt", "Skip\n")
+	commitLocalGitRepo(t, remote, "Add directories")
+
+	// Partial clones need the remote to be a URL rather than a local path.
+	local := filepath.Join(filepath.Dir(remote.LocalPath()), "sparse")
+	repo, err := NewGitRepo("file://"+filepath.ToSlash(remote.LocalPath()), local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.Filter = "blob:none"
+	repo.SparsePaths = []string{"keep/"}
+	if err = repo.Get(); err != nil {
+		t.Fatalf("Unable to get sparse Git checkout. Err was %s", err)
+	}
+
+	exists := func(p string) bool {
+		_, err := os.Stat(filepath.Join(repo.LocalPath(), filepath.FromSlash(p)))
+		return err == nil
+	}
+	if !exists("README.md") || !exists("keep/file.txt") || exists("skip") {
+		t.Error("Git Get with SparsePaths checked out the wrong files")
+	}
+	out, err := repo.RunFromDir("git", "config", "--get", "remote.origin.partialclonefilter")
+	if err != nil || strings.TrimSpace(string(out)) != "blob:none" {
+		t.Errorf("Git Get with a Filter did not make a partial clone. Got %s (err %v)", out, err)
+	}
+	if repo.IsDirty() {
+		t.Error("Git Get with SparsePaths left the working tree dirty")
+	}
+
+	// The sparse checkout stays in effect for new commits.
+	writeLocalFile(t, remote, "skip/other.txt", "Other\n")
+	writeLocalFile(t, remote, "keep/other.txt", "Other\n")
+	commitLocalGitRepo(t, remote, "Add more files")
+	if err = repo.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("keep/other.txt") || exists("skip") {
+		t.Error("Git Update did not keep the sparse checkout")
+	}
+
+	if err = repo.SparseCheckout(nil); err != nil {
+		t.Fatalf("Unable to restore sparse paths. Err was %s", err)
+	}
+	if !exists("skip/file.txt") || !exists("skip/other.txt") {
+		t.Error("Git SparseCheckout did not restore the full working tree")
+	}
+	if err = repo.SparseCheckout([]string{"/"}); err == nil {
+		t.Error("Git SparseCheckout accepted the root of the repository")
+	}
+}
+
+func TestGitCommandHook(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	var events []CommandEvent
+	repo.CommandHook = func(e CommandEvent) {
+		events = append(events, e)
+	}
+
+	v, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.RunFromDir("git", "rev-parse", "--verify", "doesnotexist"); err == nil {
+		t.Fatal("Git rev-parse of a missing revision succeeded")
+	}
+
+	if len(events) != 4 {
+		t.Fatalf("Git CommandHook was called %d times, expected 4. Got %+v", len(events), events)
+	}
+	before, after := events[0], events[1]
+	if before.Done || before.Cmd != "git" || before.Dir != repo.LocalPath() || before.Duration != 0 {
+		t.Errorf("Unexpected event before a command %+v", before)
+	}
+	if args := strings.Join(after.Args, " "); !after.Done || !strings.HasSuffix(args, "rev-parse HEAD") {
+		t.Errorf("Unexpected event after a command %+v", after)
+	}
+	if after.ExitCode != 0 || after.Err != nil || after.Duration <= 0 || strings.TrimSpace(string(after.Output)) != v {
+		t.Errorf("Unexpected event after a command %+v", after)
+	}
+	if failed := events[3]; failed.ExitCode == 0 || failed.Err == nil || len(failed.Output) == 0 {
+		t.Errorf("Unexpected event after a failed command %+v", failed)
+	}
+
+	// Output past the limit is cut.
+	writeLocalFile(t, repo, "big.txt", strings.Repeat("x", CommandEventOutputBytes*2))
+	commitLocalGitRepo(t, repo, "Add a big file")
+	if _, err = repo.RunFromDir("git", "show", "HEAD:big.txt"); err != nil {
+		t.Fatal(err)
+	}
+	last := events[len(events)-1]
+	if !last.Truncated || len(last.Output) != CommandEventOutputBytes {
+		t.Errorf("Git CommandHook did not truncate the output. Got %d bytes", len(last.Output))
+	}
+
+	// Commands reading their standard input or streaming their output are
+	// reported with the output the hook can see.
+	events = nil
+	if err = repo.ImportFastImport(strings.NewReader("not a stream\n")); err == nil {
+		t.Error("Git ImportFastImport of an invalid stream succeeded")
+	}
+	var archive bytes.Buffer
+	if err = repo.ExportSubdir(&archive, "tar", "HEAD", "."); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 || !strings.HasSuffix(strings.Join(events[1].Args, " "), "fast-import --quiet") || len(events[1].Output) == 0 {
+		t.Fatalf("Git CommandHook did not report fast-import. Got %+v", events)
+	}
+	if e := events[3]; !e.Done || e.Err != nil || !e.Truncated || archive.Len() <= CommandEventOutputBytes {
+		t.Errorf("Git CommandHook did not report the archive while streaming it. Got %+v", e)
+	}
+}
+
+func TestGitDefaultBranch(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	out, err := remote.RunFromDir("git", "branch", "feature")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	expected, err := remote.CurrentBranch()
+	if err != nil || expected == "" {
+		t.Fatalf("Git CurrentBranch returned %q. Err was %v", expected, err)
+	}
+
+	local := filepath.Join(filepath.Dir(remote.LocalPath()), "clone")
+	repo, err := NewGitRepo(remote.LocalPath(), local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := repo.DefaultBranch(); err != nil || b != expected {
+		t.Errorf("Git DefaultBranch returned %q, expected %q. Err was %v", b, expected, err)
+	}
+
+	if err = repo.UpdateVersion("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := repo.CurrentBranch(); err != nil || b != "feature" {
+		t.Errorf("Git CurrentBranch returned %q, expected feature. Err was %v", b, err)
+	}
+	v, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.UpdateVersion(v); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := repo.CurrentBranch(); err != nil || b != "" {
+		t.Errorf("Git CurrentBranch in a detached head returned %q. Err was %v", b, err)
+	}
+
+	// Without the HEAD of the remote recorded it is asked for it.
+	out, err = repo.RunFromDir("git", "remote", "set-head", "origin", "--delete")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if b, err := repo.DefaultBranch(); err != nil || b != expected {
+		t.Errorf("Git DefaultBranch from the remote returned %q, expected %q. Err was %v", b, expected, err)
+	}
+}
+
+func TestGitResetClean(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	exists := func(p string) bool {
+		_, err := os.Stat(filepath.Join(repo.LocalPath(), p))
+		return err == nil
+	}
+	writeLocalFile(t, repo, ".gitignore", "*.log\n")
+	commitLocalGitRepo(t, repo, "Ignore logs")
+
+	writeLocalFile(t, repo, "README.md", "Changed\n")
+	writeLocalFile(t, repo, "staged.txt", "Staged\n")
+	writeLocalFile(t, repo, "untracked/file.txt", "Untracked\n")
+	writeLocalFile(t, repo, "build.log", "Ignored\n")
+	out, err := repo.RunFromDir("git", "add", "staged.txt")
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	// A soft reset unstages without touching the files.
+	if err = repo.Reset(false); err != nil {
+		t.Fatal(err)
+	}
+	st, err := repo.StatusDetail()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Staged) != 0 || !exists("staged.txt") || !repo.IsDirty() {
+		t.Errorf("Git Reset(false) did not only unstage. Got %+v", st)
+	}
+
+	if err = repo.Reset(true); err != nil {
+		t.Fatal(err)
+	}
+	if repo.IsDirty() {
+		t.Error("Git Reset(true) left changes to tracked files")
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(repo.LocalPath(), "README.md"))
+	if string(b) != "Test repository\n" || !exists("untracked") {
+		t.Errorf("Git Reset(true) did not restore the tracked files only. Got %q", b)
+	}
+
+	if err = repo.Clean(false); err != nil {
+		t.Fatal(err)
+	}
+	if exists("untracked") || exists("staged.txt") || !exists("build.log") {
+		t.Error("Git Clean(false) removed the wrong files")
+	}
+	if err = repo.Clean(true); err != nil {
+		t.Fatal(err)
+	}
+	if exists("build.log") || !exists(".gitignore") {
+		t.Error("Git Clean(true) removed the wrong files")
+	}
+}
+
+func TestGitEnv(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	if err := os.Setenv("GO_VCS_TEST_ENV", "inherited"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("GO_VCS_TEST_ENV")
+	hasEnv := func(env []string, kv string) bool {
+		for _, e := range env {
+			if e == kv {
+				return true
+			}
+		}
+		return false
+	}
+
+	repo.AppendEnv("GIT_AUTHOR_NAME=Appended Author", "GIT_AUTHOR_EMAIL=appended@example.com")
+	env := repo.CmdFromDir("git", "status").Env
+	if !hasEnv(env, "GO_VCS_TEST_ENV=inherited") || !hasEnv(env, "GIT_AUTHOR_NAME=Appended Author") {
+		t.Errorf("Git AppendEnv did not add to the inherited environment. Got %v", env)
+	}
+	out, err := repo.RunFromDir("git", "var", "GIT_AUTHOR_IDENT")
+	if err != nil || !strings.HasPrefix(string(out), "Appended Author <appended@example.com>") {
+		t.Errorf("Git command did not run with the appended environment. Got %q (err %v)", out, err)
+	}
+
+	repo.SetEnv([]string{"GIT_CONFIG_NOSYSTEM=1", "HOME=" + repo.LocalPath()})
+	repo.AppendEnv("GIT_AUTHOR_NAME=Set Author")
+	env = repo.CmdFromDir("git", "status").Env
+	if hasEnv(env, "GO_VCS_TEST_ENV=inherited") || hasEnv(env, "GIT_AUTHOR_EMAIL=appended@example.com") {
+		t.Errorf("Git SetEnv did not replace the environment. Got %v", env)
+	}
+	for _, kv := range []string{"GIT_CONFIG_NOSYSTEM=1", "GIT_AUTHOR_NAME=Set Author", "PWD=" + repo.LocalPath()} {
+		if !hasEnv(env, kv) {
+			t.Errorf("Git SetEnv environment is missing %s. Got %v", kv, env)
+		}
+	}
+	if v, err := repo.Version(); err != nil || len(v) != 40 {
+		t.Errorf("Git Version failed with a set environment. Got %s (err %v)", v, err)
+	}
+
+	repo.SetEnv(nil)
+	env = repo.CmdFromDir("git", "status").Env
+	if !hasEnv(env, "GO_VCS_TEST_ENV=inherited") || hasEnv(env, "GIT_AUTHOR_NAME=Set Author") {
+		t.Errorf("Git SetEnv(nil) did not restore the inherited environment. Got %v", env)
+	}
+}
+
+func TestGitTagPush(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	remote := filepath.Join(filepath.Dir(repo.LocalPath()), "remote.git")
+	if out, err := exec.Command("git", "init", "-q", "--bare", remote).CombinedOutput(); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if err := repo.AddRemote("origin", remote); err != nil {
+		t.Fatal(err)
+	}
+	first, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, repo, "README.md", "Changed\n")
+	commitLocalGitRepo(t, repo, "Second commit")
+
+	if err = repo.CreateTag("v1.0.0", "", first); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.CreateTag("v1.1.0", "Release 1.1.0", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "-f", "a:b", "v*", "a..b"} {
+		if err = repo.CreateTag(name, "", ""); err == nil {
+			t.Errorf("Git CreateTag accepted the invalid name %q", name)
+		}
+	}
+	out, err := repo.RunFromDir("git", "cat-file", "-t", "v1.1.0")
+	if err != nil || strings.TrimSpace(string(out)) != "tag" {
+		t.Errorf("Git CreateTag with a message did not create an annotated tag. Got %q", out)
+	}
+	tags, err := repo.TagsFromCommit(first)
+	if err != nil || len(tags) != 1 || tags[0] != "v1.0.0" {
+		t.Errorf("Git CreateTag tagged the wrong version. Got %v (err %v)", tags, err)
+	}
+
+	b, err := repo.CurrentBranch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, refspec := range []string{b, "refs/tags/*:refs/tags/*"} {
+		if err = repo.Push(refspec); err != nil {
+			t.Fatalf("Git Push %s failed. Err was %s", refspec, err)
+		}
+	}
+	if err = repo.Push("--all"); err == nil {
+		t.Error("Git Push accepted an invalid refspec")
+	}
+	remoteRefs := func() string {
+		out, err := exec.Command("git", "ls-remote", remote).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+		return string(out)
+	}
+	refs := remoteRefs()
+	for _, r := range []string{"refs/heads/" + b, "refs/tags/v1.0.0", "refs/tags/v1.1.0"} {
+		if !strings.Contains(refs, r+"\n") {
+			t.Errorf("Git Push did not publish %s. Remote has %s", r, refs)
+		}
+	}
+
+	if err = repo.DeleteTag("v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if repo.IsReference("v1.0.0") {
+		t.Error("Git DeleteTag did not delete the tag")
+	}
+	if err = repo.DeleteTag("v1.0.0"); err == nil {
+		t.Error("Git DeleteTag of a missing tag succeeded")
+	}
+	if err = repo.Push(":refs/tags/v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if refs = remoteRefs(); strings.Contains(refs, "refs/tags/v1.0.0\n") {
+		t.Errorf("Git Push did not delete the tag from the remote. Remote has %s", refs)
+	}
+}
+
+func TestGitRefType(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	v, err := repo.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"branch", "feature"},
+		{"branch", "both"},
+		{"tag", "v1.0.0"},
+		{"tag", "both"},
+	} {
+		if out, err := repo.RunFromDir("git", args...); err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+
+	clone, err := NewGitRepo(repo.LocalPath(), filepath.Join(filepath.Dir(repo.LocalPath()), "clone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = clone.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]RefType{
+		"feature":          RefBranch,
+		"origin/feature":   RefBranch,
+		"both":             RefBranch,
+		"v1.0.0":           RefTag,
+		"refs/tags/v1.0.0": RefTag,
+		v:                  RefCommit,
+		v[:7]:              RefCommit,
+		"HEAD":             RefUnknown,
+		"HEAD~0":           RefUnknown,
+		"doesnotexist":     RefUnknown,
+		"--all":            RefUnknown,
+	}
+	for r, expected := range tests {
+		if rt := clone.RefType(r); rt != expected {
+			t.Errorf("Git RefType of %q returned %q, expected %q", r, rt, expected)
+		}
+	}
+}
+
+func TestGitLFS(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	if repo.UsesLFS() {
+		t.Error("Git UsesLFS detected LFS in a repository without it")
+	}
+	writeLocalFile(t, repo, filepath.Join("assets", ".gitattributes"), "*.bin filter=lfs diff=lfs merge=lfs -text\n")
+	commitLocalGitRepo(t, repo, "Track binaries with LFS")
+
+	// Without git-lfs installed the checkout still succeeds.
+	clone, err := NewGitRepo(repo.LocalPath(), filepath.Join(filepath.Dir(repo.LocalPath()), "clone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = clone.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if !clone.UsesLFS() {
+		t.Error("Git UsesLFS did not detect LFS in a nested .gitattributes")
+	}
+
+	f := &fakeRunner{output: map[string]string{
+		"git checkout v1.0.0": "",
+		"git grep -q -F filter=lfs -- :(glob)**/.gitattributes": "",
+		"git lfs version":         "git-lfs/3.4.0\n",
+		"git lfs install --local": "",
+		"git lfs pull origin":     "",
+	}}
+	fake, err := NewGitRepo("https://example.com/repo.git", filepath.Join("fake", "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.Runner = f
+	fake.SkipSubmodules = true
+	if err = fake.UpdateVersion("v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if c := f.calls[len(f.calls)-1]; !strings.HasSuffix(c, ": git lfs pull origin") || len(f.calls) != 5 {
+		t.Errorf("Git UpdateVersion did not retrieve the LFS content. Calls were %v", f.calls)
+	}
+
+	f.calls = nil
+	fake.SkipLFS = true
+	if err = fake.UpdateVersion("v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.calls) != 1 {
+		t.Errorf("Git UpdateVersion with SkipLFS ran %v", f.calls)
+	}
+	if env := fake.cmdEnv("git", []string{"checkout"}); !containsString(env, "GIT_LFS_SKIP_SMUDGE=1") {
+		t.Errorf("Git SkipLFS did not set GIT_LFS_SKIP_SMUDGE. Env was %v", env)
+	}
+}
@@ -1,10 +1,12 @@
 package vcs
 
 import (
+	"bytes"
 	"encoding/xml"
-	"os"
-	"os/exec"
+	"fmt"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -14,7 +16,7 @@ var hgDetectURL = regexp.MustCompile("default = (?P<foo>.+)\n")
 // NewHgRepo creates a new instance of HgRepo. The remote and local directories
 // need to be passed in.
 func NewHgRepo(remote, local string) (*HgRepo, error) {
-	ins := depInstalled("hg")
+	ins := DefaultRunner != nil || depInstalled("hg")
 	if !ins {
 		return nil, NewLocalError("hg is not installed", nil, "")
 	}
@@ -29,16 +31,16 @@ func NewHgRepo(remote, local string) (*HgRepo, error) {
 	r.setRemote(remote)
 	r.setLocalPath(local)
 	r.Logger = Logger
+	r.FollowRedirects = true
+	r.FS = FS
+	r.Runner = DefaultRunner
 
 	// Make sure the local Hg repo is configured the same as the remote when
 	// A remote value was passed in.
 	if err == nil && r.CheckLocal() {
 		// An Hg repo was found so test that the URL there matches
 		// the repo passed in here.
-		c := exec.Command("hg", "paths")
-		c.Dir = local
-		c.Env = envForDir(c.Dir)
-		out, err := c.CombinedOutput()
+		out, err := r.RunFromDir("hg", "paths")
 		if err != nil {
 			return nil, NewLocalError("Unable to retrieve local repo information", err, string(out))
 		}
@@ -61,6 +63,10 @@ func NewHgRepo(remote, local string) (*HgRepo, error) {
 // HgRepo implements the Repo interface for the Mercurial source control.
 type HgRepo struct {
 	base
+
+	// Mirror makes Get clone the repository without checking out a working
+	// directory, via hg clone -U, and Update only pull new changesets into it.
+	Mirror bool
 }
 
 // Vcs retrieves the underlying VCS being implemented.
@@ -69,8 +75,25 @@ func (s HgRepo) Vcs() Type {
 }
 
 // Get is used to perform an initial clone of a repository.
+// Mercurial is unable to truncate history so setting a Depth causes an error.
+// A clone limited with hg clone -r is not used in its place as it retrieves
+// the full history of the revision, which does not honor the Depth.
 func (s *HgRepo) Get() error {
-	out, err := s.run("hg", "clone", s.Remote(), s.LocalPath())
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if s.Depth > 0 {
+		return depthUnsupported(s.Vcs(), s.Depth)
+	}
+
+	args := []string{"clone"}
+	if s.Mirror {
+		args = append(args, "-U")
+	}
+	out, err := s.run("hg", append(args, s.Remote(), s.LocalPath())...)
 	if err != nil {
 		return NewRemoteError("Unable to get repository", err, string(out))
 	}
@@ -88,11 +111,48 @@ func (s *HgRepo) Init() error {
 
 // Update performs a Mercurial pull to an existing checkout.
 func (s *HgRepo) Update() error {
-	return s.UpdateVersion(``)
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if s.Mirror {
+		out, err := s.RunFromDir("hg", "pull")
+		if err != nil {
+			return NewRemoteError("Unable to update repository", err, string(out))
+		}
+		return nil
+	}
+
+	return s.updateVersion(``)
+}
+
+// Pull updates the working directory to the tip of its branch using changes
+// that have already been pulled into the repository. Unlike Update it does not
+// contact the remote.
+func (s *HgRepo) Pull() error {
+	out, err := s.RunFromDir("hg", "update")
+	if err != nil {
+		return NewLocalError("Unable to update checked out version", err, string(out))
+	}
+	return nil
 }
 
 // UpdateVersion sets the version of a package currently checked out via Hg.
 func (s *HgRepo) UpdateVersion(version string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.updateVersion(version)
+}
+
+// updateVersion implements UpdateVersion without taking the lock so it can be
+// shared with Update.
+func (s *HgRepo) updateVersion(version string) error {
 	out, err := s.RunFromDir("hg", "pull")
 	if err != nil {
 		return NewLocalError("Unable to update checked out version", err, string(out))
@@ -110,9 +170,9 @@ func (s *HgRepo) UpdateVersion(version string) error {
 
 // Version retrieves the current version.
 func (s *HgRepo) Version() (string, error) {
-	out, err := s.RunFromDir("hg", "--debug", "identify")
+	out, stderr, err := s.runSeparate("hg", "--debug", "identify")
 	if err != nil {
-		return "", NewLocalError("Unable to retrieve checked out version", err, string(out))
+		return "", NewLocalError("Unable to retrieve checked out version", err, string(stderr))
 	}
 
 	parts := strings.SplitN(string(out), " ", 2)
@@ -120,6 +180,27 @@ func (s *HgRepo) Version() (string, error) {
 	return strings.TrimSpace(sha), nil
 }
 
+// ShortVersion retrieves the abbreviated form of the current version.
+func (s *HgRepo) ShortVersion() (string, error) {
+	out, err := s.RunFromDir("hg", "identify", "-i")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve checked out version", err, string(out))
+	}
+
+	// A + is appended when the working directory has uncommitted changes.
+	return strings.TrimSuffix(strings.TrimSpace(string(out)), "+"), nil
+}
+
+// RootDir retrieves the top level directory of the checkout.
+func (s *HgRepo) RootDir() (string, error) {
+	out, err := s.RunFromDir("hg", "root")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve root directory", err, string(out))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
 // Current returns the current version-ish. This means:
 // * Branch name if on the tip of the branch
 // * Tag if on a tag
@@ -157,15 +238,31 @@ func (s *HgRepo) Current() (string, error) {
 	return curr, nil
 }
 
+// CurrentBranch retrieves the named branch of the working directory. Every
+// changeset in Hg is on a named branch so it is never empty.
+func (s *HgRepo) CurrentBranch() (string, error) {
+	out, stderr, err := s.runSeparate("hg", "branch")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve current branch", err, string(stderr))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// DefaultBranch retrieves the default branch, which Hg names default.
+func (s *HgRepo) DefaultBranch() (string, error) {
+	return "default", nil
+}
+
 // Date retrieves the date on the latest commit.
 func (s *HgRepo) Date() (time.Time, error) {
 	version, err := s.Version()
 	if err != nil {
 		return time.Time{}, NewLocalError("Unable to retrieve revision date", err, "")
 	}
-	out, err := s.RunFromDir("hg", "log", "-r", version, "--template", "{date|isodatesec}")
+	out, stderr, err := s.runSeparate("hg", "log", "-r", version, "--template", "{date|isodatesec}")
 	if err != nil {
-		return time.Time{}, NewLocalError("Unable to retrieve revision date", err, string(out))
+		return time.Time{}, NewLocalError("Unable to retrieve revision date", err, string(stderr))
 	}
 	t, err := time.Parse(longForm, string(out))
 	if err != nil {
@@ -174,9 +271,40 @@ func (s *HgRepo) Date() (time.Time, error) {
 	return t, nil
 }
 
+// RevisionAt retrieves the latest changeset on the default branch with a date
+// at or before the passed in time.
+func (s *HgRepo) RevisionAt(t time.Time) (string, error) {
+	revset := "last(branch(default) and date('<" + t.Format(longForm) + "'))"
+	out, stderr, err := s.runSeparate("hg", "log", "-r", revset, "--template", "{node}")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve revision at "+t.String(), err, string(stderr))
+	}
+	rev := strings.TrimSpace(string(out))
+	if rev == "" {
+		return "", ErrRevisionUnavailable
+	}
+	return rev, nil
+}
+
+// Description returns the description of the repository from the
+// web.description configuration used by hgweb. When it is not set an empty
+// string is returned.
+func (s *HgRepo) Description() (string, error) {
+	out, err := s.RunFromDir("hg", "config", "web.description")
+	if err != nil {
+		// hg config exits with a status of 1 when the item is not set.
+		if exitStatus(err) == 1 {
+			return "", nil
+		}
+		return "", NewLocalError("Unable to retrieve description", err, string(out))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
 // CheckLocal verifies the local location is a Git repo.
 func (s *HgRepo) CheckLocal() bool {
-	if _, err := os.Stat(s.LocalPath() + "/.hg"); err == nil {
+	if _, err := s.fs().Stat(s.LocalPath() + "/.hg"); err == nil {
 		return true
 	}
 
@@ -185,9 +313,9 @@ func (s *HgRepo) CheckLocal() bool {
 
 // Branches returns a list of available branches
 func (s *HgRepo) Branches() ([]string, error) {
-	out, err := s.RunFromDir("hg", "branches")
+	out, stderr, err := s.runSeparate("hg", "branches")
 	if err != nil {
-		return []string{}, NewLocalError("Unable to retrieve branches", err, string(out))
+		return []string{}, NewLocalError("Unable to retrieve branches", err, string(stderr))
 	}
 	branches := s.referenceList(string(out), `(?m-s)^(\S+)`)
 	return branches, nil
@@ -195,14 +323,74 @@ func (s *HgRepo) Branches() ([]string, error) {
 
 // Tags returns a list of available tags
 func (s *HgRepo) Tags() ([]string, error) {
-	out, err := s.RunFromDir("hg", "tags")
+	out, stderr, err := s.runSeparate("hg", "tags")
 	if err != nil {
-		return []string{}, NewLocalError("Unable to retrieve tags", err, string(out))
+		return []string{}, NewLocalError("Unable to retrieve tags", err, string(stderr))
 	}
 	tags := s.referenceList(string(out), `(?m-s)^(\S+)`)
 	return tags, nil
 }
 
+// CreateTag tags a version, or the checked out version when version is empty,
+// with a name. Hg records tags in the .hgtags file so this commits the change
+// to it, using message as the commit message when it is set. The tag is only
+// created locally, use Push to publish it.
+func (s *HgRepo) CreateTag(name, message, version string) error {
+	if name == "" || strings.HasPrefix(name, "-") {
+		return NewLocalError(fmt.Sprintf("Invalid tag name %q", name), nil, "")
+	}
+
+	args := []string{"tag"}
+	if message != "" {
+		args = append(args, "-m", message)
+	}
+	if version != "" {
+		args = append(args, "-r", version)
+	}
+	out, err := s.RunFromDir("hg", append(args, "--", name)...)
+	if err != nil {
+		return NewLocalError("Unable to create tag "+name, err, string(out))
+	}
+
+	return nil
+}
+
+// DeleteTag removes a tag, which commits the removal to the .hgtags file.
+func (s *HgRepo) DeleteTag(name string) error {
+	if name == "" || strings.HasPrefix(name, "-") {
+		return NewLocalError(fmt.Sprintf("Invalid tag name %q", name), nil, "")
+	}
+
+	out, err := s.RunFromDir("hg", "tag", "--remove", "--", name)
+	if err != nil {
+		return NewLocalError("Unable to delete tag "+name, err, string(out))
+	}
+
+	return nil
+}
+
+// Push pushes to the default path of the repository, the remote it was cloned
+// from. The refspec is a revision, such as a branch or tag, whose ancestors are
+// pushed. When it is empty every outgoing revision is pushed. As Hg tags are
+// commits they are published by pushing the revision that added them.
+func (s *HgRepo) Push(refspec string) error {
+	args := []string{"push"}
+	if refspec != "" {
+		if strings.HasPrefix(refspec, "-") {
+			return NewLocalError(fmt.Sprintf("Invalid revision %q", refspec), nil, "")
+		}
+		args = append(args, "-r", refspec)
+	}
+
+	// hg push exits with 1 when there is nothing to push.
+	out, err := s.RunFromDir("hg", args...)
+	if err != nil && exitStatus(err) != 1 {
+		return NewRemoteError("Unable to push to remote", err, string(out))
+	}
+
+	return nil
+}
+
 // IsReference returns if a string is a reference. A reference can be a
 // commit id, branch, or tag.
 func (s *HgRepo) IsReference(r string) bool {
@@ -210,10 +398,123 @@ func (s *HgRepo) IsReference(r string) bool {
 	return err == nil
 }
 
+// RefType returns the kind of reference a name is. Named branches, bookmarks,
+// and tip, which all move as commits are added, are branches. A changeset id,
+// an abbreviation of one, or a local revision number is a commit.
+func (s *HgRepo) RefType(r string) RefType {
+	if r == "" || strings.HasPrefix(r, "-") {
+		return RefUnknown
+	}
+	if r == "tip" {
+		return RefBranch
+	}
+
+	branches, err := s.Branches()
+	if err != nil {
+		return RefUnknown
+	}
+	if containsString(branches, r) {
+		return RefBranch
+	}
+	out, err := s.RunFromDir("hg", "bookmarks", "-T", "{bookmark}\n")
+	if err != nil {
+		return RefUnknown
+	}
+	if containsString(strings.Split(string(out), "\n"), r) {
+		return RefBranch
+	}
+	tags, err := s.Tags()
+	if err != nil {
+		return RefUnknown
+	}
+	if containsString(tags, r) {
+		return RefTag
+	}
+
+	if _, err = strconv.Atoi(r); err != nil && !isHex(r) {
+		return RefUnknown
+	}
+	if s.IsReference(r) {
+		return RefCommit
+	}
+	return RefUnknown
+}
+
+// Status retrieves a snapshot of the state of the checkout from a single hg
+// identify command.
+func (s *HgRepo) Status() (*RepoStatus, error) {
+	out, err := s.RunFromDir("hg", "--debug", "identify", "--id", "--branch")
+	if err != nil {
+		return nil, NewLocalError("Unable to retrieve status", err, string(out))
+	}
+
+	f := strings.Fields(string(out))
+	if len(f) != 2 {
+		return nil, NewLocalError("Unable to retrieve status", nil, string(out))
+	}
+
+	// A trailing + marks uncommitted changes to tracked files.
+	return &RepoStatus{
+		Version: strings.TrimSuffix(f[0], "+"),
+		Branch:  f[1],
+		Dirty:   strings.HasSuffix(f[0], "+"),
+	}, nil
+}
+
+// Pin records the remote and the full hash of the checked out changeset,
+// along with the branch, bookmark, or tag it is on as returned by Current.
+func (s *HgRepo) Pin() (Pin, error) {
+	return pinRepo(s)
+}
+
+// Reset discards the changes to tracked files, along with an uncommitted
+// merge, with hg update --clean when hard is true. Hg does not have a staging
+// area so nothing is done otherwise.
+func (s *HgRepo) Reset(hard bool) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !hard {
+		return nil
+	}
+
+	out, err := s.RunFromDir("hg", "update", "--clean", ".")
+	if err != nil {
+		return NewLocalError("Unable to reset checkout", err, string(out))
+	}
+
+	return nil
+}
+
+// Clean removes the files unknown to Hg with the purge extension, which is
+// enabled for the command, and empty directories.
+func (s *HgRepo) Clean(ignored bool) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	args := []string{"--config", "extensions.purge=", "purge"}
+	if ignored {
+		args = append(args, "--all")
+	}
+
+	out, err := s.RunFromDir("hg", args...)
+	if err != nil {
+		return NewLocalError("Unable to clean checkout", err, string(out))
+	}
+
+	return nil
+}
+
 // IsDirty returns if the checkout has been modified from the checked
 // out reference.
 func (s *HgRepo) IsDirty() bool {
-	out, err := s.RunFromDir("hg", "diff")
+	out, err := s.RunFromDir("hg", "status", "--modified", "--added", "--removed", "--deleted")
 	return err != nil || len(out) != 0
 }
 
@@ -224,6 +525,58 @@ func (s *HgRepo) CommitInfo(id string) (*CommitInfo, error) {
 		return nil, ErrRevisionUnavailable
 	}
 
+	log, err := parseHgLog(out)
+	if err != nil {
+		return nil, NewLocalError("Unable to retrieve commit information", err, string(out))
+	}
+	if len(log) == 0 {
+		return nil, ErrRevisionUnavailable
+	}
+
+	return &log[0], nil
+}
+
+// CommitLog retrieves metadata about the commits selected by the options,
+// the most recent first.
+func (s *HgRepo) CommitLog(o LogOptions) ([]CommitInfo, error) {
+	to := o.To
+	if to == "" {
+		to = "."
+	}
+	rev := "reverse(::" + hgQuote(to) + ")"
+	if o.From != "" {
+		rev = "reverse(::" + hgQuote(to) + " - ::" + hgQuote(o.From) + ")"
+	}
+	args := []string{"log", "-r", rev, "--style=xml"}
+	if o.Limit > 0 {
+		args = append(args, "--limit", strconv.Itoa(o.Limit))
+	}
+
+	out, err := s.RunFromDir("hg", args...)
+	if err != nil {
+		return []CommitInfo{}, NewLocalError("Unable to retrieve commit log", err, string(out))
+	}
+
+	// An empty log has no output rather than an empty document.
+	if len(bytes.TrimSpace(out)) == 0 {
+		return []CommitInfo{}, nil
+	}
+	log, err := parseHgLog(out)
+	if err != nil {
+		return []CommitInfo{}, NewLocalError("Unable to parse commit log", err, string(out))
+	}
+
+	return log, nil
+}
+
+// hgQuote quotes a revision so names with characters that are operators in
+// a revset, such as a tag of v1.0-rc1, are looked up as a whole.
+func hgQuote(rev string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(rev) + "'"
+}
+
+// parseHgLog parses the output of hg log with the xml style.
+func parseHgLog(out []byte) ([]CommitInfo, error) {
 	type Author struct {
 		Name  string `xml:",chardata"`
 		Email string `xml:"email,attr"`
@@ -240,28 +593,41 @@ func (s *HgRepo) CommitInfo(id string) (*CommitInfo, error) {
 	}
 
 	logs := &Log{}
-	err = xml.Unmarshal(out, &logs)
+	err := xml.Unmarshal(out, &logs)
 	if err != nil {
-		return nil, NewLocalError("Unable to retrieve commit information", err, string(out))
-	}
-	if len(logs.Logs) == 0 {
-		return nil, ErrRevisionUnavailable
+		return nil, err
 	}
 
-	ci := &CommitInfo{
-		Commit:  logs.Logs[0].Node,
-		Author:  logs.Logs[0].Author.Name + " <" + logs.Logs[0].Author.Email + ">",
-		Message: logs.Logs[0].Msg,
-	}
+	log := make([]CommitInfo, 0, len(logs.Logs))
+	for _, l := range logs.Logs {
+		ci := CommitInfo{
+			Commit:      l.Node,
+			Author:      l.Author.Name + " <" + l.Author.Email + ">",
+			AuthorName:  l.Author.Name,
+			AuthorEmail: l.Author.Email,
+			Message:     l.Msg,
+		}
 
-	if logs.Logs[0].Date != "" {
-		ci.Date, err = time.Parse(time.RFC3339, logs.Logs[0].Date)
-		if err != nil {
-			return nil, NewLocalError("Unable to retrieve commit information", err, string(out))
+		if l.Date != "" {
+			ci.Date, err = time.Parse(time.RFC3339, l.Date)
+			if err != nil {
+				return nil, err
+			}
 		}
+		log = append(log, ci)
+	}
+
+	return log, nil
+}
+
+// CommitMessage retrieves the full message of the checked out changeset.
+func (s *HgRepo) CommitMessage() (string, error) {
+	out, err := s.RunFromDir("hg", "log", "-r", ".", "--template", "{desc}")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve commit message", err, string(out))
 	}
 
-	return ci, nil
+	return string(out), nil
 }
 
 // TagsFromCommit retrieves tags from a commit id.
@@ -298,10 +664,89 @@ func (s *HgRepo) TagsFromCommit(id string) ([]string, error) {
 	return []string{}, nil
 }
 
+// ListFiles returns the paths, relative to the root of the repository, of the
+// files tracked at the checked out changeset.
+func (s *HgRepo) ListFiles() ([]string, error) {
+	out, err := s.RunFromDir("hg", "manifest", "-r", ".")
+	if err != nil {
+		return []string{}, NewLocalError("Unable to list files", err, string(out))
+	}
+
+	files := []string{}
+	for _, p := range strings.Split(string(out), "\n") {
+		if p != "" {
+			files = append(files, filepath.FromSlash(p))
+		}
+	}
+
+	return files, nil
+}
+
+// Diff returns the changes between two revisions as a unified diff in the
+// extended git format, which covers renames and binary files.
+func (s *HgRepo) Diff(from, to string) (string, error) {
+	out, stderr, err := s.runSeparate("hg", "diff", "--git", "-r", from, "-r", to)
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve diff", err, string(stderr))
+	}
+
+	return string(out), nil
+}
+
+// ChangedFiles returns the paths of the files that differ between two
+// revisions. A renamed file is reported as its new path.
+func (s *HgRepo) ChangedFiles(from, to string) ([]string, error) {
+	out, err := s.RunFromDir("hg", "status", "-mar", "-C", "--rev", from, "--rev", to)
+	if err != nil {
+		return []string{}, NewLocalError("Unable to retrieve changed files", err, string(out))
+	}
+
+	// Each line is a status and a path. An added file that was copied or
+	// renamed is followed by an indented line with the source. The removal of
+	// a rename source is reported separately and dropped here so a rename is
+	// only reported as the new path.
+	var changed []string
+	removed := make(map[string]bool)
+	sources := make(map[string]bool)
+	for _, l := range strings.Split(string(out), "\n") {
+		if len(l) < 3 {
+			continue
+		}
+		p := l[2:]
+		switch l[0] {
+		case ' ':
+			sources[p] = true
+		case 'R':
+			removed[p] = true
+			changed = append(changed, p)
+		default:
+			changed = append(changed, p)
+		}
+	}
+
+	files := []string{}
+	for _, p := range changed {
+		if !(removed[p] && sources[p]) {
+			files = append(files, p)
+		}
+	}
+
+	return files, nil
+}
+
 // Ping returns if remote location is accessible.
 func (s *HgRepo) Ping() bool {
-	_, err := s.run("hg", "identify", s.Remote())
-	return err == nil
+	return s.CheckRemote() == nil
+}
+
+// CheckRemote checks the remote location is accessible with hg identify.
+func (s *HgRepo) CheckRemote() error {
+	out, err := s.run("hg", "identify", s.Remote())
+	if err != nil {
+		return NewRemoteError("Unable to reach remote", err, string(out))
+	}
+
+	return nil
 }
 
 // ExportDir exports the current revision to the passed in directory.
@@ -315,3 +760,15 @@ func (s *HgRepo) ExportDir(dir string) error {
 
 	return nil
 }
+
+// ExportVersion exports the passed in version to the passed in directory.
+func (s *HgRepo) ExportVersion(dir, version string) error {
+	// Leave out the .hg_archival.txt describing where the files came from.
+	out, err := s.RunFromDir("hg", "archive", "--config", "ui.archivemeta=false", "-r", version, dir)
+	s.log(out)
+	if err != nil {
+		return NewLocalError("Unable to export "+version, err, string(out))
+	}
+
+	return nil
+}
@@ -42,7 +42,7 @@ func TestHg(t *testing.T) {
 	if repo.Remote() != "https://bitbucket.org/mattfarina/testhgrepo" {
 		t.Error("Remote not set properly")
 	}
-	if repo.LocalPath() != tempDir+"/testhgrepo" {
+	if repo.OriginalLocalPath() != tempDir+"/testhgrepo" {
 		t.Error("Local disk location not set properly")
 	}
 
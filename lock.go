@@ -0,0 +1,43 @@
+package vcs
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// lockPath returns the location of the lock file for a repo. It is kept next
+// to, rather than in, the local checkout as Get requires an empty directory.
+func (b *base) lockPath() string {
+	return filepath.Clean(b.local) + ".lock"
+}
+
+// lock acquires the advisory lock for the repo when Lock is enabled, blocking
+// until any other process or repo holding it releases it. The returned
+// function releases the lock and should be deferred.
+func (b *base) lock() (func(), error) {
+	if !b.Lock {
+		return func() {}, nil
+	}
+
+	// The lock relies on an operating system file lock so, unlike the rest of
+	// the checks and setup, it is always on the local disk.
+	p := b.lockPath()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, NewLocalError("Unable to create directory", err, "")
+	}
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, NewLocalError("Unable to open lock file", err, "")
+	}
+	if err = lockFile(f); err != nil {
+		f.Close()
+		return nil, NewLocalError("Unable to acquire lock", err, "")
+	}
+
+	return func() {
+		if err := unlockFile(f); err != nil {
+			b.log("Unable to release lock: " + err.Error())
+		}
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,872 @@
+package vcs
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// p4ConfigFile is the name of the configuration file a Perforce workspace
+// created by Get has at its root. It holds the P4PORT of the server and the
+// P4CLIENT of the workspace, which p4 finds from the directory it is run from
+// as it is named by the P4CONFIG environment variable.
+const p4ConfigFile = ".p4config"
+
+// p4ConfigName returns the name of the configuration file of a workspace. It is
+// the P4CONFIG of the environment, when it is set to a file name, so existing
+// workspaces using another name are found, and p4ConfigFile otherwise.
+func p4ConfigName() string {
+	if c := os.Getenv("P4CONFIG"); c != "" && c == filepath.Base(c) {
+		return c
+	}
+	return p4ConfigFile
+}
+
+// NewP4Repo creates a new instance of P4Repo. The remote and local directories
+// need to be passed in. The remote is in the form p4://host:port/depot/path,
+// or p4+ssl://host:port/depot/path for a server using SSL, and names the depot
+// path the workspace maps to the local directory.
+func NewP4Repo(remote, local string) (*P4Repo, error) {
+	ins := DefaultRunner != nil || depInstalled("p4")
+	if !ins {
+		return nil, NewLocalError("p4 is not installed", nil, "")
+	}
+	ltype, err := DetectVcsFromFS(local)
+
+	// Found a VCS other than Perforce. Need to report an error.
+	if err == nil && ltype != P4 {
+		return nil, ErrWrongVCS
+	}
+
+	r := &P4Repo{}
+	r.setRemote(remote)
+	r.setLocalPath(local)
+	r.Logger = Logger
+	r.FollowRedirects = true
+	r.FS = FS
+	r.Runner = DefaultRunner
+
+	// Make sure the local workspace maps the same depot path as the remote
+	// when a remote value was passed in.
+	if err == nil && r.CheckLocal() {
+		detected, err := r.detectRemote()
+		if err != nil {
+			return nil, err
+		}
+		if detected != "" && remote != "" && !sameP4Remote(detected, remote) {
+			return nil, ErrWrongRemote
+		}
+
+		// If no remote was passed in but the workspace maps a depot path use
+		// that one.
+		if remote == "" && detected != "" {
+			r.setRemote(detected)
+		}
+	}
+
+	return r, nil
+}
+
+// P4Repo implements the Repo interface for the Perforce (Helix Core) source
+// control. The history is kept on the server so, as with SVN, the workspace
+// only holds the files synced to it. Get creates a workspace, also known as a
+// client, whose view maps the depot path of the remote to the local location.
+// Commands find the workspace through the P4CONFIG file at its root.
+//
+// Changelist numbers are the versions and labels are the tags. Perforce
+// branches are separate depot paths, each their own remote, so Branches is
+// always empty.
+type P4Repo struct {
+	base
+
+	// Client is the name of the workspace Get creates. It defaults to a name
+	// derived from the host and the local location. For an existing workspace
+	// it is read from its P4CONFIG file.
+	Client string
+}
+
+// Vcs retrieves the underlying VCS being implemented.
+func (s P4Repo) Vcs() Type {
+	return P4
+}
+
+// parseP4Remote splits a remote, such as p4://perforce.example.com:1666/depot/project,
+// into the P4PORT of the server and the depot path, such as //depot/project.
+// The port defaults to 1666.
+func parseP4Remote(remote string) (string, string, error) {
+	invalid := NewLocalError(fmt.Sprintf("Invalid Perforce remote %q, expected p4://host:port/depot/path", remote), nil, "")
+	u, err := url.Parse(remote)
+	if err != nil || u.Host == "" || (u.Scheme != "p4" && u.Scheme != "p4+ssl") {
+		return "", "", invalid
+	}
+
+	port := u.Host
+	if !strings.Contains(port, ":") {
+		port += ":1666"
+	}
+	if u.Scheme == "p4+ssl" {
+		port = "ssl:" + port
+	}
+	depot := strings.TrimSuffix(strings.Trim(u.Path, "/"), "/...")
+	if depot == "" || depot == "..." {
+		return "", "", invalid
+	}
+
+	return port, "//" + depot, nil
+}
+
+// p4Remote forms the remote for the P4PORT of a server and a depot path. It
+// is empty for a port that cannot be expressed as a remote, such as the rsh
+// port of a personal server.
+func p4Remote(port, depot string) string {
+	scheme := "p4"
+	if strings.HasPrefix(port, "ssl:") {
+		scheme = "p4+ssl"
+	}
+	port = strings.TrimPrefix(strings.TrimPrefix(port, "ssl:"), "tcp:")
+	if strings.HasPrefix(port, "rsh:") || strings.Count(port, ":") > 1 {
+		return ""
+	}
+	if _, err := strconv.Atoi(port); err == nil {
+		port = "localhost:" + port
+	}
+
+	return scheme + "://" + port + "/" + strings.TrimPrefix(depot, "//")
+}
+
+// sameP4Remote returns if two remotes name the same depot path on the same
+// server, such as when only one of them has the default port.
+func sameP4Remote(a, b string) bool {
+	pa, da, err := parseP4Remote(a)
+	if err != nil {
+		return a == b
+	}
+	pb, db, err := parseP4Remote(b)
+	return err == nil && pa == pb && da == db
+}
+
+// depot returns the depot path of the remote, such as //depot/project.
+func (s *P4Repo) depot() (string, error) {
+	_, d, err := parseP4Remote(s.Remote())
+	return d, err
+}
+
+// p4Rev forms the revision specifier for a version. Changelist numbers and
+// labels are prefixed with @. Versions that already start with @ or #, such as
+// #head, are used as they are.
+func p4Rev(version string) string {
+	if strings.HasPrefix(version, "@") || strings.HasPrefix(version, "#") {
+		return version
+	}
+	return "@" + version
+}
+
+// p4Unescape decodes the characters Perforce escapes in depot paths.
+var p4Unescape = strings.NewReplacer("%40", "@", "%23", "#", "%2A", "*", "%25", "%")
+
+// parseP4Tagged parses the output of a p4 -ztag command into its records of
+// fields. A record ends where a field repeats, as values such as descriptions
+// can span several lines, including empty ones.
+func parseP4Tagged(out string) []map[string]string {
+	records := []map[string]string{}
+	var r map[string]string
+	var last string
+	for _, l := range strings.Split(strings.Replace(out, "\r\n", "\n", -1), "\n") {
+		if strings.HasPrefix(l, "... ") {
+			f := strings.SplitN(l[4:], " ", 2)
+			if _, ok := r[f[0]]; r == nil || ok {
+				r = make(map[string]string)
+				records = append(records, r)
+			}
+			last = f[0]
+			r[last] = ""
+			if len(f) == 2 {
+				r[last] = f[1]
+			}
+		} else if r != nil {
+			r[last] += "\n" + l
+		}
+	}
+	for _, r := range records {
+		for k, v := range r {
+			r[k] = strings.TrimRight(v, "\n")
+		}
+	}
+
+	return records
+}
+
+// tagged runs a p4 command with tagged output and parses its records.
+func (s *P4Repo) tagged(args ...string) ([]map[string]string, error) {
+	out, stderr, err := s.runSeparate("p4", append([]string{"-ztag"}, args...)...)
+	if err != nil {
+		return nil, NewRemoteError("Unable to run p4 "+args[0], err, string(stderr))
+	}
+	return parseP4Tagged(string(out)), nil
+}
+
+// readConfig reads the P4CONFIG file of the workspace into its settings.
+func (s *P4Repo) readConfig() (map[string]string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.LocalPath(), p4ConfigName()))
+	if err != nil {
+		return nil, NewLocalError("Unable to read workspace configuration", err, "")
+	}
+
+	settings := make(map[string]string)
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSpace(l)
+		if i := strings.Index(l, "="); i > 0 && !strings.HasPrefix(l, "#") {
+			settings[strings.TrimSpace(l[:i])] = strings.TrimSpace(l[i+1:])
+		}
+	}
+
+	return settings, nil
+}
+
+// detectRemote forms the remote of an existing workspace from the port in its
+// P4CONFIG file and the depot path its view maps to the root. The Client of
+// the repo is set to the workspace.
+func (s *P4Repo) detectRemote() (string, error) {
+	settings, err := s.readConfig()
+	if err != nil {
+		return "", err
+	}
+	if c := settings["P4CLIENT"]; c != "" {
+		s.Client = c
+	}
+
+	recs, err := s.tagged("client", "-o")
+	if err != nil || len(recs) == 0 {
+		return "", NewLocalError("Unable to retrieve local repo information", err, "")
+	}
+
+	// The first line of the view maps the depot path, such as
+	// "//depot/project/... //client/...", to the root of the workspace.
+	view := strings.Fields(strings.Replace(recs[0]["View0"], `"`, " ", -1))
+	if len(view) == 0 || !strings.HasSuffix(view[0], "/...") || settings["P4PORT"] == "" {
+		return "", nil
+	}
+
+	return p4Remote(settings["P4PORT"], strings.TrimSuffix(view[0], "/...")), nil
+}
+
+// p4NameRe matches the characters that are replaced in client names.
+var p4NameRe = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// p4ClientName returns the default name of the workspace for a local location,
+// which is unique to the host and the location.
+func p4ClientName(local string) string {
+	name := "go-vcs"
+	if h, err := os.Hostname(); err == nil && h != "" {
+		name += "-" + p4NameRe.ReplaceAllString(h, "-")
+	}
+	return fmt.Sprintf("%s-%x", name, sha1.Sum([]byte(local)))[:len(name)+9]
+}
+
+// Get creates a workspace mapping the depot path of the remote to the local
+// location and syncs the latest revisions to it. The workspace leaves the
+// files writable and its P4CONFIG file out of the view. As the history is kept
+// on the server Depth has no effect. The server must already be trusted, such
+// as with p4 trust, when it uses SSL.
+func (s *P4Repo) Get() error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	port, depot, err := parseP4Remote(s.Remote())
+	if err != nil {
+		return err
+	}
+	if s.Client == "" {
+		s.Client = p4ClientName(s.LocalPath())
+	}
+
+	if err = s.fs().MkdirAll(s.LocalPath(), 0755); err != nil {
+		return NewLocalError("Unable to create directory", err, "")
+	}
+	config := "P4PORT=" + port + "\nP4CLIENT=" + s.Client + "\n"
+	// The file is read by p4 so it is always written to the local disk.
+	if err = ioutil.WriteFile(filepath.Join(s.LocalPath(), p4ConfigName()), []byte(config), 0644); err != nil {
+		return NewLocalError("Unable to write workspace configuration", err, "")
+	}
+
+	spec := fmt.Sprintf("Client: %s\nRoot: %s\nOptions: allwrite clobber nocompress unlocked nomodtime rmdir\nLineEnd: local\nView:\n"+
+		"\t\"%s/...\" \"//%s/...\"\n\t\"-%s/%s\" \"//%s/%s\"\n",
+		s.Client, s.LocalPath(), depot, s.Client, depot, p4ConfigName(), s.Client, p4ConfigName())
+	c := s.CmdFromDir("p4", "client", "-i")
+	c.Stdin = strings.NewReader(spec)
+	out, err := s.runInput(c)
+	if err != nil {
+		return NewRemoteError("Unable to create workspace", err, string(out))
+	}
+
+	out, err = s.RunFromDir("p4", "sync", "-q")
+	if err != nil {
+		return NewRemoteError("Unable to get repository", err, string(out))
+	}
+	return nil
+}
+
+// Init creates a personal server for the local location with p4 init, which
+// requires the p4d server to be installed.
+func (s *P4Repo) Init() error {
+	if err := s.fs().MkdirAll(s.LocalPath(), 0755); err != nil {
+		return NewLocalError("Unable to initialize repository", err, "")
+	}
+	out, err := s.RunFromDir("p4", "init")
+	if err != nil {
+		return NewLocalError("Unable to initialize repository", err, string(out))
+	}
+	return nil
+}
+
+// Update syncs the latest revisions to the workspace.
+func (s *P4Repo) Update() error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	out, err := s.RunFromDir("p4", "sync", "-q")
+	if err != nil {
+		return NewRemoteError("Unable to update repository", err, string(out))
+	}
+	return nil
+}
+
+// UpdateVersion syncs the workspace to a version. The version is a changelist
+// number or label, or a revision specifier such as #head or @2024/01/02.
+func (s *P4Repo) UpdateVersion(version string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	d, err := s.depot()
+	if err != nil {
+		return err
+	}
+	out, err := s.RunFromDir("p4", "sync", "-q", d+"/..."+p4Rev(version))
+	if err != nil {
+		return NewRemoteError("Unable to update checked out version", err, string(out))
+	}
+	return nil
+}
+
+// changes lists the changelists affecting the depot path of the remote for a
+// revision range, such as #have or @10,@20, the most recent first. The full
+// descriptions are retrieved.
+func (s *P4Repo) changes(rev string, limit int) ([]CommitInfo, error) {
+	d, err := s.depot()
+	if err != nil {
+		return []CommitInfo{}, err
+	}
+
+	args := []string{"changes", "-l"}
+	if limit > 0 {
+		args = append(args, "-m", strconv.Itoa(limit))
+	}
+	recs, err := s.tagged(append(args, d+"/..."+rev)...)
+	if err != nil {
+		return []CommitInfo{}, err
+	}
+
+	log := []CommitInfo{}
+	for _, r := range recs {
+		ci := CommitInfo{
+			Commit:     r["change"],
+			Author:     r["user"],
+			AuthorName: r["user"],
+			Message:    strings.TrimSpace(r["desc"]),
+		}
+		if t, err := strconv.ParseInt(r["time"], 10, 64); err == nil {
+			ci.Date = time.Unix(t, 0)
+		}
+		log = append(log, ci)
+	}
+
+	return log, nil
+}
+
+// change resolves a version to the changelist it names, or for a label or
+// other revision specifier the latest changelist it includes. A changelist that
+// does not affect the depot path of the remote is unavailable.
+func (s *P4Repo) change(version string) (*CommitInfo, error) {
+	rev := p4Rev(version)
+	if _, err := strconv.Atoi(version); err == nil {
+		rev = "@=" + version
+	}
+
+	log, err := s.changes(rev, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(log) == 0 {
+		return nil, ErrRevisionUnavailable
+	}
+	return &log[0], nil
+}
+
+// Version retrieves the current version, the latest changelist synced to the
+// workspace.
+func (s *P4Repo) Version() (string, error) {
+	ci, err := s.change("#have")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve checked out version", err, "")
+	}
+
+	return ci.Commit, nil
+}
+
+// ShortVersion retrieves the current version. Changelist numbers are short so
+// this is the same as Version.
+func (s *P4Repo) ShortVersion() (string, error) {
+	return s.Version()
+}
+
+// RootDir retrieves the root of the workspace.
+func (s *P4Repo) RootDir() (string, error) {
+	recs, err := s.tagged("info")
+	if err != nil || len(recs) == 0 || recs[0]["clientRoot"] == "" {
+		return "", NewLocalError("Unable to retrieve root directory", err, "")
+	}
+
+	return filepath.Clean(recs[0]["clientRoot"]), nil
+}
+
+// Current returns the current version-ish. This means:
+// * #head if on the latest changelist of the depot path
+// * Otherwise the changelist number
+func (s *P4Repo) Current() (string, error) {
+	tip, err := s.change("#head")
+	if err != nil {
+		return "", err
+	}
+
+	curr, err := s.Version()
+	if err != nil {
+		return "", err
+	}
+
+	if tip.Commit == curr {
+		return "#head", nil
+	}
+
+	return curr, nil
+}
+
+// CurrentBranch retrieves the depot path the workspace maps, such as
+// //depot/project, as Perforce branches are depot paths.
+func (s *P4Repo) CurrentBranch() (string, error) {
+	return s.depot()
+}
+
+// DefaultBranch retrieves the depot path of the remote. Perforce branches are
+// depot paths so this is the same as CurrentBranch.
+func (s *P4Repo) DefaultBranch() (string, error) {
+	return s.depot()
+}
+
+// Date retrieves the date on the latest changelist synced to the workspace.
+func (s *P4Repo) Date() (time.Time, error) {
+	ci, err := s.change("#have")
+	if err != nil {
+		return time.Time{}, NewLocalError("Unable to retrieve revision date", err, "")
+	}
+
+	return ci.Date, nil
+}
+
+// RevisionAt retrieves the latest changelist affecting the depot path of the
+// remote submitted at or before the passed in time. Perforce interprets dates
+// in the time zone of the server, which is retrieved with p4 info.
+func (s *P4Repo) RevisionAt(t time.Time) (string, error) {
+	recs, err := s.tagged("info")
+	if err != nil || len(recs) == 0 {
+		return "", NewRemoteError("Unable to retrieve revision at "+t.String(), err, "")
+	}
+
+	// The server date is in the form "2024/01/02 03:04:05 -0800 PST".
+	if f := strings.Fields(recs[0]["serverDate"]); len(f) >= 3 {
+		if z, err := time.Parse("-0700", f[2]); err == nil {
+			t = t.In(z.Location())
+		}
+	}
+
+	ci, err := s.change("@" + t.Format("2006/01/02:15:04:05"))
+	if err != nil {
+		return "", ErrRevisionUnavailable
+	}
+	return ci.Commit, nil
+}
+
+// CheckLocal verifies the local location is a Perforce workspace, which has a
+// P4CONFIG file at its root.
+func (s *P4Repo) CheckLocal() bool {
+	if _, err := s.fs().Stat(filepath.Join(s.LocalPath(), p4ConfigName())); err == nil {
+		return true
+	}
+
+	return false
+}
+
+// Branches returns a list of available branches. Perforce branches are depot
+// paths, each their own remote, so none are returned.
+func (s *P4Repo) Branches() ([]string, error) {
+	return []string{}, nil
+}
+
+// Tags returns the labels that include revisions of the files under the depot
+// path of the remote.
+func (s *P4Repo) Tags() ([]string, error) {
+	d, err := s.depot()
+	if err != nil {
+		return []string{}, err
+	}
+	recs, err := s.tagged("labels", d+"/...")
+	if err != nil {
+		return []string{}, NewRemoteError("Unable to retrieve tags", err, "")
+	}
+
+	tags := []string{}
+	for _, r := range recs {
+		if l := r["label"]; l != "" {
+			tags = append(tags, l)
+		}
+	}
+	return tags, nil
+}
+
+// IsReference returns if a string is a reference. A reference can be a
+// changelist number, a label, or a revision specifier such as #head.
+func (s *P4Repo) IsReference(r string) bool {
+	if r == "" || strings.HasPrefix(r, "-") {
+		return false
+	}
+	_, err := s.change(r)
+	return err == nil
+}
+
+// RefType returns the kind of reference a name is. Labels are tags and
+// changelist numbers are commits. #head, which moves as changelists are
+// submitted, is a branch.
+func (s *P4Repo) RefType(r string) RefType {
+	if r == "" || strings.HasPrefix(r, "-") {
+		return RefUnknown
+	}
+	if r == "#head" {
+		return RefBranch
+	}
+
+	tags, err := s.Tags()
+	if err != nil {
+		return RefUnknown
+	}
+	if containsString(tags, r) {
+		return RefTag
+	}
+
+	if _, err = strconv.Atoi(r); err == nil && s.IsReference(r) {
+		return RefCommit
+	}
+	return RefUnknown
+}
+
+// Reset discards the changes to files in the workspace when hard is true. The
+// opened files are reverted and files changed or deleted without being opened
+// are restored with p4 clean. Otherwise the files are reverted with -k, which
+// keeps the changes in the workspace but no longer has them opened.
+func (s *P4Repo) Reset(hard bool) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	args := []string{"revert", "-q"}
+	if !hard {
+		args = append(args, "-k")
+	}
+	out, err := s.RunFromDir("p4", append(args, "...")...)
+	if err != nil {
+		return NewLocalError("Unable to reset checkout", err, string(out))
+	}
+	if !hard {
+		return nil
+	}
+
+	out, err = s.RunFromDir("p4", "clean", "-e", "-d", "...")
+	if err != nil {
+		return NewLocalError("Unable to reset checkout", err, string(out))
+	}
+	return nil
+}
+
+// Clean removes the files in the workspace that are not in the depot with p4
+// clean. Files ignored by P4IGNORE are only removed when ignored is true. The
+// P4CONFIG file is outside of the view of workspaces created by Get so it is
+// kept.
+func (s *P4Repo) Clean(ignored bool) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	args := []string{"clean", "-a"}
+	if ignored {
+		args = append(args, "-I")
+	}
+
+	out, err := s.RunFromDir("p4", append(args, "...")...)
+	if err != nil {
+		return NewLocalError("Unable to clean checkout", err, string(out))
+	}
+	return nil
+}
+
+// IsDirty returns if the workspace has opened files or files changed without
+// being opened, as reported by p4 status.
+func (s *P4Repo) IsDirty() bool {
+	out, stderr, err := s.runSeparate("p4", "-ztag", "status", "...")
+
+	// A clean workspace is reported as a warning, which some releases exit
+	// with an error for.
+	if err != nil && !strings.Contains(string(stderr), "no file(s) to reconcile") {
+		return true
+	}
+	return len(parseP4Tagged(string(out))) != 0
+}
+
+// Status retrieves a snapshot of the state of the workspace. The branch is the
+// depot path of the remote.
+func (s *P4Repo) Status() (*RepoStatus, error) {
+	v, err := s.Version()
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.depot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RepoStatus{
+		Version: v,
+		Branch:  b,
+		Dirty:   s.IsDirty(),
+	}, nil
+}
+
+// Pin records the remote and the latest changelist synced to the workspace.
+// The ref is #head when the workspace is on the latest changelist.
+func (s *P4Repo) Pin() (Pin, error) {
+	return pinRepo(s)
+}
+
+// CommitInfo retrieves metadata about a changelist. Perforce records a user
+// name rather than an email for the author.
+func (s *P4Repo) CommitInfo(id string) (*CommitInfo, error) {
+	ci, err := s.change(id)
+	if err == ErrRevisionUnavailable {
+		return nil, err
+	}
+	if err != nil {
+		return nil, NewLocalError("Unable to retrieve commit information", err, "")
+	}
+
+	return ci, nil
+}
+
+// CommitLog retrieves metadata about the changelists affecting the depot path
+// of the remote selected by the options, the most recent first.
+func (s *P4Repo) CommitLog(o LogOptions) ([]CommitInfo, error) {
+	to := o.To
+	if to == "" {
+		to = "#have"
+	}
+	end, err := s.change(to)
+	if err != nil {
+		return []CommitInfo{}, ErrRevisionUnavailable
+	}
+
+	rev := "@" + end.Commit
+	if o.From != "" {
+		start, err := s.change(o.From)
+		if err != nil {
+			return []CommitInfo{}, ErrRevisionUnavailable
+		}
+		n, _ := strconv.Atoi(start.Commit)
+		rev = "@" + strconv.Itoa(n+1) + "," + rev
+	}
+
+	return s.changes(rev, o.Limit)
+}
+
+// CommitMessage retrieves the full description of the latest changelist synced
+// to the workspace.
+func (s *P4Repo) CommitMessage() (string, error) {
+	ci, err := s.change("#have")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve commit message", err, "")
+	}
+
+	return ci.Message, nil
+}
+
+// TagsFromCommit retrieves the labels whose latest changelist is the passed in
+// changelist.
+func (s *P4Repo) TagsFromCommit(id string) ([]string, error) {
+	tags, err := s.Tags()
+	if err != nil {
+		return []string{}, err
+	}
+
+	list := []string{}
+	for _, t := range tags {
+		ci, err := s.change(t)
+		if err != nil {
+			return []string{}, err
+		}
+		if ci.Commit == id {
+			list = append(list, t)
+		}
+	}
+	return list, nil
+}
+
+// ListFiles returns the paths, relative to the root of the workspace, of the
+// files synced to it.
+func (s *P4Repo) ListFiles() ([]string, error) {
+	recs, err := s.tagged("have", "...")
+	if err != nil {
+		return []string{}, NewLocalError("Unable to list files", err, "")
+	}
+
+	files := []string{}
+	for _, r := range recs {
+		p, err := filepath.Rel(s.LocalPath(), r["path"])
+		if err == nil && r["path"] != "" {
+			files = append(files, p)
+		}
+	}
+	return files, nil
+}
+
+// Diff returns the changes to the files under the depot path of the remote
+// between two versions as a unified diff.
+func (s *P4Repo) Diff(from, to string) (string, error) {
+	d, err := s.depot()
+	if err != nil {
+		return "", err
+	}
+	out, stderr, err := s.runSeparate("p4", "diff2", "-q", "-du", d+"/..."+p4Rev(from), d+"/..."+p4Rev(to))
+	if err != nil {
+		return "", NewRemoteError("Unable to retrieve diff", err, string(stderr))
+	}
+
+	return string(out), nil
+}
+
+// ChangedFiles returns the paths, relative to the depot path of the remote, of
+// the files that differ between two versions.
+func (s *P4Repo) ChangedFiles(from, to string) ([]string, error) {
+	d, err := s.depot()
+	if err != nil {
+		return []string{}, err
+	}
+	recs, err := s.tagged("diff2", "-q", d+"/..."+p4Rev(from), d+"/..."+p4Rev(to))
+	if err != nil {
+		return []string{}, NewRemoteError("Unable to retrieve changed files", err, "")
+	}
+
+	// Files only at one of the versions have a depotFile for just that side.
+	files := []string{}
+	for _, r := range recs {
+		f := r["depotFile"]
+		if f == "" {
+			f = r["depotFile2"]
+		}
+		if strings.HasPrefix(f, d+"/") {
+			files = append(files, filepath.FromSlash(p4Unescape.Replace(f[len(d)+1:])))
+		}
+	}
+	return files, nil
+}
+
+// Ping returns if remote location is accessible.
+func (s *P4Repo) Ping() bool {
+	return s.CheckRemote() == nil
+}
+
+// CheckRemote checks the server of the remote is accessible with p4 info,
+// which does not require a login.
+func (s *P4Repo) CheckRemote() error {
+	port, _, err := parseP4Remote(s.Remote())
+	if err != nil {
+		return err
+	}
+
+	// The port on the command line takes precedence over the one of any
+	// workspace the process is run from.
+	newCmd := func() *exec.Cmd {
+		c := exec.Command("p4", "-p", port, "info")
+		c.Env = mergeEnvLists(s.authEnv("p4"), s.environ())
+		return c
+	}
+	var out bytes.Buffer
+	if err := s.captureRetry("p4", []string{"info"}, newCmd, &out, &out); err != nil {
+		return NewRemoteError("Unable to reach remote", err, out.String())
+	}
+
+	return nil
+}
+
+// ExportDir exports the revisions synced to the workspace to the passed in
+// directory.
+func (s *P4Repo) ExportDir(dir string) error {
+	return s.ExportVersion(dir, "#have")
+}
+
+// ExportVersion exports the passed in version of the files under the depot
+// path of the remote to the passed in directory. Each file is printed from the
+// server.
+func (s *P4Repo) ExportVersion(dir, version string) error {
+	d, err := s.depot()
+	if err != nil {
+		return err
+	}
+	recs, err := s.tagged("files", d+"/..."+p4Rev(version))
+	if err != nil {
+		return NewRemoteError("Unable to export "+version, err, "")
+	}
+
+	for _, r := range recs {
+		f := r["depotFile"]
+		if !strings.HasPrefix(f, d+"/") || strings.Contains(r["action"], "delete") || r["action"] == "purge" || r["action"] == "archive" {
+			continue
+		}
+		p := filepath.Join(dir, filepath.FromSlash(p4Unescape.Replace(f[len(d)+1:])))
+		if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return NewLocalError("Unable to export "+version, err, "")
+		}
+		out, err := s.RunFromDir("p4", "print", "-q", "-o", p, f+"#"+r["rev"])
+		if err != nil {
+			return NewRemoteError("Unable to export "+version, err, string(out))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package vcs
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Canary test to ensure P4Repo implements the Repo interface.
+var _ Repo = &P4Repo{}
+
+func TestP4Detect(t *testing.T) {
+	mfs := &memFileSystem{paths: make(map[string]bool)}
+	def := FS
+	FS = mfs
+	defer func() {
+		FS = def
+	}()
+
+	repo := &P4Repo{}
+	repo.setLocalPath(filepath.Join("mem", "p4", "repo"))
+	repo.FS = mfs
+
+	local := repo.LocalPath()
+	if err := mfs.MkdirAll(filepath.Join(local, p4ConfigName()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if ltype, err := DetectVcsFromFS(local); err != nil || ltype != P4 {
+		t.Errorf("DetectVcsFromFS did not detect a Perforce workspace. Got %s, err %v", ltype, err)
+	}
+	if !repo.CheckLocal() {
+		t.Error("P4 CheckLocal did not detect a workspace")
+	}
+}
+
+func TestParseP4Remote(t *testing.T) {
+	tests := map[string][2]string{
+		"p4://perforce.example.com:1666/depot/project":      {"perforce.example.com:1666", "//depot/project"},
+		"p4://perforce.example.com/depot/project/...":       {"perforce.example.com:1666", "//depot/project"},
+		"p4+ssl://perforce.example.com:1667/depot/project/": {"ssl:perforce.example.com:1667", "//depot/project"},
+	}
+	for remote, exp := range tests {
+		port, depot, err := parseP4Remote(remote)
+		if err != nil || port != exp[0] || depot != exp[1] {
+			t.Errorf("parseP4Remote(%q) returned %q, %q, expected %q. Err was %v", remote, port, depot, exp, err)
+		}
+		if r := p4Remote(port, depot); !sameP4Remote(r, remote) {
+			t.Errorf("p4Remote(%q, %q) returned %q, expected %q", port, depot, r, remote)
+		}
+	}
+
+	for _, remote := range []string{"", "p4://perforce.example.com", "p4://perforce.example.com/...", "https://example.com/depot/project"} {
+		if _, _, err := parseP4Remote(remote); err == nil {
+			t.Errorf("parseP4Remote(%q) did not return an error", remote)
+		}
+	}
+
+	if r := p4Remote("1666", "//depot/project"); r != "p4://localhost:1666/depot/project" {
+		t.Errorf("p4Remote for a port number returned %q", r)
+	}
+	if r := p4Remote("rsh:p4d -r /tmp/p4root -i", "//depot/project"); r != "" {
+		t.Errorf("p4Remote for an rsh port returned %q", r)
+	}
+}
+
+func TestParseP4Tagged(t *testing.T) {
+	out := "... change 12\n... user alice\n... desc First line\n\nSecond line\n\n" +
+		"... change 11\n... user bob\n... desc Fix\n\n"
+	recs := parseP4Tagged(out)
+	exp := []map[string]string{
+		{"change": "12", "user": "alice", "desc": "First line\n\nSecond line"},
+		{"change": "11", "user": "bob", "desc": "Fix"},
+	}
+	if !reflect.DeepEqual(recs, exp) {
+		t.Errorf("parseP4Tagged returned %v, expected %v", recs, exp)
+	}
+
+	if recs = parseP4Tagged(""); len(recs) != 0 {
+		t.Errorf("parseP4Tagged of no output returned %v", recs)
+	}
+}
+
+func TestP4Runner(t *testing.T) {
+	f := &fakeRunner{output: map[string]string{
+		"p4 -ztag changes -l -m 1 //depot/project/...#have": "... change 12\n... time 1700000000\n... user alice\n... client ws\n... status submitted\n... desc Add the parser\n\nWith tests.\n\n",
+		"p4 -ztag changes -l -m 1 //depot/project/...@=11":  "... change 11\n... time 1690000000\n... user bob\n... desc Fix\n\n",
+		"p4 -ztag changes -l -m 1 //depot/project/...@=99":  "",
+		"p4 -ztag changes -l -m 1 //depot/project/...@v1.0": "... change 11\n... time 1690000000\n... user bob\n... desc Fix\n\n",
+		"p4 -ztag changes -l -m 1 //depot/project/...@v1.1": "... change 12\n... time 1700000000\n... user alice\n... desc Add the parser\n\n",
+		"p4 -ztag labels //depot/project/...":               "... label v1.0\n... Owner bob\n... label v1.1\n... Owner alice\n",
+	}}
+	def := DefaultRunner
+	DefaultRunner = f
+	defer func() {
+		DefaultRunner = def
+	}()
+
+	repo, err := NewP4Repo("p4://perforce.example.com/depot/project", filepath.Join("fake", "p4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := repo.Version(); err != nil || v != "12" {
+		t.Errorf("P4 Version returned %s. Err was %v", v, err)
+	}
+	if m, err := repo.CommitMessage(); err != nil || m != "Add the parser\n\nWith tests." {
+		t.Errorf("P4 CommitMessage returned %q. Err was %v", m, err)
+	}
+	if d, err := repo.Date(); err != nil || !d.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("P4 Date returned %s. Err was %v", d, err)
+	}
+
+	ci, err := repo.CommitInfo("11")
+	if err != nil || ci.Commit != "11" || ci.Author != "bob" || ci.Message != "Fix" {
+		t.Errorf("P4 CommitInfo returned %+v. Err was %v", ci, err)
+	}
+	if _, err = repo.CommitInfo("99"); err != ErrRevisionUnavailable {
+		t.Errorf("P4 CommitInfo of a missing changelist returned %v", err)
+	}
+
+	if tags, err := repo.Tags(); err != nil || !reflect.DeepEqual(tags, []string{"v1.0", "v1.1"}) {
+		t.Errorf("P4 Tags returned %v. Err was %v", tags, err)
+	}
+	if tags, err := repo.TagsFromCommit("11"); err != nil || !reflect.DeepEqual(tags, []string{"v1.0"}) {
+		t.Errorf("P4 TagsFromCommit returned %v. Err was %v", tags, err)
+	}
+	if rt := repo.RefType("v1.1"); rt != RefTag {
+		t.Errorf("P4 RefType of a label returned %q", rt)
+	}
+	if rt := repo.RefType("11"); rt != RefCommit {
+		t.Errorf("P4 RefType of a changelist returned %q", rt)
+	}
+	if rt := repo.RefType("99"); rt != RefUnknown {
+		t.Errorf("P4 RefType of a missing changelist returned %q", rt)
+	}
+	if b, err := repo.Branches(); err != nil || len(b) != 0 {
+		t.Errorf("P4 Branches returned %v. Err was %v", b, err)
+	}
+}
@@ -0,0 +1,102 @@
+package vcs
+
+import (
+	"encoding/json"
+)
+
+// Pin records the exact state of a checkout so it can be reproduced with
+// Restore, such as from an entry of a lock file written with WritePinFile.
+type Pin struct {
+	// Type is the VCS of the repo.
+	Type Type `json:"type"`
+
+	// Remote is the remote location of the repo.
+	Remote string `json:"remote"`
+
+	// Revision is the checked out revision in a form that does not move: the
+	// full commit hash for Git, Hg, and Fossil, the revision number for Svn,
+	// the revision id for Bzr, and the changelist number for P4.
+	Revision string `json:"revision"`
+
+	// Ref is the human readable ref the revision was checked out from, as
+	// returned by Current, such as a branch or tag. It is empty when the
+	// checkout was not on one.
+	Ref string `json:"ref,omitempty"`
+}
+
+// pinRepo creates the Pin of a repo from its Version and Current.
+func pinRepo(r Repo) (Pin, error) {
+	v, err := r.Version()
+	if err != nil {
+		return Pin{}, err
+	}
+	ref, err := r.Current()
+	if err != nil {
+		return Pin{}, err
+	}
+	if ref == v {
+		ref = ""
+	}
+
+	return Pin{Type: r.Vcs(), Remote: r.Remote(), Revision: v, Ref: ref}, nil
+}
+
+// Restore retrieves the repo of a pin into local, which must not already hold
+// a checkout, and checks out exactly its revision. The ref of the pin is only
+// informational and is not used, so a branch that has since moved does not
+// change the result. ErrHashMismatch is returned when the revision checked
+// out is not the one pinned, and the checkout is left in place.
+func Restore(pin Pin, local string) error {
+	r, err := newRepoOfType(pin.Type, pin.Remote, local)
+	if err != nil {
+		return err
+	}
+
+	if err = r.Get(); err != nil {
+		return err
+	}
+	if err = r.UpdateVersion(pin.Revision); err != nil {
+		return err
+	}
+
+	p, err := r.Pin()
+	if err != nil {
+		return err
+	}
+	if p.Revision != pin.Revision {
+		Logger.Printf("Checked out revision %s does not match pinned revision %s", p.Revision, pin.Revision)
+		return ErrHashMismatch
+	}
+
+	return nil
+}
+
+// ReadPinFile reads a lock file, written with WritePinFile, into its pins keyed
+// by the name of each repo. The file is read through the package level FS.
+func ReadPinFile(path string) (map[string]Pin, error) {
+	data, err := readFile(FS, path)
+	if err != nil {
+		return nil, NewLocalError("Unable to read pin file", err, "")
+	}
+
+	pins := make(map[string]Pin)
+	if err = json.Unmarshal(data, &pins); err != nil {
+		return nil, NewLocalError("Unable to read pin file", err, "")
+	}
+	return pins, nil
+}
+
+// WritePinFile writes pins, keyed by a name for each repo such as its path
+// within a project, to a lock file as JSON. The entries are sorted by name so
+// the file only changes where a pin does. The file is written through the
+// package level FS.
+func WritePinFile(path string, pins map[string]Pin) error {
+	data, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return NewLocalError("Unable to write pin file", err, "")
+	}
+	if err = writeFile(FS, path, append(data, '\n'), 0644); err != nil {
+		return NewLocalError("Unable to write pin file", err, "")
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package vcs
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPin(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+	tempDir := filepath.Dir(remote.LocalPath())
+
+	first, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := remote.RunFromDir("git", "tag", "v1.0.0"); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	writeLocalFile(t, remote, "README.md", "Changed\n")
+	commitLocalGitRepo(t, remote, "Second commit")
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, "clone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.UpdateVersion("v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	pin, err := repo.Pin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Pin{Type: Git, Remote: remote.LocalPath(), Revision: first, Ref: "v1.0.0"}
+	if pin != expected {
+		t.Errorf("Git Pin returned %+v, expected %+v", pin, expected)
+	}
+
+	lock := filepath.Join(tempDir, "vcs.lock")
+	if err = WritePinFile(lock, map[string]Pin{"clone": pin}); err != nil {
+		t.Fatal(err)
+	}
+	pins, err := ReadPinFile(lock)
+	if err != nil || !reflect.DeepEqual(pins, map[string]Pin{"clone": pin}) {
+		t.Errorf("ReadPinFile returned %v. Err was %v", pins, err)
+	}
+
+	// The pinned revision is restored even once the tag has moved.
+	if out, err := remote.RunFromDir("git", "tag", "-f", "v1.0.0"); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	restored := filepath.Join(tempDir, "restored")
+	if err = Restore(pins["clone"], restored); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewGitRepo(remote.LocalPath(), restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := r.Version(); err != nil || v != first {
+		t.Errorf("Restore checked out %s, expected %s. Err was %v", v, first, err)
+	}
+
+	pin.Revision = "0000000000000000000000000000000000000000"
+	if err = Restore(pin, filepath.Join(tempDir, "missing")); err == nil {
+		t.Error("Restore of a missing revision succeeded")
+	}
+	if err = Restore(Pin{Type: "cvs"}, filepath.Join(tempDir, "cvs")); err != ErrCannotDetectVCS {
+		t.Errorf("Restore of an unknown type returned %v", err)
+	}
+	if _, err = ReadPinFile(filepath.Join(tempDir, "none.lock")); err == nil {
+		t.Error("ReadPinFile of a missing file succeeded")
+	}
+}
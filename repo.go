@@ -23,23 +23,40 @@
 // example, each VCS has its own version formats that need to be respected and
 // checkout out branches, if a branch is being worked with, is different in
 // each VCS.
+//
+// Commands are run with their working directory set on the command rather
+// than by changing the working directory of the process, so repos are safe
+// to use from multiple goroutines. Different repos can be worked with in
+// parallel, such as when cloning many dependencies at once. A single repo can
+// be shared between goroutines as long as its fields are not changed while it
+// is in use. Operations that change a checkout, such as Get and Update, should
+// not run at the same time against the same location unless Lock is set. The
+// package level Logger and FS should be set before any repos are created.
 package vcs
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
 // Logger is where you can provide a logger, implementing the log.Logger interface,
 // where verbose output from each VCS will be written. The default logger does
 // not log data. To log data supply your own logger or change the output location
-// of the provided logger.
+// of the provided logger. It is handed to new repo instances, so changing it
+// afterwards does not affect existing ones. For structured, per repo, logging
+// of the commands that are run set a CommandHook on the repo instead.
 var Logger *log.Logger
 
 func init() {
@@ -56,16 +73,39 @@ type Type string
 
 // VCS types
 const (
-	NoVCS Type = ""
-	Git   Type = "git"
-	Svn   Type = "svn"
-	Bzr   Type = "bzr"
-	Hg    Type = "hg"
+	NoVCS  Type = ""
+	Git    Type = "git"
+	Svn    Type = "svn"
+	Bzr    Type = "bzr"
+	Hg     Type = "hg"
+	Fossil Type = "fossil"
+	P4     Type = "p4"
+)
+
+// RefType describes the kind of reference a name refers to, as returned by
+// RefType.
+type RefType string
+
+// Reference types
+const (
+	// RefUnknown is a name that does not refer to a branch, tag, or commit,
+	// or one whose kind cannot be told, such as a revision expression.
+	RefUnknown RefType = ""
+
+	// RefBranch is a branch, which moves as commits are added to it.
+	RefBranch RefType = "branch"
+
+	// RefTag is a tag, which is not expected to move.
+	RefTag RefType = "tag"
+
+	// RefCommit is a commit id or revision number, which does not move.
+	RefCommit RefType = "commit"
 )
 
 // Repo provides an interface to work with repositories using different source
-// control systems such as Git, Bzr, Mercurial, and SVN. For implementations
-// of this interface see BzrRepo, GitRepo, HgRepo, and SvnRepo.
+// control systems such as Git, Bzr, Mercurial, SVN, Fossil, and Perforce. For
+// implementations of this interface see BzrRepo, FossilRepo, GitRepo, HgRepo,
+// P4Repo, and SvnRepo.
 type Repo interface {
 
 	// Vcs retrieves the underlying VCS being implemented.
@@ -77,6 +117,10 @@ type Repo interface {
 	// LocalPath retrieves the local file system location for a repo.
 	LocalPath() string
 
+	// RootDir retrieves the top level directory of the checkout. This can
+	// differ from LocalPath when it points to a subdirectory of a checkout.
+	RootDir() (string, error)
+
 	// Get is used to perform an initial clone/checkout of a repository.
 	Get() error
 
@@ -92,15 +136,34 @@ type Repo interface {
 	// Version retrieves the current version.
 	Version() (string, error)
 
+	// ShortVersion retrieves the current version in the abbreviated form the
+	// VCS uses for display.
+	ShortVersion() (string, error)
+
 	// Current retrieves the current version-ish. This is different from the
 	// Version method. The output could be a branch name if on the tip of a
 	// branch (git), a tag if on a tag, a revision if on a specific revision
 	// that's not the tip of the branch. The values here vary based on the VCS.
 	Current() (string, error)
 
+	// CurrentBranch retrieves the branch that is checked out. It is empty
+	// when the checkout is not on a branch, such as a Git detached head or an
+	// Svn tag.
+	CurrentBranch() (string, error)
+
+	// DefaultBranch retrieves the branch of the remote that is checked out
+	// by default, such as the HEAD of a Git remote, default for Hg, or the
+	// trunk for Svn.
+	DefaultBranch() (string, error)
+
 	// Date retrieves the date on the latest commit.
 	Date() (time.Time, error)
 
+	// RevisionAt retrieves the latest revision on the default branch that was
+	// committed at or before the passed in time. ErrRevisionUnavailable is
+	// returned when there is none.
+	RevisionAt(time.Time) (string, error)
+
 	// CheckLocal verifies the local location is of the correct VCS type
 	CheckLocal() bool
 
@@ -114,19 +177,70 @@ type Repo interface {
 	// commit id, branch, or tag.
 	IsReference(string) bool
 
+	// RefType returns if a name is a branch, a tag, or a commit id in the
+	// local repo, or RefUnknown when it is none of them. This tells if an
+	// update to it may move, as for a branch, or is expected to be immutable.
+	RefType(r string) RefType
+
+	// Reset discards the uncommitted changes to tracked files, returning them
+	// to the checked out revision, when hard is true. Otherwise only the
+	// changes staged for the next commit are unstaged, which only applies to
+	// Git as the other VCS do not have a staging area. Files unknown to the
+	// VCS are left in place, use Clean to remove them.
+	Reset(hard bool) error
+
+	// Clean removes the files and directories unknown to the VCS from the
+	// checkout, along with the ignored ones when ignored is true. Used after
+	// Reset it returns the checkout to a pristine state.
+	Clean(ignored bool) error
+
 	// IsDirty returns if the checkout has been modified from the checked
-	// out reference.
+	// out reference. Changes to tracked files count whether or not they
+	// have been staged, while files unknown to the VCS do not.
 	IsDirty() bool
 
+	// Status retrieves a snapshot of the state of the checkout using as few
+	// VCS commands as possible.
+	Status() (*RepoStatus, error)
+
+	// Pin records the remote and the checked out revision, in a form that
+	// does not move, along with the ref it was checked out from so Restore
+	// can reproduce the checkout.
+	Pin() (Pin, error)
+
 	// CommitInfo retrieves metadata about a commit.
 	CommitInfo(string) (*CommitInfo, error)
 
+	// CommitLog retrieves metadata about the commits selected by the
+	// options, the most recent first.
+	CommitLog(LogOptions) ([]CommitInfo, error)
+
+	// Diff returns the changes between two revisions as a unified diff.
+	Diff(from, to string) (string, error)
+
+	// ChangedFiles returns the paths of the files that differ between two
+	// revisions.
+	ChangedFiles(from, to string) ([]string, error)
+
+	// ListFiles returns the paths of the files tracked by the VCS at the
+	// checked out revision.
+	ListFiles() ([]string, error)
+
+	// CommitMessage retrieves the message of the checked out commit.
+	CommitMessage() (string, error)
+
 	// TagsFromCommit retrieves tags from a commit id.
 	TagsFromCommit(string) ([]string, error)
 
 	// Ping returns if remote location is accessible.
 	Ping() bool
 
+	// CheckRemote checks the remote location is accessible, with any
+	// credentials that are configured, without retrieving it. When it is
+	// not the returned error describes why, such as a missing repository or
+	// rejected credentials.
+	CheckRemote() error
+
 	// RunFromDir executes a command from repo's directory.
 	RunFromDir(cmd string, args ...string) ([]byte, error)
 
@@ -136,6 +250,10 @@ type Repo interface {
 
 	// ExportDir exports the current revision to the passed in directory.
 	ExportDir(string) error
+
+	// ExportVersion exports the passed in version to the passed in directory
+	// without VCS metadata or changes in the working copy.
+	ExportVersion(dir, version string) error
 }
 
 // NewRepo returns a Repo based on trying to detect the source control from the
@@ -158,6 +276,11 @@ func NewRepo(remote, local string) (Repo, error) {
 		return nil, err
 	}
 
+	return newRepoOfType(vtype, remote, local)
+}
+
+// newRepoOfType creates a repo of a VCS type with the constructor for it.
+func newRepoOfType(vtype Type, remote, local string) (Repo, error) {
 	switch vtype {
 	case Git:
 		return NewGitRepo(remote, local)
@@ -167,20 +290,53 @@ func NewRepo(remote, local string) (Repo, error) {
 		return NewHgRepo(remote, local)
 	case Bzr:
 		return NewBzrRepo(remote, local)
+	case Fossil:
+		return NewFossilRepo(remote, local)
+	case P4:
+		return NewP4Repo(remote, local)
 	}
 
 	// Should never fall through to here but just in case.
 	return nil, ErrCannotDetectVCS
 }
 
+// FileStats counts the files tracked by a repo at the checked out revision by
+// file extension. Extensions, such as .go, are lowercased and include the
+// leading dot. Files without an extension, including dotfiles such as
+// .gitignore, are counted under an empty string. The files come from
+// ListFiles so ignored and untracked files in the checkout are not counted.
+func FileStats(r Repo) (map[string]int, error) {
+	files, err := r.ListFiles()
+	if err != nil {
+		return map[string]int{}, err
+	}
+
+	stats := make(map[string]int)
+	for _, f := range files {
+		name := filepath.Base(f)
+		ext := strings.ToLower(filepath.Ext(name))
+		if ext == strings.ToLower(name) {
+			ext = ""
+		}
+		stats[ext]++
+	}
+
+	return stats, nil
+}
+
 // CommitInfo contains metadata about a commit.
 type CommitInfo struct {
 	// The commit id
 	Commit string
 
-	// Who authored the commit
+	// Who authored the commit, in the form "Name <email>" when the VCS
+	// records an email
 	Author string
 
+	// The name and email of the author split out of Author. Svn only records
+	// a user name, which is the AuthorName, and the AuthorEmail is empty.
+	AuthorName, AuthorEmail string
+
 	// Date of the commit
 	Date time.Time
 
@@ -188,9 +344,150 @@ type CommitInfo struct {
 	Message string
 }
 
+// LogOptions selects the commits returned by CommitLog.
+type LogOptions struct {
+	// From, when set, limits the log to commits that are not reachable from
+	// it, making it the exclusive start of a range such as the previous
+	// release.
+	From string
+
+	// To is the commit the log starts from and goes back in history. It
+	// defaults to the checked out revision.
+	To string
+
+	// Limit, when greater than 0, is the most commits to return.
+	Limit int
+}
+
+// RepoStatus contains a snapshot of the state of a checkout.
+type RepoStatus struct {
+	// The checked out revision, as returned by Version. It is empty for a
+	// repository without any commits.
+	Version string
+
+	// The checked out branch. It is empty when not on a branch, such as when
+	// a Git commit is checked out directly, or for VCS without branches.
+	Branch string
+
+	// If tracked files have been modified. Files unknown to the VCS are not
+	// considered.
+	Dirty bool
+
+	// If the branch tracks an upstream branch. Only Git tracks upstreams.
+	HasUpstream bool
+
+	// The number of commits on the branch that are not on its upstream and
+	// the number on the upstream that are not on the branch.
+	Ahead, Behind int
+}
+
 type base struct {
 	remote, local string
 	Logger        *log.Logger
+
+	// The local path as it was passed in, before it was normalized.
+	origLocal string
+
+	// FollowRedirects controls if HTTP redirects returned by the remote are
+	// followed. It defaults to true. Disabling it causes operations against a
+	// remote that redirects to fail rather than silently going elsewhere. This
+	// is currently honored by Git. The Kind of the error for a redirect that
+	// was not followed is ErrRedirectRefused.
+	FollowRedirects bool
+
+	// Depth limits the history retrieved by Get to the most recent Depth
+	// revisions. The default of 0 retrieves the full history. Not every VCS
+	// can truncate history. Git makes a shallow clone. Bzr supports a depth of
+	// 1 via a stacked branch that reads older history from the remote as
+	// needed. Hg does not truncate history. A subset clone with hg clone -r
+	// is not a substitute as it retrieves every ancestor of the revision, the
+	// full history of its branch, and the next pull brings in the other heads.
+	// Shallow Hg clones need extensions, such as remotefilelog, on the server.
+	// Svn checkouts never contain history so the depth has no effect. When a
+	// depth that cannot be honored is requested, as for Hg, Get returns an
+	// error rather than silently retrieving the full history.
+	Depth int
+
+	// Lock serializes Get, Update, UpdateVersion, Reset, and Clean across
+	// processes, and across repos for the same location, using an advisory
+	// lock on a file named after the local path with a .lock suffix. This
+	// prevents concurrent operations from corrupting a shared checkout. It is
+	// off by default.
+	Lock bool
+
+	// MetricsFunc, when set, is called after each VCS command the repo runs
+	// with the name of the operation, such as "git fetch", how long the
+	// command took, and the error it returned, if any. Each attempt of a
+	// retried command is reported. Commands created with CmdFromDir and run
+	// by the caller are not reported.
+	MetricsFunc func(op string, d time.Duration, err error)
+
+	// CommandHook, when set, is called before and after each VCS command the
+	// repo runs with a CommandEvent describing it. This allows the commands
+	// of a single repo to be logged, such as with a structured logger, or
+	// traced without changing the package level Logger. It is called from the
+	// goroutine running the command. Each attempt of a retried command is
+	// reported. Commands created with CmdFromDir and run by the caller are
+	// not.
+	CommandHook func(CommandEvent)
+
+	// MaxOutputBytes caps the output of each VCS command the repo runs that
+	// is buffered in memory, counting standard output and standard error
+	// together. A command that exceeds it is killed and fails with
+	// ErrOutputTooLarge, which may be the Original error of a returned
+	// RemoteError or LocalError. This guards against untrusted repos that
+	// produce huge output. The default of 0 is unlimited. Commands created
+	// with CmdFromDir are run by the caller and are not limited.
+	MaxOutputBytes int64
+
+	// FS is the file system checks and directory creation are performed
+	// against. When nil the package level FS is used.
+	FS FileSystem
+
+	// Runner, when set, runs the VCS commands of the repo in place of them
+	// being executed directly. New repo instances are handed the
+	// DefaultRunner.
+	Runner Runner
+
+	// The environment set with SetEnv and the variables added with AppendEnv.
+	env    []string
+	envSet bool
+
+	// optionEnv, when set by the constructor of a repo, returns the variables
+	// a command needs for the options of the repo, such as SkipLFS for Git.
+	optionEnv func(cmd string) []string
+
+	// Retry, when set, retries the commands that contact the remote, such as
+	// those of Get and Update, when they fail with a transient network error.
+	Retry *RetryPolicy
+
+	// Auth, when set, holds the credentials passed to the VCS commands the
+	// repo runs in place of relying on an SSH agent or credential helpers.
+	Auth *Auth
+
+	// Progress, when set, receives the output of the VCS commands the repo
+	// runs as it is written, in addition to it being collected, so long
+	// running operations such as Get can report their progress. Only the
+	// standard error is passed on for commands whose output is parsed. The
+	// VCS tools are told to report progress even though they are not run in
+	// a terminal: Git clone, fetch, and pull are passed --progress, Hg shows
+	// its progress bar, and Bzr uses its text progress bar. Svn reports each
+	// file as it is retrieved. Commands created with CmdFromDir are run by
+	// the caller and do not write to it.
+	Progress io.Writer
+}
+
+// depthUnsupported returns the error for a Depth a VCS is unable to honor.
+func depthUnsupported(t Type, depth int) error {
+	return NewLocalError(fmt.Sprintf("%s does not support a depth of %d", t, depth), nil, "")
+}
+
+// fs returns the FileSystem for the repo.
+func (b *base) fs() FileSystem {
+	if b.FS == nil {
+		return FS
+	}
+	return b.FS
 }
 
 func (b *base) log(v interface{}) {
@@ -202,39 +499,436 @@ func (b *base) Remote() string {
 	return b.remote
 }
 
-// LocalPath retrieves the local file system location for a repo.
+// LocalPath retrieves the local file system location for a repo. The location
+// is absolute with any symlinks resolved so it can be compared with paths
+// reported by the VCS.
 func (b *base) LocalPath() string {
 	return b.local
 }
 
+// OriginalLocalPath retrieves the local file system location for a repo as it
+// was passed in, before it was made absolute and symlinks were resolved. It
+// is suitable for display.
+func (b *base) OriginalLocalPath() string {
+	return b.origLocal
+}
+
 func (b *base) setRemote(remote string) {
 	b.remote = remote
 }
 
 func (b *base) setLocalPath(local string) {
-	b.local = local
+	b.origLocal = local
+	b.local = normalizePath(local)
+}
+
+// normalizePath makes a path absolute and resolves any symlinks in it. The
+// path may not exist yet, such as before Get, so only the longest existing
+// prefix is resolved. When the path cannot be made absolute it is returned
+// unchanged.
+func normalizePath(p string) string {
+	if p == "" {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+
+	dir, rest := abs, ""
+	for {
+		if r, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(r, rest)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return abs
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
+
+// SetEnv sets the environment the VCS commands of the repo run with in place
+// of inheriting the environment of the process. This keeps settings such as
+// the user's Git configuration, GIT_DIR, or proxies from leaking into the
+// commands, for example with GIT_CONFIG_NOSYSTEM=1 and HOME pointed at a
+// scratch directory. Each entry is of the form "key=value". The variables the
+// repo sets itself, such as PWD and those for Auth, are added to it. Passing
+// nil restores inheriting the environment and drops any variables added with
+// AppendEnv.
+//
+// The environment applies to the commands run after it is set. The
+// constructors inspect an existing checkout with the inherited environment.
+func (b *base) SetEnv(env []string) {
+	b.env = append([]string(nil), env...)
+	b.envSet = env != nil
+}
+
+// AppendEnv adds variables, of the form "key=value", to the environment the
+// VCS commands of the repo run with, replacing those already in it with the
+// same key. They are added to the environment set with SetEnv or, when none
+// is set, to the environment inherited from the process, such as to set a
+// proxy for a single repo.
+func (b *base) AppendEnv(env ...string) {
+	b.env = mergeEnvLists(env, b.env)
+}
+
+// environ returns the environment for the VCS commands of the repo, before
+// the variables for a single command are added.
+func (b *base) environ() []string {
+	if b.envSet {
+		return append([]string(nil), b.env...)
+	}
+	return mergeEnvLists(b.env, os.Environ())
 }
 
 func (b base) run(cmd string, args ...string) ([]byte, error) {
-	out, err := exec.Command(cmd, args...).CombinedOutput()
+	var buf bytes.Buffer
+	err := b.captureRetry(cmd, args, func() *exec.Cmd {
+		c := exec.Command(cmd, b.authArgs(cmd, b.progressArgs(cmd, args))...)
+		c.Env = mergeEnvLists(b.cmdEnv(cmd, args), b.environ())
+		return c
+	}, &buf, &buf)
+	out := buf.Bytes()
 	b.log(out)
-	if err != nil {
+	if err != nil && err != ErrOutputTooLarge {
 		err = fmt.Errorf("%s: %s", out, err)
 	}
 	return out, err
 }
 
 func (b *base) CmdFromDir(cmd string, args ...string) *exec.Cmd {
-	c := exec.Command(cmd, args...)
+	c := exec.Command(cmd, b.authArgs(cmd, b.progressArgs(cmd, args))...)
 	c.Dir = b.local
-	c.Env = envForDir(c.Dir)
+	c.Env = mergeEnvLists(b.cmdEnv(cmd, args), b.envForDir(c.Dir))
 	return c
 }
 
 func (b *base) RunFromDir(cmd string, args ...string) ([]byte, error) {
-	c := b.CmdFromDir(cmd, args...)
-	out, err := c.CombinedOutput()
-	return out, err
+	var buf bytes.Buffer
+	err := b.captureRetry(cmd, args, func() *exec.Cmd { return b.CmdFromDir(cmd, args...) }, &buf, &buf)
+	return buf.Bytes(), err
+}
+
+// runSeparate runs a command from the repo's directory and returns its standard
+// output and standard error separately. This allows the output to be parsed
+// without warnings or progress written to standard error mixed in.
+func (b *base) runSeparate(cmd string, args ...string) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	err := b.captureRetry(cmd, args, func() *exec.Cmd { return b.CmdFromDir(cmd, args...) }, &stdout, &stderr)
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// capture runs a command, collecting its standard output and standard error
+// into the passed in buffers, which may be the same buffer. When
+// MaxOutputBytes is set and the combined output grows past it the command is
+// killed and ErrOutputTooLarge is returned along with the output collected up
+// to the limit.
+func (b *base) capture(c *exec.Cmd, stdout, stderr *bytes.Buffer) error {
+	var o, e io.Writer = stdout, stderr
+	var l *outputLimit
+	if b.MaxOutputBytes > 0 {
+		l = &outputLimit{max: b.MaxOutputBytes, c: c}
+		o = &limitedWriter{l: l, buf: stdout}
+		if stderr == stdout {
+			e = o
+		} else {
+			e = &limitedWriter{l: l, buf: stderr}
+		}
+	}
+	if b.Progress != nil {
+		if stderr == stdout {
+			o = io.MultiWriter(o, b.Progress)
+			e = o
+		} else {
+			e = io.MultiWriter(e, b.Progress)
+		}
+	}
+
+	// Using the same writer has both streams share one pipe, which keeps them
+	// interleaved as CombinedOutput does.
+	c.Stdout = o
+	c.Stderr = e
+
+	return b.runLimited(c, l)
+}
+
+// runCommand runs a command, with its output already directed, through the
+// Runner of the repo or directly when it has none.
+func (b *base) runCommand(c *exec.Cmd) error {
+	return b.runLimited(c, nil)
+}
+
+// runLimited is the path every command of the repo is run through. It runs
+// the command as runCommand does and reports it to the CommandHook and the
+// MetricsFunc. When the output limit l, if any, was exceeded the command fails
+// with ErrOutputTooLarge.
+func (b *base) runLimited(c *exec.Cmd, l *outputLimit) error {
+	cmd, args := c.Args[0], b.hookArgs(c.Args[0], c.Args[1:])
+	b.hookStart(c.Dir, cmd, args)
+	start := time.Now()
+	var stdout, stderr *hookOutput
+	if b.CommandHook != nil {
+		stdout = &hookOutput{w: c.Stdout}
+		c.Stdout = stdout
+		// A single writer for both streams keeps them on one pipe.
+		if c.Stderr == stdout.w {
+			c.Stderr = stdout
+		} else {
+			stderr = &hookOutput{w: c.Stderr}
+			c.Stderr = stderr
+		}
+	}
+
+	var err error
+	if b.Runner != nil {
+		err = b.Runner.Run(c)
+	} else {
+		err = c.Run()
+	}
+	if l != nil && l.exceeded {
+		err = ErrOutputTooLarge
+	}
+
+	if b.CommandHook != nil {
+		out := stdout.buf.Bytes()
+		if stderr != nil {
+			out = append(out, stderr.buf.Bytes()...)
+		}
+		b.hookDone(c.Dir, cmd, args, start, out, err)
+	}
+	b.metric(cmd, args, start, err)
+	return err
+}
+
+// hookArgs returns the arguments of a command as reported to the CommandHook
+// and MetricsFunc, leaving out those added to pass the Auth.
+func (b *base) hookArgs(cmd string, args []string) []string {
+	a := b.authArgs(cmd, []string{})
+	if len(a) == 0 || len(a) > len(args) {
+		return args
+	}
+	for i := range a {
+		if args[i] != a[i] {
+			return args
+		}
+	}
+	return args[len(a):]
+}
+
+// hookOutput passes the output of a command on to w, when set, while keeping
+// enough of it for a CommandEvent.
+type hookOutput struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (h *hookOutput) Write(p []byte) (int, error) {
+	if n := CommandEventOutputBytes + 1 - h.buf.Len(); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		h.buf.Write(p[:n])
+	}
+	if h.w == nil {
+		return len(p), nil
+	}
+	return h.w.Write(p)
+}
+
+// runInput runs a command created with CmdFromDir, such as one that reads its
+// standard input or has variables added to its environment, and returns its
+// standard output and standard error combined. As with RunFromDir the output
+// is subject to MaxOutputBytes and Progress. The command is not retried as its
+// input cannot be read again.
+func (b *base) runInput(c *exec.Cmd) ([]byte, error) {
+	var buf bytes.Buffer
+	err := b.capture(c, &buf, &buf)
+	return buf.Bytes(), err
+}
+
+// progressArgs adds the flags that make a command report its progress, when
+// the repo has a Progress writer, to its arguments. Only the commands that
+// retrieve from the remote report it so no progress is mixed into output that
+// is parsed.
+func (b *base) progressArgs(cmd string, args []string) []string {
+	if b.Progress == nil {
+		return args
+	}
+
+	i := subcommand(args)
+	if i < 0 {
+		return args
+	}
+	switch cmd + " " + args[i] {
+	case "git clone", "git fetch", "git pull":
+		// Git only reports progress to a terminal unless asked to.
+		a := append([]string{}, args[:i+1]...)
+		return append(append(a, "--progress"), args[i+1:]...)
+	case "hg clone", "hg pull":
+		return append([]string{"--config", "progress.assume-tty=true"}, args...)
+	}
+
+	return args
+}
+
+// cmdEnv returns the environment variables the repo passes to a command.
+func (b *base) cmdEnv(cmd string, args []string) []string {
+	env := b.authEnv(cmd)
+	if b.Progress != nil && cmd == "bzr" {
+		if i := subcommand(args); i >= 0 && (args[i] == "branch" || args[i] == "pull") {
+			env = append(env, "BZR_PROGRESS_BAR=text")
+		}
+	}
+	// Perforce finds the workspace from the configuration file at its root.
+	if cmd == "p4" {
+		env = append(env, "P4CONFIG="+p4ConfigName())
+	}
+	if b.optionEnv != nil {
+		env = append(env, b.optionEnv(cmd)...)
+	}
+	return env
+}
+
+// subcommand returns the index of the first argument of a command that is not
+// a flag, skipping the values of -c and --config configuration flags, or -1
+// when there is none.
+func subcommand(args []string) int {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-c" || args[i] == "--config" {
+			i++
+		} else if !strings.HasPrefix(args[i], "-") {
+			return i
+		}
+	}
+	return -1
+}
+
+// outputLimit tracks the output written by a command against MaxOutputBytes.
+type outputLimit struct {
+	sync.Mutex
+	max, n   int64
+	exceeded bool
+	c        *exec.Cmd
+}
+
+// limitedWriter writes into a buffer until the shared outputLimit is reached,
+// at which point it kills the command.
+type limitedWriter struct {
+	l   *outputLimit
+	buf *bytes.Buffer
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	l := w.l
+	l.Lock()
+	defer l.Unlock()
+
+	if l.exceeded {
+		return 0, ErrOutputTooLarge
+	}
+	if l.n+int64(len(p)) > l.max {
+		w.buf.Write(p[:l.max-l.n])
+		l.n = l.max
+		l.exceeded = true
+		if l.c.Process != nil {
+			l.c.Process.Kill()
+		}
+		return 0, ErrOutputTooLarge
+	}
+
+	l.n += int64(len(p))
+	return w.buf.Write(p)
+}
+
+// CommandEvent describes a VCS command run by a repo to its CommandHook.
+type CommandEvent struct {
+	// Done is false for the event sent before the command runs and true for
+	// the one sent after it completes. The fields below Args are only set
+	// once it is done.
+	Done bool
+
+	// The directory the command runs from. It is empty for commands, such as
+	// a clone, that do not run from the local checkout.
+	Dir string
+
+	// The command and its arguments. Arguments added to pass Auth are left
+	// out so credentials are not exposed.
+	Cmd  string
+	Args []string
+
+	// How long the command took.
+	Duration time.Duration
+
+	// The exit status of the command. It is 0 on success and -1 when the
+	// command could not be run or was killed, such as for MaxOutputBytes.
+	ExitCode int
+
+	// The error the command failed with, if any.
+	Err error
+
+	// The output of the command, standard output followed by standard error,
+	// cut to the first CommandEventOutputBytes. Truncated reports if any of
+	// it was cut.
+	Output    []byte
+	Truncated bool
+}
+
+// CommandEventOutputBytes is the most output of a command a CommandEvent
+// holds.
+const CommandEventOutputBytes = 4096
+
+// hookStart reports a command that is about to run to the CommandHook, if one
+// is set.
+func (b *base) hookStart(dir, cmd string, args []string) {
+	if b.CommandHook == nil {
+		return
+	}
+
+	b.CommandHook(CommandEvent{Dir: dir, Cmd: cmd, Args: append([]string(nil), args...)})
+}
+
+// hookDone reports a command that ran to the CommandHook, if one is set.
+func (b *base) hookDone(dir, cmd string, args []string, start time.Time, out []byte, err error) {
+	if b.CommandHook == nil {
+		return
+	}
+
+	e := CommandEvent{
+		Done:     true,
+		Dir:      dir,
+		Cmd:      cmd,
+		Args:     append([]string(nil), args...),
+		Duration: time.Since(start),
+		Err:      err,
+	}
+	if err != nil {
+		e.ExitCode = exitStatus(err)
+	}
+	if len(out) > CommandEventOutputBytes {
+		out, e.Truncated = out[:CommandEventOutputBytes], true
+	}
+	e.Output = append([]byte(nil), out...)
+
+	b.CommandHook(e)
+}
+
+// metric reports a command that ran to the MetricsFunc, if one is set. The
+// operation is named after the command and its first argument that is not a
+// flag, skipping the values of -c configuration flags.
+func (b *base) metric(cmd string, args []string, start time.Time, err error) {
+	if b.MetricsFunc == nil {
+		return
+	}
+	d := time.Since(start)
+
+	op := cmd
+	if i := subcommand(args); i >= 0 {
+		op = cmd + " " + args[i]
+	}
+
+	b.MetricsFunc(op, d, err)
 }
 
 func (b *base) referenceList(c, r string) []string {
@@ -252,6 +946,11 @@ func envForDir(dir string) []string {
 	return mergeEnvLists([]string{"PWD=" + dir}, env)
 }
 
+// envForDir returns the environment of the repo for a command run from dir.
+func (b *base) envForDir(dir string) []string {
+	return mergeEnvLists([]string{"PWD=" + dir}, b.environ())
+}
+
 func mergeEnvLists(in, out []string) []string {
 NextVar:
 	for _, inkv := range in {
@@ -267,6 +966,33 @@ NextVar:
 	return out
 }
 
+// envValue returns the value of a variable in an environment list, or an empty
+// string when it is not set.
+func envValue(env []string, key string) string {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			return kv[len(key)+1:]
+		}
+	}
+	return ""
+}
+
+// containsString returns if a list contains a string.
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isHex returns if a string is a non-empty hexadecimal number, such as a
+// commit id or an abbreviation of one.
+func isHex(s string) bool {
+	return s != "" && strings.Trim(strings.ToLower(s), "0123456789abcdef") == ""
+}
+
 func depInstalled(name string) bool {
 	if _, err := exec.LookPath(name); err != nil {
 		return false
@@ -274,3 +1000,109 @@ func depInstalled(name string) bool {
 
 	return true
 }
+
+// splitAuthor splits an author in the form "Name <email>" into the name and
+// the email. An author without an email is returned as the name.
+func splitAuthor(author string) (string, string) {
+	author = strings.TrimSpace(author)
+	i := strings.LastIndex(author, "<")
+	if i == -1 || !strings.HasSuffix(author, ">") {
+		return author, ""
+	}
+
+	return strings.TrimSpace(author[:i]), author[i+1 : len(author)-1]
+}
+
+// exitStatus returns the exit status of a command that ran and exited with an
+// error. When the error is for some other reason, such as the command not
+// being found, -1 is returned.
+func exitStatus(err error) int {
+	if ee, ok := err.(*exec.ExitError); ok {
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok {
+			return ws.ExitStatus()
+		}
+	}
+
+	return -1
+}
+
+// versionArgs holds the arguments used to ask each VCS binary for its version.
+var versionArgs = map[Type][]string{
+	Git:    {"--version"},
+	Svn:    {"--version", "--quiet"},
+	Hg:     {"--version", "--quiet"},
+	Bzr:    {"--version"},
+	Fossil: {"version"},
+	P4:     {"-V"},
+}
+
+var versionRegex = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// Available reports whether the binary for the VCS type is installed and on
+// the PATH.
+func Available(t Type) bool {
+	if _, ok := versionArgs[t]; !ok {
+		return false
+	}
+
+	return depInstalled(string(t))
+}
+
+// VcsVersion returns the version of the installed binary for the VCS type in
+// a dotted numeric form such as 2.39.5, suitable for use with CompareVersions.
+func VcsVersion(t Type) (string, error) {
+	args, ok := versionArgs[t]
+	if !ok {
+		return "", fmt.Errorf("Unknown VCS type %q", string(t))
+	}
+	if !depInstalled(string(t)) {
+		return "", fmt.Errorf("%s is not installed", string(t))
+	}
+
+	out, err := exec.Command(string(t), args...).CombinedOutput()
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve "+string(t)+" version", err, string(out))
+	}
+
+	line := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0]
+	// p4 prints a copyright notice before the line with its release, such as
+	// Rev. P4/LINUX26X86_64/2023.1/2468153 (2023/05/18).
+	if t == P4 {
+		for _, l := range strings.Split(string(out), "\n") {
+			if f := strings.Split(l, "/"); strings.HasPrefix(l, "Rev.") && len(f) > 2 {
+				line = f[2]
+			}
+		}
+	}
+	v := versionRegex.FindString(line)
+	if v == "" {
+		return "", NewLocalError("Unable to parse "+string(t)+" version", nil, string(out))
+	}
+
+	return v, nil
+}
+
+// CompareVersions compares two dotted numeric versions, as returned by
+// VcsVersion, and returns -1, 0, or 1 when a is less than, equal to, or
+// greater than b. Missing components count as zero so 2.39 equals 2.39.0.
+func CompareVersions(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+
+	return 0
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -72,3 +75,128 @@ func TestDepInstalled(t *testing.T) {
 		t.Error("depInstalled finding not installed dep.")
 	}
 }
+
+func TestAvailable(t *testing.T) {
+	if !Available(Git) {
+		t.Error("Available not finding installed git")
+	}
+	if Available(Type("thisreallyisntinstalled")) {
+		t.Error("Available finding an unknown VCS type")
+	}
+	if Available(NoVCS) {
+		t.Error("Available finding NoVCS")
+	}
+
+	v, err := VcsVersion(Git)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^\d+(\.\d+)+$`).MatchString(v) {
+		t.Errorf("VcsVersion returned unexpected git version %q", v)
+	}
+	if CompareVersions(v, "1.0") <= 0 {
+		t.Errorf("VcsVersion returned git version %q older than 1.0", v)
+	}
+
+	if _, err = VcsVersion(Type("thisreallyisntinstalled")); err == nil {
+		t.Error("VcsVersion did not error for an unknown VCS type")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"2.39.5", "2.39.5", 0},
+		{"2.39", "2.39.0", 0},
+		{"2.9.0", "2.39.0", -1},
+		{"2.39.10", "2.39.5", 1},
+		{"1.14.1", "2", -1},
+		{"3", "2.99.99", 1},
+	}
+	for _, tc := range tests {
+		if c := CompareVersions(tc.a, tc.b); c != tc.expected {
+			t.Errorf("CompareVersions(%q, %q) returned %d, expected %d", tc.a, tc.b, c, tc.expected)
+		}
+	}
+}
+
+func TestSplitAuthor(t *testing.T) {
+	tests := []struct {
+		author, name, email string
+	}{
+		{"Matt Farina <matt@mattfarina.com>", "Matt Farina", "matt@mattfarina.com"},
+		{"  Jane Doe <jane@example.com> ", "Jane Doe", "jane@example.com"},
+		{"Jane <Doe> <jane@example.com>", "Jane <Doe>", "jane@example.com"},
+		{"<jane@example.com>", "", "jane@example.com"},
+		{"matt.farina", "matt.farina", ""},
+		{"", "", ""},
+	}
+	for _, tc := range tests {
+		name, email := splitAuthor(tc.author)
+		if name != tc.name || email != tc.email {
+			t.Errorf("splitAuthor(%q) returned %q and %q, expected %q and %q", tc.author, name, email, tc.name, tc.email)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	b := &base{}
+	if args := b.authArgs("svn", []string{"info"}); !reflect.DeepEqual(args, []string{"info"}) {
+		t.Errorf("Arguments were changed without an Auth. Got %v", args)
+	}
+	if env := b.authEnv("git"); env != nil {
+		t.Errorf("Environment was changed without an Auth. Got %v", env)
+	}
+
+	b.Auth = &Auth{SSHKeyPath: "/keys/it's", Username: "user", Token: "token"}
+	expected := []string{"--non-interactive", "--no-auth-cache", "--username", "user", "--password", "token", "info"}
+	if args := b.authArgs("svn", []string{"info"}); !reflect.DeepEqual(args, expected) {
+		t.Errorf("Svn Auth arguments were %v, expected %v", args, expected)
+	}
+	ssh := `ssh -i '/keys/it'\''s' -o IdentitiesOnly=yes -o BatchMode=yes`
+	expected = []string{"--config", "ui.interactive=false", "--config", "ui.ssh=" + ssh, "pull"}
+	if args := b.authArgs("hg", []string{"pull"}); !reflect.DeepEqual(args, expected) {
+		t.Errorf("Hg Auth arguments for an SSH remote were %v, expected %v", args, expected)
+	}
+	if args := b.hookArgs("hg", b.authArgs("hg", []string{"pull"})); !reflect.DeepEqual(args, []string{"pull"}) {
+		t.Errorf("Hg Auth arguments were reported to the CommandHook. Got %v", args)
+	}
+	if env := b.authEnv("svn"); !reflect.DeepEqual(env, []string{"SVN_SSH=" + ssh}) {
+		t.Errorf("Svn Auth environment was %v", env)
+	}
+	if args := b.authArgs("git", []string{"fetch"}); !reflect.DeepEqual(args, []string{"fetch"}) {
+		t.Errorf("Git Auth arguments were %v, expected the credentials in the environment", args)
+	}
+	env := strings.Join(b.authEnv("git"), "\n")
+	if !strings.Contains(env, "GIT_SSH_COMMAND="+ssh) || strings.Contains(env, "GIT_CONFIG") {
+		t.Errorf("Git Auth environment for an SSH remote was %v", env)
+	}
+
+	// The header is scoped to the host of the remote and added after the
+	// configuration already in the environment of the repo.
+	b.remote = "https://git@example.com:8443/repo.git"
+	b.SetEnv([]string{"GIT_CONFIG_COUNT=2"})
+	env = strings.Join(b.authEnv("git"), "\n")
+	if !strings.Contains(env, "GIT_CONFIG_COUNT=3") || !strings.Contains(env, "GIT_CONFIG_KEY_2=http.https://example.com:8443/.extraHeader") ||
+		!strings.Contains(env, "GIT_CONFIG_VALUE_2=Authorization: Basic ") {
+		t.Errorf("Git Auth environment for an HTTP remote was %v", env)
+	}
+	expected = []string{"--config", "ui.interactive=false", "--config", "ui.ssh=" + ssh,
+		"--config", "auth.vcs.prefix=https://example.com:8443/", "--config", "auth.vcs.username=user", "--config", "auth.vcs.password=token", "pull"}
+	if args := b.authArgs("hg", []string{"pull"}); !reflect.DeepEqual(args, expected) {
+		t.Errorf("Hg Auth arguments for an HTTP remote were %v, expected %v", args, expected)
+	}
+	if v, err := VcsVersion(Git); err == nil && CompareVersions(v, gitConfigEnvVersion) >= 0 {
+		if err = b.checkAuth("git"); err != nil {
+			t.Errorf("Git %s rejected the Auth: %s", v, err)
+		}
+	}
+
+	bzr := &BzrRepo{}
+	bzr.Auth = b.Auth
+	if err := bzr.Get(); err == nil {
+		t.Error("Bzr did not reject an Auth")
+	}
+}
@@ -0,0 +1,156 @@
+package vcs
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultWorkers is the number of repos a RepoSet works with at once when
+// Workers is not set.
+const defaultWorkers = 4
+
+// RepoSet runs an operation, such as Get or Update, across several repos at
+// once with a bounded number of workers. It is meant for managing many
+// dependencies or mirrors. An operation continues past the repos that fail and
+// returns their errors together in a MultiError.
+//
+// The repos are worked with from separate goroutines so, as with any repo
+// shared between goroutines, their fields should not be changed while an
+// operation is running. Each repo should have its own local location.
+type RepoSet struct {
+	// Repos are the repos the operations run across.
+	Repos []Repo
+
+	// Workers is the number of repos worked with at once. It defaults to 4.
+	Workers int
+
+	// Done, when set, is called with the result of each repo as soon as it
+	// completes, such as to report progress. It is called from the worker
+	// goroutines so it must be safe for concurrent use.
+	Done func(RepoResult)
+}
+
+// RepoResult is the result of an operation of a RepoSet for one of its repos.
+type RepoResult struct {
+	// Repo is the repo the result is for.
+	Repo Repo
+
+	// Err is the error of the operation, or nil when it succeeded.
+	Err error
+
+	// Status is the state of the checkout after the operation succeeded. It
+	// is nil when the operation failed or the state could not be retrieved.
+	Status *RepoStatus
+
+	// Duration is how long the operation took.
+	Duration time.Duration
+}
+
+// NewRepoSet creates a RepoSet for the passed in repos.
+func NewRepoSet(repos ...Repo) *RepoSet {
+	return &RepoSet{Repos: repos}
+}
+
+// Add adds repos to the set. It should not be called while an operation is
+// running.
+func (s *RepoSet) Add(repos ...Repo) {
+	s.Repos = append(s.Repos, repos...)
+}
+
+// Get runs Get for each repo in the set. The results are in the order of
+// Repos. When any repo fails the error is a *MultiError.
+func (s *RepoSet) Get() ([]RepoResult, error) {
+	return s.each("Get", func(r Repo) error {
+		return r.Get()
+	})
+}
+
+// Update runs Update for each repo in the set. The results are in the order of
+// Repos. When any repo fails the error is a *MultiError.
+func (s *RepoSet) Update() ([]RepoResult, error) {
+	return s.each("Update", func(r Repo) error {
+		return r.Update()
+	})
+}
+
+// UpdateVersion runs UpdateVersion for each repo in the set with the version
+// for its local location, as returned by LocalPath, in versions. Repos without
+// a version are left as they are and have a result without an error. The
+// results are in the order of Repos. When any repo fails the error is a
+// *MultiError.
+func (s *RepoSet) UpdateVersion(versions map[string]string) ([]RepoResult, error) {
+	return s.each("UpdateVersion", func(r Repo) error {
+		v, ok := versions[r.LocalPath()]
+		if !ok {
+			return nil
+		}
+		return r.UpdateVersion(v)
+	})
+}
+
+// Status retrieves the state of the checkout of each repo in the set. The
+// results are in the order of Repos. When any repo fails the error is a
+// *MultiError.
+func (s *RepoSet) Status() ([]RepoResult, error) {
+	return s.each("Status", func(r Repo) error {
+		return nil
+	})
+}
+
+// each runs an operation for each repo in the set with the workers and
+// collects the results. The status of the repos the operation succeeded for
+// is retrieved once it completes. For Status, whose operation does nothing, an
+// error retrieving it is the error of the repo.
+func (s *RepoSet) each(op string, f func(Repo) error) ([]RepoResult, error) {
+	results := make([]RepoResult, len(s.Repos))
+	workers := s.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(s.Repos); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				r := s.Repos[i]
+				start := time.Now()
+				res := RepoResult{Repo: r, Err: f(r)}
+				if res.Err == nil {
+					st, err := r.Status()
+					if err == nil {
+						res.Status = st
+					} else if op == "Status" {
+						res.Err = err
+					}
+				}
+				res.Duration = time.Since(start)
+				if res.Err != nil {
+					Logger.Printf("%s of %s failed: %s", op, r.LocalPath(), res.Err)
+				}
+				results[i] = res
+				if s.Done != nil {
+					s.Done(res)
+				}
+			}
+		}()
+	}
+	for i := range s.Repos {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	errs := make(map[string]error)
+	for _, res := range results {
+		if res.Err != nil {
+			errs[res.Repo.LocalPath()] = res.Err
+		}
+	}
+	if len(errs) > 0 {
+		return results, &MultiError{Errors: errs}
+	}
+	return results, nil
+}
@@ -0,0 +1,90 @@
+package vcs
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRepoSet(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+	tempDir := filepath.Dir(remote.LocalPath())
+
+	first, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, remote, "README.md", "Changed\n")
+	commitLocalGitRepo(t, remote, "Second commit")
+	second, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := NewRepoSet()
+	for _, n := range []string{"a", "b", "c"} {
+		repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		set.Add(repo)
+	}
+	missing, err := NewGitRepo(filepath.Join(tempDir, "missing"), filepath.Join(tempDir, "d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.Add(missing)
+	set.Workers = 2
+
+	var mu sync.Mutex
+	done := 0
+	set.Done = func(RepoResult) {
+		mu.Lock()
+		done++
+		mu.Unlock()
+	}
+
+	results, err := set.Get()
+	merr, ok := err.(*MultiError)
+	if !ok || len(merr.Errors) != 1 || merr.Errors[missing.LocalPath()] == nil {
+		t.Fatalf("RepoSet Get did not return the error of the missing remote. Got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "1 repo failed: "+missing.LocalPath()) {
+		t.Errorf("MultiError returned the wrong message. Got %s", err)
+	}
+	if len(results) != 4 || done != 4 {
+		t.Fatalf("RepoSet Get returned %d results and called Done %d times", len(results), done)
+	}
+	for i, res := range results[:3] {
+		if res.Repo != set.Repos[i] || res.Err != nil || res.Status == nil || res.Status.Version != second {
+			t.Errorf("RepoSet Get returned the wrong result for %s. Got %+v", set.Repos[i].LocalPath(), res)
+		}
+	}
+	if results[3].Err == nil || results[3].Status != nil {
+		t.Errorf("RepoSet Get returned the wrong result for the missing remote. Got %+v", results[3])
+	}
+
+	// Only the repos with a version are changed.
+	set.Repos = set.Repos[:3]
+	results, err = set.UpdateVersion(map[string]string{set.Repos[1].LocalPath(): first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, exp := range []string{second, first, second} {
+		if results[i].Status == nil || results[i].Status.Version != exp {
+			t.Errorf("RepoSet UpdateVersion returned the wrong result for %s. Got %+v", set.Repos[i].LocalPath(), results[i])
+		}
+	}
+
+	if results, err = set.Update(); err != nil || len(results) != 3 {
+		t.Errorf("RepoSet Update returned %d results. Err was %v", len(results), err)
+	}
+
+	set.Add(missing)
+	results, err = set.Status()
+	if merr, ok := err.(*MultiError); !ok || len(merr.Errors) != 1 || results[0].Status == nil {
+		t.Errorf("RepoSet Status did not return the error of the missing checkout. Got %v", err)
+	}
+}
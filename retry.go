@@ -0,0 +1,156 @@
+package vcs
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how a repo retries the VCS commands that contact the
+// remote when they fail with a transient network error, such as a timeout or
+// a dropped connection. Set it on a repo as Retry. The retried commands are
+// those that retrieve from or query the remote: Git clone, fetch, pull,
+// ls-remote, remote update, and lfs pull, Hg clone, pull, and identify, Svn
+// checkout, update, switch, info, and ls, Bzr branch, checkout, pull, update,
+// and info, Fossil clone, pull, sync, and update, and P4 sync, info, changes,
+// files, labels, and print. Commands that change the remote, such as a push,
+// are not retried.
+//
+// MetricsFunc and CommandHook see a retried command once, with the duration
+// of all of its attempts and the result of the last one.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a command is run, including the
+	// first. Values below 2 disable retrying.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry, doubled for each further
+	// retry. It defaults to one second.
+	Backoff time.Duration
+
+	// MaxBackoff, when set, caps the delay between attempts.
+	MaxBackoff time.Duration
+
+	// Retryable, when set, decides if a failed attempt is retried in place of
+	// the default classification of transient network errors. It is passed a
+	// RemoteError holding the output of the command.
+	Retryable func(err error) bool
+}
+
+// transientMessages are the messages, in the output of the VCS commands,
+// that identify failures caused by the network rather than the remote or the
+// request. Only the English messages are known.
+var transientMessages = []string{
+	// Git
+	"could not resolve host", "remote end hung up unexpectedly", "early eof", "rpc failed",
+	"returned error: 500", "returned error: 502", "returned error: 503", "returned error: 504",
+	"gnutls_handshake() failed", "ssl_read", "ssl_connect",
+	// Hg
+	"abort: error:", "http error 500", "http error 502", "http error 503", "http error 504",
+	// Svn
+	"e670008", "e000110", "e000111", "e175012",
+	// Bzr
+	"unable to connect", "connectionreset", "connectionerror",
+	// Networking for all
+	"connection refused", "connection reset", "connection timed out", "operation timed out",
+	"temporary failure in name resolution", "network is unreachable", "no route to host",
+	"tls handshake timeout",
+}
+
+// isTransient returns if a failed command was caused by the network, based on
+// its output and error.
+func isTransient(err error, out []byte) bool {
+	if err == ErrOutputTooLarge {
+		return false
+	}
+
+	msg := strings.ToLower(string(out) + "\n" + err.Error())
+	for _, m := range transientMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryCommand returns if a command contacts the remote without changing it,
+// so can be retried.
+func retryCommand(cmd string, args []string) bool {
+	i := subcommand(args)
+	if i < 0 {
+		return false
+	}
+	switch cmd + " " + args[i] {
+	case "git clone", "git fetch", "git pull", "git ls-remote",
+		"hg clone", "hg pull", "hg identify",
+		"svn checkout", "svn update", "svn switch", "svn info", "svn ls",
+		"bzr branch", "bzr checkout", "bzr pull", "bzr update", "bzr info",
+		"fossil clone", "fossil pull", "fossil sync", "fossil update",
+		"p4 sync", "p4 info", "p4 changes", "p4 files", "p4 labels", "p4 print":
+		return true
+	case "git remote":
+		return i+1 < len(args) && args[i+1] == "update"
+	case "git lfs":
+		return i+1 < len(args) && args[i+1] == "pull"
+	}
+	return false
+}
+
+// delay returns how long to wait before the retry following an attempt.
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	if d <= 0 {
+		d = time.Second
+	}
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// captureRetry runs a command with capture, retrying it as the Retry policy
+// of the repo allows. The command and its arguments, before those the repo
+// adds such as for Auth, decide if it can be retried. As a command can only be
+// run once newCmd is called to create it for each attempt. The buffers hold
+// the output of the last attempt. A command the Auth cannot be passed to is
+// not run at all.
+func (b *base) captureRetry(cmd string, args []string, newCmd func() *exec.Cmd, stdout, stderr *bytes.Buffer) error {
+	if err := b.checkAuth(cmd); err != nil {
+		return err
+	}
+	c := newCmd()
+	p := b.Retry
+	if p == nil || p.MaxAttempts < 2 || !retryCommand(cmd, args) {
+		return b.capture(c, stdout, stderr)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := b.capture(c, stdout, stderr)
+		if err == nil || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		out := stdout.Bytes()
+		if stderr != stdout {
+			out = append(append([]byte{}, out...), stderr.Bytes()...)
+		}
+		if p.Retryable != nil {
+			if !p.Retryable(NewRemoteError("Command failed", err, string(out))) {
+				return err
+			}
+		} else if !isTransient(err, out) {
+			return err
+		}
+
+		d := p.delay(attempt)
+		b.log(fmt.Sprintf("Retrying %s %s in %s after attempt %d failed: %s", cmd, args[subcommand(args)], d, attempt, err))
+		time.Sleep(d)
+		stdout.Reset()
+		stderr.Reset()
+		c = newCmd()
+	}
+}
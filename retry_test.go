@@ -0,0 +1,106 @@
+package vcs
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyRunner is a Runner that fails the first failures commands it runs with
+// output, then succeeds.
+type flakyRunner struct {
+	failures int
+	output   string
+	calls    int
+}
+
+func (f *flakyRunner) Run(c *exec.Cmd) error {
+	f.calls++
+	if f.calls <= f.failures {
+		io.WriteString(c.Stderr, f.output)
+		return errors.New("exit status 128")
+	}
+	_, err := io.WriteString(c.Stdout, "ok\n")
+	return err
+}
+
+func TestRetry(t *testing.T) {
+	const unreachable = "fatal: unable to access 'https://example.com/repo.git/': Could not resolve host: example.com\n"
+	newRepo := func(f *flakyRunner, p *RetryPolicy) *GitRepo {
+		repo, err := NewGitRepo("https://example.com/repo.git", "repo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo.Runner = f
+		repo.Retry = p
+		return repo
+	}
+	policy := &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+
+	f := &flakyRunner{failures: 2, output: unreachable}
+	if err := newRepo(f, nil).CheckRemote(); err == nil || f.calls != 1 {
+		t.Errorf("A repo without a Retry policy ran the command %d times. Err was %v", f.calls, err)
+	}
+
+	f = &flakyRunner{failures: 2, output: unreachable}
+	if err := newRepo(f, policy).CheckRemote(); err != nil || f.calls != 3 {
+		t.Errorf("A transient failure was retried %d times. Err was %v", f.calls, err)
+	}
+
+	f = &flakyRunner{failures: 5, output: unreachable}
+	if err := newRepo(f, policy).CheckRemote(); err == nil || f.calls != 3 {
+		t.Errorf("Retrying did not stop after MaxAttempts. Ran the command %d times, err was %v", f.calls, err)
+	}
+
+	f = &flakyRunner{failures: 1, output: "remote: Repository not found.\n"}
+	if err := newRepo(f, policy).CheckRemote(); err == nil || f.calls != 1 {
+		t.Errorf("A failure that is not transient was retried. Ran the command %d times, err was %v", f.calls, err)
+	}
+
+	f = &flakyRunner{failures: 1, output: unreachable}
+	if _, err := newRepo(f, policy).RunFromDir("git", "push", "origin"); err == nil || f.calls != 1 {
+		t.Errorf("A push was retried. Ran the command %d times, err was %v", f.calls, err)
+	}
+
+	var passed error
+	f = &flakyRunner{failures: 1, output: unreachable}
+	custom := &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, Retryable: func(err error) bool {
+		passed = err
+		return false
+	}}
+	if err := newRepo(f, custom).CheckRemote(); err == nil || f.calls != 1 {
+		t.Errorf("Retryable did not prevent the retry. Ran the command %d times, err was %v", f.calls, err)
+	}
+	if re, ok := passed.(*RemoteError); !ok || re.Out() != unreachable || re.Kind() != ErrRemoteUnavailable {
+		t.Errorf("Retryable was passed the wrong error. Got %#v", passed)
+	}
+
+	for _, args := range [][]string{
+		{"fetch", "origin"},
+		{"-c", "protocol.version=2", "ls-remote", "origin"},
+		{"remote", "update", "--prune", "origin"},
+	} {
+		if !retryCommand("git", args) {
+			t.Errorf("git %s is not retried", strings.Join(args, " "))
+		}
+	}
+	if !retryCommand("hg", []string{"--config", "ui.interactive=false", "pull"}) {
+		t.Error("hg pull with configuration flags is not retried")
+	}
+	if retryCommand("git", []string{"remote", "add", "origin", "url"}) || retryCommand("hg", []string{"push"}) {
+		t.Error("A command that does not query the remote is retried")
+	}
+
+	p := &RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if d := p.delay(attempt + 1); d != expected {
+			t.Errorf("Delay after attempt %d was %s, expected %s", attempt+1, d, expected)
+		}
+	}
+	if d := (&RetryPolicy{}).delay(2); d != 2*time.Second {
+		t.Errorf("Delay with the default Backoff was %s", d)
+	}
+}
@@ -0,0 +1,39 @@
+package vcs
+
+import "os/exec"
+
+// Runner runs the VCS commands of a repo. It allows the commands to be
+// replaced, such as with fakes in tests that do not have the VCS installed, or
+// wrapped to sandbox them, add timeouts, or record them.
+//
+// Run executes the command c as prepared by the repo and returns an error
+// describing a command that could not be run or exited with a failure. The
+// command has the full arguments, including those the repo adds itself such
+// as for Auth or Progress, and its Dir, Env, and Stdin set, with Dir empty for
+// commands that do not run from the local checkout. Its output must be written
+// to c.Stdout and c.Stderr, which are separate for commands whose standard
+// output is parsed. A Runner can run c itself, as ExecRunner does, or run
+// something else in its place, such as a modified copy of it.
+//
+// Every command of a repo goes through its Runner, including those that read
+// their standard input or stream their output. Commands created with
+// CmdFromDir and run by the caller do not use it.
+type Runner interface {
+	Run(c *exec.Cmd) error
+}
+
+// DefaultRunner is the Runner handed to new repo instances. When nil, the
+// default, commands are executed directly with the os/exec package. When set
+// the constructors, such as NewGitRepo, do not require the VCS to be
+// installed. To use a different Runner for a single repo set its Runner
+// instead.
+var DefaultRunner Runner
+
+// ExecRunner is a Runner that executes commands as they are with the os/exec
+// package. It is useful for Runners that wrap the execution of commands.
+type ExecRunner struct{}
+
+// Run executes the command with its Run method.
+func (ExecRunner) Run(c *exec.Cmd) error {
+	return c.Run()
+}
@@ -0,0 +1,122 @@
+package vcs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeRunner is a Runner that records the commands it is asked to run, along
+// with the environment and standard input of the last one, and writes canned
+// output for them.
+type fakeRunner struct {
+	calls  []string
+	output map[string]string
+	env    []string
+	stdin  string
+}
+
+func (f *fakeRunner) Run(c *exec.Cmd) error {
+	cmd := strings.Join(c.Args, " ")
+	f.calls = append(f.calls, c.Dir+": "+cmd)
+	f.env = c.Env
+	f.stdin = ""
+	if c.Stdin != nil {
+		in, err := ioutil.ReadAll(c.Stdin)
+		if err != nil {
+			return err
+		}
+		f.stdin = string(in)
+	}
+	out, ok := f.output[cmd]
+	if !ok {
+		io.WriteString(c.Stderr, "unknown command")
+		return errors.New("exit status 1")
+	}
+	_, err := io.WriteString(c.Stdout, out)
+	return err
+}
+
+func TestRunner(t *testing.T) {
+	f := &fakeRunner{output: map[string]string{
+		"git rev-parse HEAD": "1234567890abcdef\n",
+	}}
+	def := DefaultRunner
+	DefaultRunner = f
+	defer func() {
+		DefaultRunner = def
+	}()
+
+	local := filepath.Join("fake", "repo")
+	repo, err := NewGitRepo("https://example.com/repo.git", local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.Runner != f {
+		t.Error("NewGitRepo did not hand the DefaultRunner to the repo")
+	}
+
+	v, err := repo.Version()
+	if err != nil || v != "1234567890abcdef" {
+		t.Errorf("Git Version with a Runner returned %s. Err was %v", v, err)
+	}
+	if len(f.calls) != 1 || f.calls[0] != repo.LocalPath()+": git rev-parse HEAD" {
+		t.Errorf("Runner was called with the wrong commands. Got %v", f.calls)
+	}
+
+	if err = repo.Get(); err == nil {
+		t.Error("Git Get succeeded with a failing Runner")
+	}
+	if c := f.calls[len(f.calls)-1]; !strings.HasPrefix(c, ": git clone") {
+		t.Errorf("Git Get did not run a clone outside of the checkout. Got %s", c)
+	}
+
+	// Output from a Runner is subject to the same handling as other output.
+	var progress bytes.Buffer
+	repo.Progress = &progress
+	if _, err = repo.RunFromDir("git", "rev-parse", "HEAD"); err != nil || progress.String() != "1234567890abcdef\n" {
+		t.Errorf("Runner output was not passed to Progress. Got %q (err %v)", progress.String(), err)
+	}
+	repo.MaxOutputBytes = 4
+	if out, err := repo.RunFromDir("git", "rev-parse", "HEAD"); err != ErrOutputTooLarge || string(out) != "1234" {
+		t.Errorf("Runner output was not limited by MaxOutputBytes. Got %q (err %v)", out, err)
+	}
+	repo.MaxOutputBytes = 0
+	repo.Progress = nil
+
+	// Commands reading their standard input go through the Runner with the
+	// environment of the repo.
+	repo.AppendEnv("GIT_AUTHOR_NAME=Runner")
+	if err = repo.ImportFastImport(strings.NewReader("done\n")); err == nil {
+		t.Error("Git ImportFastImport succeeded with a failing Runner")
+	}
+	if c := f.calls[len(f.calls)-1]; c != repo.LocalPath()+": git fast-import --quiet" || f.stdin != "done\n" {
+		t.Errorf("Git ImportFastImport did not use the Runner. Got %s with input %q", c, f.stdin)
+	}
+	if !containsString(f.env, "GIT_AUTHOR_NAME=Runner") {
+		t.Errorf("Runner was not passed the environment of the repo. Got %v", f.env)
+	}
+}
+
+func TestExecRunner(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	c := exec.Command("git", "--version")
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := (ExecRunner{}).Run(c); err != nil || !strings.HasPrefix(stdout.String(), "git version") {
+		t.Errorf("ExecRunner wrote %q. Err was %v", stdout.String(), err)
+	}
+
+	stdout.Reset()
+	c = exec.Command("git", "not-a-command")
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := (ExecRunner{}).Run(c); err == nil || stdout.Len() != 0 || stderr.Len() == 0 {
+		t.Errorf("ExecRunner did not keep the standard error separate for a failing command. Err was %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package vcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// The names of the files a snapshot is stored in within its directory.
+const (
+	snapshotFile   = "snapshot.json"
+	snapshotBundle = "repo.bundle"
+)
+
+// RepoSnapshot is the state needed to restore a checkout, such as from a CI
+// cache, without retrieving it from the remote again. It is stored as JSON in
+// the snapshot directory along with the bundle, when there is one.
+type RepoSnapshot struct {
+	// Type is the VCS of the repo.
+	Type Type `json:"type"`
+
+	// Remote is the remote location of the repo.
+	Remote string `json:"remote"`
+
+	// Version is the revision that was checked out.
+	Version string `json:"version"`
+
+	// Bundle is the name of the file in the snapshot directory holding the
+	// history of the repo as a Git or Hg bundle. It is empty when the
+	// snapshot was taken without one.
+	Bundle string `json:"bundle,omitempty"`
+}
+
+// Snapshot records the remote and checked out version of a repo in the
+// directory dir, which is created if needed, and returns the recorded
+// snapshot. When bundle is true the history of the repo is also written to a
+// bundle so RestoreFromSnapshot can restore it without the network. Bundles
+// are supported by Git, which includes every ref, and Hg. Taking a snapshot
+// into a directory that already holds one replaces it.
+func Snapshot(r Repo, dir string, bundle bool) (*RepoSnapshot, error) {
+	v, err := r.Version()
+	if err != nil {
+		return nil, err
+	}
+	snap := &RepoSnapshot{Type: r.Vcs(), Remote: r.Remote(), Version: v}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, NewLocalError("Unable to create snapshot directory", err, "")
+	}
+	if bundle {
+		b, err := filepath.Abs(filepath.Join(dir, snapshotBundle))
+		if err != nil {
+			return nil, NewLocalError("Unable to create bundle", err, "")
+		}
+		var out []byte
+		switch r.Vcs() {
+		case Git:
+			out, err = r.RunFromDir("git", "bundle", "create", b, "--all")
+		case Hg:
+			out, err = r.RunFromDir("hg", "bundle", "--all", b)
+		default:
+			return nil, NewLocalError(fmt.Sprintf("%s does not support bundles", r.Vcs()), nil, "")
+		}
+		if err != nil {
+			return nil, NewLocalError("Unable to create bundle", err, string(out))
+		}
+		snap.Bundle = snapshotBundle
+	}
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return nil, NewLocalError("Unable to write snapshot", err, "")
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, snapshotFile), data, 0644); err != nil {
+		return nil, NewLocalError("Unable to write snapshot", err, "")
+	}
+
+	return snap, nil
+}
+
+// RestoreFromSnapshot creates a checkout at local from the snapshot in the
+// directory dir, taken with Snapshot, and returns the repo for it. When the
+// snapshot has a bundle the history is cloned from it, rather than the remote,
+// and the remote location of the checkout is set to the remote of the
+// snapshot. Otherwise the repo is retrieved from the remote with Get. Either
+// way the version of the snapshot is checked out. Use Update afterwards to
+// bring a checkout restored from a bundle up to date with the remote.
+func RestoreFromSnapshot(dir, local string) (Repo, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, snapshotFile))
+	if err != nil {
+		return nil, NewLocalError("Unable to read snapshot", err, "")
+	}
+	snap := &RepoSnapshot{}
+	if err = json.Unmarshal(data, snap); err != nil {
+		return nil, NewLocalError("Unable to read snapshot", err, "")
+	}
+
+	r, err := newRepoOfType(snap.Type, snap.Remote, local)
+	if err != nil {
+		return nil, err
+	}
+
+	if snap.Bundle == "" {
+		err = r.Get()
+	} else {
+		err = restoreBundle(r, filepath.Join(dir, snap.Bundle))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = r.UpdateVersion(snap.Version); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// restoreBundle clones a repo from a bundle and points it at its remote.
+func restoreBundle(r Repo, bundle string) error {
+	b, err := filepath.Abs(bundle)
+	if err != nil {
+		return NewLocalError("Unable to restore bundle", err, "")
+	}
+
+	switch s := r.(type) {
+	case *GitRepo:
+		out, err := s.run("git", "clone", "-q", "--no-checkout", "-o", s.RemoteLocation, b, s.LocalPath())
+		if err != nil {
+			return NewLocalError("Unable to restore bundle", err, string(out))
+		}
+		out, err = s.RunFromDir("git", "remote", "set-url", s.RemoteLocation, s.Remote())
+		if err != nil {
+			return NewLocalError("Unable to set remote", err, string(out))
+		}
+	case *HgRepo:
+		out, err := s.run("hg", "clone", "-U", b, s.LocalPath())
+		if err != nil {
+			return NewLocalError("Unable to restore bundle", err, string(out))
+		}
+		// The default path is the bundle it was cloned from.
+		hgrc := "[paths]\ndefault = " + s.Remote() + "\n"
+		if err = ioutil.WriteFile(filepath.Join(s.LocalPath(), ".hg", "hgrc"), []byte(hgrc), 0644); err != nil {
+			return NewLocalError("Unable to set remote", err, "")
+		}
+	default:
+		return NewLocalError(fmt.Sprintf("%s does not support bundles", r.Vcs()), nil, "")
+	}
+
+	return nil
+}
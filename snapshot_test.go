@@ -0,0 +1,85 @@
+package vcs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	remote, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+	tempDir := filepath.Dir(remote.LocalPath())
+
+	first, err := remote.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalFile(t, remote, "README.md", "Changed\n")
+	commitLocalGitRepo(t, remote, "Second commit")
+
+	repo, err := NewGitRepo(remote.LocalPath(), filepath.Join(tempDir, "clone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.UpdateVersion(first); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := filepath.Join(tempDir, "cache")
+	snap, err := Snapshot(repo, cache, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Type != Git || snap.Remote != remote.LocalPath() || snap.Version != first || snap.Bundle == "" {
+		t.Errorf("Snapshot recorded the wrong state. Got %+v", snap)
+	}
+
+	// Restoring from the bundle does not need the remote.
+	moved := filepath.Join(tempDir, "moved")
+	if err = os.Rename(remote.LocalPath(), moved); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreFromSnapshot(cache, filepath.Join(tempDir, "restored"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := restored.Version(); err != nil || v != first {
+		t.Errorf("RestoreFromSnapshot checked out %s, expected %s. Err was %v", v, first, err)
+	}
+	out, err := restored.RunFromDir("git", "config", "--get", "remote.origin.url")
+	if err != nil || strings.TrimSpace(string(out)) != snap.Remote {
+		t.Errorf("RestoreFromSnapshot did not set the remote. Got %q (err %v)", out, err)
+	}
+	if restored.Remote() != snap.Remote {
+		t.Errorf("RestoreFromSnapshot returned a repo for the remote %s", restored.Remote())
+	}
+	if err = os.Rename(moved, remote.LocalPath()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a bundle the repo is retrieved from the remote.
+	if snap, err = Snapshot(repo, cache, false); err != nil || snap.Bundle != "" {
+		t.Fatalf("Snapshot without a bundle returned %+v. Err was %v", snap, err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(cache, snapshotFile))
+	if err != nil || strings.Contains(string(data), "bundle") {
+		t.Errorf("Snapshot without a bundle wrote %s. Err was %v", data, err)
+	}
+	restored, err = RestoreFromSnapshot(cache, filepath.Join(tempDir, "fetched"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := restored.Version(); err != nil || v != first {
+		t.Errorf("RestoreFromSnapshot checked out %s, expected %s. Err was %v", v, first, err)
+	}
+
+	if _, err = RestoreFromSnapshot(filepath.Join(tempDir, "missing"), filepath.Join(tempDir, "none")); err == nil {
+		t.Error("RestoreFromSnapshot succeeded without a snapshot")
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"encoding/xml"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -16,7 +16,7 @@ import (
 // For example, if the package is https://github.com/Masterminds/cookoo/ the remote
 // should be https://github.com/Masterminds/cookoo/trunk for the trunk branch.
 func NewSvnRepo(remote, local string) (*SvnRepo, error) {
-	ins := depInstalled("svn")
+	ins := DefaultRunner != nil || depInstalled("svn")
 	if !ins {
 		return nil, NewLocalError("svn is not installed", nil, "")
 	}
@@ -31,13 +31,19 @@ func NewSvnRepo(remote, local string) (*SvnRepo, error) {
 	r.setRemote(remote)
 	r.setLocalPath(local)
 	r.Logger = Logger
+	r.FollowRedirects = true
+	r.FS = FS
+	r.Runner = DefaultRunner
+	r.TrunkDir = "trunk"
+	r.BranchesDir = "branches"
+	r.TagsDir = "tags"
 
 	// Make sure the local SVN repo is configured the same as the remote when
 	// A remote value was passed in.
 	if err == nil && r.CheckLocal() {
 		// An SVN repo was found so test that the URL there matches
 		// the repo passed in here.
-		out, err := exec.Command("svn", "info", local).CombinedOutput()
+		out, err := r.RunFromDir("svn", "info")
 		if err != nil {
 			return nil, NewLocalError("Unable to retrieve local repo information", err, string(out))
 		}
@@ -63,6 +69,19 @@ func NewSvnRepo(remote, local string) (*SvnRepo, error) {
 // SvnRepo implements the Repo interface for the Svn source control.
 type SvnRepo struct {
 	base
+
+	// The names of the directories in a project that hold the trunk,
+	// branches, and tags by convention. They default to trunk, branches, and
+	// tags and can be changed for repositories with a different layout.
+	TrunkDir, BranchesDir, TagsDir string
+
+	// SparsePaths, when set, makes Get check out only these directories,
+	// relative to the remote, along with the files at its top level using
+	// the sparse directories of SVN. The depth is sticky so Update and
+	// UpdateVersion leave the other directories out as well. To work with a
+	// single directory by itself include it in the remote instead, such as
+	// https://example.com/repo/trunk/subdir.
+	SparsePaths []string
 }
 
 // Vcs retrieves the underlying VCS being implemented.
@@ -74,16 +93,42 @@ func (s SvnRepo) Vcs() Type {
 // Note, because SVN isn't distributed this is a checkout without
 // a clone.
 func (s *SvnRepo) Get() error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	remote := s.Remote()
 	if strings.HasPrefix(remote, "/") {
 		remote = "file://" + remote
 	} else if runtime.GOOS == "windows" && filepath.VolumeName(remote) != "" {
 		remote = "file:///" + remote
 	}
-	out, err := s.run("svn", "checkout", remote, s.LocalPath())
+	if len(s.SparsePaths) == 0 {
+		out, err := s.run("svn", "checkout", remote, s.LocalPath())
+		if err != nil {
+			return NewRemoteError("Unable to get repository", err, string(out))
+		}
+		return nil
+	}
+
+	args := []string{"update", "--set-depth", "infinity", "--parents", "--"}
+	for _, p := range s.SparsePaths {
+		p = strings.Trim(filepath.ToSlash(p), "/")
+		if p == "" || p == "." {
+			return NewLocalError("Unable to limit the checkout to the root of the repository", nil, "")
+		}
+		args = append(args, p)
+	}
+	out, err := s.run("svn", "checkout", "--depth", "files", remote, s.LocalPath())
 	if err != nil {
 		return NewRemoteError("Unable to get repository", err, string(out))
 	}
+	out, err = s.RunFromDir("svn", args...)
+	if err != nil {
+		return NewRemoteError("Unable to check out sparse paths", err, string(out))
+	}
 	return nil
 }
 
@@ -94,8 +139,8 @@ func (s *SvnRepo) Init() error {
 	if err != nil && s.isUnableToCreateDir(err) {
 
 		basePath := filepath.Dir(filepath.FromSlash(s.Remote()))
-		if _, err := os.Stat(basePath); os.IsNotExist(err) {
-			err = os.MkdirAll(basePath, 0755)
+		if _, err := s.fs().Stat(basePath); os.IsNotExist(err) {
+			err = s.fs().MkdirAll(basePath, 0755)
 			if err != nil {
 				return NewLocalError("Unable to initialize repository", err, "")
 			}
@@ -116,6 +161,12 @@ func (s *SvnRepo) Init() error {
 
 // Update performs an SVN update to an existing checkout.
 func (s *SvnRepo) Update() error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	out, err := s.RunFromDir("svn", "update")
 	if err != nil {
 		return NewRemoteError("Unable to update repository", err, string(out))
@@ -125,6 +176,12 @@ func (s *SvnRepo) Update() error {
 
 // UpdateVersion sets the version of a package currently checked out via SVN.
 func (s *SvnRepo) UpdateVersion(version string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	out, err := s.RunFromDir("svn", "update", "-r", version)
 	if err != nil {
 		return NewRemoteError("Unable to update checked out version", err, string(out))
@@ -141,9 +198,9 @@ func (s *SvnRepo) Version() (string, error) {
 		Commit Commit `xml:"entry>commit"`
 	}
 
-	out, err := s.RunFromDir("svn", "info", "--xml")
+	out, stderr, err := s.runSeparate("svn", "info", "--xml")
 	if err != nil {
-		return "", NewLocalError("Unable to retrieve checked out version", err, string(out))
+		return "", NewLocalError("Unable to retrieve checked out version", err, string(stderr))
 	}
 	s.log(out)
 	infos := &Info{}
@@ -155,6 +212,34 @@ func (s *SvnRepo) Version() (string, error) {
 	return infos.Commit.Revision, nil
 }
 
+// ShortVersion retrieves the current version. SVN revision numbers are
+// already short so this is the same as Version.
+func (s *SvnRepo) ShortVersion() (string, error) {
+	return s.Version()
+}
+
+// RootDir retrieves the top level directory of the working copy.
+func (s *SvnRepo) RootDir() (string, error) {
+	type Info struct {
+		Root string `xml:"entry>wc-info>wcroot-abspath"`
+	}
+
+	out, err := s.RunFromDir("svn", "info", "--xml")
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve root directory", err, string(out))
+	}
+	info := &Info{}
+	err = xml.Unmarshal(out, &info)
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve root directory", err, string(out))
+	}
+	if info.Root == "" {
+		return "", NewLocalError("Unable to retrieve root directory", nil, string(out))
+	}
+
+	return filepath.FromSlash(info.Root), nil
+}
+
 // Current returns the current version-ish. This means:
 // * HEAD if on the tip.
 // * Otherwise a revision id
@@ -176,15 +261,34 @@ func (s *SvnRepo) Current() (string, error) {
 	return curr, nil
 }
 
+// CurrentBranch retrieves the branch of the project that is checked out, going
+// by the layout of the project. It is the TrunkDir for the trunk and the name
+// of the branch for one in the BranchesDir. It is empty for a tag or a
+// checkout that does not follow the layout.
+func (s *SvnRepo) CurrentBranch() (string, error) {
+	u, root, err := s.info()
+	if err != nil {
+		return "", err
+	}
+
+	return svnBranchName(strings.TrimPrefix(u, root), s.TrunkDir, s.BranchesDir, s.TagsDir), nil
+}
+
+// DefaultBranch retrieves the default branch, which is the TrunkDir by
+// convention.
+func (s *SvnRepo) DefaultBranch() (string, error) {
+	return s.TrunkDir, nil
+}
+
 // Date retrieves the date on the latest commit.
 func (s *SvnRepo) Date() (time.Time, error) {
 	version, err := s.Version()
 	if err != nil {
 		return time.Time{}, NewLocalError("Unable to retrieve revision date", err, "")
 	}
-	out, err := s.RunFromDir("svn", "pget", "svn:date", "--revprop", "-r", version)
+	out, stderr, err := s.runSeparate("svn", "pget", "svn:date", "--revprop", "-r", version)
 	if err != nil {
-		return time.Time{}, NewLocalError("Unable to retrieve revision date", err, string(out))
+		return time.Time{}, NewLocalError("Unable to retrieve revision date", err, string(stderr))
 	}
 	const longForm = "2006-01-02T15:04:05.000000Z"
 	t, err := time.Parse(longForm, strings.TrimSpace(string(out)))
@@ -194,6 +298,33 @@ func (s *SvnRepo) Date() (time.Time, error) {
 	return t, nil
 }
 
+// RevisionAt retrieves the latest revision that changed the remote location at
+// or before the passed in time. It is looked up on the remote, rather than in
+// the working copy, so revisions that have not been updated to are included.
+func (s *SvnRepo) RevisionAt(t time.Time) (string, error) {
+	type LogEntry struct {
+		Revision string `xml:"revision,attr"`
+	}
+	type Log struct {
+		Entries []LogEntry `xml:"logentry"`
+	}
+
+	rev := "{" + t.UTC().Format("2006-01-02T15:04:05Z") + "}:1"
+	out, stderr, err := s.runSeparate("svn", "log", "--xml", "-l", "1", "-r", rev, s.Remote())
+	if err != nil {
+		return "", NewRemoteError("Unable to retrieve revision at "+t.String(), err, string(stderr))
+	}
+	logs := &Log{}
+	err = xml.Unmarshal(out, logs)
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve revision at "+t.String(), err, string(out))
+	}
+	if len(logs.Entries) == 0 {
+		return "", ErrRevisionUnavailable
+	}
+	return logs.Entries[0].Revision, nil
+}
+
 // CheckLocal verifies the local location is an SVN repo.
 func (s *SvnRepo) CheckLocal() bool {
 	pth, err := filepath.Abs(s.LocalPath())
@@ -202,14 +333,14 @@ func (s *SvnRepo) CheckLocal() bool {
 		return false
 	}
 
-	if _, err := os.Stat(filepath.Join(pth, ".svn")); err == nil {
+	if _, err := s.fs().Stat(filepath.Join(pth, ".svn")); err == nil {
 		return true
 	}
 
 	oldpth := pth
 	for oldpth != pth {
 		pth = filepath.Dir(pth)
-		if _, err := os.Stat(filepath.Join(pth, ".svn")); err == nil {
+		if _, err := s.fs().Stat(filepath.Join(pth, ".svn")); err == nil {
 			return true
 		}
 	}
@@ -217,24 +348,135 @@ func (s *SvnRepo) CheckLocal() bool {
 	return false
 }
 
-// Tags returns []string{} as there are no formal tags in SVN. Tags are a
-// convention in SVN. They are typically implemented as a copy of the trunk and
-// placed in the /tags/[tag name] directory. Since this is a convention the
-// expectation is to checkout a tag the correct subdirectory will be used
-// as the path. For more information see:
+// Tags returns the names of the tags of the project. There are no formal tags
+// in SVN. Tags are a convention in SVN. They are typically implemented as a
+// copy of the trunk and placed in the /tags/[tag name] directory. The tags are
+// the directories in the TagsDir of the project the checkout is part of. To
+// checkout a tag the correct subdirectory is used as the path. For more
+// information see:
 // http://svnbook.red-bean.com/en/1.7/svn.branchmerge.tags.html
 func (s *SvnRepo) Tags() ([]string, error) {
-	return []string{}, nil
+	return s.listConvention(s.TagsDir, "Unable to retrieve tags")
 }
 
-// Branches returns []string{} as there are no formal branches in SVN. Branches
-// are a convention. They are typically implemented as a copy of the trunk and
-// placed in the /branches/[tag name] directory. Since this is a convention the
-// expectation is to checkout a branch the correct subdirectory will be used
-// as the path. For more information see:
+// Branches returns the names of the branches of the project. There are no
+// formal branches in SVN. Branches are a convention. They are typically
+// implemented as a copy of the trunk and placed in the /branches/[branch name]
+// directory. The branches are the directories in the BranchesDir of the
+// project the checkout is part of. To checkout a branch the correct
+// subdirectory is used as the path. For more information see:
 // http://svnbook.red-bean.com/en/1.7/svn.branchmerge.using.html
 func (s *SvnRepo) Branches() ([]string, error) {
-	return []string{}, nil
+	return s.listConvention(s.BranchesDir, "Unable to retrieve branches")
+}
+
+// listConvention lists the directories in a directory, such as the branches or
+// tags directory, of the project the checkout is part of. When the project
+// does not have the directory the list is empty.
+func (s *SvnRepo) listConvention(dir, msg string) ([]string, error) {
+	base, err := s.projectURL()
+	if err != nil {
+		return []string{}, NewLocalError(msg, err, "")
+	}
+
+	out, err := s.RunFromDir("svn", "list", base+"/"+dir)
+	if err != nil {
+		// A missing path is reported as a warning or error with one of these
+		// codes depending on the version and the protocol.
+		o := string(out)
+		if strings.Contains(o, "W160013") || strings.Contains(o, "E160013") || strings.Contains(o, "E200009") {
+			return []string{}, nil
+		}
+		return []string{}, NewRemoteError(msg, err, o)
+	}
+
+	// Directories have a trailing slash while files, which are not branches
+	// or tags, do not.
+	names := []string{}
+	for _, l := range strings.Split(string(out), "\n") {
+		l = strings.TrimRight(l, "\r")
+		if strings.HasSuffix(l, "/") {
+			names = append(names, strings.TrimSuffix(l, "/"))
+		}
+	}
+
+	return names, nil
+}
+
+// projectURL returns the URL of the project the checkout is part of. This is
+// the parent of the trunk, branch, or tag that is checked out. When the URL of
+// the checkout does not follow the layout the repository root is used.
+func (s *SvnRepo) projectURL() (string, error) {
+	u, root, err := s.info()
+	if err != nil {
+		return "", err
+	}
+
+	return svnProjectURL(root, strings.TrimPrefix(u, root), s.TrunkDir, s.BranchesDir, s.TagsDir), nil
+}
+
+// info returns the URL of the checkout and the root of the repository it is
+// in, which the URL starts with.
+func (s *SvnRepo) info() (string, string, error) {
+	type Info struct {
+		URL  string `xml:"entry>url"`
+		Root string `xml:"entry>repository>root"`
+	}
+
+	out, err := s.RunFromDir("svn", "info", "--xml")
+	if err != nil {
+		return "", "", NewLocalError("Unable to retrieve repository information", err, string(out))
+	}
+	info := &Info{}
+	err = xml.Unmarshal(out, &info)
+	if err != nil {
+		return "", "", NewLocalError("Unable to retrieve repository information", err, string(out))
+	}
+	if info.Root == "" || !strings.HasPrefix(info.URL, info.Root) {
+		return "", "", NewLocalError("Unable to retrieve repository information", nil, string(out))
+	}
+
+	return info.URL, info.Root, nil
+}
+
+// svnProjectURL finds the project in the path of a checkout relative to the
+// repository root. The last trunk directory, or branches or tags directory
+// followed by a name, in the path marks where the project ends.
+func svnProjectURL(root, rel, trunk, branches, tags string) string {
+	parts := strings.Split(strings.Trim(rel, "/"), "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		end := -1
+		if parts[i] == trunk {
+			end = i
+		} else if i > 0 && (parts[i-1] == branches || parts[i-1] == tags) {
+			end = i - 1
+		}
+		if end == 0 {
+			return root
+		} else if end > 0 {
+			return root + "/" + strings.Join(parts[:end], "/")
+		}
+	}
+
+	return root
+}
+
+// svnBranchName finds the branch in the path of a checkout relative to the
+// repository root the same way svnProjectURL finds the project. It is empty
+// for a tag or a path that does not follow the layout.
+func svnBranchName(rel, trunk, branches, tags string) string {
+	parts := strings.Split(strings.Trim(rel, "/"), "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if parts[i] == trunk {
+			return trunk
+		} else if i > 0 && parts[i-1] == branches {
+			return parts[i]
+		} else if i > 0 && parts[i-1] == tags {
+			return ""
+		}
+	}
+
+	return ""
 }
 
 // IsReference returns if a string is a reference. A reference is a commit id.
@@ -255,10 +497,117 @@ func (s *SvnRepo) IsReference(r string) bool {
 	return false
 }
 
+// RefType returns the kind of reference a name is. As SVN branches and tags
+// are a convention, the names of the directories in the BranchesDir and
+// TagsDir of the project are branches and tags, which contacts the remote. A
+// revision number is a commit.
+func (s *SvnRepo) RefType(r string) RefType {
+	if r == "" || strings.HasPrefix(r, "-") {
+		return RefUnknown
+	}
+
+	if _, err := strconv.Atoi(strings.TrimPrefix(r, "r")); err == nil {
+		if s.IsReference(r) {
+			return RefCommit
+		}
+		return RefUnknown
+	}
+
+	branches, err := s.Branches()
+	if err != nil {
+		return RefUnknown
+	}
+	if containsString(branches, r) {
+		return RefBranch
+	}
+	tags, err := s.Tags()
+	if err != nil {
+		return RefUnknown
+	}
+	if containsString(tags, r) {
+		return RefTag
+	}
+	return RefUnknown
+}
+
+// Status retrieves a snapshot of the state of the checkout. SVN does not have
+// branches in the sense of the other VCS so the branch is always empty.
+func (s *SvnRepo) Status() (*RepoStatus, error) {
+	v, err := s.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	// Quiet mode leaves out files that are not under version control.
+	out, err := s.RunFromDir("svn", "status", "-q")
+	if err != nil {
+		return nil, NewLocalError("Unable to retrieve status", err, string(out))
+	}
+
+	return &RepoStatus{
+		Version: v,
+		Dirty:   strings.TrimSpace(string(out)) != "",
+	}, nil
+}
+
+// Pin records the remote and the checked out revision number. The ref is HEAD
+// when the checkout is on the latest revision.
+func (s *SvnRepo) Pin() (Pin, error) {
+	return pinRepo(s)
+}
+
+// Reset discards the changes to versioned files with svn revert and then
+// cleans up the working copy, such as releasing stale locks, when hard is
+// true. Svn does not have a staging area so nothing is done otherwise.
+func (s *SvnRepo) Reset(hard bool) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !hard {
+		return nil
+	}
+
+	out, err := s.RunFromDir("svn", "revert", "-R", ".")
+	if err != nil {
+		return NewLocalError("Unable to reset checkout", err, string(out))
+	}
+	out, err = s.RunFromDir("svn", "cleanup")
+	if err != nil {
+		return NewLocalError("Unable to reset checkout", err, string(out))
+	}
+
+	return nil
+}
+
+// Clean removes the unversioned files and directories with svn cleanup, which
+// needs Subversion 1.9 or newer.
+func (s *SvnRepo) Clean(ignored bool) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	args := []string{"cleanup", "--remove-unversioned"}
+	if ignored {
+		args = append(args, "--remove-ignored")
+	}
+
+	out, err := s.RunFromDir("svn", args...)
+	if err != nil {
+		return NewLocalError("Unable to clean checkout", err, string(out))
+	}
+
+	return nil
+}
+
 // IsDirty returns if the checkout has been modified from the checked
 // out reference.
 func (s *SvnRepo) IsDirty() bool {
-	out, err := s.RunFromDir("svn", "diff")
+	out, err := s.RunFromDir("svn", "status", "--quiet")
 	return err != nil || len(out) != 0
 }
 
@@ -297,10 +646,83 @@ func (s *SvnRepo) CommitInfo(id string) (*CommitInfo, error) {
 		return nil, NewRemoteError("Unable to retrieve commit information", err, string(out))
 	}
 
+	log, err := parseSvnLog(out)
+	if err != nil {
+		return nil, NewLocalError("Unable to retrieve commit information", err, string(out))
+	}
+	if len(log) == 0 {
+		return nil, ErrRevisionUnavailable
+	}
+
+	ci := &log[0]
+	ci.Commit = id
+	return ci, nil
+}
+
+// CommitLog retrieves metadata about the commits selected by the options,
+// the most recent first. Only revisions changing the working copy path are
+// included and, as SVN keeps the log on the server, the remote is contacted.
+func (s *SvnRepo) CommitLog(o LogOptions) ([]CommitInfo, error) {
+	to := o.To
+	if to == "" {
+		to = "BASE"
+	}
+
+	// A range includes both ends so From, resolved to a number when it is a
+	// keyword such as HEAD or a date, is dropped from it.
+	from, rev := o.From, to+":1"
+	if from != "" {
+		if _, err := strconv.Atoi(from); err != nil {
+			type Entry struct {
+				Revision string `xml:"revision,attr"`
+			}
+			type Info struct {
+				Entry Entry `xml:"entry"`
+			}
+			out, err := s.RunFromDir("svn", "info", "-r", from, "--xml")
+			if err != nil {
+				return []CommitInfo{}, NewRemoteError("Unable to retrieve commit log", err, string(out))
+			}
+			info := &Info{}
+			if err = xml.Unmarshal(out, &info); err != nil {
+				return []CommitInfo{}, NewLocalError("Unable to retrieve commit log", err, string(out))
+			}
+			from = info.Entry.Revision
+		}
+		rev = to + ":" + o.From
+	}
+	args := []string{"log", "--xml", "-r", rev}
+	if o.Limit > 0 {
+		// One more is needed in case it is From.
+		args = append(args, "-l", strconv.Itoa(o.Limit+1))
+	}
+
+	out, err := s.RunFromDir("svn", args...)
+	if err != nil {
+		return []CommitInfo{}, NewRemoteError("Unable to retrieve commit log", err, string(out))
+	}
+	log, err := parseSvnLog(out)
+	if err != nil {
+		return []CommitInfo{}, NewLocalError("Unable to parse commit log", err, string(out))
+	}
+
+	entries := []CommitInfo{}
+	for _, ci := range log {
+		if ci.Commit != from && (o.Limit <= 0 || len(entries) < o.Limit) {
+			entries = append(entries, ci)
+		}
+	}
+
+	return entries, nil
+}
+
+// parseSvnLog parses the output of svn log with the --xml flag.
+func parseSvnLog(out []byte) ([]CommitInfo, error) {
 	type Logentry struct {
-		Author string `xml:"author"`
-		Date   string `xml:"date"`
-		Msg    string `xml:"msg"`
+		Revision string `xml:"revision,attr"`
+		Author   string `xml:"author"`
+		Date     string `xml:"date"`
+		Msg      string `xml:"msg"`
 	}
 	type Log struct {
 		XMLName xml.Name   `xml:"log"`
@@ -308,28 +730,41 @@ func (s *SvnRepo) CommitInfo(id string) (*CommitInfo, error) {
 	}
 
 	logs := &Log{}
-	err = xml.Unmarshal(out, &logs)
+	err := xml.Unmarshal(out, &logs)
 	if err != nil {
-		return nil, NewLocalError("Unable to retrieve commit information", err, string(out))
-	}
-	if len(logs.Logs) == 0 {
-		return nil, ErrRevisionUnavailable
+		return nil, err
 	}
 
-	ci := &CommitInfo{
-		Commit:  id,
-		Author:  logs.Logs[0].Author,
-		Message: logs.Logs[0].Msg,
-	}
+	log := make([]CommitInfo, 0, len(logs.Logs))
+	for _, l := range logs.Logs {
+		ci := CommitInfo{
+			Commit:     l.Revision,
+			Author:     l.Author,
+			AuthorName: l.Author,
+			Message:    l.Msg,
+		}
 
-	if len(logs.Logs[0].Date) > 0 {
-		ci.Date, err = time.Parse(time.RFC3339Nano, logs.Logs[0].Date)
-		if err != nil {
-			return nil, NewLocalError("Unable to retrieve commit information", err, string(out))
+		if len(l.Date) > 0 {
+			ci.Date, err = time.Parse(time.RFC3339Nano, l.Date)
+			if err != nil {
+				return nil, err
+			}
 		}
+		log = append(log, ci)
 	}
 
-	return ci, nil
+	return log, nil
+}
+
+// CommitMessage retrieves the message of the revision the working copy is at.
+// SVN keeps log messages on the server so the remote is contacted.
+func (s *SvnRepo) CommitMessage() (string, error) {
+	ci, err := s.CommitInfo("BASE")
+	if err != nil {
+		return "", err
+	}
+
+	return ci.Message, nil
 }
 
 // TagsFromCommit retrieves tags from a commit id.
@@ -339,10 +774,74 @@ func (s *SvnRepo) TagsFromCommit(id string) ([]string, error) {
 	return []string{}, nil
 }
 
+// ListFiles returns the paths, relative to the root of the working copy, of
+// the files at the revision the working copy is at. SVN lists files from the
+// repository so the remote is contacted.
+func (s *SvnRepo) ListFiles() ([]string, error) {
+	out, err := s.RunFromDir("svn", "list", "-R", "-r", "BASE")
+	if err != nil {
+		return []string{}, NewRemoteError("Unable to list files", err, string(out))
+	}
+
+	// Directories are listed with a trailing slash.
+	files := []string{}
+	for _, p := range strings.Split(string(out), "\n") {
+		p = strings.TrimRight(p, "\r")
+		if p != "" && !strings.HasSuffix(p, "/") {
+			files = append(files, filepath.FromSlash(p))
+		}
+	}
+
+	return files, nil
+}
+
+// Diff returns the changes between two revisions as a unified diff.
+func (s *SvnRepo) Diff(from, to string) (string, error) {
+	out, stderr, err := s.runSeparate("svn", "diff", "-r", from+":"+to)
+	if err != nil {
+		return "", NewLocalError("Unable to retrieve diff", err, string(stderr))
+	}
+
+	return string(out), nil
+}
+
+// ChangedFiles returns the paths of the files that differ between two
+// revisions. SVN does not track renames so a renamed file is reported as both
+// the deleted old path and the added new path.
+func (s *SvnRepo) ChangedFiles(from, to string) ([]string, error) {
+	out, err := s.RunFromDir("svn", "diff", "--summarize", "-r", from+":"+to)
+	if err != nil {
+		return []string{}, NewLocalError("Unable to retrieve changed files", err, string(out))
+	}
+
+	// Each line starts with columns for the item and property status followed
+	// by the path.
+	files := []string{}
+	for _, l := range strings.Split(string(out), "\n") {
+		if len(l) < 3 {
+			continue
+		}
+		if p := strings.TrimSpace(l[2:]); p != "" {
+			files = append(files, filepath.FromSlash(p))
+		}
+	}
+
+	return files, nil
+}
+
 // Ping returns if remote location is accessible.
 func (s *SvnRepo) Ping() bool {
-	_, err := s.run("svn", "--non-interactive", "info", s.Remote())
-	return err == nil
+	return s.CheckRemote() == nil
+}
+
+// CheckRemote checks the remote location is accessible with svn info.
+func (s *SvnRepo) CheckRemote() error {
+	out, err := s.run("svn", "--non-interactive", "info", s.Remote())
+	if err != nil {
+		return NewRemoteError("Unable to reach remote", err, string(out))
+	}
+
+	return nil
 }
 
 // ExportDir exports the current revision to the passed in directory.
@@ -357,6 +856,17 @@ func (s *SvnRepo) ExportDir(dir string) error {
 	return nil
 }
 
+// ExportVersion exports the passed in version to the passed in directory.
+func (s *SvnRepo) ExportVersion(dir, version string) error {
+	out, err := s.RunFromDir("svn", "export", "-r", version, ".", dir)
+	s.log(out)
+	if err != nil {
+		return NewLocalError("Unable to export "+version, err, string(out))
+	}
+
+	return nil
+}
+
 // isUnableToCreateDir checks for an error in Init() to see if an error
 // where the parent directory of the VCS local path doesn't exist.
 func (s *SvnRepo) isUnableToCreateDir(err error) bool {
@@ -41,7 +41,7 @@ func TestSvn(t *testing.T) {
 	if repo.Remote() != "https://github.com/Masterminds/VCSTestRepo/trunk" {
 		t.Error("Remote not set properly")
 	}
-	if repo.LocalPath() != tempDir+string(os.PathSeparator)+"VCSTestRepo" {
+	if repo.OriginalLocalPath() != tempDir+string(os.PathSeparator)+"VCSTestRepo" {
 		t.Error("Local disk location not set properly")
 	}
 
@@ -143,12 +143,14 @@ func TestSvn(t *testing.T) {
 		t.Error(err)
 	}
 
+	// Tags and branches are the directories in the tags and branches
+	// directories next to the trunk that is checked out.
 	tags, err := repo.Tags()
 	if err != nil {
 		t.Error(err)
 	}
-	if len(tags) != 0 {
-		t.Error("Svn is incorrectly returning tags")
+	if !containsString(tags, "1.0.0") {
+		t.Errorf("Svn is incorrectly returning tags. Got %v", tags)
 	}
 
 	tags, err = repo.TagsFromCommit("2")
@@ -163,8 +165,8 @@ func TestSvn(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if len(branches) != 0 {
-		t.Error("Svn is incorrectly returning branches")
+	if !containsString(branches, "test") {
+		t.Errorf("Svn is incorrectly returning branches. Got %v", branches)
 	}
 
 	if !repo.IsReference("r4") {
@@ -335,3 +337,58 @@ func TestSvnInit(t *testing.T) {
 		t.Errorf("Svn Init returns wrong version: %s", v)
 	}
 }
+
+func TestSvnProjectURL(t *testing.T) {
+	root := "https://example.com/svn"
+	tests := []struct {
+		rel, expected string
+	}{
+		{"/trunk", root},
+		{"/trunk/sub/dir", root},
+		{"/branches/feature", root},
+		{"/tags/1.0.0/sub", root},
+		{"/project/trunk", root + "/project"},
+		{"/group/project/branches/feature", root + "/group/project"},
+		{"/project/tags/1.0.0", root + "/project"},
+		{"/project/trunk/tags/docs", root + "/project/trunk"},
+		{"", root},
+		{"/unconventional/layout", root},
+		{"/branches", root},
+	}
+	for _, tc := range tests {
+		if u := svnProjectURL(root, tc.rel, "trunk", "branches", "tags"); u != tc.expected {
+			t.Errorf("svnProjectURL for %q returned %q, expected %q", tc.rel, u, tc.expected)
+		}
+	}
+
+	if u := svnProjectURL(root, "/project/main/src", "main", "dev", "releases"); u != root+"/project" {
+		t.Errorf("svnProjectURL did not honor a custom layout. Got %q", u)
+	}
+	if u := svnProjectURL(root, "/project/releases/2.0", "main", "dev", "releases"); u != root+"/project" {
+		t.Errorf("svnProjectURL did not honor a custom layout. Got %q", u)
+	}
+}
+
+func TestSvnBranchName(t *testing.T) {
+	tests := []struct {
+		rel, expected string
+	}{
+		{"/trunk", "trunk"},
+		{"/trunk/sub/dir", "trunk"},
+		{"/project/branches/feature", "feature"},
+		{"/project/branches/feature/sub", "feature"},
+		{"/project/tags/1.0.0", ""},
+		{"/unconventional/layout", ""},
+		{"/branches", ""},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if b := svnBranchName(tc.rel, "trunk", "branches", "tags"); b != tc.expected {
+			t.Errorf("svnBranchName for %q returned %q, expected %q", tc.rel, b, tc.expected)
+		}
+	}
+
+	if b := svnBranchName("/project/main/src", "main", "dev", "releases"); b != "main" {
+		t.Errorf("svnBranchName did not honor a custom layout. Got %q", b)
+	}
+}
@@ -0,0 +1,352 @@
+package vcs
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TagsFromCurrent returns the tags pointing at the checked out revision of a
+// repo. An empty list is returned when there are none.
+func TagsFromCurrent(r Repo) ([]string, error) {
+	v, err := r.Version()
+	if err != nil {
+		return []string{}, err
+	}
+
+	return r.TagsFromCommit(v)
+}
+
+// LatestTagMatching returns the tag of a repo that is the newest semantic
+// version satisfying a constraint. Tags are parsed as semantic versions, with
+// an optional leading v, and tags that are not versions are skipped.
+//
+// A constraint is one or more comparisons separated by commas or spaces, all
+// of which must hold, and alternatives of those separated by ||. For example,
+// ">= 1.2, < 2" or "1.4.x || ^2.1". The comparisons are =, !=, >, >=, <, and
+// <=, along with ~1.2.3, which allows patch releases, and ^1.2.3, which allows
+// releases that do not change the left-most non-zero number. A version with x
+// or * in place of numbers, or with numbers left off, matches any number in
+// their place, except with != where it is not supported. Pre-releases only
+// match comparisons that have a pre-release.
+//
+// ErrNoMatchingTag is returned when no tag satisfies the constraint.
+func LatestTagMatching(r Repo, constraint string) (string, error) {
+	c, err := parseConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+
+	tags, err := r.Tags()
+	if err != nil {
+		return "", err
+	}
+
+	var latest string
+	var lv semver
+	for _, t := range tags {
+		v, ok := parseSemver(t)
+		if !ok || !c.match(v) {
+			continue
+		}
+		if latest == "" || v.compare(lv) > 0 {
+			latest, lv = t, v
+		}
+	}
+
+	if latest == "" {
+		return "", ErrNoMatchingTag
+	}
+	return latest, nil
+}
+
+// semver is a parsed semantic version. Build metadata is dropped as it does
+// not take part in ordering.
+type semver struct {
+	major, minor, patch int64
+	pre                 string
+}
+
+var semverRegex = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// parseSemver parses a semantic version. Missing minor and patch numbers are
+// taken to be 0.
+func parseSemver(s string) (semver, bool) {
+	m := semverRegex.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return semver{}, false
+	}
+
+	var v semver
+	var err error
+	if v.major, err = strconv.ParseInt(m[1], 10, 64); err != nil {
+		return semver{}, false
+	}
+	if m[2] != "" {
+		if v.minor, err = strconv.ParseInt(m[2], 10, 64); err != nil {
+			return semver{}, false
+		}
+	}
+	if m[3] != "" {
+		if v.patch, err = strconv.ParseInt(m[3], 10, 64); err != nil {
+			return semver{}, false
+		}
+	}
+	v.pre = m[4]
+
+	return v, true
+}
+
+// compare returns -1, 0, or 1 when v is less than, equal to, or greater than o
+// following the semantic versioning precedence rules.
+func (v semver) compare(o semver) int {
+	for _, d := range []int64{v.major - o.major, v.minor - o.minor, v.patch - o.patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+
+	// A pre-release comes before the release itself.
+	switch {
+	case v.pre == o.pre:
+		return 0
+	case v.pre == "":
+		return 1
+	case o.pre == "":
+		return -1
+	}
+
+	vs := strings.Split(v.pre, ".")
+	ps := strings.Split(o.pre, ".")
+	for i := 0; i < len(vs) && i < len(ps); i++ {
+		if c := comparePrerelease(vs[i], ps[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(vs) < len(ps):
+		return -1
+	case len(vs) > len(ps):
+		return 1
+	}
+	return 0
+}
+
+// comparePrerelease compares pre-release identifiers. Numeric identifiers are
+// compared as numbers and come before alphanumeric ones.
+func comparePrerelease(a, b string) int {
+	x, aerr := strconv.ParseInt(a, 10, 64)
+	y, berr := strconv.ParseInt(b, 10, 64)
+	switch {
+	case aerr == nil && berr == nil:
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+		return 0
+	case aerr == nil:
+		return -1
+	case berr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// comparison is a single comparison against a version, such as >= 1.2.0.
+type comparison struct {
+	op string
+	v  semver
+}
+
+// constraint holds alternatives of comparisons that must all hold.
+type constraint [][]comparison
+
+var comparisonRegex = regexp.MustCompile(`^(=|!=|>=|<=|>|<|~|\^)?v?(\d+|[xX*])(?:\.(\d+|[xX*]))?(?:\.(\d+|[xX*]))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// parseConstraint parses a constraint in the syntax of LatestTagMatching.
+func parseConstraint(s string) (constraint, error) {
+	var c constraint
+	for _, alt := range strings.Split(s, "||") {
+		// Operators may be separated from their versions by spaces, which
+		// otherwise separate comparisons like commas do.
+		var words []string
+		for _, w := range strings.Fields(strings.Replace(alt, ",", " ", -1)) {
+			if n := len(words); n > 0 && strings.Trim(words[n-1], "=!<>~^") == "" {
+				words[n-1] += w
+			} else {
+				words = append(words, w)
+			}
+		}
+		if len(words) == 0 {
+			return nil, NewLocalError("Invalid version constraint", nil, s)
+		}
+
+		var group []comparison
+		for _, w := range words {
+			cs, ok := parseComparison(w)
+			if !ok {
+				return nil, NewLocalError("Invalid version constraint", nil, s)
+			}
+			group = append(group, cs...)
+		}
+		c = append(c, group)
+	}
+
+	return c, nil
+}
+
+// parseComparison parses a single comparison into the comparisons against
+// full versions it is equivalent to. Wildcards, missing numbers, and the ~ and
+// ^ operators become a lower and an upper bound.
+func parseComparison(s string) ([]comparison, bool) {
+	m := comparisonRegex.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+
+	// The numbers that were given, up to the first wildcard or missing one.
+	var nums []int64
+	for _, p := range m[2:5] {
+		if p == "" || p == "x" || p == "X" || p == "*" {
+			break
+		}
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		nums = append(nums, n)
+	}
+	if m[5] != "" && len(nums) < 3 {
+		// A pre-release is only meaningful on a full version.
+		return nil, false
+	}
+
+	var v semver
+	for i, n := range nums {
+		switch i {
+		case 0:
+			v.major = n
+		case 1:
+			v.minor = n
+		case 2:
+			v.patch = n
+		}
+	}
+	v.pre = m[5]
+
+	// next returns the version after the range of versions that share the
+	// first n numbers with v.
+	next := func(n int) semver {
+		switch n {
+		case 1:
+			return semver{major: v.major + 1}
+		case 2:
+			return semver{major: v.major, minor: v.minor + 1}
+		}
+		return semver{major: v.major, minor: v.minor, patch: v.patch + 1}
+	}
+
+	op := m[1]
+	switch op {
+	case "", "=":
+		if len(nums) == 0 {
+			return []comparison{}, true
+		}
+		if len(nums) == 3 {
+			return []comparison{{"=", v}}, true
+		}
+		return []comparison{{">=", v}, {"<", next(len(nums))}}, true
+	case "~":
+		if len(nums) == 0 {
+			return []comparison{}, true
+		}
+		n := len(nums)
+		if n > 2 {
+			n = 2
+		}
+		return []comparison{{">=", v}, {"<", next(n)}}, true
+	case "^":
+		if len(nums) == 0 {
+			return []comparison{}, true
+		}
+		// The first number that is not zero, or the last one given, is the
+		// one that may not change.
+		n := 1
+		for n < len(nums) && nums[n-1] == 0 {
+			n++
+		}
+		return []comparison{{">=", v}, {"<", next(n)}}, true
+	}
+
+	if len(nums) == 0 {
+		// Every version is greater than or equal to, or less than or equal
+		// to, a wildcard and none is otherwise.
+		if op == ">=" || op == "<=" {
+			return []comparison{}, true
+		}
+		return []comparison{{"<", semver{}}, {">", semver{}}}, true
+	}
+	if len(nums) < 3 {
+		// Comparisons apply to every version in the range the numbers left
+		// off stand for.
+		switch op {
+		case ">":
+			return []comparison{{">=", next(len(nums))}}, true
+		case "<=":
+			return []comparison{{"<", next(len(nums))}}, true
+		case "!=":
+			// Being outside of a range needs alternatives within the group,
+			// which are not supported.
+			return nil, false
+		}
+	}
+	return []comparison{{op, v}}, true
+}
+
+// match returns if a version satisfies any of the alternatives. A
+// pre-release only satisfies an alternative with a comparison that has a
+// pre-release.
+func (c constraint) match(v semver) bool {
+	for _, group := range c {
+		ok := true
+		pre := v.pre == ""
+		for _, cmp := range group {
+			if cmp.v.pre != "" {
+				pre = true
+			}
+			if !cmp.match(v) {
+				ok = false
+				break
+			}
+		}
+		if ok && pre {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c comparison) match(v semver) bool {
+	r := v.compare(c.v)
+	switch c.op {
+	case "=":
+		return r == 0
+	case "!=":
+		return r != 0
+	case ">":
+		return r > 0
+	case ">=":
+		return r >= 0
+	case "<":
+		return r < 0
+	case "<=":
+		return r <= 0
+	}
+	return false
+}
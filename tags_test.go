@@ -0,0 +1,142 @@
+package vcs
+
+import "testing"
+
+func TestSemverCompare(t *testing.T) {
+	// In increasing order, from the semantic versioning specification.
+	versions := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "v1.0.1",
+		"1.1", "1.10.0", "2.0.0+build.5",
+	}
+	for i := range versions {
+		for j := range versions {
+			a, ok := parseSemver(versions[i])
+			if !ok {
+				t.Fatalf("Unable to parse %s", versions[i])
+			}
+			b, _ := parseSemver(versions[j])
+
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			if c := a.compare(b); c != expected {
+				t.Errorf("Comparing %s to %s returned %d, expected %d", versions[i], versions[j], c, expected)
+			}
+		}
+	}
+
+	for _, s := range []string{"", "release-1", "1.2.3.4", "v", "1.2.3-"} {
+		if _, ok := parseSemver(s); ok {
+			t.Errorf("%q parsed as a semantic version", s)
+		}
+	}
+}
+
+func TestConstraintMatch(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		noMatch    []string
+	}{
+		{"1.2.3", []string{"1.2.3", "v1.2.3"}, []string{"1.2.4", "1.2.3-rc.1"}},
+		{">= 1.2, < 2", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0", "2.0.0-rc.1"}},
+		{">=1.2,<2", []string{"1.2.0"}, []string{"2.0.0"}},
+		{"1.4.x || ^2.1", []string{"1.4.0", "1.4.9", "2.1.0", "2.9.0"}, []string{"1.5.0", "2.0.9", "3.0.0"}},
+		{"1.x", []string{"1.0.0", "1.99.1"}, []string{"0.9.0", "2.0.0"}},
+		{"*", []string{"0.0.1", "9.0.0"}, []string{"1.0.0-beta"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.2.2", "1.3.0"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"> 1.2", []string{"1.3.0"}, []string{"1.2.9"}},
+		{"<= 1.2", []string{"1.2.9", "1.0.0"}, []string{"1.3.0"}},
+		{"!= 1.2.3", []string{"1.2.4"}, []string{"1.2.3"}},
+		{">= 1.0.0-beta", []string{"1.0.0-beta.2", "1.0.0", "1.1.0"}, []string{"1.0.0-alpha"}},
+	}
+
+	for _, tt := range tests {
+		c, err := parseConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("Unable to parse %q. Err was %s", tt.constraint, err)
+			continue
+		}
+		for _, s := range tt.match {
+			v, _ := parseSemver(s)
+			if !c.match(v) {
+				t.Errorf("%s did not match %q", s, tt.constraint)
+			}
+		}
+		for _, s := range tt.noMatch {
+			v, _ := parseSemver(s)
+			if c.match(v) {
+				t.Errorf("%s matched %q", s, tt.constraint)
+			}
+		}
+	}
+
+	for _, s := range []string{"", "foo", ">=", "1.2 ||", "1.2-beta", "!= 1.2"} {
+		if _, err := parseConstraint(s); err == nil {
+			t.Errorf("Invalid constraint %q was parsed", s)
+		}
+	}
+}
+
+func TestLatestTagMatching(t *testing.T) {
+	repo, cleanup := newLocalGitRepo(t)
+	defer cleanup()
+
+	for _, tag := range []string{"v1.0.0", "v1.2.0", "v1.10.0", "v2.0.0-rc.1", "release", "1.3"} {
+		out, err := repo.RunFromDir("git", "tag", tag)
+		if err != nil {
+			t.Fatalf("%s: %s", err, out)
+		}
+	}
+
+	tags, err := TagsFromCurrent(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 6 {
+		t.Errorf("TagsFromCurrent returned the wrong tags. Got %v", tags)
+	}
+
+	writeLocalFile(t, repo, "README.md", "Changed\n")
+	commitLocalGitRepo(t, repo, "Untagged commit")
+	tags, err = TagsFromCurrent(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 0 {
+		t.Errorf("TagsFromCurrent returned tags for an untagged commit. Got %v", tags)
+	}
+
+	tests := map[string]string{
+		"^1":                  "v1.10.0",
+		"~1.2":                "v1.2.0",
+		"< 1.5":               "1.3",
+		">= 2.0.0-alpha":      "v2.0.0-rc.1",
+		"1.0.x || 1.2.x":      "v1.2.0",
+		">= 1.0.0, != 1.10.0": "1.3",
+	}
+	for c, expected := range tests {
+		tag, err := LatestTagMatching(repo, c)
+		if err != nil {
+			t.Errorf("LatestTagMatching %q failed. Err was %s", c, err)
+		}
+		if tag != expected {
+			t.Errorf("LatestTagMatching %q returned %q, expected %q", c, tag, expected)
+		}
+	}
+
+	if _, err = LatestTagMatching(repo, "^3"); err != ErrNoMatchingTag {
+		t.Errorf("LatestTagMatching without a matching tag returned %v", err)
+	}
+	if _, err = LatestTagMatching(repo, "not a constraint"); err == nil {
+		t.Error("LatestTagMatching accepted an invalid constraint")
+	}
+}
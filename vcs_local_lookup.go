@@ -19,7 +19,7 @@ func DetectVcsFromFS(vcsPath string) (Type, error) {
 	// When the local directory to the package doesn't exist
 	// it's not yet downloaded so we can't detect the type
 	// locally.
-	if _, err := os.Stat(vcsPath); os.IsNotExist(err) {
+	if _, err := FS.Stat(vcsPath); os.IsNotExist(err) {
 		return "", ErrCannotDetectVCS
 	}
 
@@ -27,18 +27,37 @@ func DetectVcsFromFS(vcsPath string) (Type, error) {
 
 	// Walk through each of the different VCS types to see if
 	// one can be detected. Do this is order of guessed popularity.
-	if _, err := os.Stat(vcsPath + separator + ".git"); err == nil {
+	if _, err := FS.Stat(vcsPath + separator + ".git"); err == nil {
 		return Git, nil
 	}
-	if _, err := os.Stat(vcsPath + separator + ".svn"); err == nil {
+	if _, err := FS.Stat(vcsPath + separator + ".svn"); err == nil {
 		return Svn, nil
 	}
-	if _, err := os.Stat(vcsPath + separator + ".hg"); err == nil {
+	if _, err := FS.Stat(vcsPath + separator + ".hg"); err == nil {
 		return Hg, nil
 	}
-	if _, err := os.Stat(vcsPath + separator + ".bzr"); err == nil {
+	if _, err := FS.Stat(vcsPath + separator + ".bzr"); err == nil {
 		return Bzr, nil
 	}
+	// Fossil names the checkout database _FOSSIL_ on Windows and in older
+	// checkouts.
+	if _, err := FS.Stat(vcsPath + separator + ".fslckout"); err == nil {
+		return Fossil, nil
+	}
+	if _, err := FS.Stat(vcsPath + separator + "_FOSSIL_"); err == nil {
+		return Fossil, nil
+	}
+	// A Perforce workspace has the P4CONFIG file naming its server and client
+	// at its root.
+	if _, err := FS.Stat(vcsPath + separator + p4ConfigName()); err == nil {
+		return P4, nil
+	}
+
+	// A bare Git repository, such as a mirror, is a Git directory without a
+	// checkout.
+	if isBareGitDir(FS, vcsPath) {
+		return Git, nil
+	}
 
 	// If one was not already detected than we default to not finding it.
 	return "", ErrCannotDetectVCS
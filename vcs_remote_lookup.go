@@ -6,13 +6,18 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"path"
+	"path/filepath"
 	"regexp"
 	"strings"
 )
 
 type vcsInfo struct {
+	// The host the remote is on. A host starting with a dot, such as
+	// .googlesource.com, matches any host ending with it.
 	host     string
 	pattern  string
 	vcs      Type
@@ -55,16 +60,41 @@ var vcsList = []*vcsInfo{
 		vcs:     Git,
 		pattern: `^(go\.googlesource\.com/[A-Za-z0-9_.\-]+/?)$`,
 	},
+	{
+		host:    ".googlesource.com",
+		vcs:     Git,
+		pattern: `^([a-z0-9\-]+\.googlesource\.com/[A-Za-z0-9_.\-]+(/[A-Za-z0-9_.\-]+)*/?)$`,
+	},
 	{
 		host:    "git.openstack.org",
 		vcs:     Git,
 		pattern: `^(git\.openstack\.org/[A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+)$`,
 	},
+	{
+		host:    "gitlab.com",
+		vcs:     Git,
+		pattern: `^(gitlab\.com[/|:][A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+)(/[A-Za-z0-9_.\-]+)*$`,
+	},
+	{
+		host:    "git.sr.ht",
+		vcs:     Git,
+		pattern: `^(git\.sr\.ht/~[A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+)(/[A-Za-z0-9_.\-]+)*$`,
+	},
+	{
+		host:    "hg.sr.ht",
+		vcs:     Hg,
+		pattern: `^(hg\.sr\.ht/~[A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+)(/[A-Za-z0-9_.\-]+)*$`,
+	},
+	{
+		host:    "chiselapp.com",
+		vcs:     Fossil,
+		pattern: `^(chiselapp\.com/user/[A-Za-z0-9_.\-]+/repository/[A-Za-z0-9_.\-]+)(/[A-Za-z0-9_.\-]+)*$`,
+	},
 	// If none of the previous detect the type they will fall to this looking for the type in a generic sense
 	// by the extension to the path.
 	{
 		addCheck: checkURL,
-		pattern:  `\.(?P<type>git|hg|svn|bzr)$`,
+		pattern:  `\.(?P<type>git|hg|svn|bzr|fossil)$`,
 	},
 }
 
@@ -101,7 +131,7 @@ func detectVcsFromRemote(vcsURL string) (Type, string, error) {
 	if u.RawQuery == "" {
 		u.RawQuery = "go-get=1"
 	} else {
-		u.RawQuery = u.RawQuery + "+go-get=1"
+		u.RawQuery = u.RawQuery + "&go-get=1"
 	}
 	checkURL := u.String()
 	resp, err := http.Get(checkURL)
@@ -171,18 +201,25 @@ func detectVcsFromURL(vcsURL string) (Type, error) {
 		return Bzr, nil
 	case "svn+ssh":
 		return Svn, nil
+	case "p4", "p4+ssl":
+		return P4, nil
 	}
 
-	// Try to detect from known hosts, such as Github
+	// Try to detect from known hosts, such as Github. A port, such as in
+	// ssh://git@gitlab.com:22/foo/bar, does not change the host.
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
 	for _, v := range vcsList {
-		if v.host != "" && v.host != u.Host {
+		if v.host != "" && v.host != host && !(strings.HasPrefix(v.host, ".") && strings.HasSuffix(host, v.host)) {
 			continue
 		}
 
 		// Make sure the pattern matches for an actual repo location. For example,
 		// we should fail if the VCS listed is github.com/masterminds as that's
 		// not actually a repo.
-		uCheck := u.Host + u.Path
+		uCheck := host + u.Path
 		m := v.regex.FindStringSubmatch(uCheck)
 		if m == nil {
 			if v.host != "" {
@@ -232,6 +269,187 @@ func detectVcsFromURL(vcsURL string) (Type, error) {
 	return "", ErrCannotDetectVCS
 }
 
+// SameRemote returns if two repos are of the same VCS and use the same remote.
+// The remotes are compared in a normalized form so equivalent locations are
+// considered the same. For example, https://github.com/Masterminds/vcs,
+// https://github.com/Masterminds/vcs.git, and git@github.com:Masterminds/vcs.git
+// all refer to the same remote. The transport, user, and default ports are not
+// part of the comparison and host names are case insensitive.
+func SameRemote(a, b Repo) (bool, error) {
+	if a.Vcs() != b.Vcs() {
+		return false, nil
+	}
+
+	ka, err := remoteKey(a.Remote())
+	if err != nil {
+		return false, err
+	}
+	kb, err := remoteKey(b.Remote())
+	if err != nil {
+		return false, err
+	}
+
+	return ka == kb, nil
+}
+
+// defaultPorts are the ports for the transports VCS use that can be dropped
+// when comparing remotes.
+var defaultPorts = map[string]bool{
+	"22":   true,
+	"80":   true,
+	"443":  true,
+	"9418": true,
+}
+
+// remoteKey returns a normalized form of a remote location for comparison.
+func remoteKey(remote string) (string, error) {
+	if remote == "" {
+		return "", NewLocalError("Unable to compare an empty remote", nil, "")
+	}
+
+	// Paths on the local file system, including Windows paths which would
+	// otherwise be parsed as having the drive letter as the scheme.
+	if filepath.VolumeName(remote) != "" || filepath.IsAbs(remote) {
+		return localRemoteKey(remote), nil
+	}
+
+	var u *url.URL
+	var err error
+	if m := scpSyntaxRe.FindStringSubmatch(remote); m != nil {
+		u = &url.URL{
+			Host: m[2],
+			Path: "/" + m[3],
+		}
+	} else {
+		u, err = url.Parse(remote)
+		if err != nil {
+			return "", NewLocalError("Unable to parse remote", err, "")
+		}
+	}
+
+	if u.Scheme == "file" || u.Host == "" {
+		return localRemoteKey(filepath.FromSlash(u.Path)), nil
+	}
+
+	host := strings.ToLower(u.Host)
+	if h, p, err := net.SplitHostPort(host); err == nil && defaultPorts[p] {
+		host = h
+	}
+	p := strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+
+	return host + strings.TrimSuffix(p, "/"), nil
+}
+
+func localRemoteKey(p string) string {
+	return "file:" + strings.TrimSuffix(filepath.Clean(p), ".git")
+}
+
+// schemePorts maps the URL schemes of the transports VCS use to their default
+// ports. Schemes without a default port map to an empty string.
+var schemePorts = map[string]string{
+	"http":    "80",
+	"https":   "443",
+	"ssh":     "22",
+	"git":     "9418",
+	"git+ssh": "22",
+	"bzr":     "4155",
+	"bzr+ssh": "22",
+	"svn":     "3690",
+	"svn+ssh": "22",
+	"p4":      "1666",
+	"p4+ssl":  "1666",
+	"file":    "",
+}
+
+// NormalizeRemote validates a remote location and returns it in a canonical
+// form suitable for constructing repos and comparing locations. SCP-like
+// addresses, such as git@github.com:Masterminds/vcs.git, are expanded to ssh
+// URLs. The path of an SCP-like address is relative to the home directory of
+// the user so it is kept relative by rooting it, becoming /Masterminds/vcs
+// which is the form hosting services use, while an absolute path is kept as
+// is. The scheme must be one of the supported transports. Schemes and hosts
+// are lowercased and default ports, trailing slashes, and a trailing .git are
+// removed. Local paths are cleaned but otherwise left as they are, including
+// any .git suffix which is part of the directory name.
+func NormalizeRemote(remote string) (string, error) {
+	invalid := func(reason string) (string, error) {
+		return "", NewLocalError(fmt.Sprintf("Invalid remote %q: %s", remote, reason), nil, "")
+	}
+
+	remote = strings.TrimSpace(remote)
+	if remote == "" {
+		return invalid("it is empty")
+	}
+	if strings.HasPrefix(remote, "-") {
+		return invalid("it cannot start with -")
+	}
+	for _, r := range remote {
+		if r < 0x20 || r == 0x7f {
+			return invalid("it contains control characters")
+		}
+	}
+
+	if filepath.VolumeName(remote) != "" || filepath.IsAbs(remote) {
+		return filepath.Clean(remote), nil
+	}
+
+	var u *url.URL
+	var err error
+	if m := scpSyntaxRe.FindStringSubmatch(remote); m != nil {
+		if m[3] == "" {
+			return invalid("it has no path")
+		}
+		p := m[3]
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		u = &url.URL{
+			Scheme: "ssh",
+			User:   url.User(m[1]),
+			Host:   m[2],
+			Path:   p,
+		}
+	} else {
+		u, err = url.Parse(remote)
+		if err != nil {
+			return invalid(err.Error())
+		}
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme == "" {
+		return invalid("it has no scheme")
+	}
+	port, ok := schemePorts[u.Scheme]
+	if !ok {
+		return invalid("the scheme " + u.Scheme + " is not supported")
+	}
+
+	if u.Scheme == "file" {
+		if u.Path == "" {
+			return invalid("it has no path")
+		}
+		u.Path = path.Clean(u.Path)
+		return u.String(), nil
+	}
+
+	if u.Host == "" {
+		return invalid("it has no host")
+	}
+	u.Host = strings.ToLower(u.Host)
+	if h, p, err := net.SplitHostPort(u.Host); err == nil && p == port {
+		u.Host = h
+	}
+	u.Path = strings.TrimSuffix(strings.TrimRight(u.Path, "/"), ".git")
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	if u.Path == "" {
+		return invalid("it has no path")
+	}
+
+	return u.String(), nil
+}
+
 // Figure out the type for Bitbucket by the passed in information
 // or via the public API.
 func checkBitbucket(i map[string]string, ul *url.URL) (Type, error) {
@@ -346,6 +564,8 @@ func parseImportFromBody(ur *url.URL, r io.ReadCloser) (tp Type, u string, err e
 					tp = Bzr
 				case Hg:
 					tp = Hg
+				case Fossil:
+					tp = Fossil
 				}
 
 				u = f[2]
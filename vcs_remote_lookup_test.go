@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
@@ -32,9 +33,24 @@ func TestVCSLookup(t *testing.T) {
 		"https://example.com/foo/bar.svn":                                  {work: true, t: Svn},
 		"https://example.com/foo/bar/baz.bzr":                              {work: true, t: Bzr},
 		"https://example.com/foo/bar/baz.hg":                               {work: true, t: Hg},
+		"https://example.com/foo/bar/baz.fossil":                           {work: true, t: Fossil},
+		"https://chiselapp.com/user/foo/repository/bar":                    {work: true, t: Fossil},
+		"https://chiselapp.com/user/foo":                                   {work: false, t: Fossil},
+		"p4://perforce.example.com:1666/depot/project":                     {work: true, t: P4},
+		"p4+ssl://perforce.example.com/depot/project":                      {work: true, t: P4},
 		"https://gopkg.in/tomb.v1":                                         {work: true, t: Git},
 		"https://golang.org/x/net":                                         {work: true, t: Git},
 		"https://git.openstack.org/foo/bar":                                {work: true, t: Git},
+		"https://gitlab.com/foo/bar":                                       {work: true, t: Git},
+		"https://gitlab.com/group/subgroup/project":                        {work: true, t: Git},
+		"git@gitlab.com:foo/bar.git":                                       {work: true, t: Git},
+		"ssh://git@gitlab.com:22/foo/bar":                                  {work: true, t: Git},
+		"https://gitlab.com/foo":                                           {work: false, t: Git},
+		"https://git.sr.ht/~foo/bar":                                       {work: true, t: Git},
+		"https://hg.sr.ht/~foo/bar":                                        {work: true, t: Hg},
+		"https://git.sr.ht/foo/bar":                                        {work: false, t: Git},
+		"https://chromium.googlesource.com/chromium/src":                   {work: true, t: Git},
+		"https://go.googlesource.com/net":                                  {work: true, t: Git},
 		"git@github.com:Masterminds/vcs.git":                               {work: true, t: Git},
 		"git@example.com:foo.git":                                          {work: true, t: Git},
 		"ssh://hg@bitbucket.org/mattfarina/testhgrepo":                     {work: true, t: Hg},
@@ -134,3 +150,99 @@ func TestAccessDenied(t *testing.T) {
 		t.Errorf("Failed to detect access denied")
 	}
 }
+
+func TestSameRemote(t *testing.T) {
+	same := [][]string{
+		{"https://github.com/Masterminds/vcs", "https://github.com/Masterminds/vcs.git"},
+		{"https://github.com/Masterminds/vcs", "git@github.com:Masterminds/vcs.git"},
+		{"https://github.com/Masterminds/vcs/", "http://GitHub.com/Masterminds/vcs"},
+		{"ssh://git@github.com:22/Masterminds/vcs.git", "git@github.com:Masterminds/vcs"},
+		{"git://github.com/Masterminds/vcs", "https://github.com:443/Masterminds/vcs"},
+		{"/tmp/repos/vcs.git", "file:///tmp/repos/vcs"},
+	}
+	different := [][]string{
+		{"https://github.com/Masterminds/vcs", "https://github.com/Masterminds/glide"},
+		{"https://github.com/Masterminds/vcs", "https://gitlab.com/Masterminds/vcs"},
+		{"https://github.com/Masterminds/vcs", "https://github.com:8443/Masterminds/vcs"},
+		{"/tmp/repos/vcs", "/tmp/other/vcs"},
+	}
+
+	check := func(a, b string, expected bool) {
+		ra, err := NewGitRepo(a, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rb, err := NewGitRepo(b, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		eq, err := SameRemote(ra, rb)
+		if err != nil {
+			t.Errorf("SameRemote(%s, %s) returned error %s", a, b, err)
+		}
+		if eq != expected {
+			t.Errorf("SameRemote(%s, %s) returned %t, expected %t", a, b, eq, expected)
+		}
+	}
+	for _, c := range same {
+		check(c[0], c[1], true)
+	}
+	for _, c := range different {
+		check(c[0], c[1], false)
+	}
+
+	ra, err := NewGitRepo("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = SameRemote(ra, ra); err == nil {
+		t.Error("SameRemote did not return an error for an empty remote")
+	}
+}
+
+func TestNormalizeRemote(t *testing.T) {
+	valid := map[string]string{
+		"https://github.com/Masterminds/vcs":                                       "https://github.com/Masterminds/vcs",
+		"https://github.com/Masterminds/vcs.git":                                   "https://github.com/Masterminds/vcs",
+		"HTTPS://GitHub.com:443/Masterminds/vcs/":                                  "https://github.com/Masterminds/vcs",
+		"git@github.com:Masterminds/vcs.git":                                       "ssh://git@github.com/Masterminds/vcs",
+		"git@example.com:/srv/repos/vcs.git":                                       "ssh://git@example.com/srv/repos/vcs",
+		"git@example.com:~user/vcs":                                                "ssh://git@example.com/~user/vcs",
+		"ssh://git@github.com:22/Masterminds/vcs.git":                              "ssh://git@github.com/Masterminds/vcs",
+		"ssh://git@example.com:2222/vcs":                                           "ssh://git@example.com:2222/vcs",
+		"git://github.com/Masterminds/vcs":                                         "git://github.com/Masterminds/vcs",
+		"bzr+ssh://example.com/foo/bar":                                            "bzr+ssh://example.com/foo/bar",
+		"svn+ssh://example.com/foo/bar":                                            "svn+ssh://example.com/foo/bar",
+		"https://example.com/svn/trunk?p=1":                                        "https://example.com/svn/trunk?p=1",
+		"file:///tmp/repos/vcs.git/":                                               "file:///tmp/repos/vcs.git",
+		"  https://github.com/Masterminds/vcs  ":                                   "https://github.com/Masterminds/vcs",
+		filepath.Join(string(filepath.Separator), "tmp", "repos", "..", "vcs.git"): filepath.Join(string(filepath.Separator), "tmp", "vcs.git"),
+	}
+	for in, expected := range valid {
+		out, err := NormalizeRemote(in)
+		if err != nil {
+			t.Errorf("NormalizeRemote(%q) returned error %s", in, err)
+		}
+		if out != expected {
+			t.Errorf("NormalizeRemote(%q) returned %q, expected %q", in, out, expected)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"github.com/Masterminds/vcs",
+		"ftp://example.com/vcs",
+		"https:///Masterminds/vcs",
+		"https://github.com/",
+		"git@github.com:",
+		"--upload-pack=touch /tmp/x",
+		"https://github.com/Masterminds\nvcs",
+		"https://github.com/Master\x00minds/vcs",
+		"https://github.com:notaport/vcs",
+	}
+	for _, in := range invalid {
+		if out, err := NormalizeRemote(in); err == nil {
+			t.Errorf("NormalizeRemote(%q) did not error. Returned %q", in, out)
+		}
+	}
+}